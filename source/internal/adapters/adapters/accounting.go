@@ -0,0 +1,95 @@
+package adapters
+
+import (
+	"sort"
+	"time"
+)
+
+// AccountingSession is a completed VPN session as reported by accounting Stop.
+type AccountingSession struct {
+	Username            string    `json:"username"`
+	Class               string    `json:"class"`
+	AcctSessionId       string    `json:"acct_session_id"`
+	AcctUniqueSessionId string    `json:"acct_unique_session_id"`
+	FramedIpAddress     string    `json:"framed_ip_address"`
+	CallingStationId    string    `json:"calling_station_id"`
+	StartTime           time.Time `json:"start_time"`
+	StopTime            time.Time `json:"stop_time"`
+	InputOctets         int64     `json:"input_octets"`
+	OutputOctets        int64     `json:"output_octets"`
+	InputPackets        int64     `json:"input_packets"`
+	OutputPackets       int64     `json:"output_packets"`
+	TerminateCause      string    `json:"terminate_cause"`
+}
+
+// Day is the UTC day the session stopped, sessions are partitioned and
+// summarized by it.
+func (s *AccountingSession) Day() string {
+	return s.StopTime.UTC().Format(time.DateOnly)
+}
+
+type DailyUsage struct {
+	Username       string `json:"username"`
+	Day            string `json:"day"`
+	SessionCount   int64  `json:"session_count"`
+	SessionSeconds int64  `json:"session_seconds"`
+	InputOctets    int64  `json:"input_octets"`
+	OutputOctets   int64  `json:"output_octets"`
+}
+
+// AccountingDays lists UTC days between from and to, both inclusive.
+func AccountingDays(from time.Time, to time.Time) []string {
+	days := []string{}
+	to = to.UTC()
+
+	for day := from.UTC().Truncate(24 * time.Hour); !day.After(to); day = day.Add(24 * time.Hour) {
+		days = append(days, day.Format(time.DateOnly))
+	}
+
+	return days
+}
+
+// IsAccountingSessionSelected tells whether session matches SelectSessions
+// arguments, empty username matches all users.
+func IsAccountingSessionSelected(session *AccountingSession, username string, from time.Time, to time.Time) bool {
+	return ((username == "") || (session.Username == username)) &&
+		!session.StopTime.Before(from) &&
+		session.StopTime.Before(to)
+}
+
+// SummarizeDailyUsage sums sessions per user and day, result is sorted by day
+// and then by username.
+func SummarizeDailyUsage(sessions []*AccountingSession) []*DailyUsage {
+	usageMap := map[[2]string]*DailyUsage{}
+
+	for _, session := range sessions {
+		key := [2]string{session.Day(), session.Username}
+		usage, exists := usageMap[key]
+
+		if !exists {
+			usage = &DailyUsage{Username: session.Username, Day: session.Day()}
+			usageMap[key] = usage
+		}
+
+		usage.SessionCount++
+		usage.SessionSeconds += int64(session.StopTime.Sub(session.StartTime).Seconds())
+		usage.InputOctets += session.InputOctets
+		usage.OutputOctets += session.OutputOctets
+	}
+
+	usages := make([]*DailyUsage, 0, len(usageMap))
+
+	for _, usage := range usageMap {
+		usages = append(usages, usage)
+	}
+
+	sort.Slice(usages, func(i, j int) bool {
+		if usages[i].Day != usages[j].Day {
+			return usages[i].Day < usages[j].Day
+		}
+
+		return usages[i].Username < usages[j].Username
+	})
+
+	return usages
+}
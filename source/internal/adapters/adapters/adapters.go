@@ -1,6 +1,9 @@
 package adapters
 
-import "io/fs"
+import (
+	"io/fs"
+	"time"
+)
 
 type VpnUser struct {
 	Username string
@@ -12,10 +15,15 @@ type IdentityAdapter interface {
 	SelectVpnUser(username string) *VpnUser
 }
 
+// CredentialsAdapter keeps NT passwords per IP address, DeleteNtPasswords
+// revokes all passwords of user and returns their IP addresses.
+// SelectNtPassword returns ErrCredentialsMissing or ErrNtPasswordMissing if
+// user has no password for IP address, other errors mean storage failed.
 type CredentialsAdapter interface {
 	SelectIpAddresses(vpnUser *VpnUser) []string
-	SelectNtPassword(vpnUser *VpnUser, ipAddress string) string
+	SelectNtPassword(vpnUser *VpnUser, ipAddress string) (string, error)
 	UpdateNtPassword(vpnUser *VpnUser, ipAddress string, clearTextPassword string)
+	DeleteNtPasswords(vpnUser *VpnUser) ([]string, error)
 }
 
 type EmailAttachment struct {
@@ -43,9 +51,18 @@ type EmailAdapter interface {
 	SendEmail(recipientAddress string, subject string, bodyText string, bodyHtml string, attachments map[string]EmailAttachment)
 }
 
+// AccountingAdapter keeps completed sessions, SelectSessions returns sessions
+// stopped within [from, to), of all users if username is empty.
+type AccountingAdapter interface {
+	InsertSession(session *AccountingSession)
+	SelectSessions(username string, from time.Time, to time.Time) ([]*AccountingSession, error)
+	Flush()
+}
+
 type LoggingAdapter interface {
 	LogDebugText(msg string, args ...any)
 	LogErrorText(msg string, args ...any)
 	LogInfoText(channel string, msg string, args ...any)
 	LogInfoJson(channel string, msg any)
+	Flush()
 }
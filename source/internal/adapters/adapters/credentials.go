@@ -0,0 +1,372 @@
+package adapters
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fernet/fernet-go"
+	"github.com/triflesoft/portalswan/internal/settings"
+	"golang.org/x/crypto/md4"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+var ErrCredentialsMissing = errors.New("credentials are missing")
+var ErrCredentialsUsernameMismatch = errors.New("credentials username mismatch")
+var ErrNtPasswordMissing = errors.New("NT password is missing")
+
+// CredentialsStore is storage of encrypted credentials, keyed by username.
+type CredentialsStore interface {
+	// ReadCredentials returns ErrCredentialsMissing if user has no credentials.
+	ReadCredentials(username string) (*VpnUserCredentials, error)
+	// UpdateCredentials applies update to the latest credentials and writes
+	// them back, so that concurrent updates are not lost. Missing credentials
+	// are created blank if create is set, ErrCredentialsMissing is returned
+	// otherwise. Update may be applied several times.
+	UpdateCredentials(username string, create bool, update func(credentials *VpnUserCredentials) error) (*VpnUserCredentials, error)
+}
+
+// VpnUserCredentials are NT passwords of a user keyed by IP address, access and
+// create times are unix times. Credentials adapters only store them, encrypted
+// with the primary fernet key.
+type VpnUserCredentials struct {
+	Username    string            `json:"username"`
+	NtPasswords map[string]string `json:"nt_passwords"`
+	AccessTimes map[string]int64  `json:"access_times"`
+	CreateTimes map[string]int64  `json:"create_times"`
+}
+
+func NewVpnUserCredentials(username string) *VpnUserCredentials {
+	return &VpnUserCredentials{
+		Username:    username,
+		NtPasswords: map[string]string{},
+		AccessTimes: map[string]int64{},
+		CreateTimes: map[string]int64{},
+	}
+}
+
+// DecryptVpnUserCredentials decrypts credentials with any of keys, maps missing
+// in older credentials are created blank.
+func DecryptVpnUserCredentials(encryptedData []byte, keys []*fernet.Key) (*VpnUserCredentials, error) {
+	cleartextData := fernet.VerifyAndDecrypt(encryptedData, 0, keys)
+
+	if cleartextData == nil {
+		return nil, errors.New("failed to decrypt fernet data")
+	}
+
+	credentials := &VpnUserCredentials{}
+
+	if err := json.Unmarshal(cleartextData, credentials); err != nil {
+		return nil, err
+	}
+
+	if credentials.NtPasswords == nil {
+		credentials.NtPasswords = map[string]string{}
+	}
+
+	if credentials.AccessTimes == nil {
+		credentials.AccessTimes = map[string]int64{}
+	}
+
+	if credentials.CreateTimes == nil {
+		credentials.CreateTimes = map[string]int64{}
+	}
+
+	return credentials, nil
+}
+
+// Encrypt drops times of deleted passwords and encrypts credentials with key.
+func (c *VpnUserCredentials) Encrypt(key *fernet.Key) ([]byte, error) {
+	for ipAddress := range c.AccessTimes {
+		if _, exists := c.NtPasswords[ipAddress]; !exists {
+			delete(c.AccessTimes, ipAddress)
+		}
+	}
+
+	for ipAddress := range c.CreateTimes {
+		if _, exists := c.NtPasswords[ipAddress]; !exists {
+			delete(c.CreateTimes, ipAddress)
+		}
+	}
+
+	cleartextData, err := json.Marshal(c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return fernet.EncryptAndSign(cleartextData, key)
+}
+
+func (c *VpnUserCredentials) Clone() *VpnUserCredentials {
+	clone := &VpnUserCredentials{
+		Username:    c.Username,
+		NtPasswords: make(map[string]string, len(c.NtPasswords)),
+		AccessTimes: make(map[string]int64, len(c.AccessTimes)),
+		CreateTimes: make(map[string]int64, len(c.CreateTimes)),
+	}
+
+	for key, value := range c.NtPasswords {
+		clone.NtPasswords[key] = value
+	}
+
+	for key, value := range c.AccessTimes {
+		clone.AccessTimes[key] = value
+	}
+
+	for key, value := range c.CreateTimes {
+		clone.CreateTimes[key] = value
+	}
+
+	return clone
+}
+
+func (c *VpnUserCredentials) IpAddresses() []string {
+	ipAddresses := make([]string, 0, len(c.NtPasswords))
+
+	for ipAddress := range c.NtPasswords {
+		ipAddresses = append(ipAddresses, ipAddress)
+	}
+
+	sort.Strings(ipAddresses)
+
+	return ipAddresses
+}
+
+// CheckUsername guards against hash collisions and misplaced objects,
+// credentials are stored under a hash of username.
+func (c *VpnUserCredentials) CheckUsername(username string) error {
+	if c.Username != username {
+		return ErrCredentialsUsernameMismatch
+	}
+
+	return nil
+}
+
+func (c *VpnUserCredentials) ApplyPolicy(policy *settings.AppCredentialsPolicySettings, now time.Time) []DeletedNtPassword {
+	return ApplyCredentialsPolicy(policy, c.NtPasswords, c.AccessTimes, c.CreateTimes, now)
+}
+
+func (c *VpnUserCredentials) TouchNtPassword(ipAddress string, now time.Time) {
+	c.AccessTimes[ipAddress] = now.Unix()
+}
+
+func (c *VpnUserCredentials) SetNtPassword(ipAddress string, ntPassword string, now time.Time) {
+	c.NtPasswords[ipAddress] = ntPassword
+	c.AccessTimes[ipAddress] = now.Unix()
+	c.CreateTimes[ipAddress] = now.Unix()
+}
+
+// DeleteNtPasswords deletes all passwords and returns their IP addresses.
+func (c *VpnUserCredentials) DeleteNtPasswords() []string {
+	ipAddresses := c.IpAddresses()
+	c.NtPasswords = map[string]string{}
+
+	return ipAddresses
+}
+
+// NtPasswordHash returns upper case hex MD4 hash of UTF-16 little endian
+// password, as FreeRADIUS expects NT-Password.
+func NtPasswordHash(clearTextPassword string) (string, error) {
+	utf16le := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+	passwordUtf16Data, _, err := transform.String(utf16le.NewEncoder(), clearTextPassword)
+
+	if err != nil {
+		return "", err
+	}
+
+	hasher := md4.New()
+	hasher.Write([]byte(passwordUtf16Data))
+
+	return strings.ToUpper(hex.EncodeToString(hasher.Sum(nil))), nil
+}
+
+// StoredCredentialsAdapter implements CredentialsAdapter on top of a store,
+// so that storage backends share expiry, hashing and revocation.
+type StoredCredentialsAdapter struct {
+	store          CredentialsStore
+	policyForClass func(class string) *settings.AppCredentialsPolicySettings
+	log            LoggingAdapter
+}
+
+func (a *StoredCredentialsAdapter) applyPolicy(vpnUser *VpnUser, credentials *VpnUserCredentials, now time.Time) {
+	for _, deletedNtPassword := range credentials.ApplyPolicy(a.policyForClass(vpnUser.Class), now) {
+		a.log.LogDebugText(
+			"Deleted NT password",
+			"username", vpnUser.Username,
+			"ipAddress", deletedNtPassword.IpAddress,
+			"accessTime", deletedNtPassword.AccessTime,
+			"createTime", deletedNtPassword.CreateTime,
+			"reason", deletedNtPassword.Reason)
+	}
+}
+
+func (a *StoredCredentialsAdapter) checkUsername(vpnUser *VpnUser, credentials *VpnUserCredentials) error {
+	err := credentials.CheckUsername(vpnUser.Username)
+
+	if err != nil {
+		a.log.LogErrorText(
+			"Credentials username mismatch",
+			"credentialsUsername", credentials.Username,
+			"vpnUserUsername", vpnUser.Username)
+	}
+
+	return err
+}
+
+// readCredentials returns credentials with policy applied.
+func (a *StoredCredentialsAdapter) readCredentials(vpnUser *VpnUser) (*VpnUserCredentials, error) {
+	credentials, err := a.store.ReadCredentials(vpnUser.Username)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.checkUsername(vpnUser, credentials); err != nil {
+		return nil, err
+	}
+
+	a.applyPolicy(vpnUser, credentials, time.Now())
+
+	return credentials, nil
+}
+
+// updateCredentials applies policy before update, so that expired passwords
+// are deleted from storage on the next write.
+func (a *StoredCredentialsAdapter) updateCredentials(vpnUser *VpnUser, create bool, update func(credentials *VpnUserCredentials, now time.Time)) (*VpnUserCredentials, error) {
+	return a.store.UpdateCredentials(vpnUser.Username, create, func(credentials *VpnUserCredentials) error {
+		if err := a.checkUsername(vpnUser, credentials); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		a.applyPolicy(vpnUser, credentials, now)
+		update(credentials, now)
+
+		return nil
+	})
+}
+
+func (a *StoredCredentialsAdapter) SelectIpAddresses(vpnUser *VpnUser) []string {
+	credentials, err := a.readCredentials(vpnUser)
+
+	if err != nil {
+		return []string{}
+	}
+
+	ipAddresses := credentials.IpAddresses()
+	a.log.LogDebugText(
+		"Selected IP addresses",
+		"username", vpnUser.Username,
+		"ipAddresses", strings.Join(ipAddresses, ", "))
+
+	return ipAddresses
+}
+
+func (a *StoredCredentialsAdapter) SelectNtPassword(vpnUser *VpnUser, ipAddress string) (string, error) {
+	credentials, err := a.updateCredentials(vpnUser, false, func(credentials *VpnUserCredentials, now time.Time) {
+		credentials.TouchNtPassword(ipAddress, now)
+	})
+
+	if errors.Is(err, ErrCredentialsMissing) {
+		a.log.LogErrorText("Failed to get credentials, credentials are missing", "vpnUserUsername", vpnUser.Username)
+
+		return "", err
+	}
+
+	if errors.Is(err, ErrCredentialsUsernameMismatch) {
+		return "", err
+	}
+
+	if err != nil {
+		// Access time is not essential, serve password from the latest credentials anyway.
+		a.log.LogErrorText("Failed to update access time", "err", err, "vpnUserUsername", vpnUser.Username)
+		credentials, err = a.readCredentials(vpnUser)
+
+		if err != nil {
+			return "", err
+		}
+	}
+
+	ntPassword, ok := credentials.NtPasswords[ipAddress]
+
+	if !ok {
+		a.log.LogErrorText(
+			"Failed to select NT password",
+			"username", vpnUser.Username,
+			"ipAddress", ipAddress)
+
+		return "", ErrNtPasswordMissing
+	}
+
+	a.log.LogDebugText(
+		"Selected NT password",
+		"username", vpnUser.Username,
+		"ipAddress", ipAddress)
+
+	return ntPassword, nil
+}
+
+func (a *StoredCredentialsAdapter) UpdateNtPassword(vpnUser *VpnUser, ipAddress string, clearTextPassword string) {
+	ntPassword, err := NtPasswordHash(clearTextPassword)
+
+	if err != nil {
+		a.log.LogErrorText("Failed to compute NT password hash", "err", err)
+
+		return
+	}
+
+	_, err = a.updateCredentials(vpnUser, true, func(credentials *VpnUserCredentials, now time.Time) {
+		credentials.SetNtPassword(ipAddress, ntPassword, now)
+	})
+
+	if err != nil {
+		a.log.LogErrorText("Failed to update NT password", "err", err)
+
+		return
+	}
+
+	a.log.LogDebugText(
+		"Updated NT password",
+		"username", vpnUser.Username,
+		"ipAddress", ipAddress)
+}
+
+func (a *StoredCredentialsAdapter) DeleteNtPasswords(vpnUser *VpnUser) ([]string, error) {
+	ipAddresses := []string{}
+	_, err := a.updateCredentials(vpnUser, false, func(credentials *VpnUserCredentials, now time.Time) {
+		ipAddresses = credentials.DeleteNtPasswords()
+	})
+
+	if errors.Is(err, ErrCredentialsMissing) {
+		return []string{}, nil
+	}
+
+	if err != nil {
+		a.log.LogErrorText("Failed to delete NT passwords", "err", err)
+
+		return nil, err
+	}
+
+	a.log.LogDebugText(
+		"Deleted NT passwords",
+		"username", vpnUser.Username,
+		"ipAddresses", strings.Join(ipAddresses, ", "))
+
+	return ipAddresses, nil
+}
+
+func NewStoredCredentialsAdapter(
+	store CredentialsStore,
+	policyForClass func(class string) *settings.AppCredentialsPolicySettings,
+	l LoggingAdapter) *StoredCredentialsAdapter {
+	return &StoredCredentialsAdapter{
+		store:          store,
+		policyForClass: policyForClass,
+		log:            l,
+	}
+}
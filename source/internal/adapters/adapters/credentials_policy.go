@@ -0,0 +1,81 @@
+package adapters
+
+import (
+	"sort"
+	"time"
+
+	"github.com/triflesoft/portalswan/internal/settings"
+)
+
+type DeletedNtPassword struct {
+	IpAddress  string
+	AccessTime int64
+	CreateTime int64
+	Reason     string
+}
+
+// ApplyCredentialsPolicy deletes NT passwords which have not been used for too
+// long or were created too long ago, and then least recently used ones above
+// the limit of IP addresses. Maps are keyed by IP address and hold unix times.
+// Passwords created before create times were recorded get their access time
+// as create time, passwords without access time get their create time as
+// access time, so that they are not taken for idle.
+func ApplyCredentialsPolicy(
+	policy *settings.AppCredentialsPolicySettings,
+	ntPasswords map[string]string,
+	accessTimes map[string]int64,
+	createTimes map[string]int64,
+	now time.Time) []DeletedNtPassword {
+	deletedNtPasswords := []DeletedNtPassword{}
+	deleteNtPassword := func(ipAddress string, reason string) {
+		deletedNtPasswords = append(deletedNtPasswords, DeletedNtPassword{
+			IpAddress:  ipAddress,
+			AccessTime: accessTimes[ipAddress],
+			CreateTime: createTimes[ipAddress],
+			Reason:     reason,
+		})
+
+		delete(ntPasswords, ipAddress)
+		delete(accessTimes, ipAddress)
+		delete(createTimes, ipAddress)
+	}
+
+	for ipAddress := range ntPasswords {
+		if _, exists := createTimes[ipAddress]; !exists {
+			if accessTime, exists := accessTimes[ipAddress]; exists {
+				createTimes[ipAddress] = accessTime
+			} else {
+				createTimes[ipAddress] = now.Unix()
+			}
+		}
+
+		if _, exists := accessTimes[ipAddress]; !exists {
+			accessTimes[ipAddress] = createTimes[ipAddress]
+		}
+	}
+
+	idleBefore := now.Add(-policy.IdleExpiry).Unix()
+	createdBefore := now.Add(-policy.MaxAge).Unix()
+
+	for ipAddress := range ntPasswords {
+		if accessTimes[ipAddress] < idleBefore {
+			deleteNtPassword(ipAddress, "idle")
+		} else if (policy.MaxAge > 0) && (createTimes[ipAddress] < createdBefore) {
+			deleteNtPassword(ipAddress, "max_age")
+		}
+	}
+
+	ipAddresses := make([]string, 0, len(ntPasswords))
+
+	for ipAddress := range ntPasswords {
+		ipAddresses = append(ipAddresses, ipAddress)
+	}
+
+	sort.Slice(ipAddresses, func(i, j int) bool { return accessTimes[ipAddresses[i]] > accessTimes[ipAddresses[j]] })
+
+	for i := policy.MaxIpAddresses; i < len(ipAddresses); i++ {
+		deleteNtPassword(ipAddresses[i], "max_ip_addresses")
+	}
+
+	return deletedNtPasswords
+}
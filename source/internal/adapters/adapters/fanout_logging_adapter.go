@@ -0,0 +1,119 @@
+package adapters
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/puzpuzpuz/xsync"
+	"github.com/triflesoft/portalswan/internal/settings"
+)
+
+type loggingRoute struct {
+	sink     LoggingAdapter
+	channels []string
+	minLevel slog.Level
+}
+
+func (r *loggingRoute) matches(channel string, level slog.Level) bool {
+	if level < r.minLevel {
+		return false
+	}
+
+	return slices.Contains(r.channels, "*") || slices.Contains(r.channels, channel)
+}
+
+// fanoutLoggingAdapter forwards every message to the sinks whose routes match
+// its channel and level. Sinks are resolved once per channel and level.
+type fanoutLoggingAdapter struct {
+	routes []loggingRoute
+	sinks  []LoggingAdapter
+	cache  *xsync.MapOf[string, []LoggingAdapter]
+}
+
+func (a *fanoutLoggingAdapter) resolveSinks(channel string, level slog.Level) []LoggingAdapter {
+	sinks, _ := a.cache.LoadOrCompute(fmt.Sprintf("%s/%s", level, channel), func() []LoggingAdapter {
+		sinks := []LoggingAdapter{}
+
+		for _, route := range a.routes {
+			if route.matches(channel, level) && !slices.Contains(sinks, route.sink) {
+				sinks = append(sinks, route.sink)
+			}
+		}
+
+		return sinks
+	})
+
+	return sinks
+}
+
+func (a *fanoutLoggingAdapter) LogDebugText(msg string, args ...any) {
+	for _, sink := range a.resolveSinks("Debug", slog.LevelDebug) {
+		sink.LogDebugText(msg, args...)
+	}
+}
+
+func (a *fanoutLoggingAdapter) LogErrorText(msg string, args ...any) {
+	for _, sink := range a.resolveSinks("Error", slog.LevelError) {
+		sink.LogErrorText(msg, args...)
+	}
+}
+
+func (a *fanoutLoggingAdapter) LogInfoText(channel string, msg string, args ...any) {
+	for _, sink := range a.resolveSinks(channel, slog.LevelInfo) {
+		sink.LogInfoText(channel, msg, args...)
+	}
+}
+
+func (a *fanoutLoggingAdapter) LogInfoJson(channel string, msg any) {
+	for _, sink := range a.resolveSinks(channel, slog.LevelInfo) {
+		sink.LogInfoJson(channel, msg)
+	}
+}
+
+func (a *fanoutLoggingAdapter) Flush() {
+	for _, sink := range a.sinks {
+		sink.Flush()
+	}
+}
+
+func newFanoutLoggingAdapter(s *settings.AppLoggingSettings) (*fanoutLoggingAdapter, error) {
+	a := &fanoutLoggingAdapter{
+		routes: make([]loggingRoute, 0, len(s.Routes)),
+		sinks:  []LoggingAdapter{},
+		cache:  xsync.NewMapOf[[]LoggingAdapter](),
+	}
+
+	sinksByKey := map[string]LoggingAdapter{}
+
+	for i, routeSettings := range s.Routes {
+		var minLevel slog.Level
+
+		if err := minLevel.UnmarshalText([]byte(routeSettings.MinLevel)); err != nil {
+			return nil, fmt.Errorf("logging.routes[%d].min_level is invalid: %w", i, err)
+		}
+
+		sink, exists := sinksByKey[routeSettings.Sink]
+
+		if !exists {
+			var err error
+
+			sink, err = loggingAdapterRegistry.buildKey(routeSettings.Sink, s, nil)
+
+			if err != nil {
+				return nil, fmt.Errorf("logging.routes[%d].sink is invalid: %w", i, err)
+			}
+
+			sinksByKey[routeSettings.Sink] = sink
+			a.sinks = append(a.sinks, sink)
+		}
+
+		a.routes = append(a.routes, loggingRoute{
+			sink:     sink,
+			channels: routeSettings.Channels,
+			minLevel: minLevel,
+		})
+	}
+
+	return a, nil
+}
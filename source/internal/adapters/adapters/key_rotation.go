@@ -0,0 +1,132 @@
+package adapters
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+
+	"github.com/fernet/fernet-go"
+)
+
+// KeyRotatorCredentialsAdapter is implemented by credentials adapters which
+// store credentials encrypted with fernet keys and can re-encrypt them with
+// the primary, i.e. the first configured, key.
+type KeyRotatorCredentialsAdapter interface {
+	CredentialsAdapter
+	// IDs of configured keys, primary key first.
+	KeyIds() []string
+	RotateKeys(options *RotateKeysOptions) (*RotateKeysReport, error)
+}
+
+type RotateKeysOptions struct {
+	// Objects are only decrypted, nothing is written.
+	DryRun bool
+	// Report of an interrupted run to resume. Objects are processed in lexical
+	// order of their keys, so objects up to and including LastObjectKey are
+	// skipped and counts are accumulated. Objects which failed are retried
+	// first.
+	Report *RotateKeysReport
+	// Called after each processed object.
+	Progress func(report *RotateKeysReport)
+}
+
+type RotateKeysReport struct {
+	ObjectCount      int `json:"object_count"`
+	ReencryptedCount int `json:"reencrypted_count"`
+	FailedCount      int `json:"failed_count"`
+	// Number of objects per ID of the key they are encrypted with once the
+	// run is over, objects which failed to decrypt are counted as "unknown".
+	KeyIdObjectCounts map[string]int `json:"key_id_object_counts"`
+	LastObjectKey     string         `json:"last_object_key"`
+	// IDs of keys of objects which failed, keyed by object key.
+	FailedObjectKeyIds map[string]string `json:"failed_object_key_ids"`
+}
+
+func NewRotateKeysReport(options *RotateKeysOptions) *RotateKeysReport {
+	if options.Report != nil {
+		if options.Report.KeyIdObjectCounts == nil {
+			options.Report.KeyIdObjectCounts = map[string]int{}
+		}
+
+		if options.Report.FailedObjectKeyIds == nil {
+			options.Report.FailedObjectKeyIds = map[string]string{}
+		}
+
+		return options.Report
+	}
+
+	return &RotateKeysReport{
+		KeyIdObjectCounts:  map[string]int{},
+		FailedObjectKeyIds: map[string]string{},
+	}
+}
+
+// TakeFailedObjectKeys returns keys of objects which failed, sorted, and
+// removes them from counts, so that they are counted once retried.
+func (r *RotateKeysReport) TakeFailedObjectKeys() []string {
+	objectKeys := make([]string, 0, len(r.FailedObjectKeyIds))
+
+	for objectKey, keyId := range r.FailedObjectKeyIds {
+		objectKeys = append(objectKeys, objectKey)
+		r.ObjectCount--
+		r.FailedCount--
+		r.KeyIdObjectCounts[keyId]--
+
+		if r.KeyIdObjectCounts[keyId] <= 0 {
+			delete(r.KeyIdObjectCounts, keyId)
+		}
+	}
+
+	r.FailedObjectKeyIds = map[string]string{}
+	sort.Strings(objectKeys)
+
+	return objectKeys
+}
+
+// Record accounts for a processed object, keyId is the ID of the key the
+// object is encrypted with once processed. Failed objects are remembered, so
+// that they are retried on resume although LastObjectKey moves past them.
+func (r *RotateKeysReport) Record(objectKey string, keyId string, reencrypted bool, err error) {
+	r.ObjectCount++
+
+	if objectKey > r.LastObjectKey {
+		r.LastObjectKey = objectKey
+	}
+
+	if keyId == "" {
+		keyId = "unknown"
+	}
+
+	if err != nil {
+		r.FailedCount++
+		r.FailedObjectKeyIds[objectKey] = keyId
+	}
+
+	if reencrypted {
+		r.ReencryptedCount++
+	}
+
+	r.KeyIdObjectCounts[keyId]++
+}
+
+// FernetKeyId identifies a key without revealing it, the ID is a prefix of
+// SHA-256 hash of the key.
+func FernetKeyId(key *fernet.Key) string {
+	keyHash := sha256.Sum256(key[:])
+
+	return hex.EncodeToString(keyHash[:4])
+}
+
+// DecryptWithAnyKey returns cleartext and the index of the key which
+// decrypted the token, or -1 if none did.
+func DecryptWithAnyKey(token []byte, keys []*fernet.Key) ([]byte, int) {
+	for i, key := range keys {
+		cleartext := fernet.VerifyAndDecrypt(token, 0, []*fernet.Key{key})
+
+		if cleartext != nil {
+			return cleartext, i
+		}
+	}
+
+	return nil, -1
+}
@@ -0,0 +1,184 @@
+package adapters
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/triflesoft/portalswan/internal/settings"
+)
+
+// AdapterFactory builds an adapter from a settings section. IsConfigured tells
+// whether the backend's key is present in the section, so that missing and
+// ambiguous configurations can be reported before anything is built.
+type AdapterFactory[S any, A any] struct {
+	IsConfigured func(s *S) bool
+	New          func(s *S, l LoggingAdapter) (A, error)
+}
+
+type adapterRegistry[S any, A any] struct {
+	section   string
+	mtx       sync.Mutex
+	factories map[string]AdapterFactory[S, A]
+}
+
+func (r *adapterRegistry[S, A]) register(key string, factory AdapterFactory[S, A]) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if _, exists := r.factories[key]; exists {
+		panic(fmt.Sprintf("%s adapter '%s' is already registered", r.section, key))
+	}
+
+	r.factories[key] = factory
+}
+
+func (r *adapterRegistry[S, A]) build(s *S, l LoggingAdapter) (A, error) {
+	var adapter A
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if s == nil {
+		return adapter, fmt.Errorf("%s settings are missing", r.section)
+	}
+
+	knownKeys := make([]string, 0, len(r.factories))
+	configuredKeys := []string{}
+
+	for key, factory := range r.factories {
+		knownKeys = append(knownKeys, key)
+
+		if factory.IsConfigured(s) {
+			configuredKeys = append(configuredKeys, key)
+		}
+	}
+
+	sort.Strings(knownKeys)
+	sort.Strings(configuredKeys)
+
+	if len(configuredKeys) == 0 {
+		return adapter, fmt.Errorf(
+			"%s settings do not configure any backend, expected one of '%s'",
+			r.section,
+			strings.Join(knownKeys, "', '"))
+	}
+
+	if len(configuredKeys) > 1 {
+		return adapter, fmt.Errorf(
+			"%s settings are ambiguous, configured backends are '%s'",
+			r.section,
+			strings.Join(configuredKeys, "', '"))
+	}
+
+	return r.create(configuredKeys[0], r.factories[configuredKeys[0]], s, l)
+}
+
+// buildKey builds the backend registered under key, several backends of the
+// same section may be built this way, e.g. logging sinks referenced by routes.
+func (r *adapterRegistry[S, A]) buildKey(key string, s *S, l LoggingAdapter) (A, error) {
+	var adapter A
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	factory, exists := r.factories[key]
+
+	if !exists {
+		return adapter, fmt.Errorf("%s backend '%s' is unknown", r.section, key)
+	}
+
+	if !factory.IsConfigured(s) {
+		return adapter, fmt.Errorf("%s backend '%s' is not configured", r.section, key)
+	}
+
+	return r.create(key, factory, s, l)
+}
+
+func (r *adapterRegistry[S, A]) create(key string, factory AdapterFactory[S, A], s *S, l LoggingAdapter) (A, error) {
+	adapter, err := factory.New(s, l)
+
+	if err != nil {
+		return adapter, fmt.Errorf("%s.%s settings are invalid: %w", r.section, key, err)
+	}
+
+	return adapter, nil
+}
+
+var identityAdapterRegistry = &adapterRegistry[settings.AppIdentitySettings, IdentityAdapter]{
+	section:   "identity",
+	factories: map[string]AdapterFactory[settings.AppIdentitySettings, IdentityAdapter]{},
+}
+
+var credentialsAdapterRegistry = &adapterRegistry[settings.AppCredentialsSettings, CredentialsAdapter]{
+	section:   "credentials",
+	factories: map[string]AdapterFactory[settings.AppCredentialsSettings, CredentialsAdapter]{},
+}
+
+var emailAdapterRegistry = &adapterRegistry[settings.AppEmailSettings, EmailAdapter]{
+	section:   "email",
+	factories: map[string]AdapterFactory[settings.AppEmailSettings, EmailAdapter]{},
+}
+
+var accountingAdapterRegistry = &adapterRegistry[settings.AppAccountingSettings, AccountingAdapter]{
+	section:   "accounting",
+	factories: map[string]AdapterFactory[settings.AppAccountingSettings, AccountingAdapter]{},
+}
+
+var loggingAdapterRegistry = &adapterRegistry[settings.AppLoggingSettings, LoggingAdapter]{
+	section:   "logging",
+	factories: map[string]AdapterFactory[settings.AppLoggingSettings, LoggingAdapter]{},
+}
+
+func RegisterIdentityAdapter(key string, factory AdapterFactory[settings.AppIdentitySettings, IdentityAdapter]) {
+	identityAdapterRegistry.register(key, factory)
+}
+
+func RegisterCredentialsAdapter(key string, factory AdapterFactory[settings.AppCredentialsSettings, CredentialsAdapter]) {
+	credentialsAdapterRegistry.register(key, factory)
+}
+
+func RegisterEmailAdapter(key string, factory AdapterFactory[settings.AppEmailSettings, EmailAdapter]) {
+	emailAdapterRegistry.register(key, factory)
+}
+
+func RegisterAccountingAdapter(key string, factory AdapterFactory[settings.AppAccountingSettings, AccountingAdapter]) {
+	accountingAdapterRegistry.register(key, factory)
+}
+
+// Logging adapters are built first, so their factories receive nil logger.
+func RegisterLoggingAdapter(key string, factory AdapterFactory[settings.AppLoggingSettings, LoggingAdapter]) {
+	loggingAdapterRegistry.register(key, factory)
+}
+
+func NewIdentityAdapter(s *settings.AppIdentitySettings, l LoggingAdapter) (IdentityAdapter, error) {
+	return identityAdapterRegistry.build(s, l)
+}
+
+func NewCredentialsAdapter(s *settings.AppCredentialsSettings, l LoggingAdapter) (CredentialsAdapter, error) {
+	return credentialsAdapterRegistry.build(s, l)
+}
+
+func NewEmailAdapter(s *settings.AppEmailSettings, l LoggingAdapter) (EmailAdapter, error) {
+	return emailAdapterRegistry.build(s, l)
+}
+
+// Accounting is optional, nil adapter is returned if it is not configured.
+func NewAccountingAdapter(s *settings.AppAccountingSettings, l LoggingAdapter) (AccountingAdapter, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	return accountingAdapterRegistry.build(s, l)
+}
+
+// With routes configured, every sink they reference is built and messages
+// are fanned out to them, otherwise the single configured backend is used.
+func NewLoggingAdapter(s *settings.AppLoggingSettings) (LoggingAdapter, error) {
+	if (s != nil) && (len(s.Routes) > 0) {
+		return newFanoutLoggingAdapter(s)
+	}
+
+	return loggingAdapterRegistry.build(s, nil)
+}
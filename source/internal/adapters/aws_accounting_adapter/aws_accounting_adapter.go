@@ -0,0 +1,301 @@
+package aws_accounting_adapter
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/triflesoft/portalswan/internal/adapters/adapters"
+	"github.com/triflesoft/portalswan/internal/settings"
+)
+
+const (
+	flushInterval    = time.Minute
+	queueMaxSessions = 100000
+)
+
+// Sessions are buffered and uploaded as one JSON lines object per day and
+// flush, objects are never modified, so several servers may share a prefix.
+// Object keys look like "<prefix>dt=2025-01-31/<hostname>-<time>-<random>.jsonl",
+// which Athena and Glue understand as a partition.
+type awsAccountingAdapter struct {
+	settings *settings.AppAccountingAwsSettings
+	log      adapters.LoggingAdapter
+	hostname string
+	mtx      sync.Mutex
+	queue    []*adapters.AccountingSession
+	flushMtx sync.Mutex
+}
+
+func (a *awsAccountingAdapter) newS3Client(ctx context.Context) (*s3.Client, error) {
+	awsConfig, err := config.LoadDefaultConfig(ctx, config.WithRegion(a.settings.S3BucketRegion))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return s3.NewFromConfig(awsConfig), nil
+}
+
+func (a *awsAccountingAdapter) getDayPrefix(day string) string {
+	return fmt.Sprintf("%sdt=%s/", a.settings.S3Prefix, day)
+}
+
+func (a *awsAccountingAdapter) InsertSession(session *adapters.AccountingSession) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if len(a.queue) >= queueMaxSessions {
+		a.log.LogErrorText(
+			"Dropped accounting session, queue is full",
+			"username", a.queue[0].Username,
+			"acctSessionId", a.queue[0].AcctSessionId)
+		a.queue = a.queue[1:]
+	}
+
+	a.queue = append(a.queue, session)
+
+	a.log.LogDebugText(
+		"Queued accounting session",
+		"username", session.Username,
+		"acctSessionId", session.AcctSessionId)
+}
+
+func (a *awsAccountingAdapter) putSessions(ctx context.Context, s3Client *s3.Client, day string, sessions []*adapters.AccountingSession) error {
+	objectData := &bytes.Buffer{}
+	encoder := json.NewEncoder(objectData)
+
+	for _, session := range sessions {
+		if err := encoder.Encode(session); err != nil {
+			return err
+		}
+	}
+
+	suffixData := make([]byte, 4)
+	rand.Read(suffixData)
+
+	objectKey := fmt.Sprintf(
+		"%s%s-%s-%s.jsonl",
+		a.getDayPrefix(day),
+		a.hostname,
+		time.Now().UTC().Format("20060102T150405Z"),
+		hex.EncodeToString(suffixData))
+	contentType := "application/x-ndjson"
+	_, err := s3Client.PutObject(
+		ctx,
+		&s3.PutObjectInput{
+			Bucket:      &a.settings.S3BucketName,
+			Key:         &objectKey,
+			Body:        bytes.NewReader(objectData.Bytes()),
+			ContentType: &contentType,
+		})
+
+	if err != nil {
+		return err
+	}
+
+	a.log.LogDebugText(
+		"Put accounting sessions",
+		"s3BucketName", a.settings.S3BucketName,
+		"objectKey", objectKey,
+		"count", len(sessions))
+
+	return nil
+}
+
+// Flush uploads queued sessions, sessions failed to upload are queued again.
+func (a *awsAccountingAdapter) Flush() {
+	a.flushMtx.Lock()
+	defer a.flushMtx.Unlock()
+
+	a.mtx.Lock()
+	queue := a.queue
+	a.queue = nil
+	a.mtx.Unlock()
+
+	if len(queue) == 0 {
+		return
+	}
+
+	daySessions := map[string][]*adapters.AccountingSession{}
+
+	for _, session := range queue {
+		daySessions[session.Day()] = append(daySessions[session.Day()], session)
+	}
+
+	ctx := context.TODO()
+	s3Client, err := a.newS3Client(ctx)
+	failedSessions := []*adapters.AccountingSession{}
+
+	for day, sessions := range daySessions {
+		if err == nil {
+			err = a.putSessions(ctx, s3Client, day, sessions)
+
+			if err == nil {
+				continue
+			}
+		}
+
+		a.log.LogErrorText(
+			"Failed to put accounting sessions",
+			"err", err,
+			"s3BucketName", a.settings.S3BucketName,
+			"day", day,
+			"count", len(sessions))
+
+		failedSessions = append(failedSessions, sessions...)
+		err = nil
+	}
+
+	if len(failedSessions) > 0 {
+		a.mtx.Lock()
+		a.queue = append(failedSessions, a.queue...)
+		a.mtx.Unlock()
+	}
+}
+
+func (a *awsAccountingAdapter) getSessions(ctx context.Context, s3Client *s3.Client, objectKey string) ([]*adapters.AccountingSession, error) {
+	objectOutput, err := s3Client.GetObject(
+		ctx,
+		&s3.GetObjectInput{
+			Bucket: &a.settings.S3BucketName,
+			Key:    &objectKey,
+		})
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer objectOutput.Body.Close()
+
+	sessions := []*adapters.AccountingSession{}
+	scanner := bufio.NewScanner(objectOutput.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		session := &adapters.AccountingSession{}
+
+		if err := json.Unmarshal(scanner.Bytes(), session); err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	return sessions, scanner.Err()
+}
+
+// SelectSessions reads every object of every day in range, sessions still
+// queued for upload are included as well.
+func (a *awsAccountingAdapter) SelectSessions(username string, from time.Time, to time.Time) ([]*adapters.AccountingSession, error) {
+	ctx := context.TODO()
+	s3Client, err := a.newS3Client(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []*adapters.AccountingSession{}
+
+	for _, day := range adapters.AccountingDays(from, to) {
+		dayPrefix := a.getDayPrefix(day)
+		paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+			Bucket: &a.settings.S3BucketName,
+			Prefix: &dayPrefix,
+		})
+
+		for paginator.HasMorePages() {
+			listObjectsOutput, err := paginator.NextPage(ctx)
+
+			if err != nil {
+				return nil, err
+			}
+
+			for _, object := range listObjectsOutput.Contents {
+				if (object.Key == nil) || !strings.HasSuffix(*object.Key, ".jsonl") {
+					continue
+				}
+
+				objectSessions, err := a.getSessions(ctx, s3Client, *object.Key)
+
+				if err != nil {
+					return nil, fmt.Errorf("failed to read '%s': %w", *object.Key, err)
+				}
+
+				for _, session := range objectSessions {
+					if adapters.IsAccountingSessionSelected(session, username, from, to) {
+						sessions = append(sessions, session)
+					}
+				}
+			}
+		}
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	for _, session := range a.queue {
+		if adapters.IsAccountingSessionSelected(session, username, from, to) {
+			sessions = append(sessions, session)
+		}
+	}
+
+	return sessions, nil
+}
+
+func (a *awsAccountingAdapter) run() {
+	for {
+		time.Sleep(flushInterval)
+		a.Flush()
+	}
+}
+
+func NewAwsAccountingAdapter(s *settings.AppAccountingAwsSettings, l adapters.LoggingAdapter) *awsAccountingAdapter {
+	hostname, err := os.Hostname()
+
+	if err != nil {
+		hostname = "portalswan"
+	}
+
+	a := &awsAccountingAdapter{
+		settings: s,
+		log:      l,
+		hostname: hostname,
+	}
+
+	go a.run()
+
+	return a
+}
+
+func init() {
+	adapters.RegisterAccountingAdapter("aws", adapters.AdapterFactory[settings.AppAccountingSettings, adapters.AccountingAdapter]{
+		IsConfigured: func(s *settings.AppAccountingSettings) bool {
+			return s.Aws != nil
+		},
+		New: func(s *settings.AppAccountingSettings, l adapters.LoggingAdapter) (adapters.AccountingAdapter, error) {
+			if (s.Aws.S3BucketRegion == "") || (s.Aws.S3BucketName == "") {
+				return nil, errors.New("s3_bucket_region and s3_bucket_name are required")
+			}
+
+			fmt.Printf("AWS Accounting Provider\n")
+			fmt.Printf(" S3\n")
+			fmt.Printf("    Bucket Region:          '%s'\n", s.Aws.S3BucketRegion)
+			fmt.Printf("    Bucket Name:            '%s'\n", s.Aws.S3BucketName)
+			fmt.Printf("    Prefix:                 '%s'\n", s.Aws.S3Prefix)
+
+			return NewAwsAccountingAdapter(s.Aws, l), nil
+		},
+	})
+}
@@ -9,62 +9,70 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math/rand/v2"
+	"net/http"
 	"net/url"
-	"sort"
 	"strings"
 	"time"
 
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/fernet/fernet-go"
 	"github.com/jellydator/ttlcache/v3"
 	"github.com/triflesoft/portalswan/internal/adapters/adapters"
 	"github.com/triflesoft/portalswan/internal/settings"
-	"golang.org/x/crypto/md4"
-	"golang.org/x/text/encoding/unicode"
-	"golang.org/x/text/transform"
 )
 
+const (
+	conflictRetryMaxAttempts = 5
+	conflictRetryBaseDelay   = 100 * time.Millisecond
+)
+
+var errCredentialsConflict = errors.New("credentials were modified concurrently")
+
 type awsCredentialsAdapter struct {
+	*adapters.StoredCredentialsAdapter
 	settings         *settings.AppCredentialsAwsSettings
 	log              adapters.LoggingAdapter
-	credentialsCache *ttlcache.Cache[string, *vpnUserCredentials]
+	credentialsCache *ttlcache.Cache[string, *cachedCredentials]
 }
 
-type vpnUserCredentials struct {
-	Username    string            `json:"username"`
-	NtPasswords map[string]string `json:"nt_passwords"`
-	AccessTimes map[string]int64  `json:"access_times"`
+type cachedCredentials struct {
+	credentials *adapters.VpnUserCredentials
+	// ETag of S3 object the credentials were read from or written to, empty
+	// if the object did not exist.
+	eTag string
 }
 
-func (a *awsCredentialsAdapter) encryptJsonToBytes(cleartext any) ([]byte, error) {
-	cleartextData, err := json.Marshal(cleartext)
+func (a *awsCredentialsAdapter) newS3Client(ctx context.Context) (*s3.Client, error) {
+	awsConfig, err := config.LoadDefaultConfig(ctx, config.WithRegion(a.settings.S3BucketRegion))
 
 	if err != nil {
-		a.log.LogErrorText("Failed to marshal JSON", "err", err)
+		a.log.LogErrorText("Failed to load default AWS config", "err", err)
+
 		return nil, err
 	}
 
-	return fernet.EncryptAndSign(cleartextData, a.settings.FernetKeys[0])
+	return s3.NewFromConfig(awsConfig), nil
 }
 
-func (a *awsCredentialsAdapter) decryptJsonFromBytes(encryptedData []byte, cleartext any, ttl time.Duration) error {
-	cleartextData := fernet.VerifyAndDecrypt(encryptedData, ttl, a.settings.FernetKeys)
+func (a *awsCredentialsAdapter) getObjectKey(username string) string {
+	userhash := sha512.Sum512([]byte(username))
 
-	if cleartextData == nil {
-		a.log.LogErrorText("Failed to decrypt fernet data")
-
-		return errors.New("failed to decrypt fernet data")
-	}
-
-	return json.Unmarshal(cleartextData, cleartext)
+	return fmt.Sprintf("%s.bin", hex.EncodeToString(userhash[:]))
 }
 
-func (a *awsCredentialsAdapter) getCredentials(ctx context.Context, s3Client *s3.Client, objectKey string, username string) *vpnUserCredentials {
-	credentialsCacheItem := a.credentialsCache.Get(objectKey)
+// Cached credentials are shared, so callers always get a copy. Credentials
+// cached by another request may be stale, conditional writes detect that.
+func (a *awsCredentialsAdapter) getCredentials(ctx context.Context, s3Client *s3.Client, objectKey string, username string, useCache bool) (*adapters.VpnUserCredentials, string, error) {
+	if useCache {
+		credentialsCacheItem := a.credentialsCache.Get(objectKey)
 
-	if credentialsCacheItem != nil {
-		return credentialsCacheItem.Value()
+		if credentialsCacheItem != nil {
+			return credentialsCacheItem.Value().credentials.Clone(), credentialsCacheItem.Value().eTag, nil
+		}
 	}
 
 	objectOutput, err := s3Client.GetObject(
@@ -74,6 +82,19 @@ func (a *awsCredentialsAdapter) getCredentials(ctx context.Context, s3Client *s3
 			Key:    &objectKey,
 		})
 
+	if a.isMissing(err) {
+		a.log.LogDebugText(
+			"S3 object is missing",
+			"err", err,
+			"s3BucketName", a.settings.S3BucketName,
+			"objectKey", objectKey,
+			"username", username)
+
+		return nil, "", adapters.ErrCredentialsMissing
+	}
+
+	// Throttling, network and server errors are not missing credentials,
+	// callers must not create blank ones or count failed logins
 	if err != nil {
 		a.log.LogErrorText(
 			"Failed to get S3 object",
@@ -82,14 +103,10 @@ func (a *awsCredentialsAdapter) getCredentials(ctx context.Context, s3Client *s3
 			"objectKey", objectKey,
 			"username", username)
 
-		return nil
+		return nil, "", err
 	}
 
-	a.log.LogDebugText(
-		"Got S3 object",
-		"s3BucketName", a.settings.S3BucketName,
-		"objectKey", objectKey,
-		"username", username)
+	defer objectOutput.Body.Close()
 
 	objectData, err := io.ReadAll(objectOutput.Body)
 
@@ -101,17 +118,10 @@ func (a *awsCredentialsAdapter) getCredentials(ctx context.Context, s3Client *s3
 			"objectKey", objectKey,
 			"username", username)
 
-		return nil
+		return nil, "", err
 	}
 
-	a.log.LogDebugText(
-		"Read S3 object",
-		"s3BucketName", a.settings.S3BucketName,
-		"objectKey", objectKey,
-		"username", username)
-
-	credentials := vpnUserCredentials{}
-	err = a.decryptJsonFromBytes(objectData, &credentials, 0)
+	credentials, err := adapters.DecryptVpnUserCredentials(objectData, a.settings.FernetKeys)
 
 	if err != nil {
 		a.log.LogErrorText(
@@ -121,99 +131,61 @@ func (a *awsCredentialsAdapter) getCredentials(ctx context.Context, s3Client *s3
 			"objectKey", objectKey,
 			"username", username)
 
-		return nil
+		return nil, "", err
+	}
+
+	eTag := ""
+
+	if objectOutput.ETag != nil {
+		eTag = *objectOutput.ETag
 	}
 
+	a.credentialsCache.Set(objectKey, &cachedCredentials{credentials: credentials.Clone(), eTag: eTag}, ttlcache.DefaultTTL)
 	a.log.LogDebugText(
-		"Decrypted credentials",
+		"Got credentials",
 		"s3BucketName", a.settings.S3BucketName,
 		"objectKey", objectKey,
-		"username", username)
-
-	if credentials.NtPasswords == nil {
-		credentials.NtPasswords = map[string]string{}
-		a.log.LogDebugText(
-			"Created new blank nt_passwords map, credentials were missing nt_passwords attribute",
-			"s3BucketName", a.settings.S3BucketName,
-			"objectKey", objectKey,
-			"username", username)
-	}
+		"username", username,
+		"ipAddresses", strings.Join(credentials.IpAddresses(), ", "))
 
-	if credentials.AccessTimes == nil {
-		credentials.AccessTimes = map[string]int64{}
-		a.log.LogDebugText(
-			"Created new blank access_times map, credentials were missing access_times attribute",
-			"s3BucketName", a.settings.S3BucketName,
-			"objectKey", objectKey,
-			"username", username)
-	}
+	return credentials, eTag, nil
+}
 
-	expiresBefore := time.Now().Unix() - 15*24*60*60
+func (a *awsCredentialsAdapter) encodeObjectTags(credentials *adapters.VpnUserCredentials) string {
+	objectTags := url.Values{}
+	objectTags.Add("Username", credentials.Username)
 
 	for ipAddress, accessTime := range credentials.AccessTimes {
-		if accessTime < expiresBefore {
-			delete(credentials.NtPasswords, ipAddress)
-			a.log.LogDebugText(
-				"Deleted expired NT password",
-				"s3BucketName", a.settings.S3BucketName,
-				"objectKey", objectKey,
-				"username", username,
-				"ipAddress", ipAddress,
-				"accessTime", accessTime)
-		}
-	}
-
-	type IpAddressAccessTime struct {
-		IpAddress  string
-		AccessTime int64
-	}
-
-	var ipAddressAccessTimes []IpAddressAccessTime
-
-	for key, value := range credentials.AccessTimes {
-		ipAddressAccessTimes = append(ipAddressAccessTimes, IpAddressAccessTime{key, value})
+		objectTags.Add(ipAddress, fmt.Sprintf("%d", accessTime))
 	}
 
-	sort.Slice(ipAddressAccessTimes, func(i, j int) bool { return ipAddressAccessTimes[i].AccessTime > ipAddressAccessTimes[j].AccessTime })
-
-	for i := 4; i < len(ipAddressAccessTimes); i++ {
-		delete(credentials.NtPasswords, ipAddressAccessTimes[i].IpAddress)
-		delete(credentials.AccessTimes, ipAddressAccessTimes[i].IpAddress)
-
-		a.log.LogDebugText(
-			"Deleted deprecated NT password",
-			"s3BucketName", a.settings.S3BucketName,
-			"objectKey", objectKey,
-			"username", username,
-			"ipAddress", ipAddressAccessTimes[i].IpAddress,
-			"accessTime", ipAddressAccessTimes[i].AccessTime)
-	}
+	return objectTags.Encode()
+}
 
-	a.credentialsCache.Set(objectKey, &credentials, ttlcache.DefaultTTL)
-	ipAddresses := make([]string, 0, len(credentials.NtPasswords))
+// isMissing tells whether S3 object does not exist. Without s3:ListBucket
+// permission S3 responds to missing objects with access denied, so it counts
+// as missing too. Creating object is conditional, it never overwrites
+// existing one.
+func (a *awsCredentialsAdapter) isMissing(err error) bool {
+	var noSuchKeyError *types.NoSuchKey
+	var responseError *awshttp.ResponseError
+
+	return errors.As(err, &noSuchKeyError) ||
+		(errors.As(err, &responseError) &&
+			((responseError.HTTPStatusCode() == http.StatusNotFound) ||
+				(responseError.HTTPStatusCode() == http.StatusForbidden)))
+}
 
-	for ip_address := range credentials.NtPasswords {
-		ipAddresses = append(ipAddresses, ip_address)
-	}
+func (a *awsCredentialsAdapter) isConflict(err error) bool {
+	var responseError *awshttp.ResponseError
 
-	a.log.LogDebugText(
-		"Got credentials",
-		"s3BucketName", a.settings.S3BucketName,
-		"objectKey", objectKey,
-		"username", username,
-		"ipAddresses", strings.Join(ipAddresses, ", "))
-
-	return &credentials
+	return errors.As(err, &responseError) &&
+		((responseError.HTTPStatusCode() == http.StatusPreconditionFailed) ||
+			(responseError.HTTPStatusCode() == http.StatusConflict))
 }
 
-func (a *awsCredentialsAdapter) putCredentials(ctx context.Context, s3Client *s3.Client, objectKey string, username string, credentials *vpnUserCredentials) error {
-	for key := range credentials.AccessTimes {
-		if _, exists := credentials.NtPasswords[key]; !exists {
-			delete(credentials.AccessTimes, key)
-		}
-	}
-
-	objectData, err := a.encryptJsonToBytes(credentials)
+func (a *awsCredentialsAdapter) putCredentials(ctx context.Context, s3Client *s3.Client, objectKey string, username string, credentials *adapters.VpnUserCredentials, eTag string) error {
+	objectData, err := credentials.Encrypt(a.settings.FernetKeys[0])
 
 	if err != nil {
 		a.log.LogErrorText(
@@ -226,30 +198,38 @@ func (a *awsCredentialsAdapter) putCredentials(ctx context.Context, s3Client *s3
 		return err
 	}
 
-	a.log.LogDebugText(
-		"Encrypted credentials",
-		"s3BucketName", a.settings.S3BucketName,
-		"objectKey", objectKey,
-		"username", username)
-	objectTags := url.Values{}
-	objectTags.Add("Username", credentials.Username)
-
-	for ipAddress, accessTime := range credentials.AccessTimes {
-		objectTags.Add(ipAddress, fmt.Sprintf("%d", accessTime))
+	encodedObjectTags := a.encodeObjectTags(credentials)
+	putObjectInput := &s3.PutObjectInput{
+		Bucket:  &a.settings.S3BucketName,
+		Key:     &objectKey,
+		Body:    bytes.NewReader(objectData),
+		Tagging: &encodedObjectTags,
 	}
 
-	encodedObjectTags := objectTags.Encode()
+	// Write only if nobody else has written since the credentials were read.
+	if eTag != "" {
+		putObjectInput.IfMatch = &eTag
+	} else {
+		ifNoneMatch := "*"
+		putObjectInput.IfNoneMatch = &ifNoneMatch
+	}
 
-	_, err = s3Client.PutObject(
-		ctx,
-		&s3.PutObjectInput{
-			Bucket:  &a.settings.S3BucketName,
-			Key:     &objectKey,
-			Body:    bytes.NewReader(objectData),
-			Tagging: &encodedObjectTags,
-		})
+	putObjectOutput, err := s3Client.PutObject(ctx, putObjectInput)
 
 	if err != nil {
+		if a.isConflict(err) {
+			a.credentialsCache.Delete(objectKey)
+			a.log.LogDebugText(
+				"Failed to put S3 object, object was modified concurrently",
+				"err", err,
+				"s3BucketName", a.settings.S3BucketName,
+				"objectKey", objectKey,
+				"username", username,
+				"eTag", eTag)
+
+			return errCredentialsConflict
+		}
+
 		a.log.LogErrorText(
 			"Failed to put S3 object",
 			"err", err,
@@ -260,195 +240,266 @@ func (a *awsCredentialsAdapter) putCredentials(ctx context.Context, s3Client *s3
 		return err
 	}
 
-	a.credentialsCache.Set(objectKey, credentials, ttlcache.DefaultTTL)
-	a.log.LogDebugText(
-		"Put S3 object",
-		"err", err,
-		"s3BucketName", a.settings.S3BucketName,
-		"objectKey", objectKey,
-		"username", username)
-	ipAddresses := make([]string, 0, len(credentials.NtPasswords))
+	eTag = ""
 
-	for ip_address := range credentials.NtPasswords {
-		ipAddresses = append(ipAddresses, ip_address)
+	if putObjectOutput.ETag != nil {
+		eTag = *putObjectOutput.ETag
 	}
 
+	a.credentialsCache.Set(objectKey, &cachedCredentials{credentials: credentials.Clone(), eTag: eTag}, ttlcache.DefaultTTL)
 	a.log.LogDebugText(
 		"Put credentials",
 		"s3BucketName", a.settings.S3BucketName,
 		"objectKey", objectKey,
 		"username", username,
-		"ipAddresses", strings.Join(ipAddresses, ", "))
+		"ipAddresses", strings.Join(credentials.IpAddresses(), ", "))
 
 	return nil
 }
 
-func (a *awsCredentialsAdapter) SelectIpAddresses(vpnUser *adapters.VpnUser) []string {
+func (a *awsCredentialsAdapter) ReadCredentials(username string) (*adapters.VpnUserCredentials, error) {
 	ctx := context.TODO()
-	awsConfig, err := config.LoadDefaultConfig(ctx, config.WithRegion(a.settings.S3BucketRegion))
+	s3Client, err := a.newS3Client(ctx)
 
 	if err != nil {
-		a.log.LogErrorText("Failed to load default AWS config", "err", err)
-
-		return []string{}
+		return nil, err
 	}
 
-	s3Client := s3.NewFromConfig(awsConfig)
-	userhash := sha512.Sum512([]byte(vpnUser.Username))
-	objectKey := fmt.Sprintf("%s.bin", hex.EncodeToString(userhash[:]))
-	credentials := a.getCredentials(ctx, s3Client, objectKey, vpnUser.Username)
+	credentials, _, err := a.getCredentials(ctx, s3Client, a.getObjectKey(username), username, true)
 
-	if credentials == nil {
-		return []string{}
-	}
+	return credentials, err
+}
 
-	ipAddresses := make([]string, 0, len(credentials.NtPasswords))
+// UpdateCredentials writes conditionally. On conflict with another gateway
+// the credentials are read again, bypassing cache, and update is applied
+// again on top of them.
+func (a *awsCredentialsAdapter) UpdateCredentials(username string, create bool, update func(credentials *adapters.VpnUserCredentials) error) (*adapters.VpnUserCredentials, error) {
+	ctx := context.TODO()
+	s3Client, err := a.newS3Client(ctx)
 
-	for ipAddress := range credentials.NtPasswords {
-		ipAddresses = append(ipAddresses, ipAddress)
+	if err != nil {
+		return nil, err
 	}
 
-	a.log.LogDebugText(
-		"Selected IP addresses",
-		"username", vpnUser.Username,
-		"ipAddresses", strings.Join(ipAddresses, ", "))
+	objectKey := a.getObjectKey(username)
+	delay := conflictRetryBaseDelay
 
-	return ipAddresses
-}
+	for attempt := 1; ; attempt++ {
+		credentials, eTag, err := a.getCredentials(ctx, s3Client, objectKey, username, attempt == 1)
 
-func (a *awsCredentialsAdapter) SelectNtPassword(vpnUser *adapters.VpnUser, ipAddress string) string {
-	ctx := context.TODO()
-	awsConfig, err := config.LoadDefaultConfig(ctx, config.WithRegion(a.settings.S3BucketRegion))
+		if errors.Is(err, adapters.ErrCredentialsMissing) && create {
+			credentials = adapters.NewVpnUserCredentials(username)
+			a.log.LogDebugText(
+				"Created new blank credentials, credentials were missing",
+				"vpnUserUsername", username)
+		} else if err != nil {
+			return nil, err
+		}
 
-	if err != nil {
-		a.log.LogErrorText("Failed to load default AWS config", "err", err)
-		return ""
-	}
+		if err := update(credentials); err != nil {
+			return nil, err
+		}
 
-	s3Client := s3.NewFromConfig(awsConfig)
-	userhash := sha512.Sum512([]byte(vpnUser.Username))
-	objectKey := fmt.Sprintf("%s.bin", hex.EncodeToString(userhash[:]))
-	credentials := a.getCredentials(ctx, s3Client, objectKey, vpnUser.Username)
+		err = a.putCredentials(ctx, s3Client, objectKey, username, credentials, eTag)
 
-	if credentials == nil {
-		a.log.LogErrorText("Failed to get credentials, credentials are missing", "vpnUserUsername", vpnUser.Username)
+		if err == nil {
+			return credentials, nil
+		}
+
+		if !errors.Is(err, errCredentialsConflict) || (attempt >= conflictRetryMaxAttempts) {
+			return nil, err
+		}
 
-		return ""
+		a.log.LogDebugText(
+			"Retrying credentials update",
+			"objectKey", objectKey,
+			"username", username,
+			"attempt", attempt)
+
+		time.Sleep(delay + rand.N(delay))
+		delay *= 2
 	}
+}
 
-	if credentials.Username != vpnUser.Username {
-		a.log.LogErrorText(
-			"Credentials username mismatch",
-			"credentialsUsername", credentials.Username,
-			"vpnUserUsername", vpnUser.Username)
+func (a *awsCredentialsAdapter) KeyIds() []string {
+	keyIds := make([]string, 0, len(a.settings.FernetKeys))
 
-		return ""
+	for _, key := range a.settings.FernetKeys {
+		keyIds = append(keyIds, adapters.FernetKeyId(key))
 	}
 
-	credentials.AccessTimes[ipAddress] = time.Now().Unix()
+	return keyIds
+}
 
-	a.putCredentials(ctx, s3Client, objectKey, vpnUser.Username, credentials)
+// rotateObjectKey re-encrypts a single object with the primary key, data is
+// written back as is, without expiring or pruning anything.
+func (a *awsCredentialsAdapter) rotateObjectKey(ctx context.Context, s3Client *s3.Client, objectKey string, dryRun bool) (string, bool, error) {
+	for attempt := 1; ; attempt++ {
+		objectOutput, err := s3Client.GetObject(
+			ctx,
+			&s3.GetObjectInput{
+				Bucket: &a.settings.S3BucketName,
+				Key:    &objectKey,
+			})
+
+		if err != nil {
+			return "", false, err
+		}
 
-	ntPassword, ok := credentials.NtPasswords[ipAddress]
+		objectData, err := io.ReadAll(objectOutput.Body)
+		objectOutput.Body.Close()
 
-	if ok {
-		a.log.LogDebugText(
-			"Selected NT password",
-			"username", vpnUser.Username,
-			"ipAddress", ipAddress)
-	} else {
-		a.log.LogErrorText(
-			"Failed to select NT password",
-			"username", vpnUser.Username,
-			"ipAddress", ipAddress)
-	}
+		if err != nil {
+			return "", false, err
+		}
 
-	return ntPassword
-}
+		cleartextData, keyIndex := adapters.DecryptWithAnyKey(objectData, a.settings.FernetKeys)
 
-func (a *awsCredentialsAdapter) UpdateNtPassword(vpnUser *adapters.VpnUser, ipAddress string, clearTextPassword string) {
-	ctx := context.TODO()
-	awsConfig, err := config.LoadDefaultConfig(ctx, config.WithRegion(a.settings.S3BucketRegion))
+		if keyIndex < 0 {
+			return "", false, errors.New("no configured key decrypts object")
+		}
 
-	if err != nil {
-		a.log.LogErrorText("Failed to load default AWS config", "err", err)
-		return
-	}
+		if keyIndex == 0 {
+			return adapters.FernetKeyId(a.settings.FernetKeys[0]), false, nil
+		}
+
+		if dryRun {
+			return adapters.FernetKeyId(a.settings.FernetKeys[keyIndex]), true, nil
+		}
 
-	s3Client := s3.NewFromConfig(awsConfig)
-	userhash := sha512.Sum512([]byte(vpnUser.Username))
-	objectKey := fmt.Sprintf("%s.bin", hex.EncodeToString(userhash[:]))
-	credentials := a.getCredentials(ctx, s3Client, objectKey, vpnUser.Username)
+		credentials := adapters.VpnUserCredentials{}
 
-	if credentials == nil {
-		credentials = &vpnUserCredentials{
-			Username:    vpnUser.Username,
-			NtPasswords: map[string]string{},
-			AccessTimes: map[string]int64{},
+		if err := json.Unmarshal(cleartextData, &credentials); err != nil {
+			return adapters.FernetKeyId(a.settings.FernetKeys[keyIndex]), false, err
 		}
 
-		a.log.LogDebugText(
-			"Created new blank credentials, credentials were missing",
-			"vpnUserUsername", vpnUser.Username)
-	}
+		objectData, err = fernet.EncryptAndSign(cleartextData, a.settings.FernetKeys[0])
 
-	if credentials.Username != vpnUser.Username {
-		a.log.LogErrorText(
-			"Credentials username mismatch",
-			"credentialsUsername", credentials.Username,
-			"vpnUserUsername", vpnUser.Username)
+		if err != nil {
+			return adapters.FernetKeyId(a.settings.FernetKeys[keyIndex]), false, err
+		}
 
-		return
+		encodedObjectTags := a.encodeObjectTags(&credentials)
+		_, err = s3Client.PutObject(
+			ctx,
+			&s3.PutObjectInput{
+				Bucket:  &a.settings.S3BucketName,
+				Key:     &objectKey,
+				Body:    bytes.NewReader(objectData),
+				Tagging: &encodedObjectTags,
+				IfMatch: objectOutput.ETag,
+			})
+
+		if err == nil {
+			a.credentialsCache.Delete(objectKey)
+			a.log.LogDebugText(
+				"Re-encrypted credentials",
+				"s3BucketName", a.settings.S3BucketName,
+				"objectKey", objectKey,
+				"keyId", adapters.FernetKeyId(a.settings.FernetKeys[keyIndex]))
+
+			return adapters.FernetKeyId(a.settings.FernetKeys[0]), true, nil
+		}
+
+		// Object was updated by a gateway meanwhile, most likely it is encrypted
+		// with the primary key now, otherwise it is re-encrypted again.
+		if !a.isConflict(err) || (attempt >= conflictRetryMaxAttempts) {
+			return adapters.FernetKeyId(a.settings.FernetKeys[keyIndex]), false, err
+		}
 	}
+}
 
-	utf16le := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
-	utf16leEncoder := utf16le.NewEncoder()
-	passwordUtf16Data, _, err := transform.String(utf16leEncoder, clearTextPassword)
+func (a *awsCredentialsAdapter) RotateKeys(options *adapters.RotateKeysOptions) (*adapters.RotateKeysReport, error) {
+	ctx := context.TODO()
+	s3Client, err := a.newS3Client(ctx)
 
 	if err != nil {
-		a.log.LogErrorText("Failed to encode NT password as UTF-16 little endian", "err", err)
+		return nil, err
+	}
 
-		return
+	listObjectsInput := &s3.ListObjectsV2Input{
+		Bucket: &a.settings.S3BucketName,
 	}
 
-	a.log.LogDebugText(
-		"Encoded NT password as UTF-16 little endian",
-		"vpnUserUsername", vpnUser.Username)
+	report := adapters.NewRotateKeysReport(options)
+	rotate := func(objectKey string) {
+		keyId, reencrypted, err := a.rotateObjectKey(ctx, s3Client, objectKey, options.DryRun)
 
-	hasher := md4.New()
-	_, err = hasher.Write([]byte(passwordUtf16Data))
+		if err != nil {
+			a.log.LogErrorText(
+				"Failed to re-encrypt credentials",
+				"err", err,
+				"s3BucketName", a.settings.S3BucketName,
+				"objectKey", objectKey)
+		}
 
-	if err != nil {
-		a.log.LogErrorText("Failed to compute MD4 hash of NT password", "err", err)
+		report.Record(objectKey, keyId, reencrypted, err)
 
-		return
+		if options.Progress != nil {
+			options.Progress(report)
+		}
 	}
 
-	a.log.LogDebugText(
-		"Computed MD4 hash of NT password",
-		"vpnUserUsername", vpnUser.Username)
+	for _, objectKey := range report.TakeFailedObjectKeys() {
+		rotate(objectKey)
+	}
 
-	credentials.NtPasswords[ipAddress] = strings.ToUpper(hex.EncodeToString(hasher.Sum(nil)))
-	credentials.AccessTimes[ipAddress] = time.Now().Unix()
+	if report.LastObjectKey != "" {
+		startAfter := report.LastObjectKey
+		listObjectsInput.StartAfter = &startAfter
+	}
 
-	err = a.putCredentials(ctx, s3Client, objectKey, vpnUser.Username, credentials)
+	paginator := s3.NewListObjectsV2Paginator(s3Client, listObjectsInput)
 
-	if err != nil {
-		a.log.LogErrorText("Failed to update NT password", "err", err)
+	for paginator.HasMorePages() {
+		listObjectsOutput, err := paginator.NextPage(ctx)
+
+		if err != nil {
+			return report, err
+		}
+
+		for _, object := range listObjectsOutput.Contents {
+			if (object.Key == nil) || !strings.HasSuffix(*object.Key, ".bin") {
+				continue
+			}
 
-		return
+			rotate(*object.Key)
+		}
 	}
 
-	a.log.LogDebugText(
-		"Updated NT password",
-		"username", vpnUser.Username,
-		"ipAddress", ipAddress)
+	return report, nil
 }
 
-func NewAwsCredentialsAdapter(s *settings.AppCredentialsAwsSettings, l adapters.LoggingAdapter) *awsCredentialsAdapter {
-	return &awsCredentialsAdapter{
+func NewAwsCredentialsAdapter(
+	s *settings.AppCredentialsAwsSettings,
+	policyForClass func(class string) *settings.AppCredentialsPolicySettings,
+	l adapters.LoggingAdapter) *awsCredentialsAdapter {
+	a := &awsCredentialsAdapter{
 		settings:         s,
 		log:              l,
-		credentialsCache: ttlcache.New(ttlcache.WithTTL[string, *vpnUserCredentials](15 * time.Second)),
+		credentialsCache: ttlcache.New(ttlcache.WithTTL[string, *cachedCredentials](15 * time.Second)),
 	}
+	a.StoredCredentialsAdapter = adapters.NewStoredCredentialsAdapter(a, policyForClass, l)
+
+	return a
+}
+
+func init() {
+	adapters.RegisterCredentialsAdapter("aws", adapters.AdapterFactory[settings.AppCredentialsSettings, adapters.CredentialsAdapter]{
+		IsConfigured: func(s *settings.AppCredentialsSettings) bool {
+			return s.Aws != nil
+		},
+		New: func(s *settings.AppCredentialsSettings, l adapters.LoggingAdapter) (adapters.CredentialsAdapter, error) {
+			if (s.Aws.S3BucketRegion == "") || (s.Aws.S3BucketName == "") || (len(s.Aws.FernetKeys) == 0) {
+				return nil, errors.New("s3_bucket_region, s3_bucket_name and fernet_keys are required")
+			}
+
+			fmt.Printf("AWS Credentials Provider\n")
+			fmt.Printf(" S3\n")
+			fmt.Printf("    Bucket Region:          '%s'\n", s.Aws.S3BucketRegion)
+			fmt.Printf("    Bucket Name:            '%s'\n", s.Aws.S3BucketName)
+
+			return NewAwsCredentialsAdapter(s.Aws, s.PolicyForClass, l), nil
+		},
+	})
 }
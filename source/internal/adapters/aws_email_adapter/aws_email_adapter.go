@@ -2,6 +2,8 @@ package aws_email_adapter
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -110,3 +112,23 @@ func NewAwsEmailAdapter(s *settings.AppEmailAwsSettings, l adapters.LoggingAdapt
 		log:      l,
 	}
 }
+
+func init() {
+	adapters.RegisterEmailAdapter("aws", adapters.AdapterFactory[settings.AppEmailSettings, adapters.EmailAdapter]{
+		IsConfigured: func(s *settings.AppEmailSettings) bool {
+			return s.Aws != nil
+		},
+		New: func(s *settings.AppEmailSettings, l adapters.LoggingAdapter) (adapters.EmailAdapter, error) {
+			if (s.Aws.SesRegion == "") || (s.Aws.SesSource == "") {
+				return nil, errors.New("ses_region and ses_source are required")
+			}
+
+			fmt.Printf("AWS Email Provider\n")
+			fmt.Printf(" SES\n")
+			fmt.Printf("    Region:                 '%s'\n", s.Aws.SesRegion)
+			fmt.Printf("    Source:                 '%s'\n", s.Aws.SesSource)
+
+			return NewAwsEmailAdapter(s.Aws, l), nil
+		},
+	})
+}
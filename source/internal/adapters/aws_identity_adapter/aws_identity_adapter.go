@@ -2,6 +2,8 @@ package aws_identity_adapter
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"regexp"
 	"strings"
 	"sync"
@@ -393,3 +395,28 @@ func NewAwsIdentityAdapter(s *settings.AppIdentityAwsSettings, l adapters.Loggin
 		vpnUserCache:      ttlcache.New(ttlcache.WithTTL[string, *adapters.VpnUser](5 * time.Minute)),
 	}
 }
+
+func init() {
+	adapters.RegisterIdentityAdapter("aws", adapters.AdapterFactory[settings.AppIdentitySettings, adapters.IdentityAdapter]{
+		IsConfigured: func(s *settings.AppIdentitySettings) bool {
+			return s.Aws != nil
+		},
+		New: func(s *settings.AppIdentitySettings, l adapters.LoggingAdapter) (adapters.IdentityAdapter, error) {
+			if (s.Aws.IdentityStoreRegion == "") || (s.Aws.IdentityStoreId == "") || (s.Aws.RadiusClassFromGroupNamePattern == "") {
+				return nil, errors.New("identity_store_region, identity_store_id and radius_class_from_group_name_pattern are required")
+			}
+
+			if _, err := regexp.Compile(s.Aws.RadiusClassFromGroupNamePattern); err != nil {
+				return nil, fmt.Errorf("radius_class_from_group_name_pattern is invalid: %w", err)
+			}
+
+			fmt.Printf("AWS Identity Provider\n")
+			fmt.Printf(" IAM Identity Center\n")
+			fmt.Printf("    Identity Store Region:  '%s'\n", s.Aws.IdentityStoreRegion)
+			fmt.Printf("    Identity Store Id:      '%s'\n", s.Aws.IdentityStoreId)
+			fmt.Printf("    Group Name Pattern:     '%s'\n", s.Aws.RadiusClassFromGroupNamePattern)
+
+			return NewAwsIdentityAdapter(s.Aws, l), nil
+		},
+	})
+}
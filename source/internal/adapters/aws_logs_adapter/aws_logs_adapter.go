@@ -4,171 +4,378 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log/slog"
 	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
 	cloudwatchlogsTypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/puzpuzpuz/xsync"
+	"github.com/triflesoft/portalswan/internal/adapters/adapters"
 	"github.com/triflesoft/portalswan/internal/settings"
 )
 
+// CloudWatch Logs limits, see PutLogEvents API reference.
+const (
+	batchMaxEvents     = 10000
+	batchMaxBytes      = 1048576
+	batchEventOverhead = 26
+	eventMaxBytes      = 262144 - batchEventOverhead
+)
+
+const (
+	queueCapacity    = 8192
+	batchMaxDelay    = 5 * time.Second
+	retryMaxAttempts = 6
+	retryBaseDelay   = 200 * time.Millisecond
+	flushTimeout     = 30 * time.Second
+)
+
+type cloudWatchLogsClientFactory struct {
+	settings       *settings.AppLoggingAwsSettings
+	fallbackLogger *slog.Logger
+	client         *cloudwatchlogs.Client
+	once           sync.Once
+}
+
+func (f *cloudWatchLogsClientFactory) GetCloudWatchLogsClient() *cloudwatchlogs.Client {
+	f.once.Do(
+		func() {
+			awsConfig, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(f.settings.CloudWatchLogRegion))
+
+			if err != nil {
+				f.fallbackLogger.Error("Failed to load default AWS config", "err", err)
+				return
+			}
+
+			f.client = cloudwatchlogs.NewFromConfig(awsConfig)
+		})
+
+	return f.client
+}
+
 type cloudWatchLogsWriterHandler struct {
-	region         string
+	clientFactory  *cloudWatchLogsClientFactory
 	group          string
 	stream         string
+	streamCreated  bool
+	echoToStdout   bool
+	queue          chan cloudwatchlogsTypes.InputLogEvent
+	flushChan      chan chan struct{}
+	droppedCount   atomic.Int64
 	logger         *slog.Logger
 	fallbackLogger *slog.Logger
 }
 
+// Write never blocks, when the queue is full the event is dropped and counted.
+// Dropped events are reported in the same stream with the next batch.
 func (h *cloudWatchLogsWriterHandler) Write(p []byte) (n int, err error) {
-	os.Stdout.Write(p)
+	if h.echoToStdout {
+		os.Stdout.Write(p)
 
-	if len(p) > 1 && p[len(p)-1] >= 32 {
-		os.Stdout.Write([]byte{10})
+		if len(p) > 1 && p[len(p)-1] >= 32 {
+			os.Stdout.Write([]byte{10})
+		}
 	}
 
-	ctx := context.TODO()
-	awsConfig, err := config.LoadDefaultConfig(ctx, config.WithRegion(h.region))
+	message := string(p)
 
-	if err != nil {
-		h.fallbackLogger.Error("Failed to load default AWS config", "err", err)
-		return
+	if len(message) > eventMaxBytes {
+		message = strings.ToValidUTF8(message[:eventMaxBytes], "")
 	}
 
-	logsClient := cloudwatchlogs.NewFromConfig(awsConfig)
 	timestamp := time.Now().UnixMilli()
-	message := string(p)
-	logEvents := []cloudwatchlogsTypes.InputLogEvent{{
-		Message:   &message,
-		Timestamp: &timestamp,
-	}}
 
-	_, err = logsClient.PutLogEvents(
+	select {
+	case h.queue <- cloudwatchlogsTypes.InputLogEvent{Message: &message, Timestamp: &timestamp}:
+	default:
+		h.droppedCount.Add(1)
+	}
+
+	return len(p), nil
+}
+
+func (h *cloudWatchLogsWriterHandler) createLogStream(ctx context.Context, logsClient *cloudwatchlogs.Client) bool {
+	_, err := logsClient.CreateLogStream(
 		ctx,
-		&cloudwatchlogs.PutLogEventsInput{
-			LogEvents:     logEvents,
+		&cloudwatchlogs.CreateLogStreamInput{
 			LogGroupName:  &h.group,
 			LogStreamName: &h.stream,
-		},
-	)
+		})
 
 	if err != nil {
-		h.fallbackLogger.Error("Failed to put log events", "err", err)
-		return
+		var raee *cloudwatchlogsTypes.ResourceAlreadyExistsException
+
+		if !errors.As(err, &raee) {
+			h.fallbackLogger.Error("Failed to create CloudWatch Logs stream", "err", err, "stream", h.stream)
+			return false
+		}
 	}
 
-	return len(p), nil
-}
+	h.streamCreated = true
 
-type awsLoggingAdapter struct {
-	settings       *settings.AppLoggingAwsSettings
-	handlers       *map[string]*cloudWatchLogsWriterHandler
-	fallbackLogger *slog.Logger
+	return true
 }
 
-func (a *awsLoggingAdapter) LogDebugText(msg string, args ...any) {
-	handler := (*a.handlers)["Debug"]
+func (h *cloudWatchLogsWriterHandler) putLogEvents(logEvents []cloudwatchlogsTypes.InputLogEvent) {
+	if len(logEvents) == 0 {
+		return
+	}
 
-	if handler == nil {
-		handler = NewCloudWatchLogsWriterHandler(a, "Debug", a.fallbackLogger)
-		(*a.handlers)["Debug"] = handler
+	logsClient := h.clientFactory.GetCloudWatchLogsClient()
+
+	if logsClient == nil {
+		h.droppedCount.Add(int64(len(logEvents)))
+		return
 	}
 
-	if handler != nil {
-		handler.logger.Debug(msg, args...)
+	sort.SliceStable(logEvents, func(i, j int) bool { return *logEvents[i].Timestamp < *logEvents[j].Timestamp })
+
+	ctx := context.TODO()
+	delay := retryBaseDelay
+
+	for attempt := 1; attempt <= retryMaxAttempts; attempt++ {
+		if !h.streamCreated && !h.createLogStream(ctx, logsClient) {
+			time.Sleep(delay)
+			delay *= 2
+			continue
+		}
+
+		_, err := logsClient.PutLogEvents(
+			ctx,
+			&cloudwatchlogs.PutLogEventsInput{
+				LogEvents:     logEvents,
+				LogGroupName:  &h.group,
+				LogStreamName: &h.stream,
+			},
+		)
+
+		if err == nil {
+			return
+		}
+
+		var te *cloudwatchlogsTypes.ThrottlingException
+		var sue *cloudwatchlogsTypes.ServiceUnavailableException
+		var rnfe *cloudwatchlogsTypes.ResourceNotFoundException
+
+		if errors.As(err, &rnfe) {
+			h.streamCreated = false
+		} else if !errors.As(err, &te) && !errors.As(err, &sue) {
+			h.fallbackLogger.Error("Failed to put log events", "err", err, "stream", h.stream, "count", len(logEvents))
+			h.droppedCount.Add(int64(len(logEvents)))
+			return
+		}
+
+		time.Sleep(delay)
+		delay *= 2
 	}
+
+	h.fallbackLogger.Error("Failed to put log events, retries exhausted", "stream", h.stream, "count", len(logEvents))
+	h.droppedCount.Add(int64(len(logEvents)))
 }
 
-func (a *awsLoggingAdapter) LogErrorText(msg string, args ...any) {
-	handler := (*a.handlers)["Error"]
+func (h *cloudWatchLogsWriterHandler) run() {
+	logEvents := make([]cloudwatchlogsTypes.InputLogEvent, 0, 64)
+	logEventsBytes := 0
+	timer := time.NewTimer(batchMaxDelay)
+	timer.Stop()
+
+	ship := func() {
+		timer.Stop()
+
+		if droppedCount := h.droppedCount.Swap(0); droppedCount > 0 {
+			h.fallbackLogger.Error("Dropped log events", "stream", h.stream, "count", droppedCount)
+
+			messageData, _ := json.Marshal(map[string]any{
+				"time":  time.Now(),
+				"level": "WARN",
+				"msg":   "Dropped log events",
+				"count": droppedCount,
+			})
+			message := string(messageData)
+			timestamp := time.Now().UnixMilli()
+			logEvents = append(logEvents, cloudwatchlogsTypes.InputLogEvent{Message: &message, Timestamp: &timestamp})
+		}
 
-	if handler == nil {
-		handler = NewCloudWatchLogsWriterHandler(a, "Error", a.fallbackLogger)
-		(*a.handlers)["Error"] = handler
+		h.putLogEvents(logEvents)
+		logEvents = make([]cloudwatchlogsTypes.InputLogEvent, 0, 64)
+		logEventsBytes = 0
 	}
 
-	if handler != nil {
-		handler.logger.Error(msg, args...)
+	add := func(logEvent cloudwatchlogsTypes.InputLogEvent) {
+		logEventBytes := len(*logEvent.Message) + batchEventOverhead
+
+		// One event and some bytes are reserved for dropped events report
+		if (len(logEvents)+1 >= batchMaxEvents) || (logEventsBytes+logEventBytes > batchMaxBytes-1024) {
+			ship()
+		}
+
+		if len(logEvents) == 0 {
+			timer.Reset(batchMaxDelay)
+		}
+
+		logEvents = append(logEvents, logEvent)
+		logEventsBytes += logEventBytes
+	}
+
+	for {
+		select {
+		case logEvent := <-h.queue:
+			add(logEvent)
+		case <-timer.C:
+			ship()
+		case done := <-h.flushChan:
+		drain_loop:
+			for {
+				select {
+				case logEvent := <-h.queue:
+					add(logEvent)
+				default:
+					break drain_loop
+				}
+			}
+
+			ship()
+			close(done)
+		}
 	}
 }
 
-func (a *awsLoggingAdapter) LogInfoText(channel string, msg string, args ...any) {
-	handler := (*a.handlers)[channel]
+// flush asks handler to ship queued events, handler may be busy retrying, so
+// the request is abandoned once ctx is done.
+func (h *cloudWatchLogsWriterHandler) flush(ctx context.Context) chan struct{} {
+	done := make(chan struct{})
 
-	if handler == nil {
-		handler = NewCloudWatchLogsWriterHandler(a, channel, a.fallbackLogger)
-		(*a.handlers)[channel] = handler
+	select {
+	case h.flushChan <- done:
+		return done
+	case <-ctx.Done():
+		return nil
 	}
+}
 
-	if handler != nil {
-		handler.logger.Info(msg, args...)
-	}
+type awsLoggingAdapter struct {
+	settings       *settings.AppLoggingAwsSettings
+	clientFactory  *cloudWatchLogsClientFactory
+	handlers       *xsync.MapOf[string, *cloudWatchLogsWriterHandler]
+	fallbackLogger *slog.Logger
+}
+
+func (a *awsLoggingAdapter) getHandler(channel string) *cloudWatchLogsWriterHandler {
+	handler, _ := a.handlers.LoadOrCompute(channel, func() *cloudWatchLogsWriterHandler {
+		return NewCloudWatchLogsWriterHandler(a, channel, a.fallbackLogger)
+	})
+
+	return handler
+}
+
+func (a *awsLoggingAdapter) LogDebugText(msg string, args ...any) {
+	a.getHandler("Debug").logger.Debug(msg, args...)
+}
+
+func (a *awsLoggingAdapter) LogErrorText(msg string, args ...any) {
+	a.getHandler("Error").logger.Error(msg, args...)
+}
+
+func (a *awsLoggingAdapter) LogInfoText(channel string, msg string, args ...any) {
+	a.getHandler(channel).logger.Info(msg, args...)
 }
 
 func (a *awsLoggingAdapter) LogInfoJson(channel string, msg any) {
-	handler := (*a.handlers)[channel]
+	handler := a.getHandler(channel)
+	messageData, err := json.Marshal(msg)
 
-	if handler == nil {
-		handler = NewCloudWatchLogsWriterHandler(a, channel, a.fallbackLogger)
-		(*a.handlers)[channel] = handler
+	if err != nil {
+		a.fallbackLogger.Error("Failed to marshal JSON", "err", err)
+		return
 	}
 
-	if handler != nil {
-		messageData, err := json.Marshal(msg)
+	handler.Write(messageData)
+}
 
-		if err != nil {
-			a.fallbackLogger.Error("Failed to marshal JSON", "err", err)
-			return
+func (a *awsLoggingAdapter) Flush() {
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+
+	doneChans := []chan struct{}{}
+
+	a.handlers.Range(func(channel string, handler *cloudWatchLogsWriterHandler) bool {
+		done := handler.flush(ctx)
+
+		if done == nil {
+			return false
+		}
+
+		doneChans = append(doneChans, done)
+		return true
+	})
+
+	for _, done := range doneChans {
+		select {
+		case <-done:
+		case <-ctx.Done():
 		}
+	}
 
-		handler.Write(messageData)
+	if ctx.Err() != nil {
+		a.fallbackLogger.Error("Failed to flush log events, timeout expired")
 	}
 }
 
 func NewCloudWatchLogsWriterHandler(a *awsLoggingAdapter, name string, fallbackLogger *slog.Logger) *cloudWatchLogsWriterHandler {
 	handler := &cloudWatchLogsWriterHandler{
-		region:         a.settings.CloudWatchLogRegion,
+		clientFactory:  a.clientFactory,
 		group:          a.settings.CloudWatchLogGroup,
 		stream:         name,
+		echoToStdout:   a.settings.EchoToStdout,
+		queue:          make(chan cloudwatchlogsTypes.InputLogEvent, queueCapacity),
+		flushChan:      make(chan chan struct{}),
 		logger:         fallbackLogger,
 		fallbackLogger: fallbackLogger,
 	}
 	handler.logger = slog.New(slog.NewJSONHandler(handler, &slog.HandlerOptions{AddSource: false, Level: slog.LevelDebug}))
 
-	ctx := context.TODO()
-	awsConfig, err := config.LoadDefaultConfig(ctx, config.WithRegion(a.settings.CloudWatchLogRegion))
-
-	if err != nil {
-		fallbackLogger.Error("Failed to load default AWS config", "err", err)
-		return nil
-	}
-
-	logsClient := cloudwatchlogs.NewFromConfig(awsConfig)
-	_, err = logsClient.CreateLogStream(
-		ctx,
-		&cloudwatchlogs.CreateLogStreamInput{
-			LogGroupName:  &a.settings.CloudWatchLogGroup,
-			LogStreamName: &name,
-		})
-
-	if err != nil {
-		var raee *cloudwatchlogsTypes.ResourceAlreadyExistsException
-
-		if !errors.As(err, &raee) {
-			fallbackLogger.Error("Failed to create CloudWatch Logs stream", "err", err)
-			return nil
-		}
-	}
+	go handler.run()
 
 	return handler
 }
 
 func NewAwsLoggingAdapter(s *settings.AppLoggingAwsSettings) *awsLoggingAdapter {
+	fallbackLogger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{AddSource: false, Level: slog.LevelDebug}))
+
 	return &awsLoggingAdapter{
-		settings:       s,
-		handlers:       &map[string]*cloudWatchLogsWriterHandler{},
-		fallbackLogger: slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{AddSource: false, Level: slog.LevelDebug})),
+		settings: s,
+		clientFactory: &cloudWatchLogsClientFactory{
+			settings:       s,
+			fallbackLogger: fallbackLogger,
+		},
+		handlers:       xsync.NewMapOf[*cloudWatchLogsWriterHandler](),
+		fallbackLogger: fallbackLogger,
 	}
 }
+
+func init() {
+	adapters.RegisterLoggingAdapter("aws", adapters.AdapterFactory[settings.AppLoggingSettings, adapters.LoggingAdapter]{
+		IsConfigured: func(s *settings.AppLoggingSettings) bool {
+			return s.Aws != nil
+		},
+		New: func(s *settings.AppLoggingSettings, _ adapters.LoggingAdapter) (adapters.LoggingAdapter, error) {
+			if (s.Aws.CloudWatchLogRegion == "") || (s.Aws.CloudWatchLogGroup == "") {
+				return nil, errors.New("cloudwatch_log_region and cloudwatch_log_group are required")
+			}
+
+			fmt.Printf("AWS Logging Adapter\n")
+			fmt.Printf(" CloudWatch Logs\n")
+			fmt.Printf("    Region Name:            '%s'\n", s.Aws.CloudWatchLogRegion)
+			fmt.Printf("    Group Name:             '%s'\n", s.Aws.CloudWatchLogGroup)
+
+			return NewAwsLoggingAdapter(s.Aws), nil
+		},
+	})
+}
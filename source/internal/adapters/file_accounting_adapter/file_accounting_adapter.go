@@ -0,0 +1,146 @@
+package file_accounting_adapter
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/triflesoft/portalswan/internal/adapters/adapters"
+	"github.com/triflesoft/portalswan/internal/settings"
+)
+
+type fileAccountingAdapter struct {
+	settings *settings.AppAccountingFileSettings
+	log      adapters.LoggingAdapter
+	mtx      sync.Mutex
+}
+
+func (a *fileAccountingAdapter) getFilePath(day string) string {
+	return filepath.Join(a.settings.DirectoryPath, fmt.Sprintf("sessions-%s.jsonl", day))
+}
+
+// InsertSession appends one JSON line to the file of the day session stopped.
+func (a *fileAccountingAdapter) InsertSession(session *adapters.AccountingSession) {
+	filePath := a.getFilePath(session.Day())
+	lineData, err := json.Marshal(session)
+
+	if err != nil {
+		a.log.LogErrorText("Failed to marshal JSON", "err", err)
+
+		return
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+
+	if err == nil {
+		_, err = file.Write(append(lineData, '\n'))
+
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	if err != nil {
+		a.log.LogErrorText(
+			"Failed to write accounting session",
+			"err", err,
+			"filePath", filePath,
+			"username", session.Username,
+			"acctSessionId", session.AcctSessionId)
+
+		return
+	}
+
+	a.log.LogDebugText(
+		"Wrote accounting session",
+		"filePath", filePath,
+		"username", session.Username,
+		"acctSessionId", session.AcctSessionId)
+}
+
+func (a *fileAccountingAdapter) SelectSessions(username string, from time.Time, to time.Time) ([]*adapters.AccountingSession, error) {
+	sessions := []*adapters.AccountingSession{}
+
+	for _, day := range adapters.AccountingDays(from, to) {
+		filePath := a.getFilePath(day)
+		file, err := os.Open(filePath)
+
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+		for scanner.Scan() {
+			session := &adapters.AccountingSession{}
+
+			// A line may be incomplete if the process crashed while writing it.
+			if err := json.Unmarshal(scanner.Bytes(), session); err != nil {
+				a.log.LogErrorText("Failed to unmarshal accounting session", "err", err, "filePath", filePath)
+				continue
+			}
+
+			if adapters.IsAccountingSessionSelected(session, username, from, to) {
+				sessions = append(sessions, session)
+			}
+		}
+
+		err = scanner.Err()
+		file.Close()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return sessions, nil
+}
+
+func (a *fileAccountingAdapter) Flush() {
+}
+
+func NewFileAccountingAdapter(s *settings.AppAccountingFileSettings, l adapters.LoggingAdapter) *fileAccountingAdapter {
+	if err := os.MkdirAll(s.DirectoryPath, 0700); err != nil {
+		l.LogErrorText(
+			"Failed to create accounting directory",
+			"err", err,
+			"directoryPath", s.DirectoryPath)
+	}
+
+	return &fileAccountingAdapter{
+		settings: s,
+		log:      l,
+	}
+}
+
+func init() {
+	adapters.RegisterAccountingAdapter("file", adapters.AdapterFactory[settings.AppAccountingSettings, adapters.AccountingAdapter]{
+		IsConfigured: func(s *settings.AppAccountingSettings) bool {
+			return s.File != nil
+		},
+		New: func(s *settings.AppAccountingSettings, l adapters.LoggingAdapter) (adapters.AccountingAdapter, error) {
+			if s.File.DirectoryPath == "" {
+				return nil, errors.New("directory_path is required")
+			}
+
+			fmt.Printf("File Accounting Provider\n")
+			fmt.Printf(" Local Directory\n")
+			fmt.Printf("    Directory Path:         '%s'\n", s.File.DirectoryPath)
+
+			return NewFileAccountingAdapter(s.File, l), nil
+		},
+	})
+}
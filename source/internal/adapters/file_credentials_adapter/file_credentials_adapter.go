@@ -0,0 +1,387 @@
+package file_credentials_adapter
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/fernet/fernet-go"
+	"github.com/triflesoft/portalswan/internal/adapters/adapters"
+	"github.com/triflesoft/portalswan/internal/settings"
+)
+
+type fileCredentialsAdapter struct {
+	*adapters.StoredCredentialsAdapter
+	settings *settings.AppCredentialsFileSettings
+	log      adapters.LoggingAdapter
+}
+
+func (a *fileCredentialsAdapter) getObjectKey(username string) string {
+	userhash := sha512.Sum512([]byte(username))
+
+	return hex.EncodeToString(userhash[:])
+}
+
+// Lock files are kept next to credentials files and never deleted, otherwise
+// two processes could end up holding locks on different inodes.
+func (a *fileCredentialsAdapter) lockCredentials(objectKey string, username string, how int) (*os.File, error) {
+	lockPath := filepath.Join(a.settings.DirectoryPath, fmt.Sprintf("%s.lock", objectKey))
+	lockFile, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0600)
+
+	if err != nil {
+		a.log.LogErrorText(
+			"Failed to open credentials lock file",
+			"err", err,
+			"lockPath", lockPath,
+			"username", username)
+
+		return nil, err
+	}
+
+	for {
+		err = syscall.Flock(int(lockFile.Fd()), how)
+
+		if err != syscall.EINTR {
+			break
+		}
+	}
+
+	if err != nil {
+		a.log.LogErrorText(
+			"Failed to lock credentials lock file",
+			"err", err,
+			"lockPath", lockPath,
+			"username", username)
+		lockFile.Close()
+
+		return nil, err
+	}
+
+	return lockFile, nil
+}
+
+func (a *fileCredentialsAdapter) unlockCredentials(lockFile *os.File) {
+	syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+	lockFile.Close()
+}
+
+func (a *fileCredentialsAdapter) getCredentials(objectKey string, username string) (*adapters.VpnUserCredentials, error) {
+	filePath := filepath.Join(a.settings.DirectoryPath, fmt.Sprintf("%s.bin", objectKey))
+	fileData, err := os.ReadFile(filePath)
+
+	if errors.Is(err, os.ErrNotExist) {
+		a.log.LogDebugText(
+			"Credentials file is missing",
+			"filePath", filePath,
+			"username", username)
+
+		return nil, adapters.ErrCredentialsMissing
+	}
+
+	if err != nil {
+		a.log.LogErrorText(
+			"Failed to read credentials file",
+			"err", err,
+			"filePath", filePath,
+			"username", username)
+
+		return nil, err
+	}
+
+	credentials, err := adapters.DecryptVpnUserCredentials(fileData, a.settings.FernetKeys)
+
+	if err != nil {
+		a.log.LogErrorText(
+			"Failed to decrypt credentials",
+			"err", err,
+			"filePath", filePath,
+			"username", username)
+
+		return nil, err
+	}
+
+	a.log.LogDebugText(
+		"Got credentials",
+		"filePath", filePath,
+		"username", username,
+		"ipAddresses", strings.Join(credentials.IpAddresses(), ", "))
+
+	return credentials, nil
+}
+
+func (a *fileCredentialsAdapter) putCredentials(objectKey string, username string, credentials *adapters.VpnUserCredentials) error {
+	filePath := filepath.Join(a.settings.DirectoryPath, fmt.Sprintf("%s.bin", objectKey))
+	fileData, err := credentials.Encrypt(a.settings.FernetKeys[0])
+
+	if err != nil {
+		a.log.LogErrorText(
+			"Failed to encrypt credentials",
+			"err", err,
+			"filePath", filePath,
+			"username", username)
+
+		return err
+	}
+
+	if err := a.writeCredentialsFile(objectKey, username, fileData); err != nil {
+		return err
+	}
+
+	a.log.LogDebugText(
+		"Put credentials",
+		"filePath", filePath,
+		"username", username,
+		"ipAddresses", strings.Join(credentials.IpAddresses(), ", "))
+
+	return nil
+}
+
+// Credentials are written to a temporary file in the same directory and then
+// renamed over the old file, so readers never observe a partially written file.
+func (a *fileCredentialsAdapter) writeCredentialsFile(objectKey string, username string, fileData []byte) error {
+	filePath := filepath.Join(a.settings.DirectoryPath, fmt.Sprintf("%s.bin", objectKey))
+	tempFile, err := os.CreateTemp(a.settings.DirectoryPath, fmt.Sprintf("%s.*.tmp", objectKey))
+
+	if err != nil {
+		a.log.LogErrorText(
+			"Failed to create temporary credentials file",
+			"err", err,
+			"filePath", filePath,
+			"username", username)
+
+		return err
+	}
+
+	tempPath := tempFile.Name()
+	_, err = tempFile.Write(fileData)
+
+	if err == nil {
+		err = tempFile.Sync()
+	}
+
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tempPath, filePath)
+	}
+
+	if err != nil {
+		os.Remove(tempPath)
+		a.log.LogErrorText(
+			"Failed to write credentials file",
+			"err", err,
+			"filePath", filePath,
+			"username", username)
+
+		return err
+	}
+
+	if directory, err := os.Open(a.settings.DirectoryPath); err == nil {
+		directory.Sync()
+		directory.Close()
+	}
+
+	a.log.LogDebugText(
+		"Wrote credentials file",
+		"filePath", filePath,
+		"username", username)
+
+	return nil
+}
+
+func (a *fileCredentialsAdapter) ReadCredentials(username string) (*adapters.VpnUserCredentials, error) {
+	objectKey := a.getObjectKey(username)
+	lockFile, err := a.lockCredentials(objectKey, username, syscall.LOCK_SH)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer a.unlockCredentials(lockFile)
+
+	return a.getCredentials(objectKey, username)
+}
+
+// UpdateCredentials holds exclusive lock from read to write, so that update
+// is applied once.
+func (a *fileCredentialsAdapter) UpdateCredentials(username string, create bool, update func(credentials *adapters.VpnUserCredentials) error) (*adapters.VpnUserCredentials, error) {
+	objectKey := a.getObjectKey(username)
+	lockFile, err := a.lockCredentials(objectKey, username, syscall.LOCK_EX)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer a.unlockCredentials(lockFile)
+
+	credentials, err := a.getCredentials(objectKey, username)
+
+	if errors.Is(err, adapters.ErrCredentialsMissing) && create {
+		credentials = adapters.NewVpnUserCredentials(username)
+		a.log.LogDebugText(
+			"Created new blank credentials, credentials were missing",
+			"vpnUserUsername", username)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := update(credentials); err != nil {
+		return nil, err
+	}
+
+	if err := a.putCredentials(objectKey, username, credentials); err != nil {
+		return nil, err
+	}
+
+	return credentials, nil
+}
+
+func (a *fileCredentialsAdapter) KeyIds() []string {
+	keyIds := make([]string, 0, len(a.settings.FernetKeys))
+
+	for _, key := range a.settings.FernetKeys {
+		keyIds = append(keyIds, adapters.FernetKeyId(key))
+	}
+
+	return keyIds
+}
+
+// rotateObjectKey re-encrypts a single file with the primary key, data is
+// written back as is, without expiring or pruning anything.
+func (a *fileCredentialsAdapter) rotateObjectKey(objectKey string, dryRun bool) (string, bool, error) {
+	lockFile, err := a.lockCredentials(objectKey, "", syscall.LOCK_EX)
+
+	if err != nil {
+		return "", false, err
+	}
+
+	defer a.unlockCredentials(lockFile)
+
+	filePath := filepath.Join(a.settings.DirectoryPath, fmt.Sprintf("%s.bin", objectKey))
+	fileData, err := os.ReadFile(filePath)
+
+	if err != nil {
+		return "", false, err
+	}
+
+	cleartextData, keyIndex := adapters.DecryptWithAnyKey(fileData, a.settings.FernetKeys)
+
+	if keyIndex < 0 {
+		return "", false, errors.New("no configured key decrypts file")
+	}
+
+	if keyIndex == 0 {
+		return adapters.FernetKeyId(a.settings.FernetKeys[0]), false, nil
+	}
+
+	if dryRun {
+		return adapters.FernetKeyId(a.settings.FernetKeys[keyIndex]), true, nil
+	}
+
+	fileData, err = fernet.EncryptAndSign(cleartextData, a.settings.FernetKeys[0])
+
+	if err == nil {
+		err = a.writeCredentialsFile(objectKey, "", fileData)
+	}
+
+	if err != nil {
+		return adapters.FernetKeyId(a.settings.FernetKeys[keyIndex]), false, err
+	}
+
+	a.log.LogDebugText(
+		"Re-encrypted credentials",
+		"filePath", filePath,
+		"keyId", adapters.FernetKeyId(a.settings.FernetKeys[keyIndex]))
+
+	return adapters.FernetKeyId(a.settings.FernetKeys[0]), true, nil
+}
+
+func (a *fileCredentialsAdapter) RotateKeys(options *adapters.RotateKeysOptions) (*adapters.RotateKeysReport, error) {
+	filePaths, err := filepath.Glob(filepath.Join(a.settings.DirectoryPath, "*.bin"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(filePaths)
+	report := adapters.NewRotateKeysReport(options)
+	rotate := func(fileName string) {
+		keyId, reencrypted, err := a.rotateObjectKey(strings.TrimSuffix(fileName, ".bin"), options.DryRun)
+
+		if err != nil {
+			a.log.LogErrorText(
+				"Failed to re-encrypt credentials",
+				"err", err,
+				"filePath", filepath.Join(a.settings.DirectoryPath, fileName))
+		}
+
+		report.Record(fileName, keyId, reencrypted, err)
+
+		if options.Progress != nil {
+			options.Progress(report)
+		}
+	}
+
+	for _, fileName := range report.TakeFailedObjectKeys() {
+		rotate(fileName)
+	}
+
+	for _, filePath := range filePaths {
+		fileName := filepath.Base(filePath)
+
+		if (report.LastObjectKey != "") && (fileName <= report.LastObjectKey) {
+			continue
+		}
+
+		rotate(fileName)
+	}
+
+	return report, nil
+}
+
+func NewFileCredentialsAdapter(
+	s *settings.AppCredentialsFileSettings,
+	policyForClass func(class string) *settings.AppCredentialsPolicySettings,
+	l adapters.LoggingAdapter) *fileCredentialsAdapter {
+	if err := os.MkdirAll(s.DirectoryPath, 0700); err != nil {
+		l.LogErrorText(
+			"Failed to create credentials directory",
+			"err", err,
+			"directoryPath", s.DirectoryPath)
+	}
+
+	a := &fileCredentialsAdapter{
+		settings: s,
+		log:      l,
+	}
+	a.StoredCredentialsAdapter = adapters.NewStoredCredentialsAdapter(a, policyForClass, l)
+
+	return a
+}
+
+func init() {
+	adapters.RegisterCredentialsAdapter("file", adapters.AdapterFactory[settings.AppCredentialsSettings, adapters.CredentialsAdapter]{
+		IsConfigured: func(s *settings.AppCredentialsSettings) bool {
+			return s.File != nil
+		},
+		New: func(s *settings.AppCredentialsSettings, l adapters.LoggingAdapter) (adapters.CredentialsAdapter, error) {
+			if (s.File.DirectoryPath == "") || (len(s.File.FernetKeys) == 0) {
+				return nil, errors.New("directory_path and fernet_keys are required")
+			}
+
+			fmt.Printf("File Credentials Provider\n")
+			fmt.Printf(" Local Directory\n")
+			fmt.Printf("    Directory Path:         '%s'\n", s.File.DirectoryPath)
+
+			return NewFileCredentialsAdapter(s.File, s.PolicyForClass, l), nil
+		},
+	})
+}
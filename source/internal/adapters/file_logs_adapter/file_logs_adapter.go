@@ -0,0 +1,289 @@
+package file_logs_adapter
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/puzpuzpuz/xsync"
+	"github.com/triflesoft/portalswan/internal/adapters/adapters"
+	"github.com/triflesoft/portalswan/internal/settings"
+)
+
+// rotatingFileWriterHandler appends JSON lines to <channel>.jsonl and renames
+// it to <channel>.<timestamp>.jsonl once it grows too large or too old.
+type rotatingFileWriterHandler struct {
+	settings       *settings.AppLoggingFileSettings
+	channel        string
+	path           string
+	mtx            sync.Mutex
+	maintenanceMtx sync.Mutex
+	file           *os.File
+	size           int64
+	openedAt       time.Time
+	logger         *slog.Logger
+	fallbackLogger *slog.Logger
+}
+
+func (h *rotatingFileWriterHandler) open() error {
+	file, err := os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	h.file = file
+	h.size = info.Size()
+	h.openedAt = time.Now()
+
+	if h.size > 0 {
+		h.openedAt = info.ModTime()
+	}
+
+	return nil
+}
+
+func (h *rotatingFileWriterHandler) rotate() {
+	if h.file != nil {
+		h.file.Close()
+		h.file = nil
+	}
+
+	segmentPath := filepath.Join(
+		h.settings.DirectoryPath,
+		fmt.Sprintf("%s.%s.jsonl", h.channel, time.Now().UTC().Format("20060102T150405.000000000")))
+
+	if err := os.Rename(h.path, segmentPath); err != nil {
+		h.fallbackLogger.Error("Failed to rotate log file", "err", err, "path", h.path)
+		return
+	}
+
+	go h.maintainSegments(segmentPath)
+}
+
+func (h *rotatingFileWriterHandler) compressSegment(segmentPath string) {
+	segmentFile, err := os.Open(segmentPath)
+
+	if err != nil {
+		h.fallbackLogger.Error("Failed to open log segment", "err", err, "path", segmentPath)
+		return
+	}
+
+	defer segmentFile.Close()
+
+	compressedPath := segmentPath + ".gz"
+	compressedFile, err := os.OpenFile(compressedPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+
+	if err != nil {
+		h.fallbackLogger.Error("Failed to create compressed log segment", "err", err, "path", compressedPath)
+		return
+	}
+
+	gzipWriter := gzip.NewWriter(compressedFile)
+	_, err = io.Copy(gzipWriter, segmentFile)
+
+	if err == nil {
+		err = gzipWriter.Close()
+	}
+
+	if closeErr := compressedFile.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(compressedPath)
+		h.fallbackLogger.Error("Failed to compress log segment", "err", err, "path", segmentPath)
+		return
+	}
+
+	os.Remove(segmentPath)
+}
+
+func (h *rotatingFileWriterHandler) maintainSegments(segmentPath string) {
+	h.maintenanceMtx.Lock()
+	defer h.maintenanceMtx.Unlock()
+
+	if h.settings.Compress {
+		h.compressSegment(segmentPath)
+	}
+
+	segmentPaths, err := filepath.Glob(filepath.Join(h.settings.DirectoryPath, h.channel+".*.jsonl*"))
+
+	if err != nil {
+		h.fallbackLogger.Error("Failed to list log segments", "err", err, "channel", h.channel)
+		return
+	}
+
+	// Timestamps in segment names sort lexicographically, oldest first.
+	sort.Strings(segmentPaths)
+
+	for i := 0; i < len(segmentPaths)-h.settings.RetentionCount; i++ {
+		if err := os.Remove(segmentPaths[i]); err != nil {
+			h.fallbackLogger.Error("Failed to delete log segment", "err", err, "path", segmentPaths[i])
+		}
+	}
+}
+
+func (h *rotatingFileWriterHandler) Write(p []byte) (n int, err error) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if h.file != nil {
+		if (h.size+int64(len(p)) > h.settings.MaxFileSize) || (time.Since(h.openedAt) > h.settings.MaxFileAge) {
+			h.rotate()
+		}
+	}
+
+	if h.file == nil {
+		if err = h.open(); err != nil {
+			h.fallbackLogger.Error("Failed to open log file", "err", err, "path", h.path)
+			return 0, err
+		}
+	}
+
+	line := p
+
+	if len(p) > 1 && p[len(p)-1] >= 32 {
+		line = make([]byte, len(p)+1)
+		copy(line, p)
+		line[len(p)] = 10
+	}
+
+	written, err := h.file.Write(line)
+	h.size += int64(written)
+
+	if err != nil {
+		h.fallbackLogger.Error("Failed to write log file", "err", err, "path", h.path)
+		return min(written, len(p)), err
+	}
+
+	return len(p), nil
+}
+
+func (h *rotatingFileWriterHandler) flush() {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if h.file != nil {
+		h.file.Sync()
+	}
+}
+
+type fileLoggingAdapter struct {
+	settings       *settings.AppLoggingFileSettings
+	handlers       *xsync.MapOf[string, *rotatingFileWriterHandler]
+	fallbackLogger *slog.Logger
+}
+
+func (a *fileLoggingAdapter) getHandler(channel string) *rotatingFileWriterHandler {
+	handler, _ := a.handlers.LoadOrCompute(channel, func() *rotatingFileWriterHandler {
+		return NewRotatingFileWriterHandler(a, channel, a.fallbackLogger)
+	})
+
+	return handler
+}
+
+func (a *fileLoggingAdapter) LogDebugText(msg string, args ...any) {
+	a.getHandler("Debug").logger.Debug(msg, args...)
+}
+
+func (a *fileLoggingAdapter) LogErrorText(msg string, args ...any) {
+	a.getHandler("Error").logger.Error(msg, args...)
+}
+
+func (a *fileLoggingAdapter) LogInfoText(channel string, msg string, args ...any) {
+	a.getHandler(channel).logger.Info(msg, args...)
+}
+
+func (a *fileLoggingAdapter) LogInfoJson(channel string, msg any) {
+	handler := a.getHandler(channel)
+	messageData, err := json.Marshal(msg)
+
+	if err != nil {
+		a.fallbackLogger.Error("Failed to marshal JSON", "err", err)
+		return
+	}
+
+	handler.Write(messageData)
+}
+
+func (a *fileLoggingAdapter) Flush() {
+	a.handlers.Range(func(channel string, handler *rotatingFileWriterHandler) bool {
+		handler.flush()
+		return true
+	})
+}
+
+func NewRotatingFileWriterHandler(a *fileLoggingAdapter, name string, fallbackLogger *slog.Logger) *rotatingFileWriterHandler {
+	channel := strings.Map(func(r rune) rune {
+		if (r == '/') || (r == '\\') || (r == 0) {
+			return '_'
+		}
+
+		return r
+	}, name)
+
+	handler := &rotatingFileWriterHandler{
+		settings:       a.settings,
+		channel:        channel,
+		path:           filepath.Join(a.settings.DirectoryPath, fmt.Sprintf("%s.jsonl", channel)),
+		logger:         fallbackLogger,
+		fallbackLogger: fallbackLogger,
+	}
+	handler.logger = slog.New(slog.NewJSONHandler(handler, &slog.HandlerOptions{AddSource: false, Level: slog.LevelDebug}))
+
+	return handler
+}
+
+func NewFileLoggingAdapter(s *settings.AppLoggingFileSettings) *fileLoggingAdapter {
+	fallbackLogger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{AddSource: false, Level: slog.LevelDebug}))
+
+	if err := os.MkdirAll(s.DirectoryPath, 0750); err != nil {
+		fallbackLogger.Error("Failed to create log directory", "err", err, "directoryPath", s.DirectoryPath)
+	}
+
+	return &fileLoggingAdapter{
+		settings:       s,
+		handlers:       xsync.NewMapOf[*rotatingFileWriterHandler](),
+		fallbackLogger: fallbackLogger,
+	}
+}
+
+func init() {
+	adapters.RegisterLoggingAdapter("file", adapters.AdapterFactory[settings.AppLoggingSettings, adapters.LoggingAdapter]{
+		IsConfigured: func(s *settings.AppLoggingSettings) bool {
+			return s.File != nil
+		},
+		New: func(s *settings.AppLoggingSettings, _ adapters.LoggingAdapter) (adapters.LoggingAdapter, error) {
+			if s.File.DirectoryPath == "" {
+				return nil, errors.New("directory_path is required")
+			}
+
+			fmt.Printf("File Logging Adapter\n")
+			fmt.Printf(" JSON Lines\n")
+			fmt.Printf("    Directory Path:         '%s'\n", s.File.DirectoryPath)
+			fmt.Printf("    Max File Size:          '%d'\n", s.File.MaxFileSize)
+			fmt.Printf("    Max File Age:           '%s'\n", s.File.MaxFileAge)
+			fmt.Printf("    Retention Count:        '%d'\n", s.File.RetentionCount)
+			fmt.Printf("    Compress:               '%t'\n", s.File.Compress)
+
+			return NewFileLoggingAdapter(s.File), nil
+		},
+	})
+}
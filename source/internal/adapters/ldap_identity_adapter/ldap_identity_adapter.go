@@ -0,0 +1,355 @@
+package ldap_identity_adapter
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	ttlcache "github.com/jellydator/ttlcache/v3"
+	"github.com/triflesoft/portalswan/internal/adapters/adapters"
+	"github.com/triflesoft/portalswan/internal/settings"
+)
+
+type ldapUser struct {
+	Dn       string
+	Username string
+	Email    string
+	MemberOf []string
+}
+
+// ldapConnection is the subset of *ldap.Conn used by the adapter, so that an
+// in-process LDAP stand-in can be plugged in instead of a real server.
+type ldapConnection interface {
+	Bind(username, password string) error
+	Search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close() error
+}
+
+type ldapIdentityAdapter struct {
+	settings         *settings.AppIdentityLdapSettings
+	log              adapters.LoggingAdapter
+	groupNamePattern *regexp.Regexp
+	dial             func() (ldapConnection, error)
+
+	vpnUserCache *ttlcache.Cache[string, *adapters.VpnUser]
+}
+
+func (a *ldapIdentityAdapter) dialLdap() (ldapConnection, error) {
+	serverName := ""
+	serverUrl, err := url.Parse(a.settings.Url)
+
+	if err == nil {
+		serverName = serverUrl.Hostname()
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	conn, err := ldap.DialURL(
+		a.settings.Url,
+		ldap.DialWithDialer(&net.Dialer{Timeout: 10 * time.Second}),
+		ldap.DialWithTLSConfig(tlsConfig))
+
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetTimeout(10 * time.Second)
+
+	if a.settings.StartTls {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+func (a *ldapIdentityAdapter) expandFilter(filter string, username string, dn string) string {
+	return strings.NewReplacer(
+		"{username}", ldap.EscapeFilter(username),
+		"{dn}", ldap.EscapeFilter(dn),
+	).Replace(filter)
+}
+
+func (a *ldapIdentityAdapter) selectLdapUser(conn ldapConnection, username string) *ldapUser {
+	filter := a.expandFilter(a.settings.UserFilter, username, "")
+
+	for _, baseDn := range a.settings.UserBaseDns {
+		searchResult, err := conn.Search(
+			ldap.NewSearchRequest(
+				baseDn,
+				ldap.ScopeWholeSubtree,
+				ldap.NeverDerefAliases,
+				2,
+				10,
+				false,
+				filter,
+				[]string{a.settings.UsernameAttribute, a.settings.EmailAttribute, "memberOf"},
+				nil))
+
+		if err != nil {
+			a.log.LogErrorText(
+				"Failed to search LDAP user",
+				"err", err,
+				"baseDn", baseDn,
+				"filter", filter,
+				"username", username)
+
+			continue
+		}
+
+		if len(searchResult.Entries) == 0 {
+			continue
+		}
+
+		if len(searchResult.Entries) > 1 {
+			a.log.LogErrorText(
+				"Found multiple LDAP users",
+				"baseDn", baseDn,
+				"filter", filter,
+				"username", username)
+
+			return nil
+		}
+
+		entry := searchResult.Entries[0]
+		ldapUser := &ldapUser{
+			Dn:       entry.DN,
+			Username: entry.GetAttributeValue(a.settings.UsernameAttribute),
+			Email:    entry.GetAttributeValue(a.settings.EmailAttribute),
+			MemberOf: entry.GetAttributeValues("memberOf"),
+		}
+
+		if ldapUser.Username == "" {
+			ldapUser.Username = username
+		}
+
+		if ldapUser.Email == "" {
+			ldapUser.Email = ldapUser.Username
+		}
+
+		a.log.LogDebugText(
+			"Found LDAP user",
+			"dn", ldapUser.Dn,
+			"username", ldapUser.Username,
+			"email", ldapUser.Email)
+
+		return ldapUser
+	}
+
+	a.log.LogErrorText(
+		"Failed to find LDAP user",
+		"filter", filter,
+		"username", username)
+
+	return nil
+}
+
+func (a *ldapIdentityAdapter) groupNameFromDn(groupDn string) string {
+	dn, err := ldap.ParseDN(groupDn)
+
+	if (err != nil) || (len(dn.RDNs) == 0) {
+		return groupDn
+	}
+
+	for _, attribute := range dn.RDNs[0].Attributes {
+		if strings.EqualFold(attribute.Type, a.settings.GroupNameAttribute) {
+			return attribute.Value
+		}
+	}
+
+	if len(dn.RDNs[0].Attributes) > 0 {
+		return dn.RDNs[0].Attributes[0].Value
+	}
+
+	return groupDn
+}
+
+func (a *ldapIdentityAdapter) selectLdapGroupNames(conn ldapConnection, ldapUser *ldapUser) []string {
+	groupNames := []string{}
+
+	if len(a.settings.GroupBaseDns) == 0 {
+		for _, groupDn := range ldapUser.MemberOf {
+			groupNames = append(groupNames, a.groupNameFromDn(groupDn))
+		}
+
+		a.log.LogDebugText(
+			"Found LDAP memberships by memberOf",
+			"dn", ldapUser.Dn,
+			"group_names", strings.Join(groupNames, ", "))
+
+		return groupNames
+	}
+
+	filter := a.expandFilter(a.settings.GroupFilter, ldapUser.Username, ldapUser.Dn)
+
+	for _, baseDn := range a.settings.GroupBaseDns {
+		searchResult, err := conn.Search(
+			ldap.NewSearchRequest(
+				baseDn,
+				ldap.ScopeWholeSubtree,
+				ldap.NeverDerefAliases,
+				0,
+				10,
+				false,
+				filter,
+				[]string{a.settings.GroupNameAttribute},
+				nil))
+
+		if err != nil {
+			a.log.LogErrorText(
+				"Failed to search LDAP groups",
+				"err", err,
+				"baseDn", baseDn,
+				"filter", filter,
+				"dn", ldapUser.Dn)
+
+			continue
+		}
+
+		for _, entry := range searchResult.Entries {
+			groupName := entry.GetAttributeValue(a.settings.GroupNameAttribute)
+
+			if groupName == "" {
+				groupName = a.groupNameFromDn(entry.DN)
+			}
+
+			groupNames = append(groupNames, groupName)
+		}
+	}
+
+	a.log.LogDebugText(
+		"Found LDAP memberships by group search",
+		"dn", ldapUser.Dn,
+		"group_names", strings.Join(groupNames, ", "))
+
+	return groupNames
+}
+
+func (a *ldapIdentityAdapter) SelectVpnUser(username string) *adapters.VpnUser {
+	vpnUserCacheItem := a.vpnUserCache.Get(username)
+
+	if vpnUserCacheItem != nil {
+		a.log.LogDebugText(
+			"Found VPN user in cache",
+			"username", username,
+			"username", vpnUserCacheItem.Value().Username,
+			"email", vpnUserCacheItem.Value().Email,
+			"class", vpnUserCacheItem.Value().Class,
+		)
+
+		return vpnUserCacheItem.Value()
+	}
+
+	conn, err := a.dial()
+
+	if err != nil {
+		a.log.LogErrorText(
+			"Failed to connect to LDAP server",
+			"err", err,
+			"url", a.settings.Url)
+
+		return nil
+	}
+
+	defer conn.Close()
+
+	if a.settings.BindDn != "" {
+		if err := conn.Bind(a.settings.BindDn, a.settings.BindPassword); err != nil {
+			a.log.LogErrorText(
+				"Failed to bind to LDAP server",
+				"err", err,
+				"url", a.settings.Url,
+				"bindDn", a.settings.BindDn)
+
+			return nil
+		}
+	}
+
+	username = strings.ToLower(username)
+	ldapUser := a.selectLdapUser(conn, username)
+
+	if ldapUser == nil {
+		return nil
+	}
+
+	groupNames := a.selectLdapGroupNames(conn, ldapUser)
+	radiusClass := "null"
+
+	for _, groupName := range groupNames {
+		match := a.groupNamePattern.FindSubmatch([]byte(groupName))
+
+		if len(match) > 1 {
+			radiusClass = string(match[1])
+			break
+		}
+	}
+
+	vpnUser := &adapters.VpnUser{
+		Username: ldapUser.Username,
+		Email:    ldapUser.Email,
+		Class:    radiusClass,
+	}
+
+	a.vpnUserCache.Set(username, vpnUser, ttlcache.DefaultTTL)
+	a.log.LogDebugText(
+		"Found VPN user",
+		"username", username,
+		"username", vpnUser.Username,
+		"email", vpnUser.Email,
+		"class", vpnUser.Class,
+	)
+
+	return vpnUser
+}
+
+func NewLdapIdentityAdapter(s *settings.AppIdentityLdapSettings, l adapters.LoggingAdapter) *ldapIdentityAdapter {
+	a := &ldapIdentityAdapter{
+		settings:         s,
+		log:              l,
+		groupNamePattern: regexp.MustCompile(s.RadiusClassFromGroupNamePattern),
+		vpnUserCache:     ttlcache.New(ttlcache.WithTTL[string, *adapters.VpnUser](5 * time.Minute)),
+	}
+
+	a.dial = a.dialLdap
+
+	return a
+}
+
+func init() {
+	adapters.RegisterIdentityAdapter("ldap", adapters.AdapterFactory[settings.AppIdentitySettings, adapters.IdentityAdapter]{
+		IsConfigured: func(s *settings.AppIdentitySettings) bool {
+			return s.Ldap != nil
+		},
+		New: func(s *settings.AppIdentitySettings, l adapters.LoggingAdapter) (adapters.IdentityAdapter, error) {
+			if (s.Ldap.Url == "") || (len(s.Ldap.UserBaseDns) == 0) || (s.Ldap.RadiusClassFromGroupNamePattern == "") {
+				return nil, errors.New("url, user_base_dns and radius_class_from_group_name_pattern are required")
+			}
+
+			if _, err := regexp.Compile(s.Ldap.RadiusClassFromGroupNamePattern); err != nil {
+				return nil, fmt.Errorf("radius_class_from_group_name_pattern is invalid: %w", err)
+			}
+
+			fmt.Printf("LDAP Identity Provider\n")
+			fmt.Printf(" LDAP Directory\n")
+			fmt.Printf("    URL:                    '%s'\n", s.Ldap.Url)
+			fmt.Printf("    StartTLS:               '%t'\n", s.Ldap.StartTls)
+			fmt.Printf("    Bind DN:                '%s'\n", s.Ldap.BindDn)
+			fmt.Printf("    User Base DNs:          '%s'\n", strings.Join(s.Ldap.UserBaseDns, "', '"))
+			fmt.Printf("    Group Base DNs:         '%s'\n", strings.Join(s.Ldap.GroupBaseDns, "', '"))
+			fmt.Printf("    Group Name Pattern:     '%s'\n", s.Ldap.RadiusClassFromGroupNamePattern)
+
+			return NewLdapIdentityAdapter(s.Ldap, l), nil
+		},
+	})
+}
@@ -0,0 +1,332 @@
+package ldap_identity_adapter
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/triflesoft/portalswan/internal/adapters/adapters"
+	"github.com/triflesoft/portalswan/internal/settings"
+)
+
+type testLoggingAdapter struct {
+	t *testing.T
+}
+
+func (a *testLoggingAdapter) LogDebugText(msg string, args ...any) {
+	a.t.Log(append([]any{msg}, args...)...)
+}
+
+func (a *testLoggingAdapter) LogErrorText(msg string, args ...any) {
+	a.t.Log(append([]any{"error:", msg}, args...)...)
+}
+
+func (a *testLoggingAdapter) LogInfoText(channel string, msg string, args ...any) {
+}
+
+func (a *testLoggingAdapter) LogInfoJson(channel string, msg any) {
+}
+
+func (a *testLoggingAdapter) Flush() {
+}
+
+// testDirectory is an in-process LDAP stand-in, it evaluates equality,
+// presence, and, or and not filters against entries under base DN.
+type testDirectory struct {
+	entries      []*ldap.Entry
+	bindDn       string
+	bindPassword string
+	boundDn      string
+	searchCount  int
+}
+
+func (d *testDirectory) Bind(username, password string) error {
+	if (username != d.bindDn) || (password != d.bindPassword) {
+		return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+	}
+
+	d.boundDn = username
+
+	return nil
+}
+
+func (d *testDirectory) Search(searchRequest *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	d.searchCount++
+	searchResult := &ldap.SearchResult{}
+
+	for _, entry := range d.entries {
+		if !strings.HasSuffix(strings.ToLower(entry.DN), strings.ToLower(searchRequest.BaseDN)) {
+			continue
+		}
+
+		matches, _, err := matchFilter(entry, searchRequest.Filter)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if matches {
+			searchResult.Entries = append(searchResult.Entries, entry)
+		}
+	}
+
+	return searchResult, nil
+}
+
+func (d *testDirectory) Close() error {
+	return nil
+}
+
+func unescapeFilterValue(value string) string {
+	unescaped := strings.Builder{}
+
+	for i := 0; i < len(value); i++ {
+		if (value[i] == '\\') && (i+2 < len(value)) {
+			if data, err := hex.DecodeString(value[i+1 : i+3]); err == nil {
+				unescaped.Write(data)
+				i += 2
+
+				continue
+			}
+		}
+
+		unescaped.WriteByte(value[i])
+	}
+
+	return unescaped.String()
+}
+
+// matchFilter evaluates filter starting at the beginning of text and returns
+// the rest of text.
+func matchFilter(entry *ldap.Entry, text string) (bool, string, error) {
+	if !strings.HasPrefix(text, "(") {
+		return false, "", errors.New("filter must start with '('")
+	}
+
+	text = text[1:]
+
+	switch {
+	case strings.HasPrefix(text, "&"), strings.HasPrefix(text, "|"):
+		operator := text[0]
+		result := operator == '&'
+		text = text[1:]
+
+		for strings.HasPrefix(text, "(") {
+			var matches bool
+			var err error
+			matches, text, err = matchFilter(entry, text)
+
+			if err != nil {
+				return false, "", err
+			}
+
+			if operator == '&' {
+				result = result && matches
+			} else {
+				result = result || matches
+			}
+		}
+
+		return result, strings.TrimPrefix(text, ")"), nil
+	case strings.HasPrefix(text, "!"):
+		matches, text, err := matchFilter(entry, text[1:])
+
+		return !matches, strings.TrimPrefix(text, ")"), err
+	}
+
+	end := strings.Index(text, ")")
+
+	if end < 0 {
+		return false, "", errors.New("filter is not closed")
+	}
+
+	name, value, found := strings.Cut(text[:end], "=")
+
+	if !found {
+		return false, "", errors.New("filter is invalid")
+	}
+
+	values := entry.GetEqualFoldAttributeValues(name)
+
+	if value == "*" {
+		return len(values) > 0, text[end+1:], nil
+	}
+
+	value = unescapeFilterValue(value)
+
+	for _, entryValue := range values {
+		if strings.EqualFold(entryValue, value) {
+			return true, text[end+1:], nil
+		}
+	}
+
+	return false, text[end+1:], nil
+}
+
+func newTestDirectory() *testDirectory {
+	return &testDirectory{
+		bindDn:       "cn=portalswan,ou=services,dc=example,dc=com",
+		bindPassword: "secret",
+		entries: []*ldap.Entry{
+			// OpenLDAP user with memberOf overlay
+			ldap.NewEntry("uid=alice,ou=people,dc=example,dc=com", map[string][]string{
+				"objectClass": {"person", "inetOrgPerson"},
+				"uid":         {"alice"},
+				"mail":        {"alice@example.com"},
+				"memberOf":    {"cn=staff,ou=groups,dc=example,dc=com", "cn=vpn-admins,ou=groups,dc=example,dc=com"},
+			}),
+			// Active Directory user without uid
+			ldap.NewEntry("CN=Bob Smith,OU=Users,DC=example,DC=com", map[string][]string{
+				"objectClass":    {"top", "person", "user"},
+				"sAMAccountName": {"bob"},
+				"mail":           {"bob.smith@example.com"},
+				"memberOf":       {"CN=vpn-users,OU=Groups,DC=example,DC=com"},
+			}),
+			// User without VPN group and without email
+			ldap.NewEntry("uid=carol,ou=people,dc=example,dc=com", map[string][]string{
+				"objectClass": {"person"},
+				"uid":         {"carol"},
+				"memberOf":    {"cn=staff,ou=groups,dc=example,dc=com"},
+			}),
+			ldap.NewEntry("cn=staff,ou=groups,dc=example,dc=com", map[string][]string{
+				"objectClass": {"groupOfNames"},
+				"cn":          {"staff"},
+				"member":      {"uid=alice,ou=people,dc=example,dc=com", "uid=carol,ou=people,dc=example,dc=com"},
+			}),
+			ldap.NewEntry("cn=vpn-admins,ou=groups,dc=example,dc=com", map[string][]string{
+				"objectClass": {"groupOfNames"},
+				"cn":          {"vpn-admins"},
+				"member":      {"uid=alice,ou=people,dc=example,dc=com"},
+			}),
+			ldap.NewEntry("cn=vpn-contractors,ou=groups,dc=example,dc=com", map[string][]string{
+				"objectClass": {"posixGroup"},
+				"cn":          {"vpn-contractors"},
+				"memberUid":   {"carol"},
+			}),
+		},
+	}
+}
+
+// newTestSettings returns the defaults of identity.ldap settings.
+func newTestSettings(groupBaseDns []string) *settings.AppIdentityLdapSettings {
+	return &settings.AppIdentityLdapSettings{
+		Url:                             "ldap://ldap.example.com",
+		BindDn:                          "cn=portalswan,ou=services,dc=example,dc=com",
+		BindPassword:                    "secret",
+		UserBaseDns:                     []string{"ou=people,dc=example,dc=com", "OU=Users,DC=example,DC=com"},
+		UserFilter:                      "(&(objectClass=person)(|(uid={username})(sAMAccountName={username})(mail={username})))",
+		UsernameAttribute:               "uid",
+		EmailAttribute:                  "mail",
+		GroupBaseDns:                    groupBaseDns,
+		GroupFilter:                     "(|(member={dn})(uniqueMember={dn})(memberUid={username}))",
+		GroupNameAttribute:              "cn",
+		RadiusClassFromGroupNamePattern: "^vpn-(.+)$",
+	}
+}
+
+func newTestAdapter(t *testing.T, s *settings.AppIdentityLdapSettings, directory *testDirectory) *ldapIdentityAdapter {
+	a := NewLdapIdentityAdapter(s, &testLoggingAdapter{t: t})
+	a.dial = func() (ldapConnection, error) {
+		return directory, nil
+	}
+
+	return a
+}
+
+func TestSelectVpnUser(t *testing.T) {
+	testCases := []struct {
+		name         string
+		groupBaseDns []string
+		username     string
+		expected     *adapters.VpnUser
+	}{
+		{"uid and memberOf", nil, "alice", &adapters.VpnUser{Username: "alice", Email: "alice@example.com", Class: "admins"}},
+		{"uid is case insensitive", nil, "Alice", &adapters.VpnUser{Username: "alice", Email: "alice@example.com", Class: "admins"}},
+		{"mail", nil, "alice@example.com", &adapters.VpnUser{Username: "alice", Email: "alice@example.com", Class: "admins"}},
+		{"sAMAccountName and memberOf", nil, "bob", &adapters.VpnUser{Username: "bob", Email: "bob.smith@example.com", Class: "users"}},
+		{"no matching group", nil, "carol", &adapters.VpnUser{Username: "carol", Email: "carol", Class: "null"}},
+		{"member group search", []string{"ou=groups,dc=example,dc=com"}, "alice", &adapters.VpnUser{Username: "alice", Email: "alice@example.com", Class: "admins"}},
+		{"memberUid group search", []string{"ou=groups,dc=example,dc=com"}, "carol", &adapters.VpnUser{Username: "carol", Email: "carol", Class: "contractors"}},
+		{"group search ignores memberOf", []string{"ou=groups,dc=example,dc=com"}, "bob", &adapters.VpnUser{Username: "bob", Email: "bob.smith@example.com", Class: "null"}},
+		{"unknown user", nil, "mallory", nil},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			directory := newTestDirectory()
+			a := newTestAdapter(t, newTestSettings(testCase.groupBaseDns), directory)
+			vpnUser := a.SelectVpnUser(testCase.username)
+
+			if testCase.expected == nil {
+				if vpnUser != nil {
+					t.Fatalf("expected no user, got %+v", vpnUser)
+				}
+
+				return
+			}
+
+			if (vpnUser == nil) || (*vpnUser != *testCase.expected) {
+				t.Fatalf("expected %+v, got %+v", testCase.expected, vpnUser)
+			}
+
+			if directory.boundDn != directory.bindDn {
+				t.Fatalf("expected bind as '%s', got '%s'", directory.bindDn, directory.boundDn)
+			}
+		})
+	}
+}
+
+func TestSelectVpnUserClassPattern(t *testing.T) {
+	s := newTestSettings(nil)
+	s.RadiusClassFromGroupNamePattern = "^(staff)$"
+	a := newTestAdapter(t, s, newTestDirectory())
+
+	if vpnUser := a.SelectVpnUser("alice"); (vpnUser == nil) || (vpnUser.Class != "staff") {
+		t.Fatalf("expected class 'staff', got %+v", vpnUser)
+	}
+}
+
+func TestSelectVpnUserFilterEscaping(t *testing.T) {
+	directory := newTestDirectory()
+	a := newTestAdapter(t, newTestSettings(nil), directory)
+
+	if vpnUser := a.SelectVpnUser("*"); vpnUser != nil {
+		t.Fatalf("expected wildcard username to match nobody, got %+v", vpnUser)
+	}
+
+	if vpnUser := a.SelectVpnUser("alice)(uid=*"); vpnUser != nil {
+		t.Fatalf("expected injected filter to match nobody, got %+v", vpnUser)
+	}
+}
+
+func TestSelectVpnUserBindFailure(t *testing.T) {
+	directory := newTestDirectory()
+	s := newTestSettings(nil)
+	s.BindPassword = "wrong"
+	a := newTestAdapter(t, s, directory)
+
+	if vpnUser := a.SelectVpnUser("alice"); vpnUser != nil {
+		t.Fatalf("expected no user, got %+v", vpnUser)
+	}
+
+	if directory.searchCount != 0 {
+		t.Fatalf("expected no search without bind, got %d", directory.searchCount)
+	}
+}
+
+func TestSelectVpnUserCache(t *testing.T) {
+	directory := newTestDirectory()
+	a := newTestAdapter(t, newTestSettings(nil), directory)
+	a.SelectVpnUser("alice")
+	searchCount := directory.searchCount
+
+	if vpnUser := a.SelectVpnUser("alice"); (vpnUser == nil) || (vpnUser.Class != "admins") {
+		t.Fatalf("expected cached user, got %+v", vpnUser)
+	}
+
+	if directory.searchCount != searchCount {
+		t.Fatalf("expected no search for cached user, got %d searches", directory.searchCount-searchCount)
+	}
+}
@@ -0,0 +1,483 @@
+package smtp_email_adapter
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/triflesoft/portalswan/internal/adapters/adapters"
+	"github.com/triflesoft/portalswan/internal/settings"
+)
+
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && (server.Name != "localhost") && (server.Name != "127.0.0.1") && (server.Name != "::1") {
+		return "", nil, errors.New("unencrypted connection")
+	}
+
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+
+	return nil, fmt.Errorf("unexpected server challenge %q", fromServer)
+}
+
+type base64LineWriter struct {
+	w      io.Writer
+	column int
+}
+
+func (w *base64LineWriter) Write(p []byte) (int, error) {
+	for i := range p {
+		if w.column == 76 {
+			if _, err := w.w.Write([]byte("\r\n")); err != nil {
+				return i, err
+			}
+
+			w.column = 0
+		}
+
+		if _, err := w.w.Write(p[i : i+1]); err != nil {
+			return i, err
+		}
+
+		w.column++
+	}
+
+	return len(p), nil
+}
+
+func writeBase64(w io.Writer, data []byte) error {
+	encoder := base64.NewEncoder(base64.StdEncoding, &base64LineWriter{w: w})
+
+	if _, err := encoder.Write(data); err != nil {
+		return err
+	}
+
+	return encoder.Close()
+}
+
+func writeQuotedPrintable(w io.Writer, text string) error {
+	encoder := quotedprintable.NewWriter(w)
+
+	if _, err := encoder.Write([]byte(text)); err != nil {
+		return err
+	}
+
+	return encoder.Close()
+}
+
+type smtpEmailAdapter struct {
+	settings *settings.AppEmailSmtpSettings
+	log      adapters.LoggingAdapter
+	// Trusted server certificate authorities, system pool if nil.
+	rootCAs *x509.CertPool
+}
+
+// The message has the same structure as one built by SES:
+// multipart/mixed
+// ├── multipart/related
+// │   ├── multipart/alternative (text/plain, text/html)
+// │   └── inline attachments referenced by Content-ID
+// └── regular attachments
+func (a *smtpEmailAdapter) buildMessage(senderAddress string, recipientHeader string, subject string, bodyText string, bodyHtml string, attachments map[string]adapters.EmailAttachment) ([]byte, error) {
+	alternativeData := &bytes.Buffer{}
+	alternativeWriter := multipart.NewWriter(alternativeData)
+
+	for _, body := range []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=UTF-8", bodyText},
+		{"text/html; charset=UTF-8", bodyHtml},
+	} {
+		partWriter, err := alternativeWriter.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {body.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := writeQuotedPrintable(partWriter, body.content); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := alternativeWriter.Close(); err != nil {
+		return nil, err
+	}
+
+	attachmentNames := make([]string, 0, len(attachments))
+
+	for name := range attachments {
+		attachmentNames = append(attachmentNames, name)
+	}
+
+	sort.Strings(attachmentNames)
+
+	relatedData := &bytes.Buffer{}
+	relatedWriter := multipart.NewWriter(relatedData)
+	partWriter, err := relatedWriter.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": alternativeWriter.Boundary()})},
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := partWriter.Write(alternativeData.Bytes()); err != nil {
+		return nil, err
+	}
+
+	for _, name := range attachmentNames {
+		attachment := attachments[name]
+
+		if attachment.ContentID == "" {
+			continue
+		}
+
+		partWriter, err := relatedWriter.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(attachment.ContentType, map[string]string{"name": name})},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("inline", map[string]string{"filename": name})},
+			"Content-Id":                {fmt.Sprintf("<%s>", attachment.ContentID)},
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := writeBase64(partWriter, attachment.Content); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := relatedWriter.Close(); err != nil {
+		return nil, err
+	}
+
+	mixedData := &bytes.Buffer{}
+	mixedWriter := multipart.NewWriter(mixedData)
+	partWriter, err = mixedWriter.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {mime.FormatMediaType("multipart/related", map[string]string{"boundary": relatedWriter.Boundary()})},
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := partWriter.Write(relatedData.Bytes()); err != nil {
+		return nil, err
+	}
+
+	for _, name := range attachmentNames {
+		attachment := attachments[name]
+
+		if attachment.ContentID != "" {
+			continue
+		}
+
+		partWriter, err := mixedWriter.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(attachment.ContentType, map[string]string{"name": name})},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": name})},
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := writeBase64(partWriter, attachment.Content); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mixedWriter.Close(); err != nil {
+		return nil, err
+	}
+
+	messageIdData := make([]byte, 16)
+
+	if _, err := rand.Read(messageIdData); err != nil {
+		return nil, err
+	}
+
+	messageIdDomain := "localhost"
+
+	if at := strings.LastIndex(senderAddress, "@"); at >= 0 {
+		messageIdDomain = senderAddress[at+1:]
+	}
+
+	messageData := &bytes.Buffer{}
+	fmt.Fprintf(messageData, "From: %s\r\n", a.settings.Source)
+	fmt.Fprintf(messageData, "To: %s\r\n", recipientHeader)
+	fmt.Fprintf(messageData, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", subject))
+	fmt.Fprintf(messageData, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(messageData, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(messageIdData), messageIdDomain)
+	fmt.Fprintf(messageData, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(messageData, "Content-Type: %s\r\n", mime.FormatMediaType("multipart/mixed", map[string]string{"boundary": mixedWriter.Boundary()}))
+	fmt.Fprintf(messageData, "\r\n")
+	messageData.Write(mixedData.Bytes())
+
+	return messageData.Bytes(), nil
+}
+
+func (a *smtpEmailAdapter) newClient() (*smtp.Client, error) {
+	address := net.JoinHostPort(a.settings.Host, strconv.Itoa(a.settings.Port))
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	tlsConfig := &tls.Config{
+		ServerName: a.settings.Host,
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    a.rootCAs,
+	}
+
+	var conn net.Conn
+	var err error
+
+	if a.settings.Security == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(5 * time.Minute))
+	client, err := smtp.NewClient(conn, a.settings.Host)
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if hostname, err := os.Hostname(); err == nil {
+		if err := client.Hello(hostname); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	if a.settings.Security == "starttls" {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, errors.New("server does not support STARTTLS")
+		}
+
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	if a.settings.Username != "" {
+		var auth smtp.Auth
+
+		switch a.settings.AuthMechanism {
+		case "login":
+			auth = &loginAuth{
+				username: a.settings.Username,
+				password: a.settings.Password,
+				host:     a.settings.Host,
+			}
+		default:
+			auth = smtp.PlainAuth("", a.settings.Username, a.settings.Password, a.settings.Host)
+		}
+
+		if err := client.Auth(auth); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	return client, nil
+}
+
+func (a *smtpEmailAdapter) SendEmail(recipientAddress string, subject string, bodyText string, bodyHtml string, attachments map[string]adapters.EmailAttachment) {
+	defer func() {
+		if err := recover(); err != nil {
+			a.log.LogErrorText(
+				"Failed to generate email",
+				"err", err,
+				"recipientAddress", recipientAddress,
+				"subject", subject)
+		}
+	}()
+
+	senderAddress := a.settings.Source
+
+	if address, err := mail.ParseAddress(a.settings.Source); err == nil {
+		senderAddress = address.Address
+	}
+
+	// Recipient comes from identity provider, it must not inject headers or
+	// SMTP commands.
+	recipient, err := mail.ParseAddress(recipientAddress)
+
+	if (err != nil) || strings.ContainsAny(recipientAddress, "\r\n") {
+		a.log.LogErrorText(
+			"Failed to send email, recipient address is invalid",
+			"err", err,
+			"recipientAddress", recipientAddress,
+			"subject", subject)
+
+		return
+	}
+
+	recipientAddress = recipient.Address
+	messageData, err := a.buildMessage(senderAddress, recipient.String(), subject, bodyText, bodyHtml, attachments)
+
+	if err != nil {
+		a.log.LogErrorText(
+			"Failed to generate email",
+			"err", err,
+			"recipientAddress", recipientAddress,
+			"subject", subject)
+
+		return
+	}
+
+	client, err := a.newClient()
+
+	if err != nil {
+		a.log.LogErrorText(
+			"Failed to connect to SMTP server",
+			"err", err,
+			"host", a.settings.Host,
+			"port", a.settings.Port,
+			"security", a.settings.Security)
+
+		return
+	}
+
+	defer client.Close()
+
+	if err := client.Mail(senderAddress); err != nil {
+		a.log.LogErrorText(
+			"Failed to send email",
+			"err", err,
+			"recipientAddress", recipientAddress,
+			"subject", subject)
+
+		return
+	}
+
+	if err := client.Rcpt(recipientAddress); err != nil {
+		a.log.LogErrorText(
+			"Failed to send email",
+			"err", err,
+			"recipientAddress", recipientAddress,
+			"subject", subject)
+
+		return
+	}
+
+	dataWriter, err := client.Data()
+
+	if err == nil {
+		_, err = dataWriter.Write(messageData)
+
+		if closeErr := dataWriter.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	if err != nil {
+		a.log.LogErrorText(
+			"Failed to send email",
+			"err", err,
+			"recipientAddress", recipientAddress,
+			"subject", subject)
+
+		return
+	}
+
+	client.Quit()
+
+	a.log.LogDebugText(
+		"Sent email",
+		"recipientAddress", recipientAddress,
+		"subject", subject)
+}
+
+func NewSmtpEmailAdapter(s *settings.AppEmailSmtpSettings, l adapters.LoggingAdapter) *smtpEmailAdapter {
+	return &smtpEmailAdapter{
+		settings: s,
+		log:      l,
+	}
+}
+
+func init() {
+	adapters.RegisterEmailAdapter("smtp", adapters.AdapterFactory[settings.AppEmailSettings, adapters.EmailAdapter]{
+		IsConfigured: func(s *settings.AppEmailSettings) bool {
+			return s.Smtp != nil
+		},
+		New: func(s *settings.AppEmailSettings, l adapters.LoggingAdapter) (adapters.EmailAdapter, error) {
+			if (s.Smtp.Host == "") || (s.Smtp.Source == "") {
+				return nil, errors.New("host and source are required")
+			}
+
+			switch s.Smtp.Security {
+			case "none", "starttls", "tls":
+			default:
+				return nil, fmt.Errorf("security '%s' is not one of 'none', 'starttls', 'tls'", s.Smtp.Security)
+			}
+
+			switch s.Smtp.AuthMechanism {
+			case "plain", "login":
+			default:
+				return nil, fmt.Errorf("auth_mechanism '%s' is not one of 'plain', 'login'", s.Smtp.AuthMechanism)
+			}
+
+			fmt.Printf("SMTP Email Provider\n")
+			fmt.Printf(" SMTP Relay\n")
+			fmt.Printf("    Host:                   '%s'\n", s.Smtp.Host)
+			fmt.Printf("    Port:                   '%d'\n", s.Smtp.Port)
+			fmt.Printf("    Security:               '%s'\n", s.Smtp.Security)
+			fmt.Printf("    Source:                 '%s'\n", s.Smtp.Source)
+
+			return NewSmtpEmailAdapter(s.Smtp, l), nil
+		},
+	})
+}
@@ -0,0 +1,497 @@
+package smtp_email_adapter
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/triflesoft/portalswan/internal/adapters/adapters"
+	"github.com/triflesoft/portalswan/internal/settings"
+)
+
+type testLoggingAdapter struct {
+	t      *testing.T
+	mutex  sync.Mutex
+	errors []string
+}
+
+func (a *testLoggingAdapter) LogDebugText(msg string, args ...any) {
+	a.t.Log(append([]any{msg}, args...)...)
+}
+
+func (a *testLoggingAdapter) LogErrorText(msg string, args ...any) {
+	a.t.Log(append([]any{"error:", msg}, args...)...)
+	a.mutex.Lock()
+	a.errors = append(a.errors, msg)
+	a.mutex.Unlock()
+}
+
+func (a *testLoggingAdapter) LogInfoText(channel string, msg string, args ...any) {
+}
+
+func (a *testLoggingAdapter) LogInfoJson(channel string, msg any) {
+}
+
+func (a *testLoggingAdapter) Flush() {
+}
+
+// smtpSink is a local SMTP server accepting a single message, it supports
+// STARTTLS and AUTH PLAIN and LOGIN.
+type smtpSink struct {
+	t         *testing.T
+	listener  net.Listener
+	tlsConfig *tls.Config
+	username  string
+	password  string
+
+	mutex        sync.Mutex
+	tlsUsed      bool
+	authUsername string
+	authPassword string
+	mailFrom     string
+	rcptTo       []string
+	data         []byte
+	done         chan struct{}
+}
+
+func newTestCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	certificateData, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificate, err := x509.ParseCertificate(certificateData)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(certificate)
+
+	return tls.Certificate{Certificate: [][]byte{certificateData}, PrivateKey: privateKey}, rootCAs
+}
+
+func newSmtpSink(t *testing.T, certificate tls.Certificate) *smtpSink {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sink := &smtpSink{
+		t:         t,
+		listener:  listener,
+		tlsConfig: &tls.Config{Certificates: []tls.Certificate{certificate}},
+		username:  "portalswan",
+		password:  "secret",
+		done:      make(chan struct{}),
+	}
+
+	t.Cleanup(func() { listener.Close() })
+	go sink.serve()
+
+	return sink
+}
+
+func (s *smtpSink) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpSink) serve() {
+	defer close(s.done)
+
+	conn, err := s.listener.Accept()
+
+	if err != nil {
+		return
+	}
+
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	writer := conn
+	reply := func(line string) {
+		fmt.Fprintf(writer, "%s\r\n", line)
+	}
+	readLine := func() string {
+		line, _ := reader.ReadString('\n')
+
+		return strings.TrimRight(line, "\r\n")
+	}
+	decode := func(text string) string {
+		data, _ := base64.StdEncoding.DecodeString(text)
+
+		return string(data)
+	}
+
+	reply("220 sink ESMTP")
+
+	for {
+		line := readLine()
+		command, argument, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(command) {
+		case "EHLO":
+			reply("250-sink")
+
+			if !s.tlsUsed {
+				reply("250-STARTTLS")
+			}
+
+			reply("250 AUTH PLAIN LOGIN")
+		case "STARTTLS":
+			reply("220 ready")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+
+			if err := tlsConn.Handshake(); err != nil {
+				s.t.Log("sink TLS handshake failed:", err)
+				return
+			}
+
+			conn = tlsConn
+			writer = tlsConn
+			reader = bufio.NewReader(tlsConn)
+			s.mutex.Lock()
+			s.tlsUsed = true
+			s.mutex.Unlock()
+		case "AUTH":
+			mechanism, initialResponse, _ := strings.Cut(argument, " ")
+			username, password := "", ""
+
+			switch strings.ToUpper(mechanism) {
+			case "PLAIN":
+				if initialResponse == "" {
+					reply("334 ")
+					initialResponse = readLine()
+				}
+
+				fields := strings.Split(decode(initialResponse), "\x00")
+
+				if len(fields) == 3 {
+					username, password = fields[1], fields[2]
+				}
+			case "LOGIN":
+				reply("334 " + base64.StdEncoding.EncodeToString([]byte("Username:")))
+				username = decode(readLine())
+				reply("334 " + base64.StdEncoding.EncodeToString([]byte("Password:")))
+				password = decode(readLine())
+			}
+
+			s.mutex.Lock()
+			s.authUsername = mechanism + ":" + username
+			s.authPassword = password
+			s.mutex.Unlock()
+
+			if (username != s.username) || (password != s.password) {
+				reply("535 authentication failed")
+				continue
+			}
+
+			reply("235 authenticated")
+		case "MAIL":
+			s.mutex.Lock()
+			s.mailFrom = argument
+			s.mutex.Unlock()
+			reply("250 ok")
+		case "RCPT":
+			s.mutex.Lock()
+			s.rcptTo = append(s.rcptTo, argument)
+			s.mutex.Unlock()
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			data := &bytes.Buffer{}
+
+			for {
+				line, err := reader.ReadString('\n')
+
+				if (err != nil) || (line == ".\r\n") {
+					break
+				}
+
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+
+			s.mutex.Lock()
+			s.data = data.Bytes()
+			s.mutex.Unlock()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		case "":
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func (s *smtpSink) wait(t *testing.T) {
+	select {
+	case <-s.done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("SMTP session did not finish")
+	}
+}
+
+func newTestAdapter(t *testing.T, sink *smtpSink, rootCAs *x509.CertPool, security string, authMechanism string) (*smtpEmailAdapter, *testLoggingAdapter) {
+	log := &testLoggingAdapter{t: t}
+	a := NewSmtpEmailAdapter(&settings.AppEmailSmtpSettings{
+		Host:          "127.0.0.1",
+		Port:          sink.port(),
+		Security:      security,
+		AuthMechanism: authMechanism,
+		Username:      "portalswan",
+		Password:      "secret",
+		Source:        "PortalSwan <vpn@example.com>",
+	}, log)
+	a.rootCAs = rootCAs
+
+	return a, log
+}
+
+var testAttachments = map[string]adapters.EmailAttachment{
+	"logo.png": {
+		Content:     []byte("\x89PNG\r\n\x1a\nlogo"),
+		ContentID:   "logo",
+		ContentType: "image/png",
+	},
+	"portalswan.zip": {
+		Content:     bytes.Repeat([]byte("PK\x03\x04zip"), 64),
+		ContentType: "application/zip",
+	},
+}
+
+type mimePart struct {
+	mediaType string
+	header    map[string][]string
+	content   []byte
+	parts     []*mimePart
+}
+
+func parseMimePart(t *testing.T, header map[string][]string, body io.Reader) *mimePart {
+	mediaType, params, err := mime.ParseMediaType(strings.Join(header["Content-Type"], ""))
+
+	if err != nil {
+		t.Fatalf("invalid Content-Type: %v", err)
+	}
+
+	part := &mimePart{mediaType: mediaType, header: header}
+
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		content, err := io.ReadAll(body)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.EqualFold(strings.Join(header["Content-Transfer-Encoding"], ""), "base64") {
+			content, err = base64.StdEncoding.DecodeString(strings.ReplaceAll(string(content), "\r\n", ""))
+
+			if err != nil {
+				t.Fatalf("invalid base64: %v", err)
+			}
+		}
+
+		part.content = content
+
+		return part
+	}
+
+	multipartReader := multipart.NewReader(body, params["boundary"])
+
+	for {
+		subpart, err := multipartReader.NextRawPart()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		part.parts = append(part.parts, parseMimePart(t, subpart.Header, subpart))
+	}
+
+	return part
+}
+
+func (p *mimePart) mediaTypes() []string {
+	mediaTypes := []string{}
+
+	for _, part := range p.parts {
+		mediaTypes = append(mediaTypes, part.mediaType)
+	}
+
+	return mediaTypes
+}
+
+func TestSendEmail(t *testing.T) {
+	certificate, rootCAs := newTestCertificate(t)
+
+	for _, authMechanism := range []string{"plain", "login"} {
+		t.Run(authMechanism, func(t *testing.T) {
+			sink := newSmtpSink(t, certificate)
+			a, log := newTestAdapter(t, sink, rootCAs, "starttls", authMechanism)
+			a.SendEmail("Alice <alice@example.com>", "Ваш VPN", "text body", "<p>html body</p>", testAttachments)
+			sink.wait(t)
+
+			if len(log.errors) > 0 {
+				t.Fatalf("unexpected errors: %v", log.errors)
+			}
+
+			if !sink.tlsUsed {
+				t.Fatal("expected STARTTLS")
+			}
+
+			if expected := strings.ToUpper(authMechanism) + ":portalswan"; (sink.authUsername != expected) || (sink.authPassword != "secret") {
+				t.Fatalf("expected %s auth, got %s", expected, sink.authUsername)
+			}
+
+			if sink.mailFrom != "FROM:<vpn@example.com>" {
+				t.Fatalf("unexpected MAIL %q", sink.mailFrom)
+			}
+
+			if (len(sink.rcptTo) != 1) || (sink.rcptTo[0] != "TO:<alice@example.com>") {
+				t.Fatalf("unexpected RCPT %q", sink.rcptTo)
+			}
+
+			message, err := mail.ReadMessage(bytes.NewReader(sink.data))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if to := message.Header.Get("To"); to != `"Alice" <alice@example.com>` {
+				t.Fatalf("unexpected To %q", to)
+			}
+
+			if subject, _ := new(mime.WordDecoder).DecodeHeader(message.Header.Get("Subject")); subject != "Ваш VPN" {
+				t.Fatalf("unexpected Subject %q", subject)
+			}
+
+			mixed := parseMimePart(t, message.Header, message.Body)
+
+			if (mixed.mediaType != "multipart/mixed") || (strings.Join(mixed.mediaTypes(), ",") != "multipart/related,application/zip") {
+				t.Fatalf("unexpected mixed part %s %v", mixed.mediaType, mixed.mediaTypes())
+			}
+
+			related := mixed.parts[0]
+
+			if strings.Join(related.mediaTypes(), ",") != "multipart/alternative,image/png" {
+				t.Fatalf("unexpected related part %v", related.mediaTypes())
+			}
+
+			alternative := related.parts[0]
+
+			if strings.Join(alternative.mediaTypes(), ",") != "text/plain,text/html" {
+				t.Fatalf("unexpected alternative part %v", alternative.mediaTypes())
+			}
+
+			if !strings.Contains(string(alternative.parts[1].content), "html body") {
+				t.Fatalf("unexpected HTML body %q", alternative.parts[1].content)
+			}
+
+			logo := related.parts[1]
+
+			if contentId := strings.Join(logo.header["Content-Id"], ""); contentId != "<logo>" {
+				t.Fatalf("unexpected Content-ID %q", contentId)
+			}
+
+			if !strings.HasPrefix(strings.Join(logo.header["Content-Disposition"], ""), "inline") {
+				t.Fatalf("expected inline logo, got %q", logo.header["Content-Disposition"])
+			}
+
+			if !bytes.Equal(logo.content, testAttachments["logo.png"].Content) {
+				t.Fatal("logo content mismatch")
+			}
+
+			zip := mixed.parts[1]
+
+			if disposition := strings.Join(zip.header["Content-Disposition"], ""); disposition != `attachment; filename=portalswan.zip` {
+				t.Fatalf("unexpected ZIP disposition %q", disposition)
+			}
+
+			if !bytes.Equal(zip.content, testAttachments["portalswan.zip"].Content) {
+				t.Fatal("ZIP content mismatch")
+			}
+		})
+	}
+}
+
+func TestSendEmailUntrustedCertificate(t *testing.T) {
+	certificate, _ := newTestCertificate(t)
+	sink := newSmtpSink(t, certificate)
+	a, log := newTestAdapter(t, sink, x509.NewCertPool(), "starttls", "plain")
+	a.SendEmail("alice@example.com", "subject", "text", "html", nil)
+	sink.wait(t)
+
+	if (len(log.errors) != 1) || (log.errors[0] != "Failed to connect to SMTP server") {
+		t.Fatalf("expected connection failure, got %v", log.errors)
+	}
+
+	if sink.authPassword != "" {
+		t.Fatal("password was sent over untrusted connection")
+	}
+}
+
+func TestSendEmailInvalidRecipient(t *testing.T) {
+	for _, recipientAddress := range []string{
+		"alice@example.com\r\nBcc: mallory@example.com",
+		"alice@example.com>\r\nRCPT TO:<mallory@example.com",
+		"not an address",
+	} {
+		certificate, rootCAs := newTestCertificate(t)
+		sink := newSmtpSink(t, certificate)
+		a, log := newTestAdapter(t, sink, rootCAs, "starttls", "plain")
+		a.SendEmail(recipientAddress, "subject", "text", "html", nil)
+		sink.listener.Close()
+		sink.wait(t)
+
+		if (len(log.errors) != 1) || (log.errors[0] != "Failed to send email, recipient address is invalid") {
+			t.Fatalf("expected %q to be rejected, got %v", recipientAddress, log.errors)
+		}
+
+		if len(sink.rcptTo) > 0 {
+			t.Fatalf("expected no RCPT for %q, got %v", recipientAddress, sink.rcptTo)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package stdout_logs_adapter
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+
+	"github.com/puzpuzpuz/xsync"
+	"github.com/triflesoft/portalswan/internal/adapters/adapters"
+	"github.com/triflesoft/portalswan/internal/settings"
+)
+
+// lockedWriter serializes writes of all channels, so that lines written to
+// stdout by concurrent goroutines never interleave.
+type lockedWriter struct {
+	mtx    sync.Mutex
+	writer io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (n int, err error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	return w.writer.Write(p)
+}
+
+type stdoutLoggingAdapter struct {
+	settings *settings.AppLoggingStdoutSettings
+	logger   *slog.Logger
+	loggers  *xsync.MapOf[string, *slog.Logger]
+}
+
+func (a *stdoutLoggingAdapter) getLogger(channel string) *slog.Logger {
+	logger, _ := a.loggers.LoadOrCompute(channel, func() *slog.Logger {
+		return a.logger.With("channel", channel)
+	})
+
+	return logger
+}
+
+func (a *stdoutLoggingAdapter) LogDebugText(msg string, args ...any) {
+	a.getLogger("Debug").Debug(msg, args...)
+}
+
+func (a *stdoutLoggingAdapter) LogErrorText(msg string, args ...any) {
+	a.getLogger("Error").Error(msg, args...)
+}
+
+func (a *stdoutLoggingAdapter) LogInfoText(channel string, msg string, args ...any) {
+	a.getLogger(channel).Info(msg, args...)
+}
+
+func (a *stdoutLoggingAdapter) LogInfoJson(channel string, msg any) {
+	a.getLogger(channel).Info("", "data", msg)
+}
+
+func (a *stdoutLoggingAdapter) Flush() {
+}
+
+func NewStdoutLoggingAdapter(s *settings.AppLoggingStdoutSettings) *stdoutLoggingAdapter {
+	writer := &lockedWriter{writer: os.Stdout}
+
+	return &stdoutLoggingAdapter{
+		settings: s,
+		logger:   slog.New(slog.NewJSONHandler(writer, &slog.HandlerOptions{AddSource: false, Level: slog.LevelDebug})),
+		loggers:  xsync.NewMapOf[*slog.Logger](),
+	}
+}
+
+func init() {
+	adapters.RegisterLoggingAdapter("stdout", adapters.AdapterFactory[settings.AppLoggingSettings, adapters.LoggingAdapter]{
+		IsConfigured: func(s *settings.AppLoggingSettings) bool {
+			return s.Stdout != nil
+		},
+		New: func(s *settings.AppLoggingSettings, _ adapters.LoggingAdapter) (adapters.LoggingAdapter, error) {
+			fmt.Printf("Stdout Logging Adapter\n")
+			fmt.Printf(" JSON Lines\n")
+
+			return NewStdoutLoggingAdapter(s.Stdout), nil
+		},
+	})
+}
@@ -0,0 +1,127 @@
+package commands
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/triflesoft/portalswan/internal/adapters/adapters"
+	"github.com/triflesoft/portalswan/internal/settings"
+)
+
+const accountingUsageDefaultDays = 30
+
+func parseAccountingDay(name string, text string) (time.Time, error) {
+	day, err := time.Parse(time.DateOnly, text)
+
+	if err != nil {
+		return day, fmt.Errorf("-%s '%s' is not a YYYY-MM-DD date", name, text)
+	}
+
+	return day, nil
+}
+
+// accountingUsage prints usage per user and day, or individual sessions with
+// -sessions, of sessions stopped between -from and -to UTC days inclusive.
+func accountingUsage(args []string) int {
+	today := time.Now().UTC().Format(time.DateOnly)
+	flagSet := flag.NewFlagSet("accounting usage", flag.ContinueOnError)
+	username := flagSet.String("username", "", "only sessions of this user")
+	fromText := flagSet.String("from", time.Now().UTC().AddDate(0, 0, 1-accountingUsageDefaultDays).Format(time.DateOnly), "first UTC day, YYYY-MM-DD")
+	toText := flagSet.String("to", today, "last UTC day, YYYY-MM-DD")
+	listSessions := flagSet.Bool("sessions", false, "list sessions instead of daily usage")
+	outputJson := flagSet.Bool("json", false, "print JSON lines instead of a table")
+
+	if err := flagSet.Parse(args); err != nil {
+		return 2
+	}
+
+	from, err := parseAccountingDay("from", *fromText)
+
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		return 2
+	}
+
+	to, err := parseAccountingDay("to", *toText)
+
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		return 2
+	}
+
+	appSettings := settings.NewAppSettings()
+	accountingAdapter, err := adapters.NewAccountingAdapter(appSettings.Accounting, newConsoleLoggingAdapter())
+
+	if err != nil {
+		fmt.Printf("error: failed to configure accounting adapter: %v\n", err)
+		return 1
+	}
+
+	if accountingAdapter == nil {
+		fmt.Printf("error: accounting is not configured\n")
+		return 1
+	}
+
+	sessions, err := accountingAdapter.SelectSessions(*username, from, to.AddDate(0, 0, 1))
+
+	if err != nil {
+		fmt.Printf("error: failed to select sessions: %v\n", err)
+		return 1
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+
+	if *listSessions {
+		if !*outputJson {
+			fmt.Fprintf(writer, "Username\tClass\tStart\tStop\tInput Octets\tOutput Octets\tFramed IP\tCalling Station\tTerminate Cause\t\n")
+		}
+
+		for _, session := range sessions {
+			if *outputJson {
+				encoder.Encode(session)
+			} else {
+				fmt.Fprintf(
+					writer,
+					"%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\t%s\t\n",
+					session.Username,
+					session.Class,
+					session.StartTime.UTC().Format(time.DateTime),
+					session.StopTime.UTC().Format(time.DateTime),
+					session.InputOctets,
+					session.OutputOctets,
+					session.FramedIpAddress,
+					session.CallingStationId,
+					session.TerminateCause)
+			}
+		}
+	} else {
+		if !*outputJson {
+			fmt.Fprintf(writer, "Day\tUsername\tSessions\tSeconds\tInput Octets\tOutput Octets\t\n")
+		}
+
+		for _, usage := range adapters.SummarizeDailyUsage(sessions) {
+			if *outputJson {
+				encoder.Encode(usage)
+			} else {
+				fmt.Fprintf(
+					writer,
+					"%s\t%s\t%d\t%d\t%d\t%d\t\n",
+					usage.Day,
+					usage.Username,
+					usage.SessionCount,
+					usage.SessionSeconds,
+					usage.InputOctets,
+					usage.OutputOctets)
+			}
+		}
+	}
+
+	writer.Flush()
+
+	return 0
+}
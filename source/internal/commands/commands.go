@@ -0,0 +1,64 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+type command struct {
+	usage string
+	run   func(args []string) int
+}
+
+var commands = map[string]command{
+	"accounting usage": {
+		usage: "[-username name] [-from YYYY-MM-DD] [-to YYYY-MM-DD] [-sessions] [-json]",
+		run:   accountingUsage,
+	},
+	"credentials rotate-keys": {
+		usage: "[-dry-run] [-resume] [-checkpoint-path path]",
+		run:   credentialsRotateKeys,
+	},
+	"lockout list": {
+		usage: "",
+		run:   lockoutList,
+	},
+	"lockout unlock": {
+		usage: "[-username name] [-calling-station-id id]",
+		run:   lockoutUnlock,
+	},
+	"session disconnect": {
+		usage: "[-username name] [-virtual-ip address] [-revoke-passwords] [-reason text]",
+		run:   sessionDisconnect,
+	},
+}
+
+// Run executes command given by command line arguments, e.g.
+// "credentials rotate-keys -dry-run", and returns process exit code.
+func Run(args []string) int {
+	for name, command := range commands {
+		nameArgs := strings.Fields(name)
+
+		if (len(args) >= len(nameArgs)) && (strings.Join(args[:len(nameArgs)], " ") == name) {
+			return command.run(args[len(nameArgs):])
+		}
+	}
+
+	names := make([]string, 0, len(commands))
+
+	for name := range commands {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	fmt.Fprintf(os.Stderr, "usage:\n")
+	fmt.Fprintf(os.Stderr, "    portalswan\n")
+
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "    portalswan %s %s\n", name, commands[name].usage)
+	}
+
+	return 2
+}
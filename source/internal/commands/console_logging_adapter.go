@@ -0,0 +1,34 @@
+package commands
+
+import (
+	"log/slog"
+	"os"
+)
+
+// consoleLoggingAdapter reports errors to stderr and drops everything else,
+// adapters are chatty at debug level and commands print their own progress.
+type consoleLoggingAdapter struct {
+	logger *slog.Logger
+}
+
+func (a *consoleLoggingAdapter) LogDebugText(msg string, args ...any) {
+}
+
+func (a *consoleLoggingAdapter) LogErrorText(msg string, args ...any) {
+	a.logger.Error(msg, args...)
+}
+
+func (a *consoleLoggingAdapter) LogInfoText(channel string, msg string, args ...any) {
+}
+
+func (a *consoleLoggingAdapter) LogInfoJson(channel string, msg any) {
+}
+
+func (a *consoleLoggingAdapter) Flush() {
+}
+
+func newConsoleLoggingAdapter() *consoleLoggingAdapter {
+	return &consoleLoggingAdapter{
+		logger: slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{AddSource: false, Level: slog.LevelError})),
+	}
+}
@@ -0,0 +1,220 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/triflesoft/portalswan/internal/adapters/adapters"
+	"github.com/triflesoft/portalswan/internal/settings"
+)
+
+const rotateKeysProgressInterval = 5 * time.Second
+
+type rotateKeysCheckpoint struct {
+	PrimaryKeyId string                     `json:"primary_key_id"`
+	Report       *adapters.RotateKeysReport `json:"report"`
+}
+
+func loadRotateKeysCheckpoint(path string) (*rotateKeysCheckpoint, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoint := &rotateKeysCheckpoint{}
+
+	if err := json.Unmarshal(data, checkpoint); err != nil {
+		return nil, err
+	}
+
+	if checkpoint.Report == nil {
+		return nil, errors.New("checkpoint has no report")
+	}
+
+	return checkpoint, nil
+}
+
+// Checkpoint is written to a temporary file and renamed, so an interrupted
+// run never leaves a truncated checkpoint behind.
+func saveRotateKeysCheckpoint(path string, checkpoint *rotateKeysCheckpoint) error {
+	data, err := json.Marshal(checkpoint)
+
+	if err != nil {
+		return err
+	}
+
+	tempPath := path + ".tmp"
+
+	if err := os.WriteFile(tempPath, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tempPath, path)
+}
+
+func printRotateKeysSummary(report *adapters.RotateKeysReport, keyIds []string, dryRun bool) {
+	fmt.Printf("Objects:                    %d\n", report.ObjectCount)
+
+	if dryRun {
+		fmt.Printf("Would be re-encrypted:      %d\n", report.ReencryptedCount)
+	} else {
+		fmt.Printf("Re-encrypted:               %d\n", report.ReencryptedCount)
+	}
+
+	fmt.Printf("Failed:                     %d\n", report.FailedCount)
+	fmt.Printf("Keys in use:\n")
+
+	for i, keyId := range keyIds {
+		if objectCount := report.KeyIdObjectCounts[keyId]; objectCount > 0 {
+			fmt.Printf("    %s (fernet_keys[%d]): %d objects\n", keyId, i, objectCount)
+		}
+	}
+
+	unknownKeyIds := []string{}
+
+	for keyId := range report.KeyIdObjectCounts {
+		known := false
+
+		for _, knownKeyId := range keyIds {
+			known = known || (keyId == knownKeyId)
+		}
+
+		if !known {
+			unknownKeyIds = append(unknownKeyIds, keyId)
+		}
+	}
+
+	sort.Strings(unknownKeyIds)
+
+	for _, keyId := range unknownKeyIds {
+		fmt.Printf("    %s: %d objects\n", keyId, report.KeyIdObjectCounts[keyId])
+	}
+
+	fmt.Printf("Keys not in use, may be retired:\n")
+
+	for i, keyId := range keyIds {
+		if (i > 0) && (report.KeyIdObjectCounts[keyId] == 0) {
+			fmt.Printf("    %s (fernet_keys[%d])\n", keyId, i)
+		}
+	}
+}
+
+// credentialsRotateKeys re-encrypts all credentials with the primary key.
+// Progress is checkpointed after every object, so an interrupted run can be
+// resumed with -resume.
+func credentialsRotateKeys(args []string) int {
+	flagSet := flag.NewFlagSet("credentials rotate-keys", flag.ContinueOnError)
+	dryRun := flagSet.Bool("dry-run", false, "only decrypt credentials and report keys in use, write nothing")
+	resume := flagSet.Bool("resume", false, "resume interrupted run from checkpoint")
+	checkpointPath := flagSet.String("checkpoint-path", "", "checkpoint file path, '<server.state_directory_path>/credentials-rotate-keys.json' by default")
+
+	if err := flagSet.Parse(args); err != nil {
+		return 2
+	}
+
+	appSettings := settings.NewAppSettings()
+
+	if *checkpointPath == "" {
+		*checkpointPath = filepath.Join(appSettings.StateDirectoryPath(), "credentials-rotate-keys.json")
+	}
+
+	credentialsAdapter, err := adapters.NewCredentialsAdapter(appSettings.Credentials, newConsoleLoggingAdapter())
+
+	if err != nil {
+		fmt.Printf("error: failed to configure credentials adapter: %v\n", err)
+		return 1
+	}
+
+	keyRotator, ok := credentialsAdapter.(adapters.KeyRotatorCredentialsAdapter)
+
+	if !ok {
+		fmt.Printf("error: credentials adapter does not support key rotation\n")
+		return 1
+	}
+
+	keyIds := keyRotator.KeyIds()
+	checkpoint := &rotateKeysCheckpoint{PrimaryKeyId: keyIds[0]}
+
+	if *resume {
+		checkpoint, err = loadRotateKeysCheckpoint(*checkpointPath)
+
+		if err != nil {
+			fmt.Printf("error: failed to load checkpoint '%s': %v\n", *checkpointPath, err)
+			return 1
+		}
+
+		if checkpoint.PrimaryKeyId != keyIds[0] {
+			fmt.Printf("error: checkpoint was made with primary key '%s', primary key is '%s' now\n", checkpoint.PrimaryKeyId, keyIds[0])
+			return 1
+		}
+
+		fmt.Printf("Resuming after '%s', %d objects processed\n", checkpoint.Report.LastObjectKey, checkpoint.Report.ObjectCount)
+	}
+
+	if *dryRun {
+		fmt.Printf("Dry run, nothing will be written\n")
+	}
+
+	fmt.Printf("Primary key:                %s\n", keyIds[0])
+
+	progressAt := time.Now().Add(rotateKeysProgressInterval)
+	checkpointFailed := false
+	report, err := keyRotator.RotateKeys(&adapters.RotateKeysOptions{
+		DryRun: *dryRun,
+		Report: checkpoint.Report,
+		Progress: func(report *adapters.RotateKeysReport) {
+			if !*dryRun && !checkpointFailed {
+				checkpoint.Report = report
+
+				if err := saveRotateKeysCheckpoint(*checkpointPath, checkpoint); err != nil {
+					fmt.Printf("warning: failed to save checkpoint '%s', run cannot be resumed: %v\n", *checkpointPath, err)
+					checkpointFailed = true
+				}
+			}
+
+			if time.Now().After(progressAt) {
+				progressAt = time.Now().Add(rotateKeysProgressInterval)
+				fmt.Printf(
+					"Processed %d objects, re-encrypted %d, failed %d, last '%s'\n",
+					report.ObjectCount,
+					report.ReencryptedCount,
+					report.FailedCount,
+					report.LastObjectKey)
+			}
+		},
+	})
+
+	if err != nil {
+		fmt.Printf("error: failed to rotate keys: %v\n", err)
+
+		if (report != nil) && !*dryRun && !checkpointFailed {
+			fmt.Printf("Run can be resumed with -resume\n")
+		}
+
+		return 1
+	}
+
+	printRotateKeysSummary(report, keyIds, *dryRun)
+
+	// Checkpoint is kept, so that failed objects are retried with -resume
+	if report.FailedCount > 0 {
+		if !*dryRun && !checkpointFailed {
+			fmt.Printf("Failed objects can be retried with -resume\n")
+		}
+
+		return 1
+	}
+
+	if !*dryRun {
+		os.Remove(*checkpointPath)
+	}
+
+	return 0
+}
@@ -0,0 +1,113 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/triflesoft/portalswan/internal/lockout"
+	"github.com/triflesoft/portalswan/internal/settings"
+)
+
+func newLockoutTracker() (*lockout.LockoutTracker, error) {
+	appSettings := settings.NewAppSettings()
+
+	if appSettings.Radius.Lockout == nil {
+		return nil, fmt.Errorf("radius.lockout is not configured")
+	}
+
+	return lockout.NewLockoutTracker(appSettings.Radius.Lockout, appSettings.StateDirectoryPath(), newConsoleLoggingAdapter(), nil)
+}
+
+// lockoutList prints keys with recent failures or lockouts.
+func lockoutList(args []string) int {
+	flagSet := flag.NewFlagSet("lockout list", flag.ContinueOnError)
+
+	if err := flagSet.Parse(args); err != nil {
+		return 2
+	}
+
+	lockoutTracker, err := newLockoutTracker()
+
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		return 1
+	}
+
+	lockouts, err := lockoutTracker.List()
+
+	if err != nil {
+		fmt.Printf("error: failed to load lockouts: %v\n", err)
+		return 1
+	}
+
+	now := time.Now().Unix()
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(writer, "Key\tFailures\tLevel\tLocked Until\n")
+
+	for _, lockout := range lockouts {
+		lockedUntil := "-"
+
+		if lockout.LockedUntil > now {
+			lockedUntil = time.Unix(lockout.LockedUntil, 0).UTC().Format(time.DateTime)
+		}
+
+		fmt.Fprintf(writer, "%s\t%d\t%d\t%s\n", lockout.Key, len(lockout.FailureTimes), lockout.Level, lockedUntil)
+	}
+
+	writer.Flush()
+
+	return 0
+}
+
+// lockoutUnlock forgets failures and lockout level of username and/or
+// Calling-Station-Id, running service picks the change up immediately.
+func lockoutUnlock(args []string) int {
+	flagSet := flag.NewFlagSet("lockout unlock", flag.ContinueOnError)
+	username := flagSet.String("username", "", "username to unlock")
+	callingStationId := flagSet.String("calling-station-id", "", "Calling-Station-Id to unlock")
+
+	if err := flagSet.Parse(args); err != nil {
+		return 2
+	}
+
+	if (*username == "") && (*callingStationId == "") {
+		fmt.Printf("error: -username or -calling-station-id is required\n")
+		return 2
+	}
+
+	lockoutTracker, err := newLockoutTracker()
+
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		return 1
+	}
+
+	keys := map[string]string{
+		lockout.KeyKindUsername:       *username,
+		lockout.KeyKindCallingStation: *callingStationId,
+	}
+
+	for _, kind := range []string{lockout.KeyKindUsername, lockout.KeyKindCallingStation} {
+		if keys[kind] == "" {
+			continue
+		}
+
+		unlocked, err := lockoutTracker.Unlock(kind, keys[kind])
+
+		if err != nil {
+			fmt.Printf("error: failed to unlock %s '%s': %v\n", kind, keys[kind], err)
+			return 1
+		}
+
+		if unlocked {
+			fmt.Printf("Unlocked %s '%s'\n", kind, keys[kind])
+		} else {
+			fmt.Printf("No failures recorded for %s '%s'\n", kind, keys[kind])
+		}
+	}
+
+	return 0
+}
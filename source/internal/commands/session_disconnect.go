@@ -0,0 +1,84 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/triflesoft/portalswan/internal/adapters/adapters"
+	"github.com/triflesoft/portalswan/internal/session_control"
+	"github.com/triflesoft/portalswan/internal/settings"
+)
+
+// sessionDisconnect terminates IKE SAs of user via VICI, must be run on VPN
+// server. Passwords are revoked before termination, so that user cannot
+// reconnect.
+func sessionDisconnect(args []string) int {
+	flagSet := flag.NewFlagSet("session disconnect", flag.ContinueOnError)
+	username := flagSet.String("username", "", "EAP identity of user to disconnect")
+	virtualIp := flagSet.String("virtual-ip", "", "virtual IP address of connection to disconnect")
+	revokePasswords := flagSet.Bool("revoke-passwords", false, "delete all NT passwords of user")
+	reason := flagSet.String("reason", "administrator request", "reason written to log")
+
+	if err := flagSet.Parse(args); err != nil {
+		return 2
+	}
+
+	if (*username == "") && (*virtualIp == "") {
+		fmt.Printf("error: -username or -virtual-ip is required\n")
+		return 2
+	}
+
+	appSettings := settings.NewAppSettings()
+	log := newConsoleLoggingAdapter()
+	var identityAdapter adapters.IdentityAdapter
+	var credentialsAdapter adapters.CredentialsAdapter
+
+	if *revokePasswords {
+		var err error
+		identityAdapter, err = adapters.NewIdentityAdapter(appSettings.Identity, log)
+
+		if err != nil {
+			fmt.Printf("error: failed to configure identity adapter: %v\n", err)
+			return 1
+		}
+
+		credentialsAdapter, err = adapters.NewCredentialsAdapter(appSettings.Credentials, log)
+
+		if err != nil {
+			fmt.Printf("error: failed to configure credentials adapter: %v\n", err)
+			return 1
+		}
+	}
+
+	sessionControl := session_control.NewSessionControl(log, identityAdapter, credentialsAdapter)
+	result, err := sessionControl.Disconnect(&session_control.DisconnectRequest{
+		Selector: session_control.Selector{
+			Username:  *username,
+			VirtualIp: *virtualIp,
+		},
+		RevokePasswords: *revokePasswords,
+		Reason:          *reason,
+	})
+
+	if result != nil {
+		for _, ikeSa := range result.TerminatedIkeSas {
+			fmt.Printf("Terminated IKE SA '%s' #%s of '%s' (%s)\n", ikeSa.Name, ikeSa.UniqueId, ikeSa.Username, strings.Join(ikeSa.VirtualIps, ", "))
+		}
+
+		if len(result.TerminatedIkeSas) == 0 {
+			fmt.Printf("No IKE SAs found\n")
+		}
+
+		if *revokePasswords {
+			fmt.Printf("Revoked NT passwords: %d\n", len(result.RevokedIpAddresses))
+		}
+	}
+
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,419 @@
+package ip_pool
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/triflesoft/portalswan/internal/adapters/adapters"
+	"github.com/triflesoft/portalswan/internal/settings"
+)
+
+const (
+	leaseStateReserved = "reserved"
+	leaseStateActive   = "active"
+	leaseStateReleased = "released"
+)
+
+// Reserved leases were handed out by authorize, but accounting Start has not
+// arrived yet, e.g. because IKE_AUTH failed after RADIUS accepted the user.
+const reservationDuration = 2 * time.Minute
+
+var ErrPoolExhausted = errors.New("pool is exhausted")
+
+// Lease keeps the last user of an address pair, released leases are kept, so
+// that the user gets the same addresses next time unless they were reused.
+type Lease struct {
+	Pool             string `json:"pool"`
+	Username         string `json:"username"`
+	CallingStationId string `json:"calling_station_id"`
+	Ipv4Address      string `json:"ipv4_address,omitempty"`
+	Ipv6Address      string `json:"ipv6_address,omitempty"`
+	State            string `json:"state"`
+	UpdateTime       int64  `json:"update_time"`
+}
+
+type ipPool struct {
+	name          string
+	ipv4Prefix    netip.Prefix
+	ipv6Prefix    netip.Prefix
+	leaseDuration time.Duration
+}
+
+type IpPoolManager struct {
+	settings  *settings.AppRadiusSettings
+	pools     map[string]*ipPool
+	leasePath string
+	log       adapters.LoggingAdapter
+	now       func() time.Time
+
+	mtx       sync.Mutex
+	leases    []*Lease
+	addresses map[string]*Lease
+}
+
+type leasesFile struct {
+	Leases []*Lease `json:"leases"`
+}
+
+func parsePrefix(text string, is4 bool) (netip.Prefix, error) {
+	if text == "" {
+		return netip.Prefix{}, nil
+	}
+
+	prefix, err := netip.ParsePrefix(text)
+
+	if err != nil {
+		return prefix, err
+	}
+
+	if prefix.Addr().Is4() != is4 {
+		return prefix, fmt.Errorf("prefix '%s' is of wrong address family", text)
+	}
+
+	return prefix.Masked(), nil
+}
+
+func (m *IpPoolManager) load() error {
+	fileData, err := os.ReadFile(m.leasePath)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	file := leasesFile{}
+
+	if err := json.Unmarshal(fileData, &file); err != nil {
+		return err
+	}
+
+	for _, lease := range file.Leases {
+		if !m.fits(lease) {
+			m.log.LogDebugText(
+				"Dropped IP lease, it does not fit any pool",
+				"pool", lease.Pool,
+				"username", lease.Username,
+				"ipv4Address", lease.Ipv4Address,
+				"ipv6Address", lease.Ipv6Address)
+
+			continue
+		}
+
+		m.add(lease)
+	}
+
+	return nil
+}
+
+// save writes leases to a temporary file and renames it over the old one, so
+// that a crash never leaves a partially written file behind.
+func (m *IpPoolManager) save() {
+	fileData, err := json.Marshal(&leasesFile{Leases: m.leases})
+
+	if err == nil {
+		tempPath := fmt.Sprintf("%s.tmp", m.leasePath)
+		err = os.WriteFile(tempPath, fileData, 0600)
+
+		if err == nil {
+			err = os.Rename(tempPath, m.leasePath)
+		}
+	}
+
+	if err != nil {
+		m.log.LogErrorText("Failed to save IP leases", "err", err, "leasePath", m.leasePath)
+	}
+}
+
+// fits tells whether lease addresses still belong to its pool, pools may
+// have been changed since the lease was saved.
+func (m *IpPoolManager) fits(lease *Lease) bool {
+	pool, exists := m.pools[lease.Pool]
+
+	if !exists {
+		return false
+	}
+
+	for _, check := range []struct {
+		address string
+		prefix  netip.Prefix
+	}{
+		{lease.Ipv4Address, pool.ipv4Prefix},
+		{lease.Ipv6Address, pool.ipv6Prefix},
+	} {
+		if check.prefix.IsValid() != (check.address != "") {
+			return false
+		}
+
+		if check.address == "" {
+			continue
+		}
+
+		address, err := netip.ParseAddr(check.address)
+
+		if (err != nil) || !check.prefix.Contains(address) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (m *IpPoolManager) add(lease *Lease) {
+	m.leases = append(m.leases, lease)
+
+	if lease.Ipv4Address != "" {
+		m.addresses[lease.Ipv4Address] = lease
+	}
+
+	if lease.Ipv6Address != "" {
+		m.addresses[lease.Ipv6Address] = lease
+	}
+}
+
+func (m *IpPoolManager) expire(now time.Time) {
+	for _, lease := range m.leases {
+		age := now.Sub(time.Unix(lease.UpdateTime, 0))
+
+		if ((lease.State == leaseStateReserved) && (age > reservationDuration)) ||
+			((lease.State == leaseStateActive) && (age > m.pools[lease.Pool].leaseDuration)) {
+			m.log.LogDebugText(
+				"Expired IP lease",
+				"pool", lease.Pool,
+				"username", lease.Username,
+				"ipv4Address", lease.Ipv4Address,
+				"ipv6Address", lease.Ipv6Address,
+				"state", lease.State)
+
+			lease.State = leaseStateReleased
+		}
+	}
+}
+
+// firstFreeAddress skips network address and, for IPv4, broadcast address.
+func (m *IpPoolManager) firstFreeAddress(prefix netip.Prefix) (string, bool) {
+	if !prefix.IsValid() {
+		return "", true
+	}
+
+	for address := prefix.Addr().Next(); prefix.Contains(address); address = address.Next() {
+		if prefix.Addr().Is4() && !prefix.Contains(address.Next()) {
+			break
+		}
+
+		if _, exists := m.addresses[address.String()]; !exists {
+			return address.String(), true
+		}
+	}
+
+	return "", false
+}
+
+// Lease returns addresses for user connecting from calling station, nil if
+// class has no pool. The same user gets the same addresses while they are not
+// taken by another user, a user connecting from several devices at once gets
+// different addresses for each of them.
+func (m *IpPoolManager) Lease(class string, username string, callingStationId string) (*Lease, error) {
+	poolName := m.settings.PoolNameForClass(class)
+
+	if poolName == "" {
+		return nil, nil
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	now := m.now()
+	m.expire(now)
+
+	var selected *Lease
+	var released *Lease
+	var stolen *Lease
+
+	for _, lease := range m.leases {
+		if lease.Pool != poolName {
+			continue
+		}
+
+		if strings.EqualFold(lease.Username, username) {
+			if (lease.State == leaseStateReserved) && (lease.CallingStationId == callingStationId) {
+				selected = lease
+				break
+			}
+
+			if (lease.State == leaseStateReleased) && (released == nil) {
+				released = lease
+			}
+		} else if (lease.State == leaseStateReleased) && ((stolen == nil) || (lease.UpdateTime < stolen.UpdateTime)) {
+			stolen = lease
+		}
+	}
+
+	if selected == nil {
+		selected = released
+	}
+
+	if selected == nil {
+		ipv4Address, ipv4Found := m.firstFreeAddress(m.pools[poolName].ipv4Prefix)
+		ipv6Address, ipv6Found := m.firstFreeAddress(m.pools[poolName].ipv6Prefix)
+
+		if ipv4Found && ipv6Found {
+			selected = &Lease{
+				Pool:        poolName,
+				Ipv4Address: ipv4Address,
+				Ipv6Address: ipv6Address,
+			}
+
+			m.add(selected)
+		}
+	}
+
+	if selected == nil {
+		selected = stolen
+	}
+
+	if selected == nil {
+		m.log.LogErrorText(
+			"Failed to lease IP address, pool is exhausted",
+			"pool", poolName,
+			"username", username)
+
+		return nil, ErrPoolExhausted
+	}
+
+	selected.Username = username
+	selected.CallingStationId = callingStationId
+	selected.State = leaseStateReserved
+	selected.UpdateTime = now.Unix()
+	m.save()
+
+	m.log.LogDebugText(
+		"Leased IP address",
+		"pool", poolName,
+		"username", username,
+		"ipv4Address", selected.Ipv4Address,
+		"ipv6Address", selected.Ipv6Address)
+
+	leaseCopy := *selected
+
+	return &leaseCopy, nil
+}
+
+func (m *IpPoolManager) update(framedIpAddress string, username string, state string) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	lease, exists := m.addresses[framedIpAddress]
+
+	if !exists {
+		return
+	}
+
+	if !strings.EqualFold(lease.Username, username) {
+		m.log.LogErrorText(
+			"Failed to update IP lease, username mismatch",
+			"framedIpAddress", framedIpAddress,
+			"username", username,
+			"leaseUsername", lease.Username)
+
+		return
+	}
+
+	lease.State = state
+	lease.UpdateTime = m.now().Unix()
+	m.save()
+}
+
+// Activate is called on accounting Start and Interim-Update, addresses not
+// leased by PortalSwan are ignored. Usernames are compared case insensitively
+// as identity adapters do, aliases must be resolved by caller.
+func (m *IpPoolManager) Activate(framedIpAddress string, username string) {
+	m.update(framedIpAddress, username, leaseStateActive)
+}
+
+// Release is called on accounting Stop, the lease is kept for the same user.
+func (m *IpPoolManager) Release(framedIpAddress string, username string) {
+	m.update(framedIpAddress, username, leaseStateReleased)
+}
+
+func NewIpPoolManager(s *settings.AppRadiusSettings, stateDirectoryPath string, l adapters.LoggingAdapter) (*IpPoolManager, error) {
+	m := &IpPoolManager{
+		settings:  s,
+		pools:     map[string]*ipPool{},
+		leasePath: filepath.Join(stateDirectoryPath, "ip-leases.json"),
+		log:       l,
+		now:       time.Now,
+		leases:    []*Lease{},
+		addresses: map[string]*Lease{},
+	}
+
+	poolNames := make([]string, 0, len(s.Pools))
+
+	for poolName := range s.Pools {
+		poolNames = append(poolNames, poolName)
+	}
+
+	sort.Strings(poolNames)
+
+	for _, poolName := range poolNames {
+		poolSettings := s.Pools[poolName]
+		ipv4Prefix, err := parsePrefix(poolSettings.Ipv4Prefix, true)
+
+		if err != nil {
+			return nil, fmt.Errorf("radius.pools.%s.ipv4_prefix is invalid: %w", poolName, err)
+		}
+
+		ipv6Prefix, err := parsePrefix(poolSettings.Ipv6Prefix, false)
+
+		if err != nil {
+			return nil, fmt.Errorf("radius.pools.%s.ipv6_prefix is invalid: %w", poolName, err)
+		}
+
+		if !ipv4Prefix.IsValid() && !ipv6Prefix.IsValid() {
+			return nil, fmt.Errorf("radius.pools.%s requires ipv4_prefix or ipv6_prefix", poolName)
+		}
+
+		for _, otherPool := range m.pools {
+			if (ipv4Prefix.IsValid() && otherPool.ipv4Prefix.IsValid() && ipv4Prefix.Overlaps(otherPool.ipv4Prefix)) ||
+				(ipv6Prefix.IsValid() && otherPool.ipv6Prefix.IsValid() && ipv6Prefix.Overlaps(otherPool.ipv6Prefix)) {
+				return nil, fmt.Errorf("radius.pools.%s overlaps radius.pools.%s", poolName, otherPool.name)
+			}
+		}
+
+		m.pools[poolName] = &ipPool{
+			name:          poolName,
+			ipv4Prefix:    ipv4Prefix,
+			ipv6Prefix:    ipv6Prefix,
+			leaseDuration: poolSettings.LeaseDuration,
+		}
+
+		fmt.Printf("IP Pool '%s'\n", poolName)
+		fmt.Printf("    IPv4 Prefix:            '%s'\n", poolSettings.Ipv4Prefix)
+		fmt.Printf("    IPv6 Prefix:            '%s'\n", poolSettings.Ipv6Prefix)
+		fmt.Printf("    Lease Duration:         '%s'\n", poolSettings.LeaseDuration)
+	}
+
+	if len(m.pools) == 0 {
+		return m, nil
+	}
+
+	if err := os.MkdirAll(stateDirectoryPath, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	if err := m.load(); err != nil {
+		return nil, fmt.Errorf("failed to load IP leases: %w", err)
+	}
+
+	return m, nil
+}
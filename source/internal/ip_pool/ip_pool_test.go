@@ -0,0 +1,154 @@
+package ip_pool
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/triflesoft/portalswan/internal/settings"
+)
+
+type testLoggingAdapter struct {
+	t *testing.T
+}
+
+func (a *testLoggingAdapter) LogDebugText(msg string, args ...any) {
+	a.t.Log(append([]any{msg}, args...)...)
+}
+
+func (a *testLoggingAdapter) LogErrorText(msg string, args ...any) {
+	a.t.Log(append([]any{"error:", msg}, args...)...)
+}
+
+func (a *testLoggingAdapter) LogInfoText(channel string, msg string, args ...any) {
+}
+
+func (a *testLoggingAdapter) LogInfoJson(channel string, msg any) {
+}
+
+func (a *testLoggingAdapter) Flush() {
+}
+
+// newTestManager returns manager with a single "*" pool of ipv4Prefix and
+// lease duration of 1 hour, its clock is set by returned function.
+func newTestManager(t *testing.T, ipv4Prefix string, now time.Time) (*IpPoolManager, func(time.Time)) {
+	m, err := NewIpPoolManager(
+		&settings.AppRadiusSettings{
+			Pools: map[string]*settings.AppRadiusPoolSettings{
+				"*": {Ipv4Prefix: ipv4Prefix, LeaseDuration: time.Hour},
+			},
+		},
+		t.TempDir(),
+		&testLoggingAdapter{t: t})
+
+	if err != nil {
+		t.Fatalf("failed to create IP pool manager: %v", err)
+	}
+
+	m.now = func() time.Time { return now }
+
+	return m, func(instant time.Time) { now = instant }
+}
+
+func mustLease(t *testing.T, m *IpPoolManager, username string, callingStationId string) string {
+	lease, err := m.Lease("staff", username, callingStationId)
+
+	if err != nil {
+		t.Fatalf("failed to lease address for '%s': %v", username, err)
+	}
+
+	return lease.Ipv4Address
+}
+
+func TestLeaseActivateIgnoresUsernameCase(t *testing.T) {
+	m, _ := newTestManager(t, "10.0.0.0/29", time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC))
+	address := mustLease(t, m, "alice", "192.0.2.10")
+
+	// Accounting User-Name differs in case from username authorize leased for
+	m.Activate(address, "Alice")
+
+	// Active lease is not handed out again, a second device behind the same
+	// NAT gets another one, a reserved one would be reused
+	if secondAddress := mustLease(t, m, "alice", "192.0.2.10"); secondAddress == address {
+		t.Fatalf("expected active lease %s not to be reused", address)
+	}
+
+	m.Release(address, "ALICE")
+
+	if reusedAddress := mustLease(t, m, "alice", "192.0.2.12"); reusedAddress != address {
+		t.Fatalf("expected released lease %s to be reused, got %s", address, reusedAddress)
+	}
+}
+
+func TestLeaseReuseAndStealOrder(t *testing.T) {
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	m, setNow := newTestManager(t, "10.0.0.0/29", start)
+	testCases := []struct {
+		name             string
+		offset           time.Duration
+		action           string
+		username         string
+		callingStationId string
+		address          string
+		expected         string
+	}{
+		{"first free address", 0, "lease", "alice", "192.0.2.1", "", "10.0.0.1"},
+		{"activate", 0, "activate", "alice", "", "10.0.0.1", ""},
+		{"second address", 0, "lease", "bob", "192.0.2.2", "", "10.0.0.2"},
+		{"reserved lease of the same device is reused", 0, "lease", "bob", "192.0.2.2", "", "10.0.0.2"},
+		{"another device of the same user", 0, "lease", "bob", "192.0.2.3", "", "10.0.0.3"},
+		{"activate first device", 0, "activate", "bob", "", "10.0.0.2", ""},
+		{"activate second device", 0, "activate", "bob", "", "10.0.0.3", ""},
+		{"fourth address", 0, "lease", "carol", "192.0.2.4", "", "10.0.0.4"},
+		{"activate fourth address", 0, "activate", "carol", "", "10.0.0.4", ""},
+		{"fifth address", 0, "lease", "dave", "192.0.2.5", "", "10.0.0.5"},
+		{"activate fifth address", 0, "activate", "dave", "", "10.0.0.5", ""},
+		{"sixth address", 0, "lease", "erin", "192.0.2.6", "", "10.0.0.6"},
+		{"activate sixth address", 0, "activate", "erin", "", "10.0.0.6", ""},
+		{"release of another user is ignored", time.Minute, "release", "alice", "", "10.0.0.4", ""},
+		{"release carol", time.Minute, "release", "carol", "", "10.0.0.4", ""},
+		{"release bob", 2 * time.Minute, "release", "bob", "", "10.0.0.2", ""},
+		{"release dave", 3 * time.Minute, "release", "dave", "", "10.0.0.5", ""},
+		{"own released lease is reused before older ones", 4 * time.Minute, "lease", "Bob", "192.0.2.7", "", "10.0.0.2"},
+		{"oldest released lease is stolen", 4 * time.Minute, "lease", "frank", "192.0.2.8", "", "10.0.0.4"},
+		{"next oldest released lease is stolen", 4 * time.Minute, "lease", "grace", "192.0.2.9", "", "10.0.0.5"},
+		{"active and reserved leases are never stolen", 5 * time.Minute, "lease", "heidi", "192.0.2.10", "", ""},
+		{"expired reservation is stolen", 6*time.Minute + time.Second, "lease", "heidi", "192.0.2.10", "", "10.0.0.2"},
+		{"activate stolen lease", 6*time.Minute + time.Second, "activate", "heidi", "", "10.0.0.2", ""},
+		{"own lease was stolen, another one is stolen", 6*time.Minute + time.Second, "lease", "bob", "192.0.2.7", "", "10.0.0.4"},
+		{"activate another stolen lease", 6*time.Minute + time.Second, "activate", "bob", "", "10.0.0.4", ""},
+		{"expired reservation is reused by its user", 6*time.Minute + time.Second, "lease", "grace", "192.0.2.12", "", "10.0.0.5"},
+		{"activate reused lease", 6*time.Minute + time.Second, "activate", "grace", "", "10.0.0.5", ""},
+		{"active lease does not expire within lease duration", time.Hour, "lease", "ivan", "192.0.2.11", "", ""},
+		{"expired active lease is stolen", time.Hour + time.Second, "lease", "ivan", "192.0.2.11", "", "10.0.0.1"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			setNow(start.Add(testCase.offset))
+
+			switch testCase.action {
+			case "activate":
+				m.Activate(testCase.address, testCase.username)
+				return
+			case "release":
+				m.Release(testCase.address, testCase.username)
+				return
+			}
+
+			lease, err := m.Lease("staff", testCase.username, testCase.callingStationId)
+
+			if testCase.expected == "" {
+				if !errors.Is(err, ErrPoolExhausted) {
+					t.Fatalf("expected exhausted pool, got %+v, %v", lease, err)
+				}
+
+				return
+			}
+
+			if (err != nil) || (lease.Ipv4Address != testCase.expected) || (lease.Username != testCase.username) {
+				t.Fatalf("expected %s for '%s', got %+v, %v", testCase.expected, testCase.username, lease, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,463 @@
+package lockout
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	ttlcache "github.com/jellydator/ttlcache/v3"
+	"github.com/triflesoft/portalswan/internal/adapters/adapters"
+	"github.com/triflesoft/portalswan/internal/settings"
+)
+
+const (
+	KeyKindUsername       = "username"
+	KeyKindCallingStation = "calling-station"
+)
+
+// Authorized requests are remembered for as long as an EAP conversation may
+// take, rejects of other requests were already counted or are policy denials.
+const authorizationTtl = 2 * time.Minute
+
+var ErrLockedOut = errors.New("locked out")
+
+// Lockout is kept per key, e.g. "username:alice" or
+// "calling-station:192.0.2.1". Level is the number of lockouts so far and
+// defines the next lockout duration.
+type Lockout struct {
+	Key          string  `json:"key"`
+	FailureTimes []int64 `json:"failure_times"`
+	Level        int64   `json:"level"`
+	LockedUntil  int64   `json:"locked_until"`
+	UpdateTime   int64   `json:"update_time"`
+}
+
+type lockoutsFile struct {
+	Lockouts []*Lockout `json:"lockouts"`
+}
+
+// LockoutTracker counts failed logins, lockouts are saved to state directory
+// and shared with maintenance commands, which may unlock keys while service
+// is running. Every change is done under file lock and file is reloaded when
+// it was changed by another process.
+type LockoutTracker struct {
+	settings     *settings.AppRadiusLockoutSettings
+	lockoutPath  string
+	log          adapters.LoggingAdapter
+	emailAdapter adapters.EmailAdapter
+	now          func() time.Time
+
+	mtx         sync.Mutex
+	lockouts    map[string]*Lockout
+	fileModTime time.Time
+	fileSize    int64
+	authorized  *ttlcache.Cache[string, struct{}]
+}
+
+func lockoutKey(kind string, value string) string {
+	if kind == KeyKindUsername {
+		value = strings.ToLower(value)
+	}
+
+	return kind + ":" + value
+}
+
+func (t *LockoutTracker) lockoutKeys(username string, callingStationId string) []string {
+	keys := []string{}
+
+	if (username != "") && (t.settings.UsernameMaxFailures > 0) {
+		keys = append(keys, lockoutKey(KeyKindUsername, username))
+	}
+
+	if (callingStationId != "") && (t.settings.CallingStationMaxFailures > 0) {
+		keys = append(keys, lockoutKey(KeyKindCallingStation, callingStationId))
+	}
+
+	return keys
+}
+
+func (t *LockoutTracker) maxFailures(key string) int64 {
+	if strings.HasPrefix(key, KeyKindUsername+":") {
+		return t.settings.UsernameMaxFailures
+	}
+
+	return t.settings.CallingStationMaxFailures
+}
+
+// reload reads file again if it was changed since it was read or written last
+// time by this process.
+func (t *LockoutTracker) reload() error {
+	fileInfo, err := os.Stat(t.lockoutPath)
+
+	if errors.Is(err, os.ErrNotExist) {
+		t.lockouts = map[string]*Lockout{}
+		t.fileModTime = time.Time{}
+		t.fileSize = 0
+
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if fileInfo.ModTime().Equal(t.fileModTime) && (fileInfo.Size() == t.fileSize) {
+		return nil
+	}
+
+	fileData, err := os.ReadFile(t.lockoutPath)
+
+	if err != nil {
+		return err
+	}
+
+	file := lockoutsFile{}
+
+	if err := json.Unmarshal(fileData, &file); err != nil {
+		return err
+	}
+
+	t.lockouts = map[string]*Lockout{}
+
+	for _, lockout := range file.Lockouts {
+		t.lockouts[lockout.Key] = lockout
+	}
+
+	t.fileModTime = fileInfo.ModTime()
+	t.fileSize = fileInfo.Size()
+
+	return nil
+}
+
+// save writes lockouts to a temporary file and renames it over the old one,
+// so that a crash never leaves a partially written file behind.
+func (t *LockoutTracker) save() error {
+	file := lockoutsFile{Lockouts: make([]*Lockout, 0, len(t.lockouts))}
+
+	for _, lockout := range t.lockouts {
+		file.Lockouts = append(file.Lockouts, lockout)
+	}
+
+	sort.Slice(file.Lockouts, func(i, j int) bool { return file.Lockouts[i].Key < file.Lockouts[j].Key })
+
+	fileData, err := json.Marshal(&file)
+
+	if err != nil {
+		return err
+	}
+
+	tempPath := fmt.Sprintf("%s.tmp", t.lockoutPath)
+
+	if err := os.WriteFile(tempPath, fileData, 0600); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tempPath, t.lockoutPath); err != nil {
+		return err
+	}
+
+	fileInfo, err := os.Stat(t.lockoutPath)
+
+	if err != nil {
+		return err
+	}
+
+	t.fileModTime = fileInfo.ModTime()
+	t.fileSize = fileInfo.Size()
+
+	return nil
+}
+
+// update runs change under process and file locks with fresh lockouts, they
+// are saved if change returns true.
+func (t *LockoutTracker) update(change func(now time.Time) bool) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	lockFile, err := os.OpenFile(t.lockoutPath+".lock", os.O_CREATE|os.O_RDWR, 0600)
+
+	if err != nil {
+		return err
+	}
+
+	defer lockFile.Close()
+
+	if err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+
+	defer syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+
+	if err := t.reload(); err != nil {
+		return err
+	}
+
+	if !change(t.now()) {
+		return nil
+	}
+
+	return t.save()
+}
+
+// expired tells whether lockout may be forgotten, level is reset once user
+// stays out of trouble for the longest lockout duration.
+func (t *LockoutTracker) expired(lockout *Lockout, now time.Time) bool {
+	return (lockout.LockedUntil <= now.Unix()) &&
+		(now.Sub(time.Unix(lockout.UpdateTime, 0)) > t.settings.MaxLockoutDuration+t.settings.FailureWindow)
+}
+
+// Check is called before any adapter call, it returns ErrLockedOut if either
+// username or Calling-Station-Id is locked out.
+func (t *LockoutTracker) Check(username string, callingStationId string) error {
+	if t.settings == nil {
+		return nil
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if err := t.reload(); err != nil {
+		t.log.LogErrorText("Failed to load lockouts", "err", err, "lockoutPath", t.lockoutPath)
+	}
+
+	now := t.now()
+
+	for _, key := range t.lockoutKeys(username, callingStationId) {
+		if lockout, exists := t.lockouts[key]; exists && (lockout.LockedUntil > now.Unix()) {
+			return fmt.Errorf("%w, '%s' until %s", ErrLockedOut, key, time.Unix(lockout.LockedUntil, 0).UTC().Format(time.RFC3339))
+		}
+	}
+
+	return nil
+}
+
+// RecordFailure counts failed login, keys reaching max failures within
+// failure window are locked out.
+func (t *LockoutTracker) RecordFailure(username string, callingStationId string) {
+	if t.settings == nil {
+		return
+	}
+
+	lockedOut := []*Lockout{}
+	err := t.update(func(now time.Time) bool {
+		for _, key := range t.lockoutKeys(username, callingStationId) {
+			lockout, exists := t.lockouts[key]
+
+			if !exists || t.expired(lockout, now) {
+				lockout = &Lockout{Key: key}
+				t.lockouts[key] = lockout
+			}
+
+			lockout.UpdateTime = now.Unix()
+
+			if lockout.LockedUntil > now.Unix() {
+				continue
+			}
+
+			failureTimes := []int64{}
+
+			for _, failureTime := range lockout.FailureTimes {
+				if now.Sub(time.Unix(failureTime, 0)) < t.settings.FailureWindow {
+					failureTimes = append(failureTimes, failureTime)
+				}
+			}
+
+			lockout.FailureTimes = append(failureTimes, now.Unix())
+
+			if int64(len(lockout.FailureTimes)) < t.maxFailures(key) {
+				continue
+			}
+
+			lockoutDuration := t.settings.LockoutDuration
+
+			for level := int64(0); (level < lockout.Level) && (lockoutDuration < t.settings.MaxLockoutDuration); level++ {
+				lockoutDuration *= 2
+			}
+
+			lockoutDuration = min(lockoutDuration, t.settings.MaxLockoutDuration)
+			lockout.Level++
+			lockout.LockedUntil = now.Add(lockoutDuration).Unix()
+			lockout.FailureTimes = nil
+			lockedOut = append(lockedOut, lockout)
+		}
+
+		for key, lockout := range t.lockouts {
+			if t.expired(lockout, now) {
+				delete(t.lockouts, key)
+			}
+		}
+
+		return true
+	})
+
+	if err != nil {
+		t.log.LogErrorText("Failed to save lockouts", "err", err, "lockoutPath", t.lockoutPath)
+	}
+
+	for _, lockout := range lockedOut {
+		lockedUntil := time.Unix(lockout.LockedUntil, 0).UTC().Format(time.RFC3339)
+
+		t.log.LogErrorText(
+			"Locked out after failed logins",
+			"key", lockout.Key,
+			"level", lockout.Level,
+			"lockedUntil", lockedUntil,
+			"username", username,
+			"callingStationId", callingStationId)
+
+		if (t.settings.AlertEmail != "") && (t.emailAdapter != nil) {
+			bodyText := fmt.Sprintf(
+				"%s is locked out until %s after failed VPN logins.\n\nUsername: %s\nCalling-Station-Id: %s\nLockout level: %d\n",
+				lockout.Key,
+				lockedUntil,
+				username,
+				callingStationId,
+				lockout.Level)
+
+			go t.emailAdapter.SendEmail(
+				t.settings.AlertEmail,
+				fmt.Sprintf("VPN lockout: %s", lockout.Key),
+				bodyText,
+				fmt.Sprintf("<pre>%s</pre>", html.EscapeString(bodyText)),
+				nil)
+		}
+	}
+}
+
+func authorizationKey(username string, callingStationId string) string {
+	return strings.ToLower(username) + "|" + callingStationId
+}
+
+// RecordAuthorized remembers request accepted by authorize, so that only its
+// reject counts as failed login, see RecordRejected.
+func (t *LockoutTracker) RecordAuthorized(username string, callingStationId string) {
+	if t.settings == nil {
+		return
+	}
+
+	t.authorized.Set(authorizationKey(username, callingStationId), struct{}{}, ttlcache.DefaultTTL)
+}
+
+// RecordRejected counts reject reported by FreeRADIUS post-auth as failed
+// login if authorize accepted the request, i.e. password was wrong. Rejects
+// issued by authorize itself are ignored, failures among them were counted
+// already and policy denials are not failed logins.
+func (t *LockoutTracker) RecordRejected(username string, callingStationId string) bool {
+	if t.settings == nil {
+		return false
+	}
+
+	if item, _ := t.authorized.GetAndDelete(authorizationKey(username, callingStationId)); item == nil {
+		return false
+	}
+
+	t.RecordFailure(username, callingStationId)
+
+	return true
+}
+
+// RecordSuccess forgets failures and lockout level of username, failures of
+// Calling-Station-Id are kept, many users may share an address.
+func (t *LockoutTracker) RecordSuccess(username string) {
+	if (t.settings == nil) || (t.settings.UsernameMaxFailures == 0) {
+		return
+	}
+
+	key := lockoutKey(KeyKindUsername, username)
+	err := t.update(func(now time.Time) bool {
+		if _, exists := t.lockouts[key]; !exists {
+			return false
+		}
+
+		delete(t.lockouts, key)
+
+		return true
+	})
+
+	if err != nil {
+		t.log.LogErrorText("Failed to save lockouts", "err", err, "lockoutPath", t.lockoutPath)
+	}
+}
+
+// List returns lockouts sorted by key, including keys which only have
+// failures and are not locked out.
+func (t *LockoutTracker) List() ([]*Lockout, error) {
+	lockouts := []*Lockout{}
+	err := t.update(func(now time.Time) bool {
+		for _, lockout := range t.lockouts {
+			if !t.expired(lockout, now) {
+				lockouts = append(lockouts, lockout)
+			}
+		}
+
+		return false
+	})
+
+	sort.Slice(lockouts, func(i, j int) bool { return lockouts[i].Key < lockouts[j].Key })
+
+	return lockouts, err
+}
+
+// Unlock removes lockout and failures of key, it returns false if key was not
+// known.
+func (t *LockoutTracker) Unlock(kind string, value string) (bool, error) {
+	key := lockoutKey(kind, value)
+	unlocked := false
+	err := t.update(func(now time.Time) bool {
+		_, unlocked = t.lockouts[key]
+		delete(t.lockouts, key)
+
+		return unlocked
+	})
+
+	return unlocked, err
+}
+
+// NewLockoutTracker returns tracker which does nothing if s is nil.
+func NewLockoutTracker(s *settings.AppRadiusLockoutSettings, stateDirectoryPath string, l adapters.LoggingAdapter, e adapters.EmailAdapter) (*LockoutTracker, error) {
+	t := &LockoutTracker{
+		settings:     s,
+		lockoutPath:  filepath.Join(stateDirectoryPath, "lockouts.json"),
+		log:          l,
+		emailAdapter: e,
+		now:          time.Now,
+		lockouts:     map[string]*Lockout{},
+	}
+
+	if s == nil {
+		return t, nil
+	}
+
+	if s.LockoutDuration > s.MaxLockoutDuration {
+		return nil, errors.New("radius.lockout.lockout_minutes must not exceed max_lockout_minutes")
+	}
+
+	fmt.Printf("Lockout\n")
+	fmt.Printf("    Username Failures:      '%d'\n", s.UsernameMaxFailures)
+	fmt.Printf("    Station Failures:       '%d'\n", s.CallingStationMaxFailures)
+	fmt.Printf("    Failure Window:         '%s'\n", s.FailureWindow)
+	fmt.Printf("    Lockout Duration:       '%s'\n", s.LockoutDuration)
+	fmt.Printf("    Max Lockout Duration:   '%s'\n", s.MaxLockoutDuration)
+	fmt.Printf("    Alert Email:            '%s'\n", s.AlertEmail)
+
+	if err := os.MkdirAll(stateDirectoryPath, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	if err := t.reload(); err != nil {
+		return nil, fmt.Errorf("failed to load lockouts: %w", err)
+	}
+
+	t.authorized = ttlcache.New(ttlcache.WithTTL[string, struct{}](authorizationTtl))
+	go t.authorized.Start()
+
+	return t, nil
+}
@@ -0,0 +1,193 @@
+package lockout
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/triflesoft/portalswan/internal/settings"
+)
+
+type testLoggingAdapter struct {
+	t *testing.T
+}
+
+func (a *testLoggingAdapter) LogDebugText(msg string, args ...any) {
+	a.t.Log(append([]any{msg}, args...)...)
+}
+
+func (a *testLoggingAdapter) LogErrorText(msg string, args ...any) {
+	a.t.Log(append([]any{"error:", msg}, args...)...)
+}
+
+func (a *testLoggingAdapter) LogInfoText(channel string, msg string, args ...any) {
+}
+
+func (a *testLoggingAdapter) LogInfoJson(channel string, msg any) {
+}
+
+func (a *testLoggingAdapter) Flush() {
+}
+
+// newTestTracker returns tracker which locks username out after 2 failures
+// and calling station after 3, its clock is set by returned function.
+func newTestTracker(t *testing.T, now time.Time) (*LockoutTracker, func(time.Time)) {
+	tracker, err := NewLockoutTracker(
+		&settings.AppRadiusLockoutSettings{
+			UsernameMaxFailures:       2,
+			CallingStationMaxFailures: 3,
+			FailureWindow:             10 * time.Minute,
+			LockoutDuration:           time.Minute,
+			MaxLockoutDuration:        4 * time.Minute,
+		},
+		t.TempDir(),
+		&testLoggingAdapter{t: t},
+		nil)
+
+	if err != nil {
+		t.Fatalf("failed to create lockout tracker: %v", err)
+	}
+
+	tracker.now = func() time.Time { return now }
+
+	return tracker, func(instant time.Time) { now = instant }
+}
+
+func TestRecordRejectedCountsAuthorizedRequestsOnly(t *testing.T) {
+	tracker, _ := newTestTracker(t, time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC))
+
+	// Rejects issued by authorize, e.g. schedule denials, are not counted
+	for i := 0; i < 3; i++ {
+		if tracker.RecordRejected("alice", "192.0.2.10") {
+			t.Fatalf("expected reject of unauthorized request not to be counted")
+		}
+	}
+
+	if err := tracker.Check("alice", "192.0.2.10"); err != nil {
+		t.Fatalf("expected no lockout, got %v", err)
+	}
+
+	// Wrong password, authorize accepted and FreeRADIUS rejected, is counted
+	// once, whatever the case of username
+	tracker.RecordAuthorized("alice", "192.0.2.10")
+
+	if !tracker.RecordRejected("Alice", "192.0.2.10") {
+		t.Fatalf("expected reject of authorized request to be counted")
+	}
+
+	if tracker.RecordRejected("alice", "192.0.2.10") {
+		t.Fatalf("expected the second reject not to be counted")
+	}
+
+	tracker.RecordAuthorized("alice", "192.0.2.10")
+	tracker.RecordRejected("alice", "192.0.2.10")
+
+	if err := tracker.Check("alice", "192.0.2.10"); !errors.Is(err, ErrLockedOut) {
+		t.Fatalf("expected lockout after 2 failures, got %v", err)
+	}
+}
+
+func TestRecordFailureEscalation(t *testing.T) {
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	tracker, setNow := newTestTracker(t, start)
+	testCases := []struct {
+		name          string
+		offset        time.Duration
+		failures      int
+		expectedLevel int64
+		expectedUntil time.Duration
+	}{
+		{"below max failures", 0, 1, 0, 0},
+		{"first lockout", 0, 1, 1, time.Minute},
+		{"failures while locked out are ignored", 30 * time.Second, 5, 1, time.Minute},
+		{"second lockout is doubled", 2 * time.Minute, 2, 2, 4 * time.Minute},
+		{"third lockout is doubled", 5 * time.Minute, 2, 3, 9 * time.Minute},
+		{"fourth lockout is capped", 10 * time.Minute, 2, 4, 14 * time.Minute},
+		{"failure after lockout ends", 20 * time.Minute, 1, 4, 14 * time.Minute},
+		{"failures out of window are forgotten", 31 * time.Minute, 1, 4, 14 * time.Minute},
+		{"level is kept within max lockout and failure window", 35 * time.Minute, 1, 5, 39 * time.Minute},
+		{"level is reset after max lockout and failure window", 50 * time.Minute, 2, 1, 51 * time.Minute},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			setNow(start.Add(testCase.offset))
+
+			for i := 0; i < testCase.failures; i++ {
+				tracker.RecordFailure("alice", "")
+			}
+
+			lockouts, err := tracker.List()
+
+			if (err != nil) || (len(lockouts) != 1) {
+				t.Fatalf("expected one lockout, got %+v, %v", lockouts, err)
+			}
+
+			expectedUntil := int64(0)
+
+			if testCase.expectedUntil > 0 {
+				expectedUntil = start.Add(testCase.expectedUntil).Unix()
+			}
+
+			if (lockouts[0].Level != testCase.expectedLevel) || (lockouts[0].LockedUntil != expectedUntil) {
+				t.Fatalf("expected level %d until %d, got %+v", testCase.expectedLevel, expectedUntil, lockouts[0])
+			}
+		})
+	}
+}
+
+func TestCheckExpiry(t *testing.T) {
+	start := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	tracker, setNow := newTestTracker(t, start)
+
+	for i := 0; i < 3; i++ {
+		tracker.RecordFailure("alice", "192.0.2.10")
+	}
+
+	testCases := []struct {
+		name             string
+		offset           time.Duration
+		username         string
+		callingStationId string
+		lockedOut        bool
+	}{
+		{"username", 0, "Alice", "198.51.100.7", true},
+		{"calling station", 0, "bob", "192.0.2.10", true},
+		{"other username and calling station", 0, "bob", "198.51.100.7", false},
+		{"username before lockout ends", 59 * time.Second, "alice", "", true},
+		{"username after lockout ends", time.Minute, "alice", "", false},
+		{"calling station after lockout ends", time.Minute, "", "192.0.2.10", false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			setNow(start.Add(testCase.offset))
+			err := tracker.Check(testCase.username, testCase.callingStationId)
+
+			if testCase.lockedOut != errors.Is(err, ErrLockedOut) {
+				t.Fatalf("expected locked out %t, got %v", testCase.lockedOut, err)
+			}
+		})
+	}
+
+	// Lockout is forgotten once user stays out of trouble long enough
+	setNow(start.Add(15 * time.Minute))
+
+	if lockouts, err := tracker.List(); (err != nil) || (len(lockouts) != 0) {
+		t.Fatalf("expected no lockouts, got %+v, %v", lockouts, err)
+	}
+}
+
+func TestRecordSuccess(t *testing.T) {
+	tracker, _ := newTestTracker(t, time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC))
+	tracker.RecordFailure("alice", "192.0.2.10")
+	tracker.RecordFailure("alice", "192.0.2.10")
+	tracker.RecordSuccess("ALICE")
+
+	lockouts, err := tracker.List()
+
+	// Failures of calling station are kept, many users may share an address
+	if (err != nil) || (len(lockouts) != 1) || (lockouts[0].Key != "calling-station:192.0.2.10") || (len(lockouts[0].FailureTimes) != 2) {
+		t.Fatalf("expected calling station failures only, got %+v, %v", lockouts, err)
+	}
+}
@@ -0,0 +1,370 @@
+package quota
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/triflesoft/portalswan/internal/adapters/adapters"
+	"github.com/triflesoft/portalswan/internal/settings"
+)
+
+const (
+	PeriodDaily   = "daily"
+	PeriodMonthly = "monthly"
+)
+
+const (
+	dayLayout   = "2006-01-02"
+	monthLayout = "2006-01"
+)
+
+// Sessions without accounting updates for this long are forgotten, their Stop
+// was lost.
+const sessionRetention = 7 * 24 * time.Hour
+
+var ErrQuotaExceeded = errors.New("quota is exceeded")
+
+type sessionUsage struct {
+	Octets     int64 `json:"octets"`
+	UpdateTime int64 `json:"update_time"`
+}
+
+// Usage accumulates octets of all sessions of a user, octets are counted in
+// the day and month accounting reported them. Sessions keep the last reported
+// octets, so that only the difference is added by the next report.
+type Usage struct {
+	Class              string                   `json:"class"`
+	Day                string                   `json:"day"`
+	DayOctets          int64                    `json:"day_octets"`
+	DayWarnedPercent   int64                    `json:"day_warned_percent"`
+	Month              string                   `json:"month"`
+	MonthOctets        int64                    `json:"month_octets"`
+	MonthWarnedPercent int64                    `json:"month_warned_percent"`
+	Sessions           map[string]*sessionUsage `json:"sessions"`
+}
+
+type Warning struct {
+	Period     string
+	Percent    int64
+	UsedBytes  int64
+	LimitBytes int64
+}
+
+// Status is the result of accounting update, warnings are reported once per
+// threshold and period.
+type Status struct {
+	Warnings []Warning
+	Exceeded bool
+}
+
+// Remaining bytes are nil for unlimited periods.
+type Remaining struct {
+	DailyBytes   *int64
+	MonthlyBytes *int64
+}
+
+type QuotaManager struct {
+	settings  *settings.AppRadiusSettings
+	usagePath string
+	log       adapters.LoggingAdapter
+	now       func() time.Time
+
+	mtx    sync.Mutex
+	usages map[string]*Usage
+}
+
+type usagesFile struct {
+	Usages map[string]*Usage `json:"usages"`
+}
+
+func (m *QuotaManager) load() error {
+	fileData, err := os.ReadFile(m.usagePath)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	file := usagesFile{}
+
+	if err := json.Unmarshal(fileData, &file); err != nil {
+		return err
+	}
+
+	for username, usage := range file.Usages {
+		if usage.Sessions == nil {
+			usage.Sessions = map[string]*sessionUsage{}
+		}
+
+		// Usages saved before keys were folded may differ in case only
+		if existing, exists := m.usages[usageKey(username)]; exists {
+			m.log.LogErrorText("Dropped duplicate quota usage", "username", username, "existingMonthOctets", existing.MonthOctets, "monthOctets", usage.MonthOctets)
+
+			if usage.MonthOctets < existing.MonthOctets {
+				continue
+			}
+		}
+
+		m.usages[usageKey(username)] = usage
+	}
+
+	return nil
+}
+
+// save writes usages to a temporary file and renames it over the old one, so
+// that a crash never leaves a partially written file behind.
+func (m *QuotaManager) save() {
+	fileData, err := json.Marshal(&usagesFile{Usages: m.usages})
+
+	if err == nil {
+		tempPath := fmt.Sprintf("%s.tmp", m.usagePath)
+		err = os.WriteFile(tempPath, fileData, 0600)
+
+		if err == nil {
+			err = os.Rename(tempPath, m.usagePath)
+		}
+	}
+
+	if err != nil {
+		m.log.LogErrorText("Failed to save quota usages", "err", err, "usagePath", m.usagePath)
+	}
+}
+
+// usageKey folds case, identity adapters compare usernames case insensitively.
+func usageKey(username string) string {
+	return strings.ToLower(username)
+}
+
+// usage returns usage of user with counters of past periods reset.
+func (m *QuotaManager) usage(username string, now time.Time) *Usage {
+	usage, exists := m.usages[usageKey(username)]
+
+	if !exists {
+		usage = &Usage{
+			Sessions: map[string]*sessionUsage{},
+		}
+		m.usages[usageKey(username)] = usage
+	}
+
+	day := now.UTC().Format(dayLayout)
+	month := now.UTC().Format(monthLayout)
+
+	if usage.Day != day {
+		usage.Day = day
+		usage.DayOctets = 0
+		usage.DayWarnedPercent = 0
+	}
+
+	if usage.Month != month {
+		usage.Month = month
+		usage.MonthOctets = 0
+		usage.MonthWarnedPercent = 0
+	}
+
+	for sessionId, session := range usage.Sessions {
+		if now.Sub(time.Unix(session.UpdateTime, 0)) > sessionRetention {
+			delete(usage.Sessions, sessionId)
+		}
+	}
+
+	return usage
+}
+
+func exceeded(usedBytes int64, limitBytes int64) bool {
+	return (limitBytes > 0) && (usedBytes >= limitBytes)
+}
+
+// warning returns the highest threshold crossed since the last warning.
+func warning(period string, usedBytes int64, limitBytes int64, warningPercents []int64, warnedPercent *int64) []Warning {
+	if limitBytes <= 0 {
+		return nil
+	}
+
+	crossedPercent := int64(0)
+
+	for _, percent := range warningPercents {
+		if (percent > *warnedPercent) && (percent > crossedPercent) && (usedBytes*100 >= limitBytes*percent) {
+			crossedPercent = percent
+		}
+	}
+
+	if crossedPercent == 0 {
+		return nil
+	}
+
+	*warnedPercent = crossedPercent
+
+	return []Warning{{
+		Period:     period,
+		Percent:    crossedPercent,
+		UsedBytes:  usedBytes,
+		LimitBytes: limitBytes,
+	}}
+}
+
+func (m *QuotaManager) Enabled() bool {
+	return len(m.settings.Quotas) > 0
+}
+
+// Check is called on authorize, it returns ErrQuotaExceeded if user has used
+// up daily or monthly quota of class. Username must be canonical, as accounting
+// keeps it, aliases are not resolved here.
+func (m *QuotaManager) Check(username string, class string) error {
+	quota := m.settings.QuotaForClass(class)
+
+	if quota == nil {
+		return nil
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	usage := m.usage(username, m.now())
+	usage.Class = class
+
+	if exceeded(usage.DayOctets, quota.DailyBytes) {
+		return fmt.Errorf("%w, %d of %d daily bytes used", ErrQuotaExceeded, usage.DayOctets, quota.DailyBytes)
+	}
+
+	if exceeded(usage.MonthOctets, quota.MonthlyBytes) {
+		return fmt.Errorf("%w, %d of %d monthly bytes used", ErrQuotaExceeded, usage.MonthOctets, quota.MonthlyBytes)
+	}
+
+	return nil
+}
+
+// Update is called on accounting Interim-Update and Stop with total octets of
+// the session in both directions. Late updates with fewer octets are ignored.
+func (m *QuotaManager) Update(username string, class string, sessionId string, octets int64, stopped bool) *Status {
+	status := &Status{}
+	quota := m.settings.QuotaForClass(class)
+
+	if quota == nil {
+		return status
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	now := m.now()
+	usage := m.usage(username, now)
+	usage.Class = class
+	session, exists := usage.Sessions[sessionId]
+
+	if !exists {
+		session = &sessionUsage{}
+		usage.Sessions[sessionId] = session
+	}
+
+	if octets > session.Octets {
+		usage.DayOctets += octets - session.Octets
+		usage.MonthOctets += octets - session.Octets
+		session.Octets = octets
+	}
+
+	session.UpdateTime = now.Unix()
+
+	if stopped {
+		delete(usage.Sessions, sessionId)
+	}
+
+	status.Warnings = append(status.Warnings, warning(PeriodDaily, usage.DayOctets, quota.DailyBytes, quota.WarningPercents, &usage.DayWarnedPercent)...)
+	status.Warnings = append(status.Warnings, warning(PeriodMonthly, usage.MonthOctets, quota.MonthlyBytes, quota.WarningPercents, &usage.MonthWarnedPercent)...)
+	status.Exceeded = exceeded(usage.DayOctets, quota.DailyBytes) || exceeded(usage.MonthOctets, quota.MonthlyBytes)
+
+	m.save()
+
+	return status
+}
+
+// Remaining uses class user had on the last authorize or accounting update.
+func (m *QuotaManager) Remaining(username string) *Remaining {
+	remaining := &Remaining{}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	usage, exists := m.usages[usageKey(username)]
+
+	if !exists {
+		return remaining
+	}
+
+	quota := m.settings.QuotaForClass(usage.Class)
+
+	if quota == nil {
+		return remaining
+	}
+
+	usage = m.usage(username, m.now())
+
+	if quota.DailyBytes > 0 {
+		remaining.DailyBytes = new(int64)
+		*remaining.DailyBytes = max(quota.DailyBytes-usage.DayOctets, 0)
+	}
+
+	if quota.MonthlyBytes > 0 {
+		remaining.MonthlyBytes = new(int64)
+		*remaining.MonthlyBytes = max(quota.MonthlyBytes-usage.MonthOctets, 0)
+	}
+
+	return remaining
+}
+
+func NewQuotaManager(s *settings.AppRadiusSettings, stateDirectoryPath string, l adapters.LoggingAdapter) (*QuotaManager, error) {
+	m := &QuotaManager{
+		settings:  s,
+		usagePath: filepath.Join(stateDirectoryPath, "quota-usages.json"),
+		log:       l,
+		now:       time.Now,
+		usages:    map[string]*Usage{},
+	}
+
+	classes := make([]string, 0, len(s.Quotas))
+
+	for class := range s.Quotas {
+		classes = append(classes, class)
+	}
+
+	sort.Strings(classes)
+
+	for _, class := range classes {
+		quota := s.Quotas[class]
+
+		for _, percent := range quota.WarningPercents {
+			if (percent <= 0) || (percent >= 100) {
+				return nil, fmt.Errorf("radius.quotas.%s.warning_percents must be between 0 and 100", class)
+			}
+		}
+
+		fmt.Printf("Quota '%s'\n", class)
+		fmt.Printf("    Daily Bytes:            '%d'\n", quota.DailyBytes)
+		fmt.Printf("    Monthly Bytes:          '%d'\n", quota.MonthlyBytes)
+		fmt.Printf("    Warning Percents:       '%v'\n", quota.WarningPercents)
+		fmt.Printf("    Interim Interval:       '%s'\n", quota.InterimInterval)
+	}
+
+	if len(s.Quotas) == 0 {
+		return m, nil
+	}
+
+	if err := os.MkdirAll(stateDirectoryPath, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	if err := m.load(); err != nil {
+		return nil, fmt.Errorf("failed to load quota usages: %w", err)
+	}
+
+	return m, nil
+}
@@ -0,0 +1,146 @@
+package quota
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/triflesoft/portalswan/internal/settings"
+)
+
+type testLoggingAdapter struct {
+	t *testing.T
+}
+
+func (a *testLoggingAdapter) LogDebugText(msg string, args ...any) {
+	a.t.Log(append([]any{msg}, args...)...)
+}
+
+func (a *testLoggingAdapter) LogErrorText(msg string, args ...any) {
+	a.t.Log(append([]any{"error:", msg}, args...)...)
+}
+
+func (a *testLoggingAdapter) LogInfoText(channel string, msg string, args ...any) {
+}
+
+func (a *testLoggingAdapter) LogInfoJson(channel string, msg any) {
+}
+
+func (a *testLoggingAdapter) Flush() {
+}
+
+// newTestManager returns manager with "staff" quota of 100 daily and 220
+// monthly bytes, its clock is set by returned function.
+func newTestManager(t *testing.T, stateDirectoryPath string, now time.Time) (*QuotaManager, func(time.Time)) {
+	m, err := NewQuotaManager(
+		&settings.AppRadiusSettings{
+			Quotas: map[string]*settings.AppRadiusQuotaSettings{
+				"staff": {DailyBytes: 100, MonthlyBytes: 220, WarningPercents: []int64{80, 95}},
+			},
+		},
+		stateDirectoryPath,
+		&testLoggingAdapter{t: t})
+
+	if err != nil {
+		t.Fatalf("failed to create quota manager: %v", err)
+	}
+
+	m.now = func() time.Time { return now }
+
+	return m, func(instant time.Time) { now = instant }
+}
+
+func TestUpdateAcrossSessions(t *testing.T) {
+	m, _ := newTestManager(t, t.TempDir(), time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC))
+
+	// Octets are totals of a session, only the difference counts
+	m.Update("alice", "staff", "session-1", 30, false)
+	m.Update("alice", "staff", "session-1", 50, false)
+	m.Update("alice", "staff", "session-2", 20, false)
+
+	// Late update with fewer octets is ignored
+	m.Update("alice", "staff", "session-1", 40, false)
+
+	if remaining := m.Remaining("alice"); (remaining.DailyBytes == nil) || (*remaining.DailyBytes != 30) {
+		t.Fatalf("expected 30 daily bytes remaining, got %v", remaining.DailyBytes)
+	}
+
+	if err := m.Check("alice", "staff"); err != nil {
+		t.Fatalf("expected quota not to be exceeded, got %v", err)
+	}
+
+	status := m.Update("alice", "staff", "session-1", 60, true)
+
+	if status.Exceeded || (len(status.Warnings) != 1) || (status.Warnings[0].Percent != 80) {
+		t.Fatalf("expected 80%% daily warning, got %+v", status)
+	}
+
+	// Stopped session is forgotten, a new session continues the sum
+	status = m.Update("alice", "staff", "session-3", 20, false)
+
+	if !status.Exceeded || (len(status.Warnings) != 1) || (status.Warnings[0].Percent != 95) {
+		t.Fatalf("expected exceeded quota with 95%% daily warning, got %+v", status)
+	}
+
+	if err := m.Check("alice", "staff"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected exceeded quota, got %v", err)
+	}
+
+	if err := m.Check("bob", "staff"); err != nil {
+		t.Fatalf("expected quota of another user not to be exceeded, got %v", err)
+	}
+}
+
+func TestUpdateIgnoresUsernameCase(t *testing.T) {
+	m, _ := newTestManager(t, t.TempDir(), time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC))
+	m.Update("Alice", "staff", "session-1", 100, false)
+
+	if err := m.Check("alice", "staff"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected exceeded quota, got %v", err)
+	}
+
+	if remaining := m.Remaining("ALICE"); (remaining.DailyBytes == nil) || (*remaining.DailyBytes != 0) {
+		t.Fatalf("expected no daily bytes remaining, got %v", remaining.DailyBytes)
+	}
+}
+
+func TestUpdateRollover(t *testing.T) {
+	stateDirectoryPath := t.TempDir()
+	m, setNow := newTestManager(t, stateDirectoryPath, time.Date(2026, 3, 30, 23, 0, 0, 0, time.UTC))
+	m.Update("alice", "staff", "session-1", 100, false)
+
+	if err := m.Check("alice", "staff"); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("expected exceeded daily quota, got %v", err)
+	}
+
+	// Days are UTC days, the next one resets daily usage and warnings only
+	setNow(time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC))
+
+	if err := m.Check("alice", "staff"); err != nil {
+		t.Fatalf("expected daily quota to be reset, got %v", err)
+	}
+
+	status := m.Update("alice", "staff", "session-1", 190, false)
+
+	if status.Exceeded || (len(status.Warnings) != 2) || (status.Warnings[0].Period != PeriodDaily) || (status.Warnings[1].Period != PeriodMonthly) {
+		t.Fatalf("expected daily and monthly warnings, got %+v", status)
+	}
+
+	// Usage survives restart
+	m, setNow = newTestManager(t, stateDirectoryPath, time.Date(2026, 3, 31, 1, 0, 0, 0, time.UTC))
+	status = m.Update("alice", "staff", "session-1", 250, false)
+
+	if !status.Exceeded {
+		t.Fatalf("expected exceeded monthly quota, got %+v", status)
+	}
+
+	setNow(time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC))
+
+	if err := m.Check("alice", "staff"); err != nil {
+		t.Fatalf("expected monthly quota to be reset, got %v", err)
+	}
+
+	if remaining := m.Remaining("alice"); (remaining.MonthlyBytes == nil) || (*remaining.MonthlyBytes != 220) {
+		t.Fatalf("expected 220 monthly bytes remaining, got %v", remaining.MonthlyBytes)
+	}
+}
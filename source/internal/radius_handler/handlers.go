@@ -0,0 +1,574 @@
+package radius_handler
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/triflesoft/portalswan/internal/adapters/adapters"
+	"github.com/triflesoft/portalswan/internal/state"
+)
+
+// Event-Timestamp is formatted the way FreeRADIUS prints dates.
+const eventTimestampLayout = "Jan _2 2006 15:04:05 MST"
+
+var errTooManySessions = errors.New("too many sessions")
+
+// Authorize looks up VPN user and NT password, status is 200 on success and
+// 401 otherwise. NT password is returned as "control:NT-Password" and must
+// never leave PortalSwan except to FreeRADIUS. Unknown users and users without
+// password count as failed logins, locked out users are rejected before any
+// adapter call.
+func Authorize(ws *state.WorkerState, request *RadiusRequest) (int, *RadiusReply) {
+	log := ws.AppState.LoggingAdapter
+	username := ""
+	ipAddress := ""
+
+	for attributeName, attribute := range *request {
+		if (attributeName == "User-Name") && (len(attribute.Value) == 1) {
+			username, _ = attribute.Value[0].(string)
+		} else if (attributeName == "Calling-Station-Id") && (len(attribute.Value) == 1) {
+			ipAddress, _ = attribute.Value[0].(string)
+		}
+	}
+
+	if err := ws.AppState.LockoutTracker.Check(username, ipAddress); err != nil {
+		go logRadiusRequestReply(log, "RadiusAuthorize", 401, request, nil, nil)
+
+		log.LogErrorText("Failed to authorize VPN user", "err", err, "username", username, "callingStationId", ipAddress)
+
+		return 401, nil
+	}
+
+	vpnUser := ws.AppState.IdentityAdapter.SelectVpnUser(username)
+
+	if vpnUser == nil {
+		go logRadiusRequestReply(log, "RadiusAuthorize", 401, request, nil, nil)
+
+		log.LogErrorText("Failed to get VPN user by username", "username", username)
+		ws.AppState.LockoutTracker.RecordFailure(username, ipAddress)
+
+		return 401, nil
+	}
+
+	if vpnUser.Class == "" {
+		go logRadiusRequestReply(log, "RadiusAuthorize", 401, request, nil, nil)
+
+		log.LogErrorText("Failed to get VPN user class", "username", username)
+		ws.AppState.LockoutTracker.RecordFailure(username, ipAddress)
+
+		return 401, nil
+	}
+
+	sourceRule, err := ws.AppState.SourcePolicies.Check(vpnUser.Class, ipAddress)
+
+	if err != nil {
+		go logRadiusRequestReply(log, "RadiusAuthorize", 401, request, nil, nil)
+
+		log.LogErrorText("Failed to authorize VPN user", "err", err, "username", username, "class", vpnUser.Class, "callingStationId", ipAddress)
+
+		return 401, nil
+	}
+
+	if err := ws.AppState.QuotaManager.Check(vpnUser.Username, vpnUser.Class); err != nil {
+		go logRadiusRequestReply(log, "RadiusAuthorize", 401, request, nil, nil)
+
+		log.LogErrorText("Failed to authorize VPN user", "err", err, "username", username, "class", vpnUser.Class)
+
+		return 401, nil
+	}
+
+	// Checked before NT password and IP address are touched, FreeRADIUS
+	// checksimul only runs after authorize
+	if sessionCount, maxSessions, err := checkMaxSessions(ws, vpnUser); err != nil {
+		go logRadiusRequestReply(log, "RadiusAuthorize", 401, request, nil, nil)
+
+		log.LogErrorText(
+			"Failed to authorize VPN user, too many sessions",
+			"username", username,
+			"class", vpnUser.Class,
+			"sessionCount", sessionCount,
+			"maxSessions", maxSessions)
+
+		return 401, nil
+	}
+
+	sessionTimeout, err := ws.AppState.Schedules.Check(vpnUser.Class, time.Now())
+
+	if err != nil {
+		go logRadiusRequestReply(log, "RadiusAuthorize", 401, request, nil, nil)
+
+		log.LogErrorText("Failed to authorize VPN user", "err", err, "username", username, "class", vpnUser.Class)
+
+		return 401, nil
+	}
+
+	ntPassword, err := ws.AppState.CredentialsAdapter.SelectNtPassword(vpnUser, ipAddress)
+
+	if err != nil {
+		go logRadiusRequestReply(log, "RadiusAuthorize", 401, request, nil, nil)
+
+		log.LogErrorText("Failed to get VPN user NT password", "err", err, "username", username)
+
+		// Storage outages are not failed logins, user could not do better
+		if errors.Is(err, adapters.ErrCredentialsMissing) || errors.Is(err, adapters.ErrNtPasswordMissing) {
+			ws.AppState.LockoutTracker.RecordFailure(username, ipAddress)
+		}
+
+		return 401, nil
+	}
+
+	classAttributes, err := ws.AppState.ReplyTemplates.Render(vpnUser)
+
+	if err != nil {
+		go logRadiusRequestReply(log, "RadiusAuthorize", 401, request, nil, nil)
+
+		log.LogErrorText("Failed to render VPN user reply attributes", "err", err, "username", username, "class", vpnUser.Class)
+
+		return 401, nil
+	}
+
+	lease, err := ws.AppState.IpPoolManager.Lease(vpnUser.Class, vpnUser.Username, ipAddress)
+
+	if err != nil {
+		go logRadiusRequestReply(log, "RadiusAuthorize", 401, request, nil, nil)
+
+		log.LogErrorText("Failed to lease VPN user IP address", "err", err, "username", username, "class", vpnUser.Class)
+
+		return 401, nil
+	}
+
+	reply := RadiusReply{}
+
+	reply["control:NT-Password"] = RadiusAttribute{
+		Type:  "string",
+		Value: []any{ntPassword},
+	}
+
+	reply["reply:Class"] = RadiusAttribute{
+		Type:  "string",
+		Value: []any{vpnUser.Class},
+	}
+
+	dnsServers := []string{}
+
+	if clientSettings := ws.AppState.GetClientSettings(); clientSettings != nil {
+		dnsServers = clientSettings.DnsServers
+	}
+
+	if len(dnsServers) >= 1 {
+		reply["reply:MS-Primary-DNS-Server"] = RadiusAttribute{
+			Type:  "string",
+			Value: []any{dnsServers[0]},
+		}
+	}
+
+	if len(dnsServers) >= 2 {
+		reply["reply:MS-Secondary-DNS-Server"] = RadiusAttribute{
+			Type:  "string",
+			Value: []any{dnsServers[1]},
+		}
+	}
+
+	if (lease != nil) && (lease.Ipv4Address != "") {
+		reply["reply:Framed-IP-Address"] = RadiusAttribute{
+			Type:  "ipaddr",
+			Value: []any{lease.Ipv4Address},
+		}
+	}
+
+	if (lease != nil) && (lease.Ipv6Address != "") {
+		reply["reply:Framed-IPv6-Address"] = RadiusAttribute{
+			Type:  "ipv6addr",
+			Value: []any{lease.Ipv6Address},
+		}
+	}
+
+	classAttributeNames := map[string]bool{}
+
+	for attributeName, values := range classAttributes {
+		attributeValues := make([]any, 0, len(values))
+
+		for _, value := range values {
+			attributeValues = append(attributeValues, value)
+		}
+
+		reply["reply:"+attributeName] = RadiusAttribute{
+			Type:  "string",
+			Value: attributeValues,
+		}
+		classAttributeNames[attributeName] = true
+	}
+
+	// FreeRADIUS calls session section, and thus checksimul, only if
+	// Simultaneous-Use is set
+	if maxSessions := ws.AppState.GetRadiusSettings().MaxSessionsForClass(vpnUser.Class); maxSessions > 0 {
+		reply["control:Simultaneous-Use"] = RadiusAttribute{
+			Type:  "integer",
+			Value: []any{strconv.Itoa(maxSessions)},
+		}
+	}
+
+	// Quota usage is only known from accounting, strongSwan sends no
+	// Interim-Update unless accounting_interval is set or the interval is in
+	// Access-Accept
+	if quota := ws.AppState.GetRadiusSettings().QuotaForClass(vpnUser.Class); (quota != nil) && (quota.InterimInterval > 0) {
+		if _, exists := classAttributes["Acct-Interim-Interval"]; !exists {
+			reply["reply:Acct-Interim-Interval"] = RadiusAttribute{
+				Type:  "integer",
+				Value: []any{strconv.FormatInt(int64(quota.InterimInterval.Seconds()), 10)},
+			}
+		}
+	}
+
+	// Session ends at access window boundary, unless class attributes end it
+	// earlier
+	if sessionTimeout > 0 {
+		seconds := int64(math.Ceil(sessionTimeout.Seconds()))
+
+		if values := classAttributes["Session-Timeout"]; len(values) == 1 {
+			if classSeconds, err := strconv.ParseInt(values[0], 10, 64); (err == nil) && (classSeconds > 0) && (classSeconds < seconds) {
+				seconds = classSeconds
+			}
+		}
+
+		reply["reply:Session-Timeout"] = RadiusAttribute{
+			Type:  "integer",
+			Value: []any{strconv.FormatInt(seconds, 10)},
+		}
+		delete(classAttributeNames, "Session-Timeout")
+	}
+
+	ws.AppState.LockoutTracker.RecordAuthorized(username, ipAddress)
+
+	go logRadiusRequestReply(log, "RadiusAuthorize", 200, request, &reply, classAttributeNames)
+
+	log.LogDebugText(
+		"Radius authorize",
+		"username", vpnUser.Username,
+		"class", vpnUser.Class,
+		"sourceRule", sourceRule)
+
+	return 200, &reply
+}
+
+// checkMaxSessions counts live sessions of user against class limit.
+func checkMaxSessions(ws *state.WorkerState, vpnUser *adapters.VpnUser) (int, int, error) {
+	maxSessions := ws.AppState.GetRadiusSettings().MaxSessionsForClass(vpnUser.Class)
+	sessionCount := ws.AppState.CountVpnConnectionStates(vpnUser.Username)
+
+	if (maxSessions > 0) && (sessionCount >= maxSessions) {
+		return sessionCount, maxSessions, errTooManySessions
+	}
+
+	return sessionCount, maxSessions, nil
+}
+
+// CheckSimul counts live sessions of user, status is 204 if one more session
+// is allowed, 403 if class limit is reached and 401 for unknown users.
+func CheckSimul(ws *state.WorkerState, request *RadiusRequest) int {
+	log := ws.AppState.LoggingAdapter
+	username := ""
+
+	for attributeName, attribute := range *request {
+		if (attributeName == "User-Name") && (len(attribute.Value) == 1) {
+			username, _ = attribute.Value[0].(string)
+		}
+	}
+
+	vpnUser := ws.AppState.IdentityAdapter.SelectVpnUser(username)
+
+	if vpnUser == nil {
+		go logRadiusRequestReply(log, "RadiusCheckSimul", 401, request, nil, nil)
+
+		log.LogErrorText("Failed to get VPN user by username", "username", username)
+
+		return 401
+	}
+
+	sessionCount, maxSessions, err := checkMaxSessions(ws, vpnUser)
+
+	if err != nil {
+		go logRadiusRequestReply(log, "RadiusCheckSimul", 403, request, nil, nil)
+
+		log.LogErrorText(
+			"Failed to authorize VPN user, too many sessions",
+			"username", username,
+			"class", vpnUser.Class,
+			"sessionCount", sessionCount,
+			"maxSessions", maxSessions)
+
+		return 403
+	}
+
+	go logRadiusRequestReply(log, "RadiusCheckSimul", 204, request, nil, nil)
+
+	log.LogDebugText(
+		"Radius checksimul",
+		"username", vpnUser.Username,
+		"class", vpnUser.Class,
+		"sessionCount", sessionCount,
+		"maxSessions", maxSessions)
+
+	return 204
+}
+
+// PostAuth records outcome of authentication, which only FreeRADIUS knows.
+// Rejects of requests authorize accepted count as failed logins, other rejects
+// were issued by authorize, accepts reset failures of user. Status is always
+// 204.
+func PostAuth(ws *state.WorkerState, request *RadiusRequest, rejected bool) int {
+	log := ws.AppState.LoggingAdapter
+	username := ""
+	ipAddress := ""
+
+	for attributeName, attribute := range *request {
+		if (attributeName == "User-Name") && (len(attribute.Value) == 1) {
+			username, _ = attribute.Value[0].(string)
+		} else if (attributeName == "Calling-Station-Id") && (len(attribute.Value) == 1) {
+			ipAddress, _ = attribute.Value[0].(string)
+		}
+	}
+
+	if rejected {
+		go logRadiusRequestReply(log, "RadiusPostAuth", 401, request, nil, nil)
+
+		counted := ws.AppState.LockoutTracker.RecordRejected(username, ipAddress)
+		log.LogErrorText("VPN user authentication rejected", "username", username, "callingStationId", ipAddress, "countedAsFailure", counted)
+
+		return 204
+	}
+
+	go logRadiusRequestReply(log, "RadiusPostAuth", 200, request, nil, nil)
+
+	log.LogDebugText("Radius post-auth", "username", username, "callingStationId", ipAddress)
+	ws.AppState.LockoutTracker.RecordSuccess(username)
+
+	return 204
+}
+
+// updateQuota accumulates usage of user, connections are terminated as soon
+// as quota is exceeded, including other connections of user on Stop.
+func updateQuota(ws *state.WorkerState, username string, class string, sessionId string, octets int64, stopped bool) {
+	log := ws.AppState.LoggingAdapter
+
+	if !ws.AppState.QuotaManager.Enabled() {
+		return
+	}
+
+	// Class attribute is missing if NAS does not echo it in accounting
+	if class == "" {
+		if vpnUser := ws.AppState.IdentityAdapter.SelectVpnUser(username); vpnUser != nil {
+			class = vpnUser.Class
+		}
+	}
+
+	status := ws.AppState.QuotaManager.Update(username, class, sessionId, octets, stopped)
+
+	for _, warning := range status.Warnings {
+		log.LogInfoText(
+			"RadiusQuota",
+			"VPN user quota warning",
+			"username", username,
+			"class", class,
+			"period", warning.Period,
+			"percent", warning.Percent,
+			"usedBytes", warning.UsedBytes,
+			"limitBytes", warning.LimitBytes)
+	}
+
+	if status.Exceeded && (!stopped || (ws.AppState.CountVpnConnectionStates(username) > 0)) {
+		log.LogErrorText(
+			"VPN user quota exceeded, terminating VPN connections",
+			"username", username,
+			"class", class)
+		ws.AppState.RequestVpnTermination(username, "quota exceeded")
+	}
+}
+
+// Sessions are identified by Acct-Unique-Session-Id, which is unique across
+// NAS restarts, Acct-Session-Id is used if FreeRADIUS does not provide it.
+func accountingSessionId(session *adapters.AccountingSession) string {
+	if session.AcctUniqueSessionId != "" {
+		return session.AcctUniqueSessionId
+	}
+
+	return session.AcctSessionId
+}
+
+// Accounting tracks VPN connections, status is always 204. Connections, leases
+// and quota usage are kept under canonical username.
+func Accounting(ws *state.WorkerState, request *RadiusRequest) int {
+	log := ws.AppState.LoggingAdapter
+	username := ""
+	framedIpAddress := ""
+	statusType := ""
+	inputOctets := int64(0)
+	inputPackets := int64(0)
+	outputOctets := int64(0)
+	outputPackets := int64(0)
+	inputGigawords := int64(0)
+	outputGigawords := int64(0)
+	sessionTime := int64(0)
+	session := &adapters.AccountingSession{}
+
+	for attributeName, attribute := range *request {
+		if len(attribute.Value) == 1 {
+			strValue, ok := attribute.Value[0].(string)
+
+			if ok {
+				switch attributeName {
+				case "User-Name":
+					username = strValue
+				case "Framed-IP-Address":
+					framedIpAddress = strValue
+				case "Acct-Status-Type":
+					statusType = strValue
+				case "Acct-Session-Id":
+					session.AcctSessionId = strValue
+				case "Acct-Unique-Session-Id":
+					session.AcctUniqueSessionId = strValue
+				case "Acct-Terminate-Cause":
+					session.TerminateCause = strValue
+				case "Calling-Station-Id":
+					session.CallingStationId = strValue
+				case "Class":
+					if class, ok := decodeHexText(strValue); ok {
+						session.Class = class
+					} else {
+						session.Class = strValue
+					}
+				case "Event-Timestamp":
+					if eventTime, err := time.Parse(eventTimestampLayout, strValue); err == nil {
+						session.StopTime = eventTime
+					}
+				}
+			} else {
+				floatValue, ok := attribute.Value[0].(float64)
+				intValue := int64(floatValue)
+
+				if ok {
+					switch attributeName {
+					case "Acct-Input-Octets":
+						inputOctets = intValue
+					case "Acct-Input-Packets":
+						inputPackets = intValue
+					case "Acct-Output-Octets":
+						outputOctets = intValue
+					case "Acct-Output-Packets":
+						outputPackets = intValue
+					case "Acct-Input-Gigawords":
+						inputGigawords = intValue
+					case "Acct-Output-Gigawords":
+						outputGigawords = intValue
+					case "Acct-Session-Time":
+						sessionTime = intValue
+					}
+				}
+			}
+		}
+	}
+
+	inputOctets += inputGigawords << 32
+	outputOctets += outputGigawords << 32
+	username = ws.AppState.CanonicalUsername(username)
+
+	sessionId := accountingSessionId(session)
+
+	if (username != "") && (framedIpAddress != "") && (statusType != "") && (sessionId != "") {
+
+		switch statusType {
+		case "Start", "Interim-Update":
+			connectionState, created := ws.AppState.EnsureVpnConnectionState(sessionId, framedIpAddress, username, session.Class)
+
+			if connectionState == nil {
+				log.LogErrorText(
+					"Radius update VPN connection ignored, connection stopped",
+					"sessionId", sessionId,
+					"statusType", statusType,
+					"framedIpAddress", framedIpAddress,
+					"username", username)
+
+				break
+			}
+
+			ws.AppState.IpPoolManager.Activate(framedIpAddress, username)
+
+			if created && (statusType == "Start") {
+				log.LogDebugText(
+					"Radius create VPN connection",
+					"sessionId", sessionId,
+					"framedIpAddress", framedIpAddress,
+					"username", username)
+			} else if created {
+				// Connection started before PortalSwan restart and missed by VICI
+				log.LogDebugText(
+					"Radius restore VPN connection",
+					"sessionId", sessionId,
+					"framedIpAddress", framedIpAddress,
+					"username", username)
+			}
+
+			if (inputOctets > 0) && (inputPackets > 0) && (outputOctets > 0) && (outputPackets > 0) {
+				connectionState.UpdateCounters(inputOctets, outputOctets, inputPackets, outputPackets)
+			}
+
+			if statusType == "Interim-Update" {
+				updateQuota(ws, username, session.Class, sessionId, inputOctets+outputOctets, false)
+			}
+		case "Stop":
+			connectionState, stopped := ws.AppState.StopVpnConnectionState(sessionId, framedIpAddress, username)
+
+			if !stopped {
+				log.LogDebugText(
+					"Radius delete VPN connection ignored, duplicate Stop",
+					"sessionId", sessionId,
+					"framedIpAddress", framedIpAddress,
+					"username", username)
+
+				break
+			}
+
+			updateQuota(ws, username, session.Class, sessionId, inputOctets+outputOctets, true)
+
+			// Address may already belong to a newer session of the same user
+			if _, ok := ws.AppState.GetVpnConnectionState(framedIpAddress); !ok {
+				ws.AppState.IpPoolManager.Release(framedIpAddress, username)
+			}
+
+			if ws.AppState.AccountingAdapter != nil {
+				if session.StopTime.IsZero() {
+					session.StopTime = time.Now()
+				}
+
+				session.Username = username
+				session.FramedIpAddress = framedIpAddress
+				session.StartTime = session.StopTime.Add(-time.Duration(sessionTime) * time.Second)
+				session.InputOctets = inputOctets
+				session.OutputOctets = outputOctets
+				session.InputPackets = inputPackets
+				session.OutputPackets = outputPackets
+				ws.AppState.AccountingAdapter.InsertSession(session)
+			}
+
+			if connectionState == nil {
+				log.LogErrorText(
+					"Radius delete VPN connection failed, connection missing",
+					"sessionId", sessionId,
+					"framedIpAddress", framedIpAddress,
+					"username", username)
+			} else {
+				log.LogDebugText(
+					"Radius delete VPN connection",
+					"sessionId", sessionId,
+					"framedIpAddress", framedIpAddress,
+					"username", username)
+			}
+		}
+
+		go logRadiusRequestReply(log, "RadiusAccounting", 204, request, nil, nil)
+	}
+
+	return 204
+}
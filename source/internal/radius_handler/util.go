@@ -0,0 +1,215 @@
+package radius_handler
+
+import (
+	"encoding/hex"
+	"strings"
+
+	"github.com/triflesoft/portalswan/internal/adapters/adapters"
+)
+
+// Attributes are encoded the way FreeRADIUS rlm_rest encodes them, integers
+// are numbers, enumerated integers, addresses and dates are strings, octets
+// are hex strings prefixed with "0x".
+type RadiusAttribute struct {
+	Type  string `json:"type"`
+	Value []any  `json:"value"`
+}
+
+type RadiusRequest map[string]RadiusAttribute
+type RadiusReply map[string]RadiusAttribute
+
+type radiusRequestLog struct {
+	AcctInputOctets     *int64                     `json:"Acct-Input-Octets,omitempty"`
+	AcctInputPackets    *int64                     `json:"Acct-Input-Packets,omitempty"`
+	AcctOutputOctets    *int64                     `json:"Acct-Output-Octets,omitempty"`
+	AcctOutputPackets   *int64                     `json:"Acct-Output-Packets,omitempty"`
+	AcctSessionId       *string                    `json:"Acct-Session-Id,omitempty"`
+	AcctSessionTime     *int64                     `json:"Acct-Session-Time,omitempty"`
+	AcctStatusType      *string                    `json:"Acct-Status-Type,omitempty"`
+	AcctTerminateCause  *string                    `json:"Acct-Terminate-Cause,omitempty"`
+	AcctUniqueSessionId *string                    `json:"Acct-Unique-Session-Id,omitempty"`
+	CalledStationId     *string                    `json:"Called-Station-Id,omitempty"`
+	CallingStationId    *string                    `json:"Calling-Station-Id,omitempty"`
+	Class               *string                    `json:"Class,omitempty"`
+	EventTimestamp      *string                    `json:"Event-Timestamp,omitempty"`
+	FramedIpAddress     *string                    `json:"Framed-IP-Address,omitempty"`
+	NasIdentifier       *string                    `json:"NAS-Identifier,omitempty"`
+	NasIpAddress        *string                    `json:"NAS-IP-Address,omitempty"`
+	NasPort             *int64                     `json:"NAS-Port,omitempty"`
+	ServiceType         *string                    `json:"Service-Type,omitempty"`
+	UserName            *string                    `json:"User-Name,omitempty"`
+	OtherAttributes     map[string]RadiusAttribute `json:"OtherAttributes,omitempty"`
+}
+
+type radiusReplyLog struct {
+	Class                *string                    `json:"Class,omitempty"`
+	FramedIpAddress      *string                    `json:"Framed-IP-Address,omitempty"`
+	FramedIpv6Address    *string                    `json:"Framed-IPv6-Address,omitempty"`
+	ClassAttributes      map[string]RadiusAttribute `json:"ClassAttributes,omitempty"`
+	MsPrimaryDsnServer   *string                    `json:"MS-Primary-DNS-Server,omitempty"`
+	MsSecondaryDnsServer *string                    `json:"MS-Secondary-DNS-Server,omitempty"`
+	OtherAttributes      map[string]RadiusAttribute `json:"OtherAttributes,omitempty"`
+}
+
+type radiusRequestReplyLog struct {
+	Status  int               `json:"status"`
+	Request *radiusRequestLog `json:"request,omitempty"`
+	Reply   *radiusReplyLog   `json:"reply,omitempty"`
+}
+
+func decodeHexText(text string) (string, bool) {
+	if (!strings.HasPrefix(text, "0x")) && (!strings.HasPrefix(text, "0X")) {
+		return "", false
+	}
+
+	text = text[2:]
+	data, err := hex.DecodeString(text)
+
+	if err != nil {
+		return "", false
+	}
+
+	for b := range data {
+		if (b <= 32) && (b >= 127) {
+			return "", false
+		}
+	}
+
+	return string(data), true
+}
+
+// Reply attributes configured for the class are logged under ClassAttributes
+// with any number of values.
+func logRadiusRequestReply(l adapters.LoggingAdapter, stream string, status int, request *RadiusRequest, reply *RadiusReply, classAttributeNames map[string]bool) {
+	message := radiusRequestReplyLog{
+		Status: status,
+	}
+
+	if request != nil {
+		requestLog := &radiusRequestLog{}
+		requestLog.OtherAttributes = map[string]RadiusAttribute{}
+
+		for attributeName, attribute := range *request {
+			if (attributeName == "EAP-Message") ||
+				(attributeName == "Message-Authenticator") ||
+				(attributeName == "State") ||
+				strings.HasPrefix(attributeName, "NAS-Port-") {
+				continue
+			}
+
+			if len(attribute.Value) != 1 {
+				requestLog.OtherAttributes[attributeName] = attribute
+			} else if !strings.HasPrefix(attributeName, "Tmp-") {
+				strValue, ok := attribute.Value[0].(string)
+
+				if ok {
+					switch attributeName {
+					case "Acct-Session-Id":
+						requestLog.AcctSessionId = &strValue
+					case "Acct-Status-Type":
+						requestLog.AcctStatusType = &strValue
+					case "Acct-Terminate-Cause":
+						requestLog.AcctTerminateCause = &strValue
+					case "Acct-Unique-Session-Id":
+						requestLog.AcctUniqueSessionId = &strValue
+					case "Called-Station-Id":
+						requestLog.CalledStationId = &strValue
+					case "Calling-Station-Id":
+						requestLog.CallingStationId = &strValue
+					case "Class":
+						clsValue, ok := decodeHexText(strValue)
+
+						if ok {
+							requestLog.Class = &clsValue
+						} else {
+							requestLog.OtherAttributes[attributeName] = attribute
+						}
+					case "Event-Timestamp":
+						requestLog.EventTimestamp = &strValue
+					case "Framed-IP-Address":
+						requestLog.FramedIpAddress = &strValue
+					case "NAS-Identifier":
+						requestLog.NasIdentifier = &strValue
+					case "NAS-IP-Address":
+						requestLog.NasIpAddress = &strValue
+					case "Service-Type":
+						requestLog.ServiceType = &strValue
+					case "User-Name":
+						requestLog.UserName = &strValue
+					default:
+						requestLog.OtherAttributes[attributeName] = attribute
+					}
+				} else {
+					floatValue, ok := attribute.Value[0].(float64)
+					intValue := int64(floatValue)
+
+					if ok {
+						switch attributeName {
+						case "Acct-Input-Octets":
+							requestLog.AcctInputOctets = &intValue
+						case "Acct-Input-Packets":
+							requestLog.AcctInputPackets = &intValue
+						case "Acct-Output-Octets":
+							requestLog.AcctOutputOctets = &intValue
+						case "Acct-Output-Packets":
+							requestLog.AcctOutputPackets = &intValue
+						case "Acct-Session-Time":
+							requestLog.AcctSessionTime = &intValue
+						case "NAS-Port":
+							requestLog.NasPort = &intValue
+						default:
+							requestLog.OtherAttributes[attributeName] = attribute
+						}
+					} else {
+						requestLog.OtherAttributes[attributeName] = attribute
+					}
+				}
+			}
+		}
+
+		message.Request = requestLog
+	}
+
+	if reply != nil {
+		replyLog := &radiusReplyLog{}
+		replyLog.OtherAttributes = map[string]RadiusAttribute{}
+		replyLog.ClassAttributes = map[string]RadiusAttribute{}
+
+		for attributeName, attribute := range *reply {
+			if attributeName == "control:NT-Password" {
+				continue
+			}
+
+			if classAttributeNames[strings.TrimPrefix(attributeName, "reply:")] {
+				replyLog.ClassAttributes[attributeName] = attribute
+			} else if len(attribute.Value) != 1 {
+				replyLog.OtherAttributes[attributeName] = attribute
+			} else if !strings.HasPrefix(attributeName, "Tmp-") {
+				strValue, ok := attribute.Value[0].(string)
+
+				if ok {
+					switch attributeName {
+					case "reply:Class":
+						replyLog.Class = &strValue
+					case "reply:Framed-IP-Address":
+						replyLog.FramedIpAddress = &strValue
+					case "reply:Framed-IPv6-Address":
+						replyLog.FramedIpv6Address = &strValue
+					case "reply:MS-Primary-DNS-Server":
+						replyLog.MsPrimaryDsnServer = &strValue
+					case "reply:MS-Secondary-DNS-Server":
+						replyLog.MsSecondaryDnsServer = &strValue
+					default:
+						replyLog.OtherAttributes[attributeName] = attribute
+					}
+				} else {
+					replyLog.OtherAttributes[attributeName] = attribute
+				}
+			}
+		}
+
+		message.Reply = replyLog
+	}
+
+	l.LogInfoJson(stream, message)
+}
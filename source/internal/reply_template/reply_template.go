@@ -0,0 +1,149 @@
+package reply_template
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/triflesoft/portalswan/internal/adapters/adapters"
+	"github.com/triflesoft/portalswan/internal/settings"
+)
+
+// Attributes PortalSwan sets itself cannot be overridden by class templates.
+var reservedAttributeNames = map[string]bool{
+	"Class":                   true,
+	"Framed-IP-Address":       true,
+	"Framed-IPv6-Address":     true,
+	"MS-Primary-DNS-Server":   true,
+	"MS-Secondary-DNS-Server": true,
+	"MS-MPPE-Send-Key":        true,
+	"MS-MPPE-Recv-Key":        true,
+	"EAP-Message":             true,
+	"Message-Authenticator":   true,
+	"State":                   true,
+}
+
+var attributeNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9.-]*$`)
+
+type replyAttributeTemplate struct {
+	name      string
+	templates []*template.Template
+}
+
+// ReplyTemplates renders per-class reply attributes from VpnUser fields, e.g.
+// "Reply-Message": ["Welcome, {{.Username}}"].
+type ReplyTemplates struct {
+	classes map[string][]*replyAttributeTemplate
+}
+
+func compileReplyAttributeTemplates(class string, replyAttributes map[string][]string) ([]*replyAttributeTemplate, error) {
+	names := make([]string, 0, len(replyAttributes))
+
+	for name := range replyAttributes {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	attributeTemplates := []*replyAttributeTemplate{}
+	sampleVpnUser := &adapters.VpnUser{Username: "username", Email: "username@example.com", Class: class}
+
+	for _, name := range names {
+		if !attributeNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("radius.reply_attributes.%s.%s is not a valid attribute name", class, name)
+		}
+
+		if reservedAttributeNames[name] {
+			return nil, fmt.Errorf("radius.reply_attributes.%s.%s is set by PortalSwan itself", class, name)
+		}
+
+		attributeTemplate := &replyAttributeTemplate{name: name}
+
+		for i, text := range replyAttributes[name] {
+			t, err := template.New(fmt.Sprintf("%s.%s.%d", class, name, i)).Option("missingkey=error").Parse(text)
+
+			if err == nil {
+				err = t.Execute(&strings.Builder{}, sampleVpnUser)
+			}
+
+			if err != nil {
+				return nil, fmt.Errorf("radius.reply_attributes.%s.%s is invalid: %w", class, name, err)
+			}
+
+			attributeTemplate.templates = append(attributeTemplate.templates, t)
+		}
+
+		attributeTemplates = append(attributeTemplates, attributeTemplate)
+	}
+
+	return attributeTemplates, nil
+}
+
+// Render returns attribute values by name, values rendered as empty strings
+// are skipped, so that templates may omit attributes conditionally.
+func (t *ReplyTemplates) Render(vpnUser *adapters.VpnUser) (map[string][]string, error) {
+	attributeTemplates, exists := t.classes[vpnUser.Class]
+
+	if !exists {
+		attributeTemplates = t.classes["*"]
+	}
+
+	replyAttributes := map[string][]string{}
+
+	for _, attributeTemplate := range attributeTemplates {
+		for _, valueTemplate := range attributeTemplate.templates {
+			value := &strings.Builder{}
+
+			if err := valueTemplate.Execute(value, vpnUser); err != nil {
+				return nil, fmt.Errorf("failed to render %s: %w", attributeTemplate.name, err)
+			}
+
+			if value.Len() > 0 {
+				replyAttributes[attributeTemplate.name] = append(replyAttributes[attributeTemplate.name], value.String())
+			}
+		}
+	}
+
+	return replyAttributes, nil
+}
+
+// AttributeNames lists every attribute configured for any class.
+func (t *ReplyTemplates) AttributeNames() []string {
+	uniqueNames := map[string]bool{}
+
+	for _, attributeTemplates := range t.classes {
+		for _, attributeTemplate := range attributeTemplates {
+			uniqueNames[attributeTemplate.name] = true
+		}
+	}
+
+	names := make([]string, 0, len(uniqueNames))
+
+	for name := range uniqueNames {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func NewReplyTemplates(s *settings.AppRadiusSettings) (*ReplyTemplates, error) {
+	t := &ReplyTemplates{
+		classes: map[string][]*replyAttributeTemplate{},
+	}
+
+	for class, replyAttributes := range s.ReplyAttributes {
+		attributeTemplates, err := compileReplyAttributeTemplates(class, replyAttributes)
+
+		if err != nil {
+			return nil, err
+		}
+
+		t.classes[class] = attributeTemplates
+	}
+
+	return t, nil
+}
@@ -0,0 +1,198 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/triflesoft/portalswan/internal/settings"
+)
+
+// Adjacent windows are joined when session timeout is computed, windows
+// joined for a whole week mean the schedule is always open.
+const week = 7 * 24 * time.Hour
+
+var ErrOutsideSchedule = errors.New("outside of access window")
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// window starts on weekday at from minutes after midnight and lasts until to
+// minutes after the same midnight, to exceeds a day for overnight windows.
+type window struct {
+	weekday time.Weekday
+	from    int
+	to      int
+}
+
+type classSchedule struct {
+	location *time.Location
+	windows  []window
+}
+
+type Schedules struct {
+	schedules map[string]*classSchedule
+}
+
+func parseMinutes(text string, allowEndOfDay bool) (int, error) {
+	var hours, minutes int
+
+	if _, err := fmt.Sscanf(text, "%d:%d", &hours, &minutes); err != nil {
+		return 0, fmt.Errorf("'%s' is not HH:MM", text)
+	}
+
+	if (hours == 24) && (minutes == 0) && allowEndOfDay {
+		return 24 * 60, nil
+	}
+
+	if (hours < 0) || (hours > 23) || (minutes < 0) || (minutes > 59) {
+		return 0, fmt.Errorf("'%s' is out of range", text)
+	}
+
+	return hours*60 + minutes, nil
+}
+
+// end returns the latest end of windows containing instant, windows which
+// started the day before are checked too.
+func (s *classSchedule) end(instant time.Time) (time.Time, bool) {
+	local := instant.In(s.location)
+	end := time.Time{}
+
+	for _, dayOffset := range []int{0, -1} {
+		year, month, day := local.AddDate(0, 0, dayOffset).Date()
+
+		for _, w := range s.windows {
+			start := time.Date(year, month, day, w.from/60, w.from%60, 0, 0, s.location)
+			stop := time.Date(year, month, day, w.to/60, w.to%60, 0, 0, s.location)
+
+			if (start.Weekday() == w.weekday) && !local.Before(start) && local.Before(stop) && stop.After(end) {
+				end = stop
+			}
+		}
+	}
+
+	return end, !end.IsZero()
+}
+
+// Check returns ErrOutsideSchedule if class may not connect at instant,
+// otherwise time left until the window closes, zero if class has no schedule
+// or the schedule never closes.
+func (s *Schedules) Check(class string, instant time.Time) (time.Duration, error) {
+	schedule, exists := s.schedules[class]
+
+	if !exists {
+		schedule, exists = s.schedules["*"]
+	}
+
+	if !exists {
+		return 0, nil
+	}
+
+	end, ok := schedule.end(instant)
+
+	if !ok {
+		return 0, fmt.Errorf("%w of class '%s' at %s", ErrOutsideSchedule, class, instant.In(schedule.location).Format("Mon 15:04 MST"))
+	}
+
+	// Every iteration moves to a later window end, windows repeat weekly
+	for end.Sub(instant) < week {
+		nextEnd, ok := schedule.end(end)
+
+		if !ok || !nextEnd.After(end) {
+			break
+		}
+
+		end = nextEnd
+	}
+
+	if end.Sub(instant) >= week {
+		return 0, nil
+	}
+
+	return end.Sub(instant), nil
+}
+
+func NewSchedules(s *settings.AppRadiusSettings) (*Schedules, error) {
+	schedules := &Schedules{
+		schedules: map[string]*classSchedule{},
+	}
+
+	classes := make([]string, 0, len(s.Schedules))
+
+	for class := range s.Schedules {
+		classes = append(classes, class)
+	}
+
+	sort.Strings(classes)
+
+	for _, class := range classes {
+		scheduleSettings := s.Schedules[class]
+		location, err := time.LoadLocation(scheduleSettings.TimeZone)
+
+		if err != nil {
+			return nil, fmt.Errorf("radius.schedules.%s.time_zone is invalid: %w", class, err)
+		}
+
+		if len(scheduleSettings.Windows) == 0 {
+			return nil, fmt.Errorf("radius.schedules.%s.windows are missing", class)
+		}
+
+		schedule := &classSchedule{
+			location: location,
+		}
+
+		for i, windowSettings := range scheduleSettings.Windows {
+			from, err := parseMinutes(windowSettings.From, false)
+
+			if err != nil {
+				return nil, fmt.Errorf("radius.schedules.%s.windows[%d].from is invalid: %w", class, i, err)
+			}
+
+			to, err := parseMinutes(windowSettings.To, true)
+
+			if err != nil {
+				return nil, fmt.Errorf("radius.schedules.%s.windows[%d].to is invalid: %w", class, i, err)
+			}
+
+			if to <= from {
+				to += 24 * 60
+			}
+
+			days := windowSettings.Days
+
+			if len(days) == 0 {
+				days = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+			}
+
+			for _, day := range days {
+				weekday, ok := weekdays[strings.ToLower(day)]
+
+				if !ok {
+					return nil, fmt.Errorf("radius.schedules.%s.windows[%d].days has unknown day '%s'", class, i, day)
+				}
+
+				schedule.windows = append(schedule.windows, window{weekday: weekday, from: from, to: to})
+			}
+		}
+
+		schedules.schedules[class] = schedule
+
+		fmt.Printf("Schedule '%s'\n", class)
+		fmt.Printf("    Time Zone:              '%s'\n", scheduleSettings.TimeZone)
+
+		for _, windowSettings := range scheduleSettings.Windows {
+			fmt.Printf("    Window:                 '%s %s-%s'\n", strings.Join(windowSettings.Days, ","), windowSettings.From, windowSettings.To)
+		}
+	}
+
+	return schedules, nil
+}
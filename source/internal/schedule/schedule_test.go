@@ -0,0 +1,145 @@
+package schedule
+
+import (
+	"errors"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/triflesoft/portalswan/internal/settings"
+)
+
+func newTestSchedules(t *testing.T) *Schedules {
+	schedules, err := NewSchedules(&settings.AppRadiusSettings{
+		Schedules: map[string]*settings.AppRadiusScheduleSettings{
+			"office": {
+				TimeZone: "Europe/Berlin",
+				Windows:  []settings.AppRadiusScheduleWindowSettings{{Days: []string{"mon", "tue", "wed", "thu", "fri"}, From: "08:00", To: "18:00"}},
+			},
+			"night": {
+				TimeZone: "Europe/Berlin",
+				Windows:  []settings.AppRadiusScheduleWindowSettings{{From: "22:00", To: "06:00"}},
+			},
+			"weekend": {
+				TimeZone: "Europe/Berlin",
+				Windows:  []settings.AppRadiusScheduleWindowSettings{{Days: []string{"Sat", "Sun"}, From: "00:00", To: "24:00"}},
+			},
+			"always": {
+				TimeZone: "Europe/Berlin",
+				Windows:  []settings.AppRadiusScheduleWindowSettings{{From: "00:00", To: "24:00"}},
+			},
+			"split": {
+				TimeZone: "Europe/Berlin",
+				Windows: []settings.AppRadiusScheduleWindowSettings{
+					{Days: []string{"mon"}, From: "08:00", To: "12:00"},
+					{Days: []string{"mon"}, From: "12:00", To: "24:00"},
+					{Days: []string{"tue"}, From: "00:00", To: "02:00"},
+				},
+			},
+		},
+	})
+
+	if err != nil {
+		t.Fatalf("failed to create schedules: %v", err)
+	}
+
+	return schedules
+}
+
+func TestCheck(t *testing.T) {
+	schedules := newTestSchedules(t)
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	testCases := []struct {
+		name     string
+		class    string
+		instant  time.Time
+		expected time.Duration
+		outside  bool
+	}{
+		{"inside window", "office", time.Date(2026, 3, 11, 10, 0, 0, 0, berlin), 8 * time.Hour, false},
+		{"before window", "office", time.Date(2026, 3, 11, 7, 59, 0, 0, berlin), 0, true},
+		{"window end is exclusive", "office", time.Date(2026, 3, 11, 18, 0, 0, 0, berlin), 0, true},
+		{"day without window", "office", time.Date(2026, 3, 14, 10, 0, 0, 0, berlin), 0, true},
+		{"instant in other time zone", "office", time.Date(2026, 3, 11, 10, 0, 0, 0, time.UTC), 7 * time.Hour, false},
+		{"overnight window evening", "night", time.Date(2026, 3, 11, 23, 0, 0, 0, berlin), 7 * time.Hour, false},
+		{"overnight window morning", "night", time.Date(2026, 3, 12, 5, 0, 0, 0, berlin), time.Hour, false},
+		{"overnight window daytime", "night", time.Date(2026, 3, 12, 12, 0, 0, 0, berlin), 0, true},
+		{"overnight window into DST", "night", time.Date(2026, 3, 28, 22, 0, 0, 0, berlin), 7 * time.Hour, false},
+		{"overnight window out of DST", "night", time.Date(2026, 10, 24, 22, 0, 0, 0, berlin), 9 * time.Hour, false},
+		{"24:00 end joins next day", "weekend", time.Date(2026, 3, 14, 12, 0, 0, 0, berlin), 36 * time.Hour, false},
+		{"24:00 end", "weekend", time.Date(2026, 3, 15, 23, 30, 0, 0, berlin), 30 * time.Minute, false},
+		{"24:00 end into DST", "weekend", time.Date(2026, 3, 28, 12, 0, 0, 0, berlin), 35 * time.Hour, false},
+		{"adjacent windows are joined", "split", time.Date(2026, 3, 9, 9, 0, 0, 0, berlin), 17 * time.Hour, false},
+		{"always open", "always", time.Date(2026, 3, 11, 10, 0, 0, 0, berlin), 0, false},
+		{"always open at DST change", "always", time.Date(2026, 3, 29, 1, 59, 0, 0, berlin), 0, false},
+		{"class without schedule", "staff", time.Date(2026, 3, 11, 3, 0, 0, 0, berlin), 0, false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			timeout, err := schedules.Check(testCase.class, testCase.instant)
+
+			if testCase.outside {
+				if !errors.Is(err, ErrOutsideSchedule) {
+					t.Fatalf("expected outside of schedule, got %s, %v", timeout, err)
+				}
+
+				return
+			}
+
+			if (err != nil) || (timeout != testCase.expected) {
+				t.Fatalf("expected %s, got %s, %v", testCase.expected, timeout, err)
+			}
+		})
+	}
+}
+
+func TestCheckDefaultSchedule(t *testing.T) {
+	schedules, err := NewSchedules(&settings.AppRadiusSettings{
+		Schedules: map[string]*settings.AppRadiusScheduleSettings{
+			"*":      {TimeZone: "UTC", Windows: []settings.AppRadiusScheduleWindowSettings{{From: "08:00", To: "18:00"}}},
+			"admins": {TimeZone: "UTC", Windows: []settings.AppRadiusScheduleWindowSettings{{From: "00:00", To: "24:00"}}},
+		},
+	})
+
+	if err != nil {
+		t.Fatalf("failed to create schedules: %v", err)
+	}
+
+	night := time.Date(2026, 3, 11, 3, 0, 0, 0, time.UTC)
+
+	if _, err := schedules.Check("staff", night); !errors.Is(err, ErrOutsideSchedule) {
+		t.Fatalf("expected default schedule to apply, got %v", err)
+	}
+
+	if _, err := schedules.Check("admins", night); err != nil {
+		t.Fatalf("expected own schedule to apply, got %v", err)
+	}
+}
+
+func TestNewSchedulesInvalid(t *testing.T) {
+	testCases := []struct {
+		name     string
+		schedule *settings.AppRadiusScheduleSettings
+	}{
+		{"unknown time zone", &settings.AppRadiusScheduleSettings{TimeZone: "Mars/Olympus", Windows: []settings.AppRadiusScheduleWindowSettings{{From: "08:00", To: "18:00"}}}},
+		{"no windows", &settings.AppRadiusScheduleSettings{TimeZone: "UTC"}},
+		{"from is 24:00", &settings.AppRadiusScheduleSettings{TimeZone: "UTC", Windows: []settings.AppRadiusScheduleWindowSettings{{From: "24:00", To: "18:00"}}}},
+		{"to is 24:30", &settings.AppRadiusScheduleSettings{TimeZone: "UTC", Windows: []settings.AppRadiusScheduleWindowSettings{{From: "08:00", To: "24:30"}}}},
+		{"minutes out of range", &settings.AppRadiusScheduleSettings{TimeZone: "UTC", Windows: []settings.AppRadiusScheduleWindowSettings{{From: "08:60", To: "18:00"}}}},
+		{"not HH:MM", &settings.AppRadiusScheduleSettings{TimeZone: "UTC", Windows: []settings.AppRadiusScheduleWindowSettings{{From: "eight", To: "18:00"}}}},
+		{"unknown day", &settings.AppRadiusScheduleSettings{TimeZone: "UTC", Windows: []settings.AppRadiusScheduleWindowSettings{{Days: []string{"monday"}, From: "08:00", To: "18:00"}}}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, err := NewSchedules(&settings.AppRadiusSettings{
+				Schedules: map[string]*settings.AppRadiusScheduleSettings{"staff": testCase.schedule},
+			})
+
+			if err == nil {
+				t.Fatalf("expected error")
+			}
+		})
+	}
+}
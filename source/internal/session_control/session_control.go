@@ -0,0 +1,268 @@
+package session_control
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/strongswan/govici/vici"
+	"github.com/triflesoft/portalswan/internal/adapters/adapters"
+)
+
+const ViciSocketPath = "/var/run/strongswan/charon.vici"
+
+// Peers which do not answer DELETE are dropped after this many milliseconds.
+const terminateTimeout = "5000"
+
+var ErrSelectorMissing = errors.New("username or virtual IP is required")
+var ErrUsernameAmbiguous = errors.New("virtual IP matches IKE SAs of several users")
+
+type IkeSa struct {
+	Name       string   `json:"name"`
+	UniqueId   string   `json:"unique_id"`
+	State      string   `json:"state"`
+	Username   string   `json:"username"`
+	VirtualIps []string `json:"virtual_ips"`
+}
+
+// Selector matches IKE SAs by EAP identity, case insensitively, and/or by
+// virtual IP address.
+type Selector struct {
+	Username  string `json:"username"`
+	VirtualIp string `json:"virtual_ip"`
+}
+
+func (s *Selector) matches(ikeSa *IkeSa) bool {
+	if (s.Username != "") && !strings.EqualFold(ikeSa.Username, s.Username) {
+		return false
+	}
+
+	if (s.VirtualIp != "") && !slices.Contains(ikeSa.VirtualIps, s.VirtualIp) {
+		return false
+	}
+
+	return true
+}
+
+type DisconnectRequest struct {
+	Selector
+	RevokePasswords bool   `json:"revoke_passwords"`
+	Reason          string `json:"reason"`
+}
+
+type DisconnectResult struct {
+	TerminatedIkeSas   []*IkeSa `json:"terminated_ike_sas"`
+	RevokedIpAddresses []string `json:"revoked_ip_addresses"`
+}
+
+func viciString(message *vici.Message, key string) string {
+	value, _ := message.Get(key).(string)
+
+	return value
+}
+
+// IkeSaUsername returns EAP identity, the same as RADIUS User-Name. XAuth and
+// IKE identities are used only if EAP was not involved.
+func IkeSaUsername(ikeSa *vici.Message) string {
+	for _, key := range []string{"remote-eap-id", "remote-xauth-id", "remote-id"} {
+		if username := viciString(ikeSa, key); username != "" {
+			return username
+		}
+	}
+
+	return ""
+}
+
+// ListIkeSas returns IKE SAs matching selector in any state.
+func ListIkeSas(session *vici.Session, selector *Selector) ([]*IkeSa, error) {
+	messages, err := session.StreamedCommandRequest("list-sas", "list-sa", nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ikeSas := []*IkeSa{}
+
+	for _, message := range messages {
+		for _, ikeSaName := range message.Keys() {
+			ikeSaMessage, ok := message.Get(ikeSaName).(*vici.Message)
+
+			if !ok {
+				continue
+			}
+
+			virtualIps, _ := ikeSaMessage.Get("remote-vips").([]string)
+			ikeSa := &IkeSa{
+				Name:       ikeSaName,
+				UniqueId:   viciString(ikeSaMessage, "uniqueid"),
+				State:      viciString(ikeSaMessage, "state"),
+				Username:   IkeSaUsername(ikeSaMessage),
+				VirtualIps: virtualIps,
+			}
+
+			if selector.matches(ikeSa) {
+				ikeSas = append(ikeSas, ikeSa)
+			}
+		}
+	}
+
+	return ikeSas, nil
+}
+
+// TerminateIkeSas terminates IKE SAs matching selector, there may be several
+// if user is connected from several devices. Failures of single IKE SAs are
+// logged and joined, the rest are terminated anyway.
+func TerminateIkeSas(session *vici.Session, selector *Selector, reason string, l adapters.LoggingAdapter) ([]*IkeSa, error) {
+	ikeSas, err := ListIkeSas(session, selector)
+
+	if err != nil {
+		l.LogErrorText("Failed to list IKE SAs", "err", err)
+		return nil, err
+	}
+
+	terminatedIkeSas := []*IkeSa{}
+	errs := []error{}
+
+	for _, ikeSa := range ikeSas {
+		terminateMessage := vici.NewMessage()
+		terminateMessage.Set("ike-id", ikeSa.UniqueId)
+		terminateMessage.Set("force", "yes")
+		terminateMessage.Set("timeout", terminateTimeout)
+		terminateMessages, err := session.StreamedCommandRequest("terminate", "control-log", terminateMessage)
+
+		if err == nil {
+			err = terminateMessages[len(terminateMessages)-1].Err()
+		}
+
+		if err != nil {
+			l.LogErrorText(
+				"Failed to terminate IKE SA",
+				"err", err,
+				"ikeSa", ikeSa.Name,
+				"ikeSaId", ikeSa.UniqueId,
+				"username", ikeSa.Username,
+				"reason", reason)
+			errs = append(errs, fmt.Errorf("failed to terminate IKE SA '%s': %w", ikeSa.UniqueId, err))
+
+			continue
+		}
+
+		l.LogDebugText(
+			"VICI terminate VPN connection",
+			"ikeSa", ikeSa.Name,
+			"ikeSaId", ikeSa.UniqueId,
+			"username", ikeSa.Username,
+			"reason", reason)
+		terminatedIkeSas = append(terminatedIkeSas, ikeSa)
+	}
+
+	return terminatedIkeSas, errors.Join(errs...)
+}
+
+// SessionControl disconnects VPN users on behalf of administrators. It opens
+// its own VICI session per call, so that it works both in the service and in
+// CLI commands.
+type SessionControl struct {
+	log                adapters.LoggingAdapter
+	identityAdapter    adapters.IdentityAdapter
+	credentialsAdapter adapters.CredentialsAdapter
+}
+
+// revokePasswords deletes NT passwords of user, users already removed from
+// identity store are revoked by username alone.
+func (c *SessionControl) revokePasswords(username string) ([]string, error) {
+	vpnUser := c.identityAdapter.SelectVpnUser(username)
+
+	if vpnUser == nil {
+		vpnUser = &adapters.VpnUser{Username: username}
+	}
+
+	return c.credentialsAdapter.DeleteNtPasswords(vpnUser)
+}
+
+// Disconnect revokes passwords first, if requested, so that user cannot
+// reconnect, and then terminates IKE SAs. Passwords of user given by name are
+// revoked even if StrongSwan is not reachable, without username passwords of
+// the user owning virtual IP are revoked.
+func (c *SessionControl) Disconnect(request *DisconnectRequest) (*DisconnectResult, error) {
+	var err error
+
+	if (request.Username == "") && (request.VirtualIp == "") {
+		return nil, ErrSelectorMissing
+	}
+
+	result := &DisconnectResult{
+		TerminatedIkeSas:   []*IkeSa{},
+		RevokedIpAddresses: []string{},
+	}
+
+	if request.RevokePasswords && (request.Username != "") {
+		result.RevokedIpAddresses, err = c.revokePasswords(request.Username)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to revoke NT passwords: %w", err)
+		}
+	}
+
+	session, err := vici.NewSession(vici.WithAddr("unix", ViciSocketPath))
+
+	if err != nil {
+		c.log.LogErrorText("Failed to connect to StrongSwan", "err", err)
+		return result, err
+	}
+
+	defer session.Close()
+
+	if request.RevokePasswords && (request.Username == "") {
+		ikeSas, err := ListIkeSas(session, &request.Selector)
+
+		if err != nil {
+			c.log.LogErrorText("Failed to list IKE SAs", "err", err)
+			return result, err
+		}
+
+		username := ""
+
+		for _, ikeSa := range ikeSas {
+			if (username != "") && !strings.EqualFold(username, ikeSa.Username) {
+				return result, ErrUsernameAmbiguous
+			}
+
+			username = ikeSa.Username
+		}
+
+		if username != "" {
+			result.RevokedIpAddresses, err = c.revokePasswords(username)
+
+			if err != nil {
+				return result, fmt.Errorf("failed to revoke NT passwords: %w", err)
+			}
+		}
+	}
+
+	terminatedIkeSas, err := TerminateIkeSas(session, &request.Selector, request.Reason, c.log)
+
+	if terminatedIkeSas != nil {
+		result.TerminatedIkeSas = terminatedIkeSas
+	}
+
+	c.log.LogInfoText(
+		"SessionControl",
+		"VPN user disconnected",
+		"username", request.Username,
+		"virtualIp", request.VirtualIp,
+		"reason", request.Reason,
+		"terminatedCount", len(result.TerminatedIkeSas),
+		"revokedIpAddresses", strings.Join(result.RevokedIpAddresses, ", "))
+
+	return result, err
+}
+
+func NewSessionControl(l adapters.LoggingAdapter, identityAdapter adapters.IdentityAdapter, credentialsAdapter adapters.CredentialsAdapter) *SessionControl {
+	return &SessionControl{
+		log:                l,
+		identityAdapter:    identityAdapter,
+		credentialsAdapter: credentialsAdapter,
+	}
+}
@@ -3,13 +3,17 @@ package settings
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"os"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	smtypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
 	"github.com/fernet/fernet-go"
 )
 
@@ -19,8 +23,24 @@ type appIdentityAwsSettingsJson struct {
 	RadiusClassFromGroupNamePattern *string `json:"radius_class_from_group_name_pattern"`
 }
 
+type appIdentityLdapSettingsJson struct {
+	Url                             *string   `json:"url"`
+	StartTls                        *bool     `json:"start_tls"`
+	BindDn                          *string   `json:"bind_dn"`
+	BindPassword                    *string   `json:"bind_password"`
+	UserBaseDns                     *[]string `json:"user_base_dns"`
+	UserFilter                      *string   `json:"user_filter"`
+	UsernameAttribute               *string   `json:"username_attribute"`
+	EmailAttribute                  *string   `json:"email_attribute"`
+	GroupBaseDns                    *[]string `json:"group_base_dns"`
+	GroupFilter                     *string   `json:"group_filter"`
+	GroupNameAttribute              *string   `json:"group_name_attribute"`
+	RadiusClassFromGroupNamePattern *string   `json:"radius_class_from_group_name_pattern"`
+}
+
 type appIdentitySettingsJson struct {
-	Aws *appIdentityAwsSettingsJson `json:"aws"`
+	Aws  *appIdentityAwsSettingsJson  `json:"aws"`
+	Ldap *appIdentityLdapSettingsJson `json:"ldap"`
 }
 
 type appCredentialsAwsSettingsJson struct {
@@ -29,8 +49,21 @@ type appCredentialsAwsSettingsJson struct {
 	FernetKeys     *string `json:"fernet_keys"`
 }
 
+type appCredentialsFileSettingsJson struct {
+	DirectoryPath *string `json:"directory_path"`
+	FernetKeys    *string `json:"fernet_keys"`
+}
+
+type appCredentialsPolicySettingsJson struct {
+	IdleExpiryHours *int64 `json:"idle_expiry_hours"`
+	MaxAgeHours     *int64 `json:"max_age_hours"`
+	MaxIpAddresses  *int   `json:"max_ip_addresses"`
+}
+
 type appCredentialsSettingsJson struct {
-	Aws *appCredentialsAwsSettingsJson `json:"aws"`
+	Aws      *appCredentialsAwsSettingsJson               `json:"aws"`
+	File     *appCredentialsFileSettingsJson              `json:"file"`
+	Policies *map[string]appCredentialsPolicySettingsJson `json:"policies"`
 }
 
 type appEmailAwsSettingsJson struct {
@@ -38,23 +71,56 @@ type appEmailAwsSettingsJson struct {
 	SesSource *string `json:"ses_source"`
 }
 
+type appEmailSmtpSettingsJson struct {
+	Host          *string `json:"host"`
+	Port          *int    `json:"port"`
+	Security      *string `json:"security"`
+	AuthMechanism *string `json:"auth_mechanism"`
+	Username      *string `json:"username"`
+	Password      *string `json:"password"`
+	Source        *string `json:"source"`
+}
+
 type appEmailSettingsJson struct {
-	Aws *appEmailAwsSettingsJson `json:"aws"`
+	Aws  *appEmailAwsSettingsJson  `json:"aws"`
+	Smtp *appEmailSmtpSettingsJson `json:"smtp"`
 }
 
 type appLoggingAwsSettingsJson struct {
 	CloudWatchLogRegion *string `json:"cloudwatch_log_region"`
 	CloudWatchLogGroup  *string `json:"cloudwatch_log_group"`
+	EchoToStdout        *bool   `json:"echo_to_stdout"`
+}
+
+type appLoggingFileSettingsJson struct {
+	DirectoryPath   *string `json:"directory_path"`
+	MaxFileSizeMb   *int64  `json:"max_file_size_mb"`
+	MaxFileAgeHours *int64  `json:"max_file_age_hours"`
+	RetentionCount  *int    `json:"retention_count"`
+	Compress        *bool   `json:"compress"`
+}
+
+type appLoggingStdoutSettingsJson struct {
+}
+
+type appLoggingRouteSettingsJson struct {
+	Sink     *string   `json:"sink"`
+	Channels *[]string `json:"channels"`
+	MinLevel *string   `json:"min_level"`
 }
 
 type appLoggingSettingsJson struct {
-	Aws *appLoggingAwsSettingsJson `json:"aws"`
+	Aws    *appLoggingAwsSettingsJson     `json:"aws"`
+	File   *appLoggingFileSettingsJson    `json:"file"`
+	Stdout *appLoggingStdoutSettingsJson  `json:"stdout"`
+	Routes *[]appLoggingRouteSettingsJson `json:"routes"`
 }
 
 type appServerSettingsJson struct {
 	TlsCertificatePath   *string `json:"tls_certificate_path"`
 	TlsPrivateKeyPath    *string `json:"tls_private_key_path"`
 	VerificationHostname *string `json:"verification_hostname"`
+	StateDirectoryPath   *string `json:"state_directory_path"`
 }
 
 type appClientSettingsJson struct {
@@ -63,6 +129,127 @@ type appClientSettingsJson struct {
 	DestinationPrefixes *[]string `json:"destination_prefixes"`
 }
 
+type appRadiusUdpSettingsJson struct {
+	AuthAddress *string `json:"auth_address"`
+	AcctAddress *string `json:"acct_address"`
+	Secret      *string `json:"secret"`
+	ServerName  *string `json:"server_name"`
+}
+
+type appRadiusHttpSettingsJson struct {
+	Address           *string `json:"address"`
+	SocketPath        *string `json:"socket_path"`
+	SocketMode        *string `json:"socket_mode"`
+	SocketOwner       *string `json:"socket_owner"`
+	SocketGroup       *string `json:"socket_group"`
+	Secret            *string `json:"secret"`
+	SignatureRequired *bool   `json:"signature_required"`
+	AdminSecret       *string `json:"admin_secret"`
+}
+
+type appRadiusPoolSettingsJson struct {
+	Ipv4Prefix *string `json:"ipv4_prefix"`
+	Ipv6Prefix *string `json:"ipv6_prefix"`
+	LeaseHours *int64  `json:"lease_hours"`
+}
+
+type appRadiusQuotaSettingsJson struct {
+	DailyMegabytes   *int64   `json:"daily_megabytes"`
+	MonthlyMegabytes *int64   `json:"monthly_megabytes"`
+	WarningPercents  *[]int64 `json:"warning_percents"`
+	InterimMinutes   *int64   `json:"interim_minutes"`
+}
+
+type appRadiusLockoutSettingsJson struct {
+	UsernameMaxFailures       *int64  `json:"username_max_failures"`
+	CallingStationMaxFailures *int64  `json:"calling_station_max_failures"`
+	FailureWindowMinutes      *int64  `json:"failure_window_minutes"`
+	LockoutMinutes            *int64  `json:"lockout_minutes"`
+	MaxLockoutMinutes         *int64  `json:"max_lockout_minutes"`
+	AlertEmail                *string `json:"alert_email"`
+}
+
+type appRadiusScheduleWindowSettingsJson struct {
+	Days *[]string `json:"days"`
+	From *string   `json:"from"`
+	To   *string   `json:"to"`
+}
+
+type appRadiusScheduleSettingsJson struct {
+	TimeZone *string                                `json:"time_zone"`
+	Windows  *[]appRadiusScheduleWindowSettingsJson `json:"windows"`
+}
+
+type appRadiusSourceAddressSettingsJson struct {
+	Allow      *[]string `json:"allow"`
+	Deny       *[]string `json:"deny"`
+	AllowFiles *[]string `json:"allow_files"`
+	DenyFiles  *[]string `json:"deny_files"`
+}
+
+type appRadiusSettingsJson struct {
+	Http            *appRadiusHttpSettingsJson                     `json:"http"`
+	Udp             *appRadiusUdpSettingsJson                      `json:"udp"`
+	Pools           *map[string]appRadiusPoolSettingsJson          `json:"pools"`
+	ReplyAttributes *map[string]map[string][]string                `json:"reply_attributes"`
+	Quotas          *map[string]appRadiusQuotaSettingsJson         `json:"quotas"`
+	Lockout         *appRadiusLockoutSettingsJson                  `json:"lockout"`
+	Schedules       *map[string]appRadiusScheduleSettingsJson      `json:"schedules"`
+	SourceAddresses *map[string]appRadiusSourceAddressSettingsJson `json:"source_addresses"`
+	MaxSessions     *map[string]int                                `json:"max_sessions"`
+}
+
+type appAccountingAwsSettingsJson struct {
+	S3BucketRegion *string `json:"s3_bucket_region"`
+	S3BucketName   *string `json:"s3_bucket_name"`
+	S3Prefix       *string `json:"s3_prefix"`
+}
+
+type appAccountingFileSettingsJson struct {
+	DirectoryPath *string `json:"directory_path"`
+}
+
+type appAccountingSettingsJson struct {
+	Aws  *appAccountingAwsSettingsJson  `json:"aws"`
+	File *appAccountingFileSettingsJson `json:"file"`
+}
+
+type appVpnChildSettingsJson struct {
+	LocalTs      *[]string `json:"local_ts"`
+	EspProposals *[]string `json:"esp_proposals"`
+	DpdAction    *string   `json:"dpd_action"`
+}
+
+type appVpnConnectionSettingsJson struct {
+	LocalAddrs      *[]string                           `json:"local_addrs"`
+	Proposals       *[]string                           `json:"proposals"`
+	LocalId         *string                             `json:"local_id"`
+	CertificatePath *string                             `json:"certificate_path"`
+	PrivateKeyPath  *string                             `json:"private_key_path"`
+	RemoteAuth      *string                             `json:"remote_auth"`
+	Pools           *[]string                           `json:"pools"`
+	DpdDelaySeconds *int64                              `json:"dpd_delay_seconds"`
+	Children        *map[string]appVpnChildSettingsJson `json:"children"`
+}
+
+type appVpnPoolSettingsJson struct {
+	Addrs   *string   `json:"addrs"`
+	Dns     *[]string `json:"dns"`
+	Subnets *[]string `json:"subnets"`
+}
+
+type appVpnAuthoritySettingsJson struct {
+	CaCertPath *string   `json:"cacert_path"`
+	CrlUris    *[]string `json:"crl_uris"`
+	OcspUris   *[]string `json:"ocsp_uris"`
+}
+
+type appVpnSettingsJson struct {
+	Connections *map[string]appVpnConnectionSettingsJson `json:"connections"`
+	Pools       *map[string]appVpnPoolSettingsJson       `json:"pools"`
+	Authorities *map[string]appVpnAuthoritySettingsJson  `json:"authorities"`
+}
+
 type appSettingsJson struct {
 	Identity    *appIdentitySettingsJson    `json:"identity"`
 	Credentials *appCredentialsSettingsJson `json:"credentials"`
@@ -70,6 +257,9 @@ type appSettingsJson struct {
 	Logging     *appLoggingSettingsJson     `json:"logging"`
 	Server      *appServerSettingsJson      `json:"server"`
 	Client      *appClientSettingsJson      `json:"client"`
+	Radius      *appRadiusSettingsJson      `json:"radius"`
+	Accounting  *appAccountingSettingsJson  `json:"accounting"`
+	Vpn         *appVpnSettingsJson         `json:"vpn"`
 }
 
 type AppCredentialsAwsSettings struct {
@@ -88,17 +278,100 @@ func (s *AppCredentialsAwsSettings) merge(sj *appCredentialsAwsSettingsJson) {
 	}
 }
 
+type AppCredentialsFileSettings struct {
+	DirectoryPath string
+	FernetKeys    []*fernet.Key
+}
+
+func (s *AppCredentialsFileSettings) merge(sj *appCredentialsFileSettingsJson) {
+	if (sj.DirectoryPath != nil) && (*sj.DirectoryPath != "") &&
+		(sj.FernetKeys != nil) && (*sj.FernetKeys != "") {
+		s.DirectoryPath = *sj.DirectoryPath
+		s.FernetKeys = fernet.MustDecodeKeys(*sj.FernetKeys)
+	}
+}
+
+// Zero MaxAge means passwords never expire while they are in use.
+type AppCredentialsPolicySettings struct {
+	IdleExpiry     time.Duration
+	MaxAge         time.Duration
+	MaxIpAddresses int
+}
+
+func (s *AppCredentialsPolicySettings) merge(sj *appCredentialsPolicySettingsJson) {
+	if (sj.IdleExpiryHours != nil) && (*sj.IdleExpiryHours > 0) {
+		s.IdleExpiry = time.Duration(*sj.IdleExpiryHours) * time.Hour
+	}
+
+	if (sj.MaxAgeHours != nil) && (*sj.MaxAgeHours >= 0) {
+		s.MaxAge = time.Duration(*sj.MaxAgeHours) * time.Hour
+	}
+
+	if (sj.MaxIpAddresses != nil) && (*sj.MaxIpAddresses > 0) {
+		s.MaxIpAddresses = *sj.MaxIpAddresses
+	}
+}
+
 type AppCredentialsSettings struct {
-	Aws *AppCredentialsAwsSettings
+	Aws  *AppCredentialsAwsSettings
+	File *AppCredentialsFileSettings
+	// Keyed by RADIUS class, "*" applies to classes without own policy.
+	Policies map[string]*AppCredentialsPolicySettings
+}
+
+// PolicyForClass never returns nil, built-in policy is used if neither class
+// nor "*" policy is configured.
+func (s *AppCredentialsSettings) PolicyForClass(class string) *AppCredentialsPolicySettings {
+	if policy, exists := s.Policies[class]; exists {
+		return policy
+	}
+
+	if policy, exists := s.Policies["*"]; exists {
+		return policy
+	}
+
+	return newAppCredentialsPolicySettings()
+}
+
+func newAppCredentialsPolicySettings() *AppCredentialsPolicySettings {
+	return &AppCredentialsPolicySettings{
+		IdleExpiry:     15 * 24 * time.Hour,
+		MaxAge:         0,
+		MaxIpAddresses: 4,
+	}
 }
 
 func (s *AppCredentialsSettings) merge(sj *appCredentialsSettingsJson) {
 	if sj != nil {
-		if (sj.Aws != nil) && (s.Aws == nil) {
-			s.Aws = &AppCredentialsAwsSettings{}
+		if sj.Aws != nil {
+			if s.Aws == nil {
+				s.Aws = &AppCredentialsAwsSettings{}
+			}
+
+			s.Aws.merge(sj.Aws)
 		}
 
-		s.Aws.merge(sj.Aws)
+		if sj.File != nil {
+			if s.File == nil {
+				s.File = &AppCredentialsFileSettings{}
+			}
+
+			s.File.merge(sj.File)
+		}
+
+		if sj.Policies != nil {
+			if s.Policies == nil {
+				s.Policies = map[string]*AppCredentialsPolicySettings{}
+			}
+
+			for class, sjPolicy := range *sj.Policies {
+				if s.Policies[class] == nil {
+					s.Policies[class] = newAppCredentialsPolicySettings()
+				}
+
+				s.Policies[class].merge(&sjPolicy)
+			}
+		}
 	}
 }
 
@@ -115,17 +388,70 @@ func (s *AppEmailAwsSettings) merge(sj *appEmailAwsSettingsJson) {
 	}
 }
 
+type AppEmailSmtpSettings struct {
+	Host          string
+	Port          int
+	Security      string
+	AuthMechanism string
+	Username      string
+	Password      string
+	Source        string
+}
+
+func (s *AppEmailSmtpSettings) merge(sj *appEmailSmtpSettingsJson) {
+	if (sj.Host != nil) && (*sj.Host != "") {
+		s.Host = *sj.Host
+	}
+
+	if (sj.Port != nil) && (*sj.Port > 0) {
+		s.Port = *sj.Port
+	}
+
+	if (sj.Security != nil) && (*sj.Security != "") {
+		s.Security = *sj.Security
+	}
+
+	if (sj.AuthMechanism != nil) && (*sj.AuthMechanism != "") {
+		s.AuthMechanism = *sj.AuthMechanism
+	}
+
+	if (sj.Username != nil) && (*sj.Username != "") &&
+		(sj.Password != nil) && (*sj.Password != "") {
+		s.Username = *sj.Username
+		s.Password = *sj.Password
+	}
+
+	if (sj.Source != nil) && (*sj.Source != "") {
+		s.Source = *sj.Source
+	}
+}
+
 type AppEmailSettings struct {
-	Aws *AppEmailAwsSettings
+	Aws  *AppEmailAwsSettings
+	Smtp *AppEmailSmtpSettings
 }
 
 func (s *AppEmailSettings) merge(sj *appEmailSettingsJson) {
 	if sj != nil {
-		if (sj.Aws != nil) && (s.Aws == nil) {
-			s.Aws = &AppEmailAwsSettings{}
+		if sj.Aws != nil {
+			if s.Aws == nil {
+				s.Aws = &AppEmailAwsSettings{}
+			}
+
+			s.Aws.merge(sj.Aws)
 		}
 
-		s.Aws.merge(sj.Aws)
+		if sj.Smtp != nil {
+			if s.Smtp == nil {
+				s.Smtp = &AppEmailSmtpSettings{
+					Port:          587,
+					Security:      "starttls",
+					AuthMechanism: "plain",
+				}
+			}
+
+			s.Smtp.merge(sj.Smtp)
+		}
 	}
 }
 
@@ -145,23 +471,104 @@ func (s *AppIdentityAwsSettings) merge(sj *appIdentityAwsSettingsJson) {
 	}
 }
 
+type AppIdentityLdapSettings struct {
+	Url                             string
+	StartTls                        bool
+	BindDn                          string
+	BindPassword                    string
+	UserBaseDns                     []string
+	UserFilter                      string
+	UsernameAttribute               string
+	EmailAttribute                  string
+	GroupBaseDns                    []string
+	GroupFilter                     string
+	GroupNameAttribute              string
+	RadiusClassFromGroupNamePattern string
+}
+
+func (s *AppIdentityLdapSettings) merge(sj *appIdentityLdapSettingsJson) {
+	if (sj.Url != nil) && (*sj.Url != "") {
+		s.Url = *sj.Url
+	}
+
+	if sj.StartTls != nil {
+		s.StartTls = *sj.StartTls
+	}
+
+	if (sj.BindDn != nil) && (*sj.BindDn != "") &&
+		(sj.BindPassword != nil) && (*sj.BindPassword != "") {
+		s.BindDn = *sj.BindDn
+		s.BindPassword = *sj.BindPassword
+	}
+
+	if (sj.UserBaseDns != nil) && (len(*sj.UserBaseDns) > 0) {
+		s.UserBaseDns = *sj.UserBaseDns
+	}
+
+	if (sj.UserFilter != nil) && (*sj.UserFilter != "") {
+		s.UserFilter = *sj.UserFilter
+	}
+
+	if (sj.UsernameAttribute != nil) && (*sj.UsernameAttribute != "") {
+		s.UsernameAttribute = *sj.UsernameAttribute
+	}
+
+	if (sj.EmailAttribute != nil) && (*sj.EmailAttribute != "") {
+		s.EmailAttribute = *sj.EmailAttribute
+	}
+
+	if sj.GroupBaseDns != nil {
+		s.GroupBaseDns = *sj.GroupBaseDns
+	}
+
+	if (sj.GroupFilter != nil) && (*sj.GroupFilter != "") {
+		s.GroupFilter = *sj.GroupFilter
+	}
+
+	if (sj.GroupNameAttribute != nil) && (*sj.GroupNameAttribute != "") {
+		s.GroupNameAttribute = *sj.GroupNameAttribute
+	}
+
+	if (sj.RadiusClassFromGroupNamePattern != nil) && (*sj.RadiusClassFromGroupNamePattern != "") {
+		s.RadiusClassFromGroupNamePattern = *sj.RadiusClassFromGroupNamePattern
+	}
+}
+
 type AppIdentitySettings struct {
-	Aws *AppIdentityAwsSettings
+	Aws  *AppIdentityAwsSettings
+	Ldap *AppIdentityLdapSettings
 }
 
 func (s *AppIdentitySettings) merge(sj *appIdentitySettingsJson) {
 	if sj != nil {
-		if (sj.Aws != nil) && (s.Aws == nil) {
-			s.Aws = &AppIdentityAwsSettings{}
+		if sj.Aws != nil {
+			if s.Aws == nil {
+				s.Aws = &AppIdentityAwsSettings{}
+			}
+
+			s.Aws.merge(sj.Aws)
 		}
 
-		s.Aws.merge(sj.Aws)
+		if sj.Ldap != nil {
+			if s.Ldap == nil {
+				s.Ldap = &AppIdentityLdapSettings{
+					UserFilter:         "(&(objectClass=person)(|(uid={username})(sAMAccountName={username})(mail={username})))",
+					UsernameAttribute:  "uid",
+					EmailAttribute:     "mail",
+					GroupFilter:        "(|(member={dn})(uniqueMember={dn})(memberUid={username}))",
+					GroupNameAttribute: "cn",
+				}
+			}
+
+			s.Ldap.merge(sj.Ldap)
+		}
 	}
 }
 
 type AppLoggingAwsSettings struct {
 	CloudWatchLogRegion string
 	CloudWatchLogGroup  string
+	EchoToStdout        bool
 }
 
 func (s *AppLoggingAwsSettings) merge(sj *appLoggingAwsSettingsJson) {
@@ -170,19 +577,119 @@ func (s *AppLoggingAwsSettings) merge(sj *appLoggingAwsSettingsJson) {
 		s.CloudWatchLogRegion = *sj.CloudWatchLogRegion
 		s.CloudWatchLogGroup = *sj.CloudWatchLogGroup
 	}
+
+	if sj.EchoToStdout != nil {
+		s.EchoToStdout = *sj.EchoToStdout
+	}
+}
+
+type AppLoggingFileSettings struct {
+	DirectoryPath  string
+	MaxFileSize    int64
+	MaxFileAge     time.Duration
+	RetentionCount int
+	Compress       bool
+}
+
+func (s *AppLoggingFileSettings) merge(sj *appLoggingFileSettingsJson) {
+	if (sj.DirectoryPath != nil) && (*sj.DirectoryPath != "") {
+		s.DirectoryPath = *sj.DirectoryPath
+	}
+
+	if (sj.MaxFileSizeMb != nil) && (*sj.MaxFileSizeMb > 0) {
+		s.MaxFileSize = *sj.MaxFileSizeMb * 1024 * 1024
+	}
+
+	if (sj.MaxFileAgeHours != nil) && (*sj.MaxFileAgeHours > 0) {
+		s.MaxFileAge = time.Duration(*sj.MaxFileAgeHours) * time.Hour
+	}
+
+	if (sj.RetentionCount != nil) && (*sj.RetentionCount >= 0) {
+		s.RetentionCount = *sj.RetentionCount
+	}
+
+	if sj.Compress != nil {
+		s.Compress = *sj.Compress
+	}
+}
+
+type AppLoggingStdoutSettings struct {
+}
+
+func (s *AppLoggingStdoutSettings) merge(sj *appLoggingStdoutSettingsJson) {
+}
+
+type AppLoggingRouteSettings struct {
+	Sink     string
+	Channels []string
+	MinLevel string
 }
 
 type AppLoggingSettings struct {
-	Aws *AppLoggingAwsSettings
+	Aws    *AppLoggingAwsSettings
+	File   *AppLoggingFileSettings
+	Stdout *AppLoggingStdoutSettings
+	Routes []AppLoggingRouteSettings
 }
 
 func (s *AppLoggingSettings) merge(sj *appLoggingSettingsJson) {
 	if sj != nil {
-		if (sj.Aws != nil) && (s.Aws == nil) {
-			s.Aws = &AppLoggingAwsSettings{}
+		if sj.Aws != nil {
+			if s.Aws == nil {
+				s.Aws = &AppLoggingAwsSettings{
+					EchoToStdout: true,
+				}
+			}
+
+			s.Aws.merge(sj.Aws)
+		}
+
+		if sj.File != nil {
+			if s.File == nil {
+				s.File = &AppLoggingFileSettings{
+					DirectoryPath:  "/var/log/portalswan",
+					MaxFileSize:    100 * 1024 * 1024,
+					MaxFileAge:     24 * time.Hour,
+					RetentionCount: 14,
+					Compress:       true,
+				}
+			}
+
+			s.File.merge(sj.File)
 		}
 
-		s.Aws.merge(sj.Aws)
+		if sj.Stdout != nil {
+			if s.Stdout == nil {
+				s.Stdout = &AppLoggingStdoutSettings{}
+			}
+
+			s.Stdout.merge(sj.Stdout)
+		}
+
+		if sj.Routes != nil {
+			s.Routes = make([]AppLoggingRouteSettings, 0, len(*sj.Routes))
+
+			for _, sjRoute := range *sj.Routes {
+				route := AppLoggingRouteSettings{
+					Channels: []string{"*"},
+					MinLevel: "debug",
+				}
+
+				if sjRoute.Sink != nil {
+					route.Sink = *sjRoute.Sink
+				}
+
+				if (sjRoute.Channels != nil) && (len(*sjRoute.Channels) > 0) {
+					route.Channels = *sjRoute.Channels
+				}
+
+				if (sjRoute.MinLevel != nil) && (*sjRoute.MinLevel != "") {
+					route.MinLevel = *sjRoute.MinLevel
+				}
+
+				s.Routes = append(s.Routes, route)
+			}
+		}
 	}
 }
 
@@ -190,6 +697,7 @@ type AppServerSettings struct {
 	TlsCertificatePath   string
 	TlsPrivateKeyPath    string
 	VerificationHostname string
+	StateDirectoryPath   string
 }
 
 func (s *AppServerSettings) merge(sj *appServerSettingsJson) {
@@ -202,6 +710,10 @@ func (s *AppServerSettings) merge(sj *appServerSettingsJson) {
 	if (sj.VerificationHostname != nil) && (*sj.VerificationHostname != "") {
 		s.VerificationHostname = *sj.VerificationHostname
 	}
+
+	if (sj.StateDirectoryPath != nil) && (*sj.StateDirectoryPath != "") {
+		s.StateDirectoryPath = *sj.StateDirectoryPath
+	}
 }
 
 type AppClientSettings struct {
@@ -249,6 +761,655 @@ func (s *AppClientSettings) merge(sj *appClientSettingsJson) {
 	}
 }
 
+type AppRadiusUdpSettings struct {
+	AuthAddress string
+	AcctAddress string
+	Secret      string
+	ServerName  string
+}
+
+func (s *AppRadiusUdpSettings) merge(sj *appRadiusUdpSettingsJson) {
+	if (sj.AuthAddress != nil) && (*sj.AuthAddress != "") {
+		s.AuthAddress = *sj.AuthAddress
+	}
+
+	if (sj.AcctAddress != nil) && (*sj.AcctAddress != "") {
+		s.AcctAddress = *sj.AcctAddress
+	}
+
+	if (sj.Secret != nil) && (*sj.Secret != "") {
+		s.Secret = *sj.Secret
+	}
+
+	if (sj.ServerName != nil) && (*sj.ServerName != "") {
+		s.ServerName = *sj.ServerName
+	}
+}
+
+// RADIUS REST server listens on SocketPath if specified, on Address
+// otherwise. SocketMode is an octal string validated when server starts.
+type AppRadiusHttpSettings struct {
+	Address           string
+	SocketPath        string
+	SocketMode        string
+	SocketOwner       string
+	SocketGroup       string
+	Secret            string
+	SignatureRequired bool
+	// Optional, admin endpoints are not served without it.
+	AdminSecret string
+}
+
+func newAppRadiusHttpSettings() *AppRadiusHttpSettings {
+	return &AppRadiusHttpSettings{
+		Address:    "127.0.0.1:8080",
+		SocketMode: "0660",
+	}
+}
+
+func (s *AppRadiusHttpSettings) merge(sj *appRadiusHttpSettingsJson) {
+	if (sj.Address != nil) && (*sj.Address != "") {
+		s.Address = *sj.Address
+	}
+
+	if sj.SocketPath != nil {
+		s.SocketPath = *sj.SocketPath
+	}
+
+	if (sj.SocketMode != nil) && (*sj.SocketMode != "") {
+		s.SocketMode = *sj.SocketMode
+	}
+
+	if sj.SocketOwner != nil {
+		s.SocketOwner = *sj.SocketOwner
+	}
+
+	if sj.SocketGroup != nil {
+		s.SocketGroup = *sj.SocketGroup
+	}
+
+	if (sj.Secret != nil) && (*sj.Secret != "") {
+		s.Secret = *sj.Secret
+	}
+
+	if sj.SignatureRequired != nil {
+		s.SignatureRequired = *sj.SignatureRequired
+	}
+
+	if (sj.AdminSecret != nil) && (*sj.AdminSecret != "") {
+		s.AdminSecret = *sj.AdminSecret
+	}
+}
+
+// Prefixes are validated when pools are loaded, LeaseDuration limits how long
+// a lease stays assigned without accounting updates.
+type AppRadiusPoolSettings struct {
+	Ipv4Prefix    string
+	Ipv6Prefix    string
+	LeaseDuration time.Duration
+}
+
+func (s *AppRadiusPoolSettings) merge(sj *appRadiusPoolSettingsJson) {
+	if sj.Ipv4Prefix != nil {
+		s.Ipv4Prefix = *sj.Ipv4Prefix
+	}
+
+	if sj.Ipv6Prefix != nil {
+		s.Ipv6Prefix = *sj.Ipv6Prefix
+	}
+
+	if (sj.LeaseHours != nil) && (*sj.LeaseHours > 0) {
+		s.LeaseDuration = time.Duration(*sj.LeaseHours) * time.Hour
+	}
+}
+
+// Quotas limit bytes sent and received together, zero means unlimited.
+// Days and months are UTC. InterimInterval is sent to NAS as
+// Acct-Interim-Interval, usage is only known from accounting.
+type AppRadiusQuotaSettings struct {
+	DailyBytes      int64
+	MonthlyBytes    int64
+	WarningPercents []int64
+	InterimInterval time.Duration
+}
+
+func (s *AppRadiusQuotaSettings) merge(sj *appRadiusQuotaSettingsJson) {
+	if (sj.DailyMegabytes != nil) && (*sj.DailyMegabytes >= 0) {
+		s.DailyBytes = *sj.DailyMegabytes * 1024 * 1024
+	}
+
+	if (sj.MonthlyMegabytes != nil) && (*sj.MonthlyMegabytes >= 0) {
+		s.MonthlyBytes = *sj.MonthlyMegabytes * 1024 * 1024
+	}
+
+	if sj.WarningPercents != nil {
+		s.WarningPercents = *sj.WarningPercents
+	}
+
+	if (sj.InterimMinutes != nil) && (*sj.InterimMinutes > 0) {
+		s.InterimInterval = time.Duration(*sj.InterimMinutes) * time.Minute
+	}
+}
+
+// Failures within FailureWindow are counted per username and per
+// Calling-Station-Id, zero max failures disables counting. Lockout duration
+// doubles with every lockout up to MaxLockoutDuration.
+type AppRadiusLockoutSettings struct {
+	UsernameMaxFailures       int64
+	CallingStationMaxFailures int64
+	FailureWindow             time.Duration
+	LockoutDuration           time.Duration
+	MaxLockoutDuration        time.Duration
+	AlertEmail                string
+}
+
+func (s *AppRadiusLockoutSettings) merge(sj *appRadiusLockoutSettingsJson) {
+	if (sj.UsernameMaxFailures != nil) && (*sj.UsernameMaxFailures >= 0) {
+		s.UsernameMaxFailures = *sj.UsernameMaxFailures
+	}
+
+	if (sj.CallingStationMaxFailures != nil) && (*sj.CallingStationMaxFailures >= 0) {
+		s.CallingStationMaxFailures = *sj.CallingStationMaxFailures
+	}
+
+	if (sj.FailureWindowMinutes != nil) && (*sj.FailureWindowMinutes > 0) {
+		s.FailureWindow = time.Duration(*sj.FailureWindowMinutes) * time.Minute
+	}
+
+	if (sj.LockoutMinutes != nil) && (*sj.LockoutMinutes > 0) {
+		s.LockoutDuration = time.Duration(*sj.LockoutMinutes) * time.Minute
+	}
+
+	if (sj.MaxLockoutMinutes != nil) && (*sj.MaxLockoutMinutes > 0) {
+		s.MaxLockoutDuration = time.Duration(*sj.MaxLockoutMinutes) * time.Minute
+	}
+
+	if sj.AlertEmail != nil {
+		s.AlertEmail = *sj.AlertEmail
+	}
+}
+
+// Days are "mon" to "sun", all days if empty. From and To are "HH:MM" in
+// schedule time zone, window ends next day if To is not after From.
+type AppRadiusScheduleWindowSettings struct {
+	Days []string
+	From string
+	To   string
+}
+
+// Windows are validated when schedules are loaded.
+type AppRadiusScheduleSettings struct {
+	TimeZone string
+	Windows  []AppRadiusScheduleWindowSettings
+}
+
+func (s *AppRadiusScheduleSettings) merge(sj *appRadiusScheduleSettingsJson) {
+	if (sj.TimeZone != nil) && (*sj.TimeZone != "") {
+		s.TimeZone = *sj.TimeZone
+	}
+
+	if sj.Windows != nil {
+		s.Windows = make([]AppRadiusScheduleWindowSettings, 0, len(*sj.Windows))
+
+		for _, sjWindow := range *sj.Windows {
+			window := AppRadiusScheduleWindowSettings{}
+
+			if sjWindow.Days != nil {
+				window.Days = *sjWindow.Days
+			}
+
+			if sjWindow.From != nil {
+				window.From = *sjWindow.From
+			}
+
+			if sjWindow.To != nil {
+				window.To = *sjWindow.To
+			}
+
+			s.Windows = append(s.Windows, window)
+		}
+	}
+}
+
+// Lists are CIDRs or addresses, files contain one per line and may have
+// comments starting with "#".
+type AppRadiusSourceAddressSettings struct {
+	Allow      []string
+	Deny       []string
+	AllowFiles []string
+	DenyFiles  []string
+}
+
+func (s *AppRadiusSourceAddressSettings) merge(sj *appRadiusSourceAddressSettingsJson) {
+	if sj.Allow != nil {
+		s.Allow = *sj.Allow
+	}
+
+	if sj.Deny != nil {
+		s.Deny = *sj.Deny
+	}
+
+	if sj.AllowFiles != nil {
+		s.AllowFiles = *sj.AllowFiles
+	}
+
+	if sj.DenyFiles != nil {
+		s.DenyFiles = *sj.DenyFiles
+	}
+}
+
+type AppRadiusSettings struct {
+	Http *AppRadiusHttpSettings
+	Udp  *AppRadiusUdpSettings
+	// Keyed by RADIUS class, "*" applies to classes without own pool.
+	Pools map[string]*AppRadiusPoolSettings
+	// Keyed by RADIUS class and then by attribute name, values are templates,
+	// "*" applies to classes without own attributes.
+	ReplyAttributes map[string]map[string][]string
+	// Keyed by RADIUS class, "*" applies to classes without own quota.
+	Quotas map[string]*AppRadiusQuotaSettings
+	// Optional, nil if failed logins are not tracked.
+	Lockout *AppRadiusLockoutSettings
+	// Keyed by RADIUS class, "*" applies to classes without own schedule.
+	Schedules map[string]*AppRadiusScheduleSettings
+	// Keyed by RADIUS class, deny lists of "*" apply to all classes, allow
+	// lists of "*" apply to classes without own allow lists.
+	SourceAddresses map[string]*AppRadiusSourceAddressSettings
+	// Keyed by RADIUS class, "*" applies to classes without own limit.
+	MaxSessions map[string]int
+}
+
+// MaxSessionsForClass returns limit of simultaneous sessions of class users,
+// zero if class is not limited.
+func (s *AppRadiusSettings) MaxSessionsForClass(class string) int {
+	if maxSessions, exists := s.MaxSessions[class]; exists {
+		return maxSessions
+	}
+
+	return s.MaxSessions["*"]
+}
+
+// QuotaForClass returns quota of class, nil if class is not limited.
+func (s *AppRadiusSettings) QuotaForClass(class string) *AppRadiusQuotaSettings {
+	if quota, exists := s.Quotas[class]; exists {
+		return quota
+	}
+
+	return s.Quotas["*"]
+}
+
+// PoolNameForClass returns key of the pool serving class, empty if there is
+// none and addresses are assigned by strongSwan.
+func (s *AppRadiusSettings) PoolNameForClass(class string) string {
+	if _, exists := s.Pools[class]; exists {
+		return class
+	}
+
+	if _, exists := s.Pools["*"]; exists {
+		return "*"
+	}
+
+	return ""
+}
+
+func (s *AppRadiusSettings) merge(sj *appRadiusSettingsJson) {
+	if sj != nil {
+		if sj.ReplyAttributes != nil {
+			if s.ReplyAttributes == nil {
+				s.ReplyAttributes = map[string]map[string][]string{}
+			}
+
+			for class, replyAttributes := range *sj.ReplyAttributes {
+				s.ReplyAttributes[class] = replyAttributes
+			}
+		}
+
+		if sj.MaxSessions != nil {
+			if s.MaxSessions == nil {
+				s.MaxSessions = map[string]int{}
+			}
+
+			for class, maxSessions := range *sj.MaxSessions {
+				s.MaxSessions[class] = maxSessions
+			}
+		}
+
+		if sj.SourceAddresses != nil {
+			if s.SourceAddresses == nil {
+				s.SourceAddresses = map[string]*AppRadiusSourceAddressSettings{}
+			}
+
+			for class, sjSourceAddress := range *sj.SourceAddresses {
+				if s.SourceAddresses[class] == nil {
+					s.SourceAddresses[class] = &AppRadiusSourceAddressSettings{}
+				}
+
+				s.SourceAddresses[class].merge(&sjSourceAddress)
+			}
+		}
+
+		if sj.Schedules != nil {
+			if s.Schedules == nil {
+				s.Schedules = map[string]*AppRadiusScheduleSettings{}
+			}
+
+			for class, sjSchedule := range *sj.Schedules {
+				if s.Schedules[class] == nil {
+					s.Schedules[class] = &AppRadiusScheduleSettings{
+						TimeZone: "UTC",
+					}
+				}
+
+				s.Schedules[class].merge(&sjSchedule)
+			}
+		}
+
+		if sj.Lockout != nil {
+			if s.Lockout == nil {
+				s.Lockout = &AppRadiusLockoutSettings{
+					UsernameMaxFailures:       5,
+					CallingStationMaxFailures: 20,
+					FailureWindow:             15 * time.Minute,
+					LockoutDuration:           5 * time.Minute,
+					MaxLockoutDuration:        24 * time.Hour,
+				}
+			}
+
+			s.Lockout.merge(sj.Lockout)
+		}
+
+		if sj.Quotas != nil {
+			if s.Quotas == nil {
+				s.Quotas = map[string]*AppRadiusQuotaSettings{}
+			}
+
+			for class, sjQuota := range *sj.Quotas {
+				if s.Quotas[class] == nil {
+					s.Quotas[class] = &AppRadiusQuotaSettings{
+						WarningPercents: []int64{80, 95},
+						InterimInterval: 5 * time.Minute,
+					}
+				}
+
+				s.Quotas[class].merge(&sjQuota)
+			}
+		}
+
+		if sj.Pools != nil {
+			if s.Pools == nil {
+				s.Pools = map[string]*AppRadiusPoolSettings{}
+			}
+
+			for class, sjPool := range *sj.Pools {
+				if s.Pools[class] == nil {
+					s.Pools[class] = &AppRadiusPoolSettings{
+						LeaseDuration: 24 * time.Hour,
+					}
+				}
+
+				s.Pools[class].merge(&sjPool)
+			}
+		}
+
+		if sj.Http != nil {
+			if s.Http == nil {
+				s.Http = newAppRadiusHttpSettings()
+			}
+
+			s.Http.merge(sj.Http)
+		}
+
+		if sj.Udp != nil {
+			if s.Udp == nil {
+				s.Udp = &AppRadiusUdpSettings{
+					AuthAddress: "127.0.0.1:1812",
+					AcctAddress: "127.0.0.1:1813",
+					ServerName:  "portalswan",
+				}
+			}
+
+			s.Udp.merge(sj.Udp)
+		}
+	}
+}
+
+type AppAccountingAwsSettings struct {
+	S3BucketRegion string
+	S3BucketName   string
+	S3Prefix       string
+}
+
+func (s *AppAccountingAwsSettings) merge(sj *appAccountingAwsSettingsJson) {
+	if (sj.S3BucketRegion != nil) && (*sj.S3BucketRegion != "") {
+		s.S3BucketRegion = *sj.S3BucketRegion
+	}
+
+	if (sj.S3BucketName != nil) && (*sj.S3BucketName != "") {
+		s.S3BucketName = *sj.S3BucketName
+	}
+
+	if sj.S3Prefix != nil {
+		s.S3Prefix = *sj.S3Prefix
+	}
+}
+
+type AppAccountingFileSettings struct {
+	DirectoryPath string
+}
+
+func (s *AppAccountingFileSettings) merge(sj *appAccountingFileSettingsJson) {
+	if (sj.DirectoryPath != nil) && (*sj.DirectoryPath != "") {
+		s.DirectoryPath = *sj.DirectoryPath
+	}
+}
+
+type AppAccountingSettings struct {
+	Aws  *AppAccountingAwsSettings
+	File *AppAccountingFileSettings
+}
+
+func (s *AppAccountingSettings) merge(sj *appAccountingSettingsJson) {
+	if sj != nil {
+		if sj.Aws != nil {
+			if s.Aws == nil {
+				s.Aws = &AppAccountingAwsSettings{
+					S3Prefix: "accounting/",
+				}
+			}
+
+			s.Aws.merge(sj.Aws)
+		}
+
+		if sj.File != nil {
+			if s.File == nil {
+				s.File = &AppAccountingFileSettings{}
+			}
+
+			s.File.merge(sj.File)
+		}
+	}
+}
+
+// Empty LocalTs, Dns and Subnets are filled from client settings when
+// definitions are loaded, so that they follow client settings.
+type AppVpnChildSettings struct {
+	LocalTs      []string
+	EspProposals []string
+	DpdAction    string
+}
+
+func (s *AppVpnChildSettings) merge(sj *appVpnChildSettingsJson) {
+	if sj.LocalTs != nil {
+		s.LocalTs = *sj.LocalTs
+	}
+
+	if sj.EspProposals != nil {
+		s.EspProposals = *sj.EspProposals
+	}
+
+	if (sj.DpdAction != nil) && (*sj.DpdAction != "") {
+		s.DpdAction = *sj.DpdAction
+	}
+}
+
+// Empty CertificatePath and PrivateKeyPath are taken from server settings.
+type AppVpnConnectionSettings struct {
+	LocalAddrs      []string
+	Proposals       []string
+	LocalId         string
+	CertificatePath string
+	PrivateKeyPath  string
+	RemoteAuth      string
+	Pools           []string
+	DpdDelay        time.Duration
+	Children        map[string]*AppVpnChildSettings
+}
+
+func (s *AppVpnConnectionSettings) merge(sj *appVpnConnectionSettingsJson) {
+	if sj.LocalAddrs != nil {
+		s.LocalAddrs = *sj.LocalAddrs
+	}
+
+	if sj.Proposals != nil {
+		s.Proposals = *sj.Proposals
+	}
+
+	if sj.LocalId != nil {
+		s.LocalId = *sj.LocalId
+	}
+
+	if sj.CertificatePath != nil {
+		s.CertificatePath = *sj.CertificatePath
+	}
+
+	if sj.PrivateKeyPath != nil {
+		s.PrivateKeyPath = *sj.PrivateKeyPath
+	}
+
+	if (sj.RemoteAuth != nil) && (*sj.RemoteAuth != "") {
+		s.RemoteAuth = *sj.RemoteAuth
+	}
+
+	if sj.Pools != nil {
+		s.Pools = *sj.Pools
+	}
+
+	if (sj.DpdDelaySeconds != nil) && (*sj.DpdDelaySeconds >= 0) {
+		s.DpdDelay = time.Duration(*sj.DpdDelaySeconds) * time.Second
+	}
+
+	if sj.Children != nil {
+		if s.Children == nil {
+			s.Children = map[string]*AppVpnChildSettings{}
+		}
+
+		for name, sjChild := range *sj.Children {
+			if s.Children[name] == nil {
+				s.Children[name] = &AppVpnChildSettings{
+					DpdAction: "clear",
+				}
+			}
+
+			s.Children[name].merge(&sjChild)
+		}
+	}
+}
+
+type AppVpnPoolSettings struct {
+	Addrs   string
+	Dns     []string
+	Subnets []string
+}
+
+func (s *AppVpnPoolSettings) merge(sj *appVpnPoolSettingsJson) {
+	if sj.Addrs != nil {
+		s.Addrs = *sj.Addrs
+	}
+
+	if sj.Dns != nil {
+		s.Dns = *sj.Dns
+	}
+
+	if sj.Subnets != nil {
+		s.Subnets = *sj.Subnets
+	}
+}
+
+type AppVpnAuthoritySettings struct {
+	CaCertPath string
+	CrlUris    []string
+	OcspUris   []string
+}
+
+func (s *AppVpnAuthoritySettings) merge(sj *appVpnAuthoritySettingsJson) {
+	if sj.CaCertPath != nil {
+		s.CaCertPath = *sj.CaCertPath
+	}
+
+	if sj.CrlUris != nil {
+		s.CrlUris = *sj.CrlUris
+	}
+
+	if sj.OcspUris != nil {
+		s.OcspUris = *sj.OcspUris
+	}
+}
+
+// Keyed by StrongSwan connection, pool and authority name.
+type AppVpnSettings struct {
+	Connections map[string]*AppVpnConnectionSettings
+	Pools       map[string]*AppVpnPoolSettings
+	Authorities map[string]*AppVpnAuthoritySettings
+}
+
+func (s *AppVpnSettings) merge(sj *appVpnSettingsJson) {
+	if sj.Connections != nil {
+		if s.Connections == nil {
+			s.Connections = map[string]*AppVpnConnectionSettings{}
+		}
+
+		for name, sjConnection := range *sj.Connections {
+			if s.Connections[name] == nil {
+				s.Connections[name] = &AppVpnConnectionSettings{
+					LocalAddrs: []string{"%any"},
+					RemoteAuth: "eap-radius",
+					DpdDelay:   30 * time.Second,
+				}
+			}
+
+			s.Connections[name].merge(&sjConnection)
+		}
+	}
+
+	if sj.Pools != nil {
+		if s.Pools == nil {
+			s.Pools = map[string]*AppVpnPoolSettings{}
+		}
+
+		for name, sjPool := range *sj.Pools {
+			if s.Pools[name] == nil {
+				s.Pools[name] = &AppVpnPoolSettings{}
+			}
+
+			s.Pools[name].merge(&sjPool)
+		}
+	}
+
+	if sj.Authorities != nil {
+		if s.Authorities == nil {
+			s.Authorities = map[string]*AppVpnAuthoritySettings{}
+		}
+
+		for name, sjAuthority := range *sj.Authorities {
+			if s.Authorities[name] == nil {
+				s.Authorities[name] = &AppVpnAuthoritySettings{}
+			}
+
+			s.Authorities[name].merge(&sjAuthority)
+		}
+	}
+}
+
 type AppSettings struct {
 	Identity    *AppIdentitySettings
 	Credentials *AppCredentialsSettings
@@ -256,6 +1417,10 @@ type AppSettings struct {
 	Logging     *AppLoggingSettings
 	Server      *AppServerSettings
 	Client      *AppClientSettings
+	Radius      *AppRadiusSettings
+	Accounting  *AppAccountingSettings
+	// Optional, nil if StrongSwan definitions are not managed.
+	Vpn *AppVpnSettings
 }
 
 func (s *AppSettings) merge(sj *appSettingsJson) {
@@ -298,6 +1463,7 @@ func (s *AppSettings) merge(sj *appSettingsJson) {
 
 				s.Server = &AppServerSettings{
 					VerificationHostname: hostname,
+					StateDirectoryPath:   "/var/lib/portalswan",
 				}
 			}
 
@@ -311,10 +1477,46 @@ func (s *AppSettings) merge(sj *appSettingsJson) {
 
 			s.Client.merge(sj.Client)
 		}
+
+		if sj.Radius != nil {
+			if s.Radius == nil {
+				s.Radius = &AppRadiusSettings{
+					Http: newAppRadiusHttpSettings(),
+				}
+			}
+
+			s.Radius.merge(sj.Radius)
+		}
+
+		if sj.Accounting != nil {
+			if s.Accounting == nil {
+				s.Accounting = &AppAccountingSettings{}
+			}
+
+			s.Accounting.merge(sj.Accounting)
+		}
+
+		if sj.Vpn != nil {
+			if s.Vpn == nil {
+				s.Vpn = &AppVpnSettings{}
+			}
+
+			s.Vpn.merge(sj.Vpn)
+		}
 	}
 }
 
-func (appSettings *AppSettings) updateFromFile(path string) {
+// StateDirectoryPath falls back to default when server section is missing.
+func (appSettings *AppSettings) StateDirectoryPath() string {
+	if appSettings.Server != nil {
+		return appSettings.Server.StateDirectoryPath
+	}
+
+	return "/var/lib/portalswan"
+}
+
+// updateFromFile skips missing file, settings may come from AWS only.
+func (appSettings *AppSettings) updateFromFile(path string) error {
 	logger := slog.New(
 		slog.NewJSONHandler(
 			os.Stderr,
@@ -322,9 +1524,14 @@ func (appSettings *AppSettings) updateFromFile(path string) {
 
 	file, err := os.Open(path)
 
+	if errors.Is(err, os.ErrNotExist) {
+		logger.Debug("Settings file does not exist", "path", path)
+		return nil
+	}
+
 	if err != nil {
 		logger.Error("Failed to open file", "err", err, "path", path)
-		return
+		return fmt.Errorf("failed to open '%s': %w", path, err)
 	}
 
 	defer file.Close()
@@ -333,13 +1540,17 @@ func (appSettings *AppSettings) updateFromFile(path string) {
 
 	if err := json.NewDecoder(file).Decode(appSettingsJson); err != nil {
 		logger.Error("Failed to unmarshal JSON", "err", err)
-		return
+		return fmt.Errorf("failed to unmarshal '%s': %w", path, err)
 	}
 
 	appSettings.merge(appSettingsJson)
+
+	return nil
 }
 
-func (appSettings *AppSettings) updateFromAws() {
+// updateFromAws skips instances without metadata service or secret, settings
+// may come from file only.
+func (appSettings *AppSettings) updateFromAws() error {
 	logger := slog.New(
 		slog.NewJSONHandler(
 			os.Stderr,
@@ -350,7 +1561,7 @@ func (appSettings *AppSettings) updateFromAws() {
 
 	if err != nil {
 		logger.Error("Failed to load default AWS config", "err", err)
-		return
+		return nil
 	}
 
 	imdsClient := imds.NewFromConfig(awsConfig)
@@ -362,7 +1573,7 @@ func (appSettings *AppSettings) updateFromAws() {
 
 	if err != nil {
 		logger.Error("Failed to get AWS EC2 instance metadata", "err", err)
-		return
+		return nil
 	}
 
 	ec2InstanceIdData, _ := io.ReadAll(ec2InstanceIdOutput.Content)
@@ -374,7 +1585,7 @@ func (appSettings *AppSettings) updateFromAws() {
 
 	if err != nil {
 		logger.Error("Failed to get AWS region metadata", "err", err)
-		return
+		return fmt.Errorf("failed to get AWS region metadata: %w", err)
 	}
 
 	regionData, _ := io.ReadAll(regionOutput.Content)
@@ -387,9 +1598,16 @@ func (appSettings *AppSettings) updateFromAws() {
 		ctx,
 		&secretsmanager.GetSecretValueInput{SecretId: &ec2InstanceId})
 
+	var notFoundErr *smtypes.ResourceNotFoundException
+
+	if errors.As(err, &notFoundErr) {
+		logger.Debug("Secret does not exist", "secretId", ec2InstanceId)
+		return nil
+	}
+
 	if err != nil {
 		logger.Error("Failed to get secret value", "err", err, "secretId", ec2InstanceId)
-		return
+		return fmt.Errorf("failed to get secret value '%s': %w", ec2InstanceId, err)
 	}
 
 	appSettingsJson := &appSettingsJson{}
@@ -397,17 +1615,32 @@ func (appSettings *AppSettings) updateFromAws() {
 
 	if err != nil {
 		logger.Error("Failed to unmarshal JSON", "err", err)
-		return
+		return fmt.Errorf("failed to unmarshal secret value '%s': %w", ec2InstanceId, err)
 	}
 
 	appSettings.merge(appSettingsJson)
+
+	return nil
 }
 
-func NewAppSettings() *AppSettings {
-	appSettings := &AppSettings{}
+// LoadAppSettings reads settings from file and AWS, missing file or secret is
+// not an error, unreadable or invalid one is.
+func LoadAppSettings() (*AppSettings, error) {
+	appSettings := &AppSettings{
+		Radius: &AppRadiusSettings{
+			Http: newAppRadiusHttpSettings(),
+		},
+	}
+
+	fileErr := appSettings.updateFromFile("/etc/portalswan/portalswan.conf")
+	awsErr := appSettings.updateFromAws()
 
-	appSettings.updateFromFile("/etc/portalswan/portalswan.conf")
-	appSettings.updateFromAws()
+	return appSettings, errors.Join(fileErr, awsErr)
+}
+
+// NewAppSettings returns whatever settings could be read, errors are logged.
+func NewAppSettings() *AppSettings {
+	appSettings, _ := LoadAppSettings()
 
 	return appSettings
 }
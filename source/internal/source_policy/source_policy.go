@@ -0,0 +1,286 @@
+package source_policy
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/triflesoft/portalswan/internal/adapters/adapters"
+	"github.com/triflesoft/portalswan/internal/settings"
+)
+
+// List files are checked for changes at most this often.
+const listFileRefreshInterval = time.Minute
+
+var (
+	ErrSourceDenied     = errors.New("source address is denied")
+	ErrSourceNotAllowed = errors.New("source address is not allowed")
+	ErrSourceInvalid    = errors.New("source address is invalid")
+)
+
+// rule keeps origin of prefix, e.g. "radius.source_addresses.finance.allow"
+// or "/etc/portalswan/office.txt:3", so that matched rule can be logged.
+type rule struct {
+	prefix netip.Prefix
+	origin string
+}
+
+func (r *rule) String() string {
+	return fmt.Sprintf("%s (%s)", r.prefix, r.origin)
+}
+
+type listFile struct {
+	path      string
+	rules     []*rule
+	modTime   time.Time
+	checkTime time.Time
+}
+
+type classPolicy struct {
+	allow      []*rule
+	deny       []*rule
+	allowFiles []*listFile
+	denyFiles  []*listFile
+}
+
+type SourcePolicies struct {
+	log adapters.LoggingAdapter
+
+	mtx      sync.Mutex
+	policies map[string]*classPolicy
+}
+
+func parsePrefix(text string) (netip.Prefix, error) {
+	if strings.Contains(text, "/") {
+		prefix, err := netip.ParsePrefix(text)
+
+		return prefix.Masked(), err
+	}
+
+	addr, err := netip.ParseAddr(text)
+
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// parseAddress accepts Calling-Station-Id of strongSwan, which has port in
+// brackets unless station_id_with_port is disabled.
+func parseAddress(text string) (netip.Addr, error) {
+	if index := strings.LastIndex(text, "["); (index > 0) && strings.HasSuffix(text, "]") {
+		text = text[:index]
+	}
+
+	addr, err := netip.ParseAddr(text)
+
+	if err != nil {
+		return addr, err
+	}
+
+	return addr.Unmap(), nil
+}
+
+func parseRules(texts []string, origin string) ([]*rule, error) {
+	rules := make([]*rule, 0, len(texts))
+
+	for _, text := range texts {
+		prefix, err := parsePrefix(strings.TrimSpace(text))
+
+		if err != nil {
+			return nil, fmt.Errorf("%s is invalid: %w", origin, err)
+		}
+
+		rules = append(rules, &rule{prefix: prefix, origin: origin})
+	}
+
+	return rules, nil
+}
+
+func (f *listFile) load() error {
+	fileInfo, err := os.Stat(f.path)
+
+	if err != nil {
+		return err
+	}
+
+	f.checkTime = time.Now()
+
+	if fileInfo.ModTime().Equal(f.modTime) {
+		return nil
+	}
+
+	fileData, err := os.ReadFile(f.path)
+
+	if err != nil {
+		return err
+	}
+
+	rules := []*rule{}
+	scanner := bufio.NewScanner(bytes.NewReader(fileData))
+
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+
+		if line == "" {
+			continue
+		}
+
+		prefix, err := parsePrefix(line)
+
+		if err != nil {
+			return fmt.Errorf("%s:%d is invalid: %w", f.path, lineNumber, err)
+		}
+
+		rules = append(rules, &rule{prefix: prefix, origin: fmt.Sprintf("%s:%d", f.path, lineNumber)})
+	}
+
+	f.rules = rules
+	f.modTime = fileInfo.ModTime()
+
+	return nil
+}
+
+// match returns the first rule containing addr, list files are reloaded if
+// they were changed, old rules are kept if reload fails.
+func (s *SourcePolicies) match(addr netip.Addr, rules []*rule, files []*listFile) *rule {
+	for _, r := range rules {
+		if r.prefix.Contains(addr) {
+			return r
+		}
+	}
+
+	for _, file := range files {
+		if time.Since(file.checkTime) >= listFileRefreshInterval {
+			if err := file.load(); err != nil {
+				s.log.LogErrorText("Failed to reload source address list", "err", err, "path", file.path)
+			}
+		}
+
+		for _, r := range file.rules {
+			if r.prefix.Contains(addr) {
+				return r
+			}
+		}
+	}
+
+	return nil
+}
+
+// Check returns error if address may not be used by class, description of
+// matched rule is returned either way, empty if no rule matched.
+func (s *SourcePolicies) Check(class string, address string) (string, error) {
+	ownPolicy, ownExists := s.policies[class]
+	defaultPolicy, defaultExists := s.policies["*"]
+
+	if !ownExists && !defaultExists {
+		return "", nil
+	}
+
+	addr, err := parseAddress(address)
+
+	if err != nil {
+		return "", fmt.Errorf("%w, '%s'", ErrSourceInvalid, address)
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	allowPolicy := defaultPolicy
+
+	for _, policy := range []*classPolicy{ownPolicy, defaultPolicy} {
+		if policy == nil {
+			continue
+		}
+
+		if r := s.match(addr, policy.deny, policy.denyFiles); r != nil {
+			return r.String(), fmt.Errorf("%w by %s", ErrSourceDenied, r)
+		}
+	}
+
+	if (ownPolicy != nil) && ((len(ownPolicy.allow) > 0) || (len(ownPolicy.allowFiles) > 0)) {
+		allowPolicy = ownPolicy
+	}
+
+	if (allowPolicy == nil) || ((len(allowPolicy.allow) == 0) && (len(allowPolicy.allowFiles) == 0)) {
+		return "", nil
+	}
+
+	if r := s.match(addr, allowPolicy.allow, allowPolicy.allowFiles); r != nil {
+		return r.String(), nil
+	}
+
+	return "", ErrSourceNotAllowed
+}
+
+func newListFiles(paths []string) ([]*listFile, error) {
+	files := make([]*listFile, 0, len(paths))
+
+	for _, path := range paths {
+		file := &listFile{path: path}
+
+		if err := file.load(); err != nil {
+			return nil, err
+		}
+
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+func NewSourcePolicies(s *settings.AppRadiusSettings, l adapters.LoggingAdapter) (*SourcePolicies, error) {
+	sourcePolicies := &SourcePolicies{
+		log:      l,
+		policies: map[string]*classPolicy{},
+	}
+
+	classes := make([]string, 0, len(s.SourceAddresses))
+
+	for class := range s.SourceAddresses {
+		classes = append(classes, class)
+	}
+
+	sort.Strings(classes)
+
+	for _, class := range classes {
+		policySettings := s.SourceAddresses[class]
+		policy := &classPolicy{}
+		var err error
+
+		if policy.allow, err = parseRules(policySettings.Allow, fmt.Sprintf("radius.source_addresses.%s.allow", class)); err != nil {
+			return nil, err
+		}
+
+		if policy.deny, err = parseRules(policySettings.Deny, fmt.Sprintf("radius.source_addresses.%s.deny", class)); err != nil {
+			return nil, err
+		}
+
+		if policy.allowFiles, err = newListFiles(policySettings.AllowFiles); err != nil {
+			return nil, fmt.Errorf("radius.source_addresses.%s.allow_files are invalid: %w", class, err)
+		}
+
+		if policy.denyFiles, err = newListFiles(policySettings.DenyFiles); err != nil {
+			return nil, fmt.Errorf("radius.source_addresses.%s.deny_files are invalid: %w", class, err)
+		}
+
+		sourcePolicies.policies[class] = policy
+
+		fmt.Printf("Source Address Policy '%s'\n", class)
+		fmt.Printf("    Allow:                  '%s'\n", strings.Join(policySettings.Allow, "', '"))
+		fmt.Printf("    Deny:                   '%s'\n", strings.Join(policySettings.Deny, "', '"))
+		fmt.Printf("    Allow Files:            '%s'\n", strings.Join(policySettings.AllowFiles, "', '"))
+		fmt.Printf("    Deny Files:             '%s'\n", strings.Join(policySettings.DenyFiles, "', '"))
+	}
+
+	return sourcePolicies, nil
+}
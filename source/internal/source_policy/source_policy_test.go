@@ -0,0 +1,134 @@
+package source_policy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/triflesoft/portalswan/internal/settings"
+)
+
+type testLoggingAdapter struct {
+	t *testing.T
+}
+
+func (a *testLoggingAdapter) LogDebugText(msg string, args ...any) {
+	a.t.Log(append([]any{msg}, args...)...)
+}
+
+func (a *testLoggingAdapter) LogErrorText(msg string, args ...any) {
+	a.t.Log(append([]any{"error:", msg}, args...)...)
+}
+
+func (a *testLoggingAdapter) LogInfoText(channel string, msg string, args ...any) {
+}
+
+func (a *testLoggingAdapter) LogInfoJson(channel string, msg any) {
+}
+
+func (a *testLoggingAdapter) Flush() {
+}
+
+func writeTestFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write '%s': %v", path, err)
+	}
+
+	return path
+}
+
+func TestCheck(t *testing.T) {
+	allowPath := writeTestFile(t, "guests-allow.txt", "# Guest WiFi\n\n203.0.113.0/24 # NAT\n")
+	denyPath := writeTestFile(t, "guests-deny.txt", "203.0.113.7\n")
+	sourcePolicies, err := NewSourcePolicies(
+		&settings.AppRadiusSettings{
+			SourceAddresses: map[string]*settings.AppRadiusSourceAddressSettings{
+				"*":           {Allow: []string{"192.0.2.0/24"}, Deny: []string{"192.0.2.66"}},
+				"finance":     {Allow: []string{"198.51.100.0/24"}, Deny: []string{"198.51.100.13"}},
+				"contractors": {Deny: []string{"192.0.2.128/25"}},
+				"guests":      {AllowFiles: []string{allowPath}, DenyFiles: []string{denyPath}},
+			},
+		},
+		&testLoggingAdapter{t: t})
+
+	if err != nil {
+		t.Fatalf("failed to create source policies: %v", err)
+	}
+
+	testCases := []struct {
+		name          string
+		class         string
+		address       string
+		expectedRule  string
+		expectedError error
+	}{
+		{"default allow", "staff", "192.0.2.10", "192.0.2.0/24 (radius.source_addresses.*.allow)", nil},
+		{"default deny before default allow", "staff", "192.0.2.66", "192.0.2.66/32 (radius.source_addresses.*.deny)", ErrSourceDenied},
+		{"not in default allow", "staff", "198.51.100.1", "", ErrSourceNotAllowed},
+		{"own allow", "finance", "198.51.100.1", "198.51.100.0/24 (radius.source_addresses.finance.allow)", nil},
+		{"own allow replaces default allow", "finance", "192.0.2.10", "", ErrSourceNotAllowed},
+		{"own deny", "finance", "198.51.100.13", "198.51.100.13/32 (radius.source_addresses.finance.deny)", ErrSourceDenied},
+		{"default deny applies with own allow", "finance", "192.0.2.66", "192.0.2.66/32 (radius.source_addresses.*.deny)", ErrSourceDenied},
+		{"default allow without own allow", "contractors", "192.0.2.10", "192.0.2.0/24 (radius.source_addresses.*.allow)", nil},
+		{"own deny before default allow", "contractors", "192.0.2.200", "192.0.2.128/25 (radius.source_addresses.contractors.deny)", ErrSourceDenied},
+		{"allow file", "guests", "203.0.113.10", "203.0.113.0/24 (" + allowPath + ":3)", nil},
+		{"deny file", "guests", "203.0.113.7", "203.0.113.7/32 (" + denyPath + ":1)", ErrSourceDenied},
+		{"calling station with port", "staff", "192.0.2.10[4500]", "192.0.2.0/24 (radius.source_addresses.*.allow)", nil},
+		{"IPv4-mapped IPv6 address", "staff", "::ffff:192.0.2.10", "192.0.2.0/24 (radius.source_addresses.*.allow)", nil},
+		{"IPv6 address", "staff", "2001:db8::1", "", ErrSourceNotAllowed},
+		{"invalid address", "staff", "client.example.com", "", ErrSourceInvalid},
+		{"empty address", "staff", "", "", ErrSourceInvalid},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			matchedRule, err := sourcePolicies.Check(testCase.class, testCase.address)
+
+			if (matchedRule != testCase.expectedRule) || !errors.Is(err, testCase.expectedError) {
+				t.Fatalf("expected '%s', %v, got '%s', %v", testCase.expectedRule, testCase.expectedError, matchedRule, err)
+			}
+		})
+	}
+}
+
+func TestCheckWithoutPolicies(t *testing.T) {
+	sourcePolicies, err := NewSourcePolicies(&settings.AppRadiusSettings{}, &testLoggingAdapter{t: t})
+
+	if err != nil {
+		t.Fatalf("failed to create source policies: %v", err)
+	}
+
+	// Address is not even parsed when no class has a policy
+	if matchedRule, err := sourcePolicies.Check("staff", "client.example.com"); (matchedRule != "") || (err != nil) {
+		t.Fatalf("expected no rule and no error, got '%s', %v", matchedRule, err)
+	}
+}
+
+func TestNewSourcePoliciesInvalid(t *testing.T) {
+	testCases := []struct {
+		name   string
+		policy *settings.AppRadiusSourceAddressSettings
+	}{
+		{"invalid allow", &settings.AppRadiusSourceAddressSettings{Allow: []string{"192.0.2.0/33"}}},
+		{"invalid deny", &settings.AppRadiusSourceAddressSettings{Deny: []string{"office"}}},
+		{"missing allow file", &settings.AppRadiusSourceAddressSettings{AllowFiles: []string{filepath.Join(t.TempDir(), "missing.txt")}}},
+		{"invalid deny file", &settings.AppRadiusSourceAddressSettings{DenyFiles: []string{writeTestFile(t, "deny.txt", "192.0.2.1\noffice\n")}}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			_, err := NewSourcePolicies(
+				&settings.AppRadiusSettings{
+					SourceAddresses: map[string]*settings.AppRadiusSourceAddressSettings{"staff": testCase.policy},
+				},
+				&testLoggingAdapter{t: t})
+
+			if err == nil {
+				t.Fatalf("expected error")
+			}
+		})
+	}
+}
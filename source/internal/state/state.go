@@ -1,19 +1,23 @@
 package state
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
 	"sync/atomic"
 
-	"github.com/puzpuzpuz/xsync"
 	"github.com/triflesoft/portalswan/internal/adapters/adapters"
-	"github.com/triflesoft/portalswan/internal/adapters/aws_credentials_adapter"
-	"github.com/triflesoft/portalswan/internal/adapters/aws_email_adapter"
-	"github.com/triflesoft/portalswan/internal/adapters/aws_identity_adapter"
-	"github.com/triflesoft/portalswan/internal/adapters/aws_logs_adapter"
+	"github.com/triflesoft/portalswan/internal/ip_pool"
+	"github.com/triflesoft/portalswan/internal/lockout"
+	"github.com/triflesoft/portalswan/internal/quota"
+	"github.com/triflesoft/portalswan/internal/reply_template"
+	"github.com/triflesoft/portalswan/internal/schedule"
+	"github.com/triflesoft/portalswan/internal/session_control"
 	"github.com/triflesoft/portalswan/internal/settings"
+	"github.com/triflesoft/portalswan/internal/source_policy"
+	"github.com/triflesoft/portalswan/internal/vpn_config"
 )
 
 type WorkerState struct {
@@ -22,109 +26,143 @@ type WorkerState struct {
 	initCounter atomic.Int32
 }
 
-type VpnConnectionState struct {
-	Username              string
-	ClientToServerBytes   atomic.Int64
-	ServerToClientBytes   atomic.Int64
-	ClientToServerPackets atomic.Int64
-	ServerToClientPackets atomic.Int64
-}
-
 type AppState struct {
 	LoggingAdapter     adapters.LoggingAdapter
 	IdentityAdapter    adapters.IdentityAdapter
 	CredentialsAdapter adapters.CredentialsAdapter
 	EmailAdapter       adapters.EmailAdapter
+	AccountingAdapter  adapters.AccountingAdapter
+	IpPoolManager      *ip_pool.IpPoolManager
+	ReplyTemplates     *reply_template.ReplyTemplates
+	QuotaManager       *quota.QuotaManager
+	LockoutTracker     *lockout.LockoutTracker
+	Schedules          *schedule.Schedules
+	SourcePolicies     *source_policy.SourcePolicies
+	SessionControl     *session_control.SessionControl
 
 	workerStates       []*WorkerState
 	initGroup          *sync.WaitGroup
 	quitGroup          *sync.WaitGroup
-	appSettings        *settings.AppSettings
-	connectionStateMap *xsync.MapOf[string, *VpnConnectionState]
+	appSettings        atomic.Pointer[settings.AppSettings]
+	reloadMtx          sync.Mutex
+	connections        *vpnConnectionRegistry
+	terminationChan    chan VpnTerminationRequest
+	reconcileChan      chan struct{}
 	baseFileSystemPath string
 }
 
-func NewAppState() *AppState {
-	appSettings := settings.NewAppSettings()
+func NewAppState() (*AppState, error) {
+	return NewAppStateWithSettings(settings.NewAppSettings())
+}
+
+// NewAppStateWithSettings builds adapters and managers from appSettings as
+// they are, nothing is read from configuration file or AWS.
+func NewAppStateWithSettings(appSettings *settings.AppSettings) (*AppState, error) {
 	exePath, err := os.Executable()
 
 	if err != nil {
-		fmt.Printf("error: %v\n", err)
-		return nil
+		return nil, err
 	}
 
 	exePath, err = filepath.EvalSymlinks(exePath)
 
 	if err != nil {
-		fmt.Printf("error: %v\n", err)
-		return nil
-	}
-
-	var loggingAdapter adapters.LoggingAdapter
-	var identityAdapter adapters.IdentityAdapter
-	var credentialsAdapter adapters.CredentialsAdapter
-	var emailAdapter adapters.EmailAdapter
-
-	if appSettings.Logging.Aws != nil {
-		fmt.Printf("AWS Logging Adapter\n")
-		fmt.Printf(" CloudWatch Logs\n")
-		fmt.Printf("    Region Name:            '%s'\n", appSettings.Logging.Aws.CloudWatchLogRegion)
-		fmt.Printf("    Group Name:             '%s'\n", appSettings.Logging.Aws.CloudWatchLogGroup)
-		loggingAdapter = aws_logs_adapter.NewAwsLoggingAdapter(appSettings.Logging.Aws)
-	} else {
-		fmt.Printf("error: failed to configure logging adapter\n")
-		return nil
-	}
-
-	if appSettings.Identity.Aws != nil {
-		fmt.Printf("AWS Identity Provider\n")
-		fmt.Printf(" IAM Identity Center\n")
-		fmt.Printf("    Identity Store Region:  '%s'\n", appSettings.Identity.Aws.IdentityStoreRegion)
-		fmt.Printf("    Identity Store Id:      '%s'\n", appSettings.Identity.Aws.IdentityStoreId)
-		fmt.Printf("    Group Name Pattern:     '%s'\n", appSettings.Identity.Aws.RadiusClassFromGroupNamePattern)
-		identityAdapter = aws_identity_adapter.NewAwsIdentityAdapter(appSettings.Identity.Aws, loggingAdapter)
-	} else {
-		fmt.Printf("error: failed to configure identity adapter\n")
-		return nil
-	}
-
-	if appSettings.Credentials.Aws != nil {
-		fmt.Printf("AWS Credentials Provider\n")
-		fmt.Printf(" S3\n")
-		fmt.Printf("    Bucket Region:          '%s'\n", appSettings.Credentials.Aws.S3BucketRegion)
-		fmt.Printf("    Bucket Name:            '%s'\n", appSettings.Credentials.Aws.S3BucketName)
-		credentialsAdapter = aws_credentials_adapter.NewAwsCredentialsAdapter(appSettings.Credentials.Aws, loggingAdapter)
-	} else {
-		fmt.Printf("error: failed to configure credentials adapter\n")
-		return nil
-	}
-
-	if appSettings.Email.Aws != nil {
-		fmt.Printf("AWS Email Provider\n")
-		fmt.Printf(" SES\n")
-		fmt.Printf("    Region:                 '%s'\n", appSettings.Email.Aws.SesRegion)
-		fmt.Printf("    Source:                 '%s'\n", appSettings.Email.Aws.SesSource)
-		emailAdapter = aws_email_adapter.NewAwsEmailAdapter(appSettings.Email.Aws, loggingAdapter)
-	} else {
-		fmt.Printf("error: failed to configure email adapter\n")
-		return nil
+		return nil, err
+	}
+
+	loggingAdapter, err := adapters.NewLoggingAdapter(appSettings.Logging)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure logging adapter: %w", err)
+	}
+
+	identityAdapter, err := adapters.NewIdentityAdapter(appSettings.Identity, loggingAdapter)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure identity adapter: %w", err)
+	}
+
+	credentialsAdapter, err := adapters.NewCredentialsAdapter(appSettings.Credentials, loggingAdapter)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure credentials adapter: %w", err)
+	}
+
+	emailAdapter, err := adapters.NewEmailAdapter(appSettings.Email, loggingAdapter)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure email adapter: %w", err)
+	}
+
+	accountingAdapter, err := adapters.NewAccountingAdapter(appSettings.Accounting, loggingAdapter)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure accounting adapter: %w", err)
+	}
+
+	ipPoolManager, err := ip_pool.NewIpPoolManager(appSettings.Radius, appSettings.StateDirectoryPath(), loggingAdapter)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure IP pools: %w", err)
+	}
+
+	replyTemplates, err := reply_template.NewReplyTemplates(appSettings.Radius)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure reply attributes: %w", err)
+	}
+
+	quotaManager, err := quota.NewQuotaManager(appSettings.Radius, appSettings.StateDirectoryPath(), loggingAdapter)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure quotas: %w", err)
+	}
+
+	lockoutTracker, err := lockout.NewLockoutTracker(appSettings.Radius.Lockout, appSettings.StateDirectoryPath(), loggingAdapter, emailAdapter)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure lockout: %w", err)
+	}
+
+	schedules, err := schedule.NewSchedules(appSettings.Radius)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure schedules: %w", err)
+	}
+
+	sourcePolicies, err := source_policy.NewSourcePolicies(appSettings.Radius, loggingAdapter)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure source address policies: %w", err)
 	}
 
 	fmt.Printf("Linux Process ID:           '%d'\n", os.Getpid())
 
-	return &AppState{
+	appState := &AppState{
 		LoggingAdapter:     loggingAdapter,
 		IdentityAdapter:    identityAdapter,
 		CredentialsAdapter: credentialsAdapter,
 		EmailAdapter:       emailAdapter,
+		AccountingAdapter:  accountingAdapter,
+		IpPoolManager:      ipPoolManager,
+		ReplyTemplates:     replyTemplates,
+		QuotaManager:       quotaManager,
+		LockoutTracker:     lockoutTracker,
+		Schedules:          schedules,
+		SourcePolicies:     sourcePolicies,
+		SessionControl:     session_control.NewSessionControl(loggingAdapter, identityAdapter, credentialsAdapter),
 
 		workerStates:       []*WorkerState{},
 		initGroup:          &sync.WaitGroup{},
 		quitGroup:          &sync.WaitGroup{},
-		appSettings:        appSettings,
-		connectionStateMap: xsync.NewTypedMapOf[string, *VpnConnectionState](xsync.StrHash64),
+		connections:        newVpnConnectionRegistry(),
+		terminationChan:    make(chan VpnTerminationRequest, 256),
+		reconcileChan:      make(chan struct{}, 1),
 		baseFileSystemPath: filepath.Dir(exePath),
 	}
+	appState.appSettings.Store(appSettings)
+
+	return appState, nil
 }
 
 func (appState *AppState) NewWorkerState() *WorkerState {
@@ -147,6 +185,12 @@ func (appState *AppState) WaitInitCompleted() {
 
 func (appState *AppState) WaitQuitCompleted() {
 	appState.quitGroup.Wait()
+
+	if appState.AccountingAdapter != nil {
+		appState.AccountingAdapter.Flush()
+	}
+
+	appState.LoggingAdapter.Flush()
 }
 
 func (appState *AppState) Quit() {
@@ -155,24 +199,140 @@ func (appState *AppState) Quit() {
 	}
 }
 
+func (appState *AppState) GetAppSettings() *settings.AppSettings {
+	return appState.appSettings.Load()
+}
+
 func (appState *AppState) GetServerSettings() *settings.AppServerSettings {
-	return appState.appSettings.Server
+	return appState.appSettings.Load().Server
 }
 
 func (appState *AppState) GetClientSettings() *settings.AppClientSettings {
-	return appState.appSettings.Client
+	return appState.appSettings.Load().Client
 }
 
+func (appState *AppState) GetRadiusSettings() *settings.AppRadiusSettings {
+	return appState.appSettings.Load().Radius
+}
+
+// ReloadAppSettings reads settings again and replaces client and VPN sections,
+// other sections are used by adapters and managers built on startup. VICI
+// client is asked to reconcile StrongSwan definitions afterwards. Settings are
+// read from AWS, so this must not be called from event loops. Current sections
+// are kept if settings cannot be read, sections present before are missing or
+// VPN definitions are invalid, so that a broken file never unloads VPN.
+func (appState *AppState) ReloadAppSettings() error {
+	appState.reloadMtx.Lock()
+	defer appState.reloadMtx.Unlock()
+
+	reloadedSettings, err := settings.LoadAppSettings()
+
+	if err != nil {
+		appState.LoggingAdapter.LogErrorText("Failed to reload settings, current settings are kept", "err", err)
+		return err
+	}
+
+	appSettings := *appState.appSettings.Load()
+
+	if (appSettings.Client != nil) && (reloadedSettings.Client == nil) {
+		appState.LoggingAdapter.LogErrorText("Reloaded settings have no client section, current settings are kept")
+		return errors.New("client section is missing")
+	}
+
+	if (appSettings.Vpn != nil) && (reloadedSettings.Vpn == nil) {
+		appState.LoggingAdapter.LogErrorText("Reloaded settings have no vpn section, current settings are kept")
+		return errors.New("vpn section is missing")
+	}
+
+	appSettings.Client = reloadedSettings.Client
+	appSettings.Vpn = reloadedSettings.Vpn
+
+	if err := vpn_config.Validate(&appSettings); err != nil {
+		appState.LoggingAdapter.LogErrorText("Reloaded VPN settings are invalid, current settings are kept", "err", err)
+		return err
+	}
+
+	appState.appSettings.Store(&appSettings)
+
+	appState.LoggingAdapter.LogDebugText("Reloaded client and VPN settings")
+	appState.RequestVpnReconcile()
+
+	return nil
+}
+
+// CanonicalUsername resolves username as sent by client, which may be an alias
+// or differ in case, to username of VPN user, which connections, leases and
+// quota usage are kept under. Unknown users are kept as they are.
+func (appState *AppState) CanonicalUsername(username string) string {
+	if username == "" {
+		return username
+	}
+
+	if vpnUser := appState.IdentityAdapter.SelectVpnUser(username); vpnUser != nil {
+		return vpnUser.Username
+	}
+
+	return username
+}
+
+// GetVpnConnectionState looks up live session by framed IP address.
 func (appState *AppState) GetVpnConnectionState(framedIpAddress string) (*VpnConnectionState, bool) {
-	return appState.connectionStateMap.Load(framedIpAddress)
+	return appState.connections.get(framedIpAddress)
+}
+
+// CountVpnConnectionStates counts live sessions of user.
+func (appState *AppState) CountVpnConnectionStates(username string) int {
+	return appState.connections.countByUsername(username)
 }
 
-func (appState *AppState) SetVpnConnectionState(framedIpAddress string, connectionState *VpnConnectionState) {
-	appState.connectionStateMap.Store(framedIpAddress, connectionState)
+// GetVpnConnectionFramedIpAddresses returns addresses of live sessions of user.
+func (appState *AppState) GetVpnConnectionFramedIpAddresses(username string) []string {
+	return appState.connections.framedIpAddressesByUsername(username)
+}
+
+// EnsureVpnConnectionState returns live session, creating it if necessary,
+// and tells whether it was created. Nil is returned for stopped session.
+func (appState *AppState) EnsureVpnConnectionState(sessionId string, framedIpAddress string, username string, class string) (*VpnConnectionState, bool) {
+	return appState.connections.ensure(sessionId, framedIpAddress, username, class)
+}
+
+// StopVpnConnectionState removes live session, if known, and tells whether it
+// was stopped just now rather than before.
+func (appState *AppState) StopVpnConnectionState(sessionId string, framedIpAddress string, username string) (*VpnConnectionState, bool) {
+	return appState.connections.stop(sessionId, framedIpAddress, username)
+}
+
+// RestoreVpnConnectionState adds session which is unknown to accounting, if
+// none of its addresses is in use, otherwise returns the session using them.
+func (appState *AppState) RestoreVpnConnectionState(connectionState *VpnConnectionState) (*VpnConnectionState, bool) {
+	return appState.connections.restore(connectionState)
+}
+
+// RequestVpnTermination asks VICI client to terminate IKE SAs of user, it
+// never blocks, requests are dropped if VICI client is not keeping up.
+func (appState *AppState) RequestVpnTermination(username string, reason string) {
+	select {
+	case appState.terminationChan <- VpnTerminationRequest{Username: username, Reason: reason}:
+	default:
+		appState.LoggingAdapter.LogErrorText("Dropped VPN termination request", "username", username, "reason", reason)
+	}
+}
+
+func (appState *AppState) VpnTerminationRequests() <-chan VpnTerminationRequest {
+	return appState.terminationChan
+}
+
+// RequestVpnReconcile asks VICI client to reconcile StrongSwan definitions with
+// current VPN settings, pending request is not duplicated.
+func (appState *AppState) RequestVpnReconcile() {
+	select {
+	case appState.reconcileChan <- struct{}{}:
+	default:
+	}
 }
 
-func (appState *AppState) DelVpnConnectionState(framedIpAddress string) (*VpnConnectionState, bool) {
-	return appState.connectionStateMap.LoadAndDelete(framedIpAddress)
+func (appState *AppState) VpnReconcileRequests() <-chan struct{} {
+	return appState.reconcileChan
 }
 
 func (appState *AppState) GetBaseFileSystemPath() string {
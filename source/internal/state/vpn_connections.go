@@ -0,0 +1,240 @@
+package state
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ttlcache "github.com/jellydator/ttlcache/v3"
+)
+
+// Stopped sessions are remembered for a while, so that duplicate Stop and
+// late Start or Interim-Update packets do not resurrect them.
+const stoppedVpnConnectionTtl = time.Hour
+
+// Class is taken from accounting Class attribute, it is empty if NAS does not
+// echo it. Sessions restored from StrongSwan take it from identity adapter.
+type VpnConnectionState struct {
+	SessionId             string
+	FramedIpAddresses     []string
+	Username              string
+	Class                 string
+	ClientToServerBytes   atomic.Int64
+	ServerToClientBytes   atomic.Int64
+	ClientToServerPackets atomic.Int64
+	ServerToClientPackets atomic.Int64
+
+	// Restored from StrongSwan, session ID is unknown until accounting arrives
+	restored bool
+}
+
+type VpnTerminationRequest struct {
+	Username string
+	Reason   string
+}
+
+func storeMaxInt64(counter *atomic.Int64, value int64) {
+	for {
+		current := counter.Load()
+
+		if (value <= current) || counter.CompareAndSwap(current, value) {
+			return
+		}
+	}
+}
+
+// UpdateCounters never decreases counters, accounting packets may arrive out
+// of order.
+func (s *VpnConnectionState) UpdateCounters(clientToServerBytes, serverToClientBytes, clientToServerPackets, serverToClientPackets int64) {
+	storeMaxInt64(&s.ClientToServerBytes, clientToServerBytes)
+	storeMaxInt64(&s.ServerToClientBytes, serverToClientBytes)
+	storeMaxInt64(&s.ClientToServerPackets, clientToServerPackets)
+	storeMaxInt64(&s.ServerToClientPackets, serverToClientPackets)
+}
+
+// vpnConnectionRegistry keeps live sessions by session ID, framed IP index is
+// used by NetFilter and verification lookups. An address belongs to the most
+// recent session which claimed it.
+type vpnConnectionRegistry struct {
+	mtx             sync.RWMutex
+	sessions        map[string]*VpnConnectionState
+	framedIpIndex   map[string]*VpnConnectionState
+	stoppedSessions *ttlcache.Cache[string, struct{}]
+}
+
+func newVpnConnectionRegistry() *vpnConnectionRegistry {
+	r := &vpnConnectionRegistry{
+		sessions:        map[string]*VpnConnectionState{},
+		framedIpIndex:   map[string]*VpnConnectionState{},
+		stoppedSessions: ttlcache.New(ttlcache.WithTTL[string, struct{}](stoppedVpnConnectionTtl)),
+	}
+
+	go r.stoppedSessions.Start()
+
+	return r
+}
+
+func (r *vpnConnectionRegistry) remove(connectionState *VpnConnectionState) {
+	delete(r.sessions, connectionState.SessionId)
+
+	for _, framedIpAddress := range connectionState.FramedIpAddresses {
+		if r.framedIpIndex[framedIpAddress] == connectionState {
+			delete(r.framedIpIndex, framedIpAddress)
+		}
+	}
+}
+
+// add removes sessions whose addresses are claimed, they missed their Stop.
+func (r *vpnConnectionRegistry) add(connectionState *VpnConnectionState) {
+	for _, framedIpAddress := range connectionState.FramedIpAddresses {
+		if staleState, ok := r.framedIpIndex[framedIpAddress]; ok {
+			r.remove(staleState)
+		}
+	}
+
+	r.sessions[connectionState.SessionId] = connectionState
+
+	for _, framedIpAddress := range connectionState.FramedIpAddresses {
+		r.framedIpIndex[framedIpAddress] = connectionState
+	}
+}
+
+// restoredByFramedIp returns session restored from StrongSwan which is the
+// same as accounting session.
+func (r *vpnConnectionRegistry) restoredByFramedIp(framedIpAddress string, username string) *VpnConnectionState {
+	connectionState, ok := r.framedIpIndex[framedIpAddress]
+
+	if ok && connectionState.restored && (connectionState.Username == username) {
+		return connectionState
+	}
+
+	return nil
+}
+
+func (r *vpnConnectionRegistry) get(framedIpAddress string) (*VpnConnectionState, bool) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	connectionState, ok := r.framedIpIndex[framedIpAddress]
+
+	return connectionState, ok
+}
+
+// countByUsername counts live sessions of user, usernames are compared case
+// insensitively as identity adapters do.
+func (r *vpnConnectionRegistry) countByUsername(username string) int {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	count := 0
+
+	for _, connectionState := range r.sessions {
+		if strings.EqualFold(connectionState.Username, username) {
+			count++
+		}
+	}
+
+	return count
+}
+
+// framedIpAddressesByUsername returns addresses of live sessions of user.
+func (r *vpnConnectionRegistry) framedIpAddressesByUsername(username string) []string {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	framedIpAddresses := []string{}
+
+	for _, connectionState := range r.sessions {
+		if strings.EqualFold(connectionState.Username, username) {
+			framedIpAddresses = append(framedIpAddresses, connectionState.FramedIpAddresses...)
+		}
+	}
+
+	return framedIpAddresses
+}
+
+func (r *vpnConnectionRegistry) ensure(sessionId string, framedIpAddress string, username string, class string) (*VpnConnectionState, bool) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.stoppedSessions.Has(sessionId) {
+		return nil, false
+	}
+
+	connectionState, ok := r.sessions[sessionId]
+
+	if ok && (connectionState.Username == username) {
+		return connectionState, false
+	}
+
+	if restoredState := r.restoredByFramedIp(framedIpAddress, username); restoredState != nil {
+		delete(r.sessions, restoredState.SessionId)
+		restoredState.SessionId = sessionId
+		restoredState.restored = false
+
+		// Sessions restored from VICI do not know class, accounting does
+		if class != "" {
+			restoredState.Class = class
+		}
+
+		r.sessions[sessionId] = restoredState
+
+		return restoredState, false
+	}
+
+	if ok {
+		r.remove(connectionState)
+	}
+
+	connectionState = &VpnConnectionState{
+		SessionId:         sessionId,
+		FramedIpAddresses: []string{framedIpAddress},
+		Username:          username,
+		Class:             class,
+	}
+
+	r.add(connectionState)
+
+	return connectionState, true
+}
+
+func (r *vpnConnectionRegistry) stop(sessionId string, framedIpAddress string, username string) (*VpnConnectionState, bool) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.stoppedSessions.Has(sessionId) {
+		return nil, false
+	}
+
+	r.stoppedSessions.Set(sessionId, struct{}{}, ttlcache.DefaultTTL)
+	connectionState, ok := r.sessions[sessionId]
+
+	if !ok {
+		connectionState = r.restoredByFramedIp(framedIpAddress, username)
+	}
+
+	if connectionState == nil {
+		return nil, true
+	}
+
+	r.remove(connectionState)
+
+	return connectionState, true
+}
+
+func (r *vpnConnectionRegistry) restore(connectionState *VpnConnectionState) (*VpnConnectionState, bool) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	for _, framedIpAddress := range connectionState.FramedIpAddresses {
+		if actualState, ok := r.framedIpIndex[framedIpAddress]; ok {
+			return actualState, true
+		}
+	}
+
+	connectionState.restored = true
+	r.add(connectionState)
+
+	return connectionState, false
+}
@@ -0,0 +1,476 @@
+package vpn_config
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/strongswan/govici/vici"
+	"github.com/triflesoft/portalswan/internal/adapters/adapters"
+	"github.com/triflesoft/portalswan/internal/settings"
+)
+
+const loadedDefinitionsFileName = "vpn-definitions.json"
+
+// loadedDefinitions are names of definitions loaded by PortalSwan, only these
+// are ever unloaded, definitions loaded from swanctl.conf are left alone.
+type loadedDefinitions struct {
+	Connections []string `json:"connections"`
+	Pools       []string `json:"pools"`
+	Authorities []string `json:"authorities"`
+}
+
+// definitions are VICI messages, keyed by name, built from settings.
+type definitions struct {
+	privateKeys  []string
+	certificates []string
+	connections  map[string]*vici.Message
+	pools        map[string]*vici.Message
+	authorities  map[string]*vici.Message
+}
+
+func readPemBlocks(path string) ([]string, error) {
+	fileData, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := []string{}
+
+	for {
+		var block *pem.Block
+		block, fileData = pem.Decode(fileData)
+
+		if block == nil {
+			break
+		}
+
+		blocks = append(blocks, string(pem.EncodeToMemory(block)))
+	}
+
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("file '%s' contains no PEM blocks", path)
+	}
+
+	return blocks, nil
+}
+
+func orDefault(values []string, defaultValues []string) []string {
+	if len(values) > 0 {
+		return values
+	}
+
+	return defaultValues
+}
+
+func (d *definitions) addConnection(appSettings *settings.AppSettings, name string, s *settings.AppVpnConnectionSettings) error {
+	certificatePath := s.CertificatePath
+	privateKeyPath := s.PrivateKeyPath
+
+	if (certificatePath == "") && (appSettings.Server != nil) {
+		certificatePath = appSettings.Server.TlsCertificatePath
+	}
+
+	if (privateKeyPath == "") && (appSettings.Server != nil) {
+		privateKeyPath = appSettings.Server.TlsPrivateKeyPath
+	}
+
+	if (certificatePath == "") || (privateKeyPath == "") {
+		return errors.New("certificate_path and private_key_path are required without server TLS settings")
+	}
+
+	// The first certificate authenticates the server, the rest are chain
+	certificates, err := readPemBlocks(certificatePath)
+
+	if err != nil {
+		return err
+	}
+
+	privateKeys, err := readPemBlocks(privateKeyPath)
+
+	if err != nil {
+		return err
+	}
+
+	d.privateKeys = append(d.privateKeys, privateKeys...)
+	d.certificates = append(d.certificates, certificates[1:]...)
+
+	destinationPrefixes := []string{}
+
+	if appSettings.Client != nil {
+		destinationPrefixes = appSettings.Client.DestinationPrefixes
+	}
+
+	connection := vici.NewMessage()
+	connection.Set("version", "2")
+	connection.Set("local_addrs", s.LocalAddrs)
+
+	if len(s.Proposals) > 0 {
+		connection.Set("proposals", s.Proposals)
+	}
+
+	if len(s.Pools) > 0 {
+		connection.Set("pools", s.Pools)
+	}
+
+	connection.Set("dpd_delay", strconv.FormatInt(int64(s.DpdDelay.Seconds()), 10))
+
+	local := vici.NewMessage()
+	local.Set("auth", "pubkey")
+	local.Set("certs", []string{certificates[0]})
+
+	if s.LocalId != "" {
+		local.Set("id", s.LocalId)
+	}
+
+	connection.Set("local", local)
+
+	remote := vici.NewMessage()
+	remote.Set("auth", s.RemoteAuth)
+	remote.Set("eap_id", "%any")
+	connection.Set("remote", remote)
+
+	children := vici.NewMessage()
+
+	for childName, childSettings := range s.Children {
+		localTs := orDefault(childSettings.LocalTs, destinationPrefixes)
+
+		if len(localTs) == 0 {
+			return fmt.Errorf("children.%s.local_ts is required without client destination_prefixes", childName)
+		}
+
+		child := vici.NewMessage()
+		child.Set("local_ts", localTs)
+		child.Set("dpd_action", childSettings.DpdAction)
+
+		if len(childSettings.EspProposals) > 0 {
+			child.Set("esp_proposals", childSettings.EspProposals)
+		}
+
+		children.Set(childName, child)
+	}
+
+	if len(children.Keys()) == 0 {
+		return errors.New("children are required")
+	}
+
+	connection.Set("children", children)
+	d.connections[name] = connection
+
+	return nil
+}
+
+func (d *definitions) addPool(appSettings *settings.AppSettings, name string, s *settings.AppVpnPoolSettings) error {
+	if s.Addrs == "" {
+		return errors.New("addrs is required")
+	}
+
+	dnsServers := []string{}
+	destinationPrefixes := []string{}
+
+	if appSettings.Client != nil {
+		dnsServers = appSettings.Client.DnsServers
+		destinationPrefixes = appSettings.Client.DestinationPrefixes
+	}
+
+	pool := vici.NewMessage()
+	pool.Set("addrs", s.Addrs)
+
+	if dns := orDefault(s.Dns, dnsServers); len(dns) > 0 {
+		pool.Set("dns", dns)
+	}
+
+	if subnets := orDefault(s.Subnets, destinationPrefixes); len(subnets) > 0 {
+		pool.Set("subnet", subnets)
+	}
+
+	d.pools[name] = pool
+
+	return nil
+}
+
+func (d *definitions) addAuthority(name string, s *settings.AppVpnAuthoritySettings) error {
+	if s.CaCertPath == "" {
+		return errors.New("cacert_path is required")
+	}
+
+	certificates, err := readPemBlocks(s.CaCertPath)
+
+	if err != nil {
+		return err
+	}
+
+	authority := vici.NewMessage()
+	authority.Set("cacert", certificates[0])
+
+	if len(s.CrlUris) > 0 {
+		authority.Set("crl_uris", s.CrlUris)
+	}
+
+	if len(s.OcspUris) > 0 {
+		authority.Set("ocsp_uris", s.OcspUris)
+	}
+
+	d.authorities[name] = authority
+
+	return nil
+}
+
+// buildDefinitions fails as a whole, so that a broken setting never unloads
+// definitions which are in use.
+func buildDefinitions(appSettings *settings.AppSettings) (*definitions, error) {
+	d := &definitions{
+		privateKeys:  []string{},
+		certificates: []string{},
+		connections:  map[string]*vici.Message{},
+		pools:        map[string]*vici.Message{},
+		authorities:  map[string]*vici.Message{},
+	}
+
+	s := appSettings.Vpn
+
+	if s == nil {
+		return d, nil
+	}
+
+	for name, authoritySettings := range s.Authorities {
+		if err := d.addAuthority(name, authoritySettings); err != nil {
+			return nil, fmt.Errorf("vpn.authorities.%s settings are invalid: %w", name, err)
+		}
+	}
+
+	for name, poolSettings := range s.Pools {
+		if err := d.addPool(appSettings, name, poolSettings); err != nil {
+			return nil, fmt.Errorf("vpn.pools.%s settings are invalid: %w", name, err)
+		}
+	}
+
+	for name, connectionSettings := range s.Connections {
+		if err := d.addConnection(appSettings, name, connectionSettings); err != nil {
+			return nil, fmt.Errorf("vpn.connections.%s settings are invalid: %w", name, err)
+		}
+	}
+
+	return d, nil
+}
+
+// Validate builds definitions without loading them, so that invalid settings
+// are rejected before they replace valid ones.
+func Validate(appSettings *settings.AppSettings) error {
+	_, err := buildDefinitions(appSettings)
+
+	return err
+}
+
+func loadLoadedDefinitions(path string) (*loadedDefinitions, error) {
+	loaded := &loadedDefinitions{}
+	fileData, err := os.ReadFile(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return loaded, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(fileData, loaded); err != nil {
+		return nil, err
+	}
+
+	return loaded, nil
+}
+
+// saveLoadedDefinitions writes names to a temporary file and renames it over
+// the old one, so that a crash never leaves a partially written file behind.
+func saveLoadedDefinitions(path string, loaded *loadedDefinitions) error {
+	fileData, err := json.Marshal(loaded)
+
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	tempPath := fmt.Sprintf("%s.tmp", path)
+
+	if err := os.WriteFile(tempPath, fileData, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tempPath, path)
+}
+
+func command(session *vici.Session, name string, message *vici.Message) error {
+	response, err := session.CommandRequest(name, message)
+
+	if err != nil {
+		return err
+	}
+
+	return response.Err()
+}
+
+func namedMessage(name string, section *vici.Message) *vici.Message {
+	message := vici.NewMessage()
+	message.Set(name, section)
+
+	return message
+}
+
+func nameMessage(name string) *vici.Message {
+	message := vici.NewMessage()
+	message.Set("name", name)
+
+	return message
+}
+
+func sortedNames(messages map[string]*vici.Message) []string {
+	names := make([]string, 0, len(messages))
+
+	for name := range messages {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// loadAll loads definitions of one kind and returns names which are loaded,
+// failed definitions are logged.
+func loadAll(session *vici.Session, commandName string, kind string, messages map[string]*vici.Message, l adapters.LoggingAdapter) ([]string, []error) {
+	loadedNames := []string{}
+	errs := []error{}
+
+	for _, name := range sortedNames(messages) {
+		if err := command(session, commandName, namedMessage(name, messages[name])); err != nil {
+			l.LogErrorText("Failed to load StrongSwan definition", "err", err, "kind", kind, "name", name)
+			errs = append(errs, fmt.Errorf("failed to load %s '%s': %w", kind, name, err))
+
+			continue
+		}
+
+		l.LogDebugText("VICI load StrongSwan definition", "kind", kind, "name", name)
+		loadedNames = append(loadedNames, name)
+	}
+
+	return loadedNames, errs
+}
+
+// unloadStale unloads definitions loaded before and no longer defined, names
+// which failed to unload are kept, so that unload is retried next time.
+func unloadStale(session *vici.Session, commandName string, kind string, previousNames []string, loadedNames []string, messages map[string]*vici.Message, l adapters.LoggingAdapter) ([]string, []error) {
+	errs := []error{}
+
+	for _, name := range previousNames {
+		if _, defined := messages[name]; defined {
+			// Defined but failed to load, previous definition is still there
+			if !slices.Contains(loadedNames, name) {
+				loadedNames = append(loadedNames, name)
+			}
+
+			continue
+		}
+
+		if err := command(session, commandName, nameMessage(name)); err != nil {
+			l.LogErrorText("Failed to unload StrongSwan definition", "err", err, "kind", kind, "name", name)
+			errs = append(errs, fmt.Errorf("failed to unload %s '%s': %w", kind, name, err))
+			loadedNames = append(loadedNames, name)
+
+			continue
+		}
+
+		l.LogDebugText("VICI unload StrongSwan definition", "kind", kind, "name", name)
+	}
+
+	sort.Strings(loadedNames)
+
+	return loadedNames, errs
+}
+
+// Reconcile loads connections, pools and authorities defined in vpn settings
+// and unloads those loaded by PortalSwan before and no longer defined. Keys
+// and chain certificates are loaded too, they are never unloaded.
+func Reconcile(session *vici.Session, appSettings *settings.AppSettings, l adapters.LoggingAdapter) error {
+	d, err := buildDefinitions(appSettings)
+
+	if err != nil {
+		l.LogErrorText("Failed to build StrongSwan definitions", "err", err)
+		return err
+	}
+
+	loadedPath := filepath.Join(appSettings.StateDirectoryPath(), loadedDefinitionsFileName)
+	previous, err := loadLoadedDefinitions(loadedPath)
+
+	if err != nil {
+		l.LogErrorText("Failed to load loaded StrongSwan definitions", "err", err, "path", loadedPath)
+		return err
+	}
+
+	errs := []error{}
+
+	for _, privateKey := range d.privateKeys {
+		keyMessage := vici.NewMessage()
+		keyMessage.Set("type", "any")
+		keyMessage.Set("data", privateKey)
+
+		if err := command(session, "load-key", keyMessage); err != nil {
+			l.LogErrorText("Failed to load private key", "err", err)
+			errs = append(errs, fmt.Errorf("failed to load private key: %w", err))
+		}
+	}
+
+	for _, certificate := range d.certificates {
+		certMessage := vici.NewMessage()
+		certMessage.Set("type", "x509")
+		certMessage.Set("flag", "none")
+		certMessage.Set("data", certificate)
+
+		if err := command(session, "load-cert", certMessage); err != nil {
+			l.LogErrorText("Failed to load certificate", "err", err)
+			errs = append(errs, fmt.Errorf("failed to load certificate: %w", err))
+		}
+	}
+
+	current := &loadedDefinitions{}
+	var loadErrs, unloadErrs []error
+
+	// Authorities and pools are loaded before connections using them,
+	// connections are unloaded before pools and authorities they used
+	current.Authorities, loadErrs = loadAll(session, "load-authority", "authority", d.authorities, l)
+	errs = append(errs, loadErrs...)
+	current.Pools, loadErrs = loadAll(session, "load-pool", "pool", d.pools, l)
+	errs = append(errs, loadErrs...)
+	current.Connections, loadErrs = loadAll(session, "load-conn", "connection", d.connections, l)
+	errs = append(errs, loadErrs...)
+
+	current.Connections, unloadErrs = unloadStale(session, "unload-conn", "connection", previous.Connections, current.Connections, d.connections, l)
+	errs = append(errs, unloadErrs...)
+	current.Pools, unloadErrs = unloadStale(session, "unload-pool", "pool", previous.Pools, current.Pools, d.pools, l)
+	errs = append(errs, unloadErrs...)
+	current.Authorities, unloadErrs = unloadStale(session, "unload-authority", "authority", previous.Authorities, current.Authorities, d.authorities, l)
+	errs = append(errs, unloadErrs...)
+
+	if err := saveLoadedDefinitions(loadedPath, current); err != nil {
+		l.LogErrorText("Failed to save loaded StrongSwan definitions", "err", err, "path", loadedPath)
+		errs = append(errs, err)
+	}
+
+	l.LogDebugText(
+		"VICI reconcile StrongSwan definitions completed",
+		"connections", len(current.Connections),
+		"pools", len(current.Pools),
+		"authorities", len(current.Authorities))
+
+	return errors.Join(errs...)
+}
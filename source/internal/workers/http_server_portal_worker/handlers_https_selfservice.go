@@ -69,6 +69,18 @@ func (sc *httpServerPortalContext) externalHttpsSelfServiceHandler(r *http.Reque
 
 				return http.StatusFound, "/self-service/create-password/sent/", nil, nil
 			}
+
+			if _, err := ws.AppState.SourcePolicies.Check(vpnUser.Class, r.RemoteAddr); err != nil {
+				log.LogErrorText(
+					"Failed to create password token",
+					"err", err,
+					"remoteIpAddress", r.RemoteAddr,
+					"username", emailAddress.Address,
+					"class", vpnUser.Class)
+
+				return http.StatusFound, "/self-service/create-password/sent/", nil, nil
+			}
+
 			token := &webAccessToken{
 				Username:  emailAddress.Address,
 				IpAddress: r.RemoteAddr,
@@ -272,6 +284,17 @@ func (sc *httpServerPortalContext) externalHttpsSelfServiceCreatePasswordDoneHan
 		return http.StatusUnauthorized, "webui-self-service-create-password-fail.html", nil, nil
 	}
 
+	if _, err := ws.AppState.SourcePolicies.Check(vpnUser.Class, r.RemoteAddr); err != nil {
+		log.LogErrorText(
+			"Failed to create password",
+			"err", err,
+			"remoteIpAddress", r.RemoteAddr,
+			"username", token.Username,
+			"class", vpnUser.Class)
+
+		return http.StatusUnauthorized, "webui-self-service-create-password-fail.html", nil, nil
+	}
+
 	ws.AppState.CredentialsAdapter.UpdateNtPassword(vpnUser, r.RemoteAddr, string(passwordData))
 	htmlPasswordBuilder := strings.Builder{}
 
@@ -15,6 +15,9 @@ type verificationReply struct {
 	ServerToClientBytes   int64  `json:"ServerToClientBytes"`
 	ClientToServerPackets int64  `json:"ClientToServerPackets"`
 	ServerToClientPackets int64  `json:"ServerToClientPackets"`
+	// Null if user has no quota for the period
+	DailyQuotaRemainingBytes   *int64 `json:"DailyQuotaRemainingBytes"`
+	MonthlyQuotaRemainingBytes *int64 `json:"MonthlyQuotaRemainingBytes"`
 }
 
 func (sc *httpServerPortalContext) publicHttpsVerificationHandler(w http.ResponseWriter, r *http.Request) {
@@ -38,6 +41,7 @@ func (sc *httpServerPortalContext) publicHttpsVerificationHandler(w http.Respons
 		return
 	}
 
+	quotaRemaining := ws.AppState.QuotaManager.Remaining(connectionState.Username)
 	data, err := json.Marshal(verificationReply{
 		Timestamp:             time.Now().UnixMilli(),
 		Username:              connectionState.Username,
@@ -46,6 +50,9 @@ func (sc *httpServerPortalContext) publicHttpsVerificationHandler(w http.Respons
 		ServerToClientBytes:   connectionState.ServerToClientBytes.Load(),
 		ClientToServerPackets: connectionState.ClientToServerPackets.Load(),
 		ServerToClientPackets: connectionState.ServerToClientPackets.Load(),
+
+		DailyQuotaRemainingBytes:   quotaRemaining.DailyBytes,
+		MonthlyQuotaRemainingBytes: quotaRemaining.MonthlyBytes,
 	})
 
 	if err != nil {
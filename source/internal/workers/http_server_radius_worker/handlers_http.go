@@ -2,10 +2,13 @@ package http_server_radius_worker
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
+	"strings"
 
-	"github.com/triflesoft/portalswan/internal/state"
+	"github.com/triflesoft/portalswan/internal/radius_handler"
+	"github.com/triflesoft/portalswan/internal/session_control"
 )
 
 func (sc *httpServerRadiusContext) internalHttpRadiusHandle(w http.ResponseWriter, r *http.Request) {
@@ -22,7 +25,7 @@ func (sc *httpServerRadiusContext) internalHttpRadiusHandle(w http.ResponseWrite
 		return
 	}
 
-	request := radiusRequest{}
+	request := radius_handler.RadiusRequest{}
 	err = json.Unmarshal(requestData, &request)
 
 	if err != nil {
@@ -32,206 +35,124 @@ func (sc *httpServerRadiusContext) internalHttpRadiusHandle(w http.ResponseWrite
 		return
 	}
 
-	if action == "authorize" {
-		username := ""
-		ipAddress := ""
+	switch action {
+	case "authorize":
+		status, reply := radius_handler.Authorize(ws, &request)
 
-		for attributeName, attribute := range request {
-			if (attributeName == "User-Name") && (len(attribute.Value) == 1) {
-				username, _ = attribute.Value[0].(string)
-			} else if (attributeName == "Calling-Station-Id") && (len(attribute.Value) == 1) {
-				ipAddress, _ = attribute.Value[0].(string)
-			}
-		}
+		if status != 200 {
+			jsonErrorResponse(w, status)
 
-		vpnUser := ws.AppState.IdentityAdapter.SelectVpnUser(username)
+			return
+		}
 
-		if vpnUser == nil {
-			go logRadiusRequestReply(log, "RadiusAuthorize", 401, &request, nil)
+		replyData, err := json.Marshal(reply)
 
-			log.LogErrorText("Failed to get VPN user by username", "username", username)
+		if err != nil {
+			log.LogErrorText("Failed to marshal response", "err", err)
 			jsonErrorResponse(w, 401)
 
 			return
 		}
 
-		if vpnUser.Class == "" {
-			go logRadiusRequestReply(log, "RadiusAuthorize", 401, &request, nil)
+		w.Header().Del("Content-Type")
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(200)
+		w.Write(replyData)
+	case "checksimul":
+		status := radius_handler.CheckSimul(ws, &request)
 
-			log.LogErrorText("Failed to get VPN user class", "username", username)
-			jsonErrorResponse(w, 401)
+		if status != 204 {
+			jsonErrorResponse(w, status)
 
 			return
 		}
 
-		ntPassword := ws.AppState.CredentialsAdapter.SelectNtPassword(vpnUser, ipAddress)
+		w.Header().Del("Content-Type")
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(status)
+	case "post-auth":
+		// Post-Auth-Type is passed in URL, it is not part of request list
+		rejected := strings.EqualFold(query.Get("result"), "reject")
+		status := radius_handler.PostAuth(ws, &request, rejected)
 
-		if ntPassword == "" {
-			go logRadiusRequestReply(log, "RadiusAuthorize", 401, &request, nil)
+		w.Header().Del("Content-Type")
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(status)
+	default:
+		status := radius_handler.Accounting(ws, &request)
 
-			log.LogErrorText("Failed to get VPN user NT password", "username", username)
-			jsonErrorResponse(w, 401)
+		w.Header().Del("Content-Type")
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(status)
+	}
+}
 
-			return
-		}
+type disconnectResponse struct {
+	*session_control.DisconnectResult
+	Error string `json:"error,omitempty"`
+}
 
-		response := radiusReply{}
+// internalHttpAdminDisconnectHandle terminates IKE SAs of user and optionally
+// revokes NT passwords, status is 200 if everything succeeded.
+func (sc *httpServerRadiusContext) internalHttpAdminDisconnectHandle(w http.ResponseWriter, r *http.Request) {
+	ws := sc.workerState
+	log := ws.AppState.LoggingAdapter
 
-		response["control:NT-Password"] = radiusAttribute{
-			Type:  "string",
-			Value: []any{ntPassword},
-		}
+	if r.Method != http.MethodPost {
+		jsonErrorResponse(w, 405)
 
-		response["reply:Class"] = radiusAttribute{
-			Type:  "string",
-			Value: []any{vpnUser.Class},
-		}
+		return
+	}
 
-		dnsServers := ws.AppState.GetClientSettings().DnsServers
+	requestData, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<16))
 
-		if len(dnsServers) >= 1 {
-			response["reply:MS-Primary-DNS-Server"] = radiusAttribute{
-				Type:  "string",
-				Value: []any{dnsServers[0]},
-			}
-		}
+	if err != nil {
+		log.LogErrorText("Failed to read request body", "err", err)
+		jsonErrorResponse(w, 400)
 
-		if len(dnsServers) >= 2 {
-			response["reply:MS-Secondary-DNS-Server"] = radiusAttribute{
-				Type:  "string",
-				Value: []any{dnsServers[1]},
-			}
-		}
+		return
+	}
 
-		responseData, err := json.Marshal(response)
+	request := session_control.DisconnectRequest{}
+	err = json.Unmarshal(requestData, &request)
 
-		if err != nil {
-			go logRadiusRequestReply(log, "RadiusAuthorize", 401, &request, nil)
+	if err != nil {
+		log.LogErrorText("Failed to unmarshal JSON", "err", err)
+		jsonErrorResponse(w, 400)
 
-			log.LogErrorText("Failed to marshal response", "err", err)
-			jsonErrorResponse(w, 401)
+		return
+	}
 
-			return
-		}
+	if request.Reason == "" {
+		request.Reason = "administrator request"
+	}
 
-		go logRadiusRequestReply(log, "RadiusAuthorize", 200, &request, &response)
+	status := 200
+	result, err := ws.AppState.SessionControl.Disconnect(&request)
+	response := disconnectResponse{DisconnectResult: result}
+
+	if errors.Is(err, session_control.ErrSelectorMissing) || errors.Is(err, session_control.ErrUsernameAmbiguous) {
+		status = 400
+		response.Error = err.Error()
+	} else if err != nil {
+		status = 500
+		response.Error = err.Error()
+		log.LogErrorText("Failed to disconnect VPN user", "err", err, "username", request.Username, "virtualIp", request.VirtualIp)
+	}
 
-		log.LogDebugText(
-			"Radius authorize",
-			"username", vpnUser.Username,
-			"class", vpnUser.Class)
-		w.Header().Del("Content-Type")
-		w.Header().Add("Content-Type", "application/json")
-		w.WriteHeader(200)
-		w.Write(responseData)
-	} else {
-		username := ""
-		framedIpAddress := ""
-		statusType := ""
-		inputOctets := int64(0)
-		inputPackets := int64(0)
-		outputOctets := int64(0)
-		outputPackets := int64(0)
-
-		for attributeName, attribute := range request {
-			if len(attribute.Value) == 1 {
-				strValue, ok := attribute.Value[0].(string)
-
-				if ok {
-					switch attributeName {
-					case "User-Name":
-						username = strValue
-					case "Framed-IP-Address":
-						framedIpAddress = strValue
-					case "Acct-Status-Type":
-						statusType = strValue
-					}
-				} else {
-					floatValue, ok := attribute.Value[0].(float64)
-					intValue := int64(floatValue)
-
-					if ok {
-						switch attributeName {
-						case "Acct-Input-Octets":
-							inputOctets = intValue
-						case "Acct-Input-Packets":
-							inputPackets = intValue
-						case "Acct-Output-Octets":
-							outputOctets = intValue
-						case "Acct-Output-Packets":
-							outputPackets = intValue
-						}
-					}
-				}
-			}
-		}
+	responseData, err := json.Marshal(response)
 
-		if (username != "") && (framedIpAddress != "") && (statusType != "") {
-			switch statusType {
-			case "Start":
-				log.LogDebugText(
-					"Radius create VPN connection",
-					"framedIpAddress", framedIpAddress,
-					"username", username)
-				ws.AppState.SetVpnConnectionState(
-					framedIpAddress,
-					&state.VpnConnectionState{
-						Username: username,
-					})
-			case "Stop":
-				connectionState, ok := ws.AppState.DelVpnConnectionState(framedIpAddress)
-
-				if !ok {
-					log.LogErrorText(
-						"Radius delete VPN connection failed, connection missing",
-						"framedIpAddress", framedIpAddress,
-						"username", username)
-				} else if connectionState.Username != username {
-					log.LogErrorText(
-						"Radius delete VPN connection failed, username mismatch",
-						"framedIpAddress", framedIpAddress,
-						"username", username)
-				} else {
-					log.LogDebugText(
-						"Radius delete VPN connection",
-						"framedIpAddress", framedIpAddress,
-						"username", username)
-				}
-			case "Interim-Update":
-				if (inputOctets > 0) && (inputPackets > 0) && (outputOctets > 0) && (outputPackets > 0) {
-					connectionState, ok := ws.AppState.GetVpnConnectionState(framedIpAddress)
-
-					if !ok {
-						log.LogErrorText(
-							"Radius update VPN connection failed, unknown connection",
-							"framedIpAddress", framedIpAddress)
-					} else {
-
-						if connectionState.Username != username {
-							log.LogErrorText(
-								"Radius update VPN connection failed, username mismatch",
-								"framedIpAddress", framedIpAddress,
-								"username", username)
-							connectionState.Username = username
-						}
-
-						connectionState.ClientToServerBytes.Store(inputOctets)
-						connectionState.ServerToClientBytes.Store(outputOctets)
-						connectionState.ClientToServerPackets.Store(inputPackets)
-						connectionState.ServerToClientPackets.Store(outputPackets)
-
-						ws.AppState.SetVpnConnectionState(framedIpAddress, connectionState)
-					}
-				}
-			}
-
-			go logRadiusRequestReply(log, "RadiusAccounting", 204, &request, nil)
-		}
+	if err != nil {
+		log.LogErrorText("Failed to marshal response", "err", err)
+		jsonErrorResponse(w, 500)
 
-		w.Header().Del("Content-Type")
-		w.Header().Add("Content-Type", "application/json")
-		w.WriteHeader(204)
+		return
 	}
+
+	w.Header().Del("Content-Type")
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(responseData)
 }
 
 func (sc *httpServerRadiusContext) internalHttpIndexHandler(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,83 @@
+package http_server_radius_worker
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+
+	"github.com/triflesoft/portalswan/internal/settings"
+)
+
+func lookupSocketOwner(s *settings.AppRadiusHttpSettings) (int, int, error) {
+	uid := -1
+	gid := -1
+
+	if s.SocketOwner != "" {
+		socketOwner, err := user.Lookup(s.SocketOwner)
+
+		if err != nil {
+			return uid, gid, fmt.Errorf("socket owner '%s' is invalid: %w", s.SocketOwner, err)
+		}
+
+		uid, _ = strconv.Atoi(socketOwner.Uid)
+	}
+
+	if s.SocketGroup != "" {
+		socketGroup, err := user.LookupGroup(s.SocketGroup)
+
+		if err != nil {
+			return uid, gid, fmt.Errorf("socket group '%s' is invalid: %w", s.SocketGroup, err)
+		}
+
+		gid, _ = strconv.Atoi(socketGroup.Gid)
+	}
+
+	return uid, gid, nil
+}
+
+// listen opens Unix domain socket if socket path is specified, TCP socket
+// otherwise. Socket file left by previous run is removed, the new one is
+// removed when listener is closed.
+func listen(s *settings.AppRadiusHttpSettings) (net.Listener, error) {
+	if s.SocketPath == "" {
+		return net.Listen("tcp", s.Address)
+	}
+
+	socketMode, err := strconv.ParseUint(s.SocketMode, 8, 32)
+
+	if (err != nil) || (socketMode > 0777) {
+		return nil, fmt.Errorf("socket mode '%s' is invalid", s.SocketMode)
+	}
+
+	uid, gid, err := lookupSocketOwner(s)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if fileInfo, err := os.Lstat(s.SocketPath); (err == nil) && (fileInfo.Mode()&os.ModeSocket != 0) {
+		os.Remove(s.SocketPath)
+	}
+
+	listener, err := net.Listen("unix", s.SocketPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if (uid != -1) || (gid != -1) {
+		if err := os.Chown(s.SocketPath, uid, gid); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+
+	if err := os.Chmod(s.SocketPath, os.FileMode(socketMode)); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return listener, nil
+}
@@ -6,26 +6,49 @@ import (
 	"sync/atomic"
 	"time"
 
+	ttlcache "github.com/jellydator/ttlcache/v3"
+	"github.com/triflesoft/portalswan/internal/settings"
 	"github.com/triflesoft/portalswan/internal/state"
 )
 
 type httpServerRadiusContext struct {
 	workerState *state.WorkerState
+	settings    *settings.AppRadiusHttpSettings
+	signatures  *ttlcache.Cache[string, struct{}]
 }
 
 func HttpServerRadiusWorker(ws *state.WorkerState) bool {
 	log := ws.AppState.LoggingAdapter
+	s := ws.AppState.GetRadiusSettings().Http
 
 	httpServerRadiusContext := httpServerRadiusContext{
 		workerState: ws,
+		settings:    s,
+		signatures: ttlcache.New(
+			ttlcache.WithTTL[string, struct{}](2*signatureMaxAge),
+			ttlcache.WithDisableTouchOnHit[string, struct{}]()),
+	}
+
+	go httpServerRadiusContext.signatures.Start()
+
+	if (s.Secret == "") && (s.SocketPath == "") {
+		log.LogErrorText("Radius HTTP server accepts requests without secret over TCP, any local process may get NT passwords")
 	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/radius/", httpServerRadiusContext.internalHttpRadiusHandle)
+	mux.HandleFunc("/radius/", httpServerRadiusContext.authMiddleware(httpServerRadiusContext.internalHttpRadiusHandle))
+
+	if s.AdminSecret == "" {
+		log.LogDebugText("Radius HTTP admin endpoints are disabled, admin secret is missing")
+	} else if s.AdminSecret == s.Secret {
+		log.LogErrorText("Radius HTTP admin endpoints are disabled, admin secret must differ from secret")
+	} else {
+		mux.HandleFunc("/admin/sessions/disconnect", httpServerRadiusContext.adminAuthMiddleware(httpServerRadiusContext.internalHttpAdminDisconnectHandle))
+	}
+
 	mux.HandleFunc("/", httpServerRadiusContext.internalHttpIndexHandler)
 
 	httpServer := &http.Server{
-		Addr:    "127.0.0.1:8080",
 		Handler: mux,
 	}
 
@@ -34,11 +57,20 @@ func HttpServerRadiusWorker(ws *state.WorkerState) bool {
 
 	go func() {
 		for {
-			if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
-				isRunning.Store(false)
-				log.LogErrorText("Failed to start HTTP server", "err", err)
+			listener, err := listen(s)
+
+			if err == nil {
+				log.LogDebugText("Radius HTTP listening", "address", listener.Addr().String())
+				err = httpServer.Serve(listener)
 			}
 
+			if err == http.ErrServerClosed {
+				return
+			}
+
+			isRunning.Store(false)
+			log.LogErrorText("Failed to start HTTP server", "err", err)
+
 			time.Sleep(time.Second)
 		}
 	}()
@@ -56,6 +88,8 @@ func HttpServerRadiusWorker(ws *state.WorkerState) bool {
 					log.LogErrorText("Failed to stop HTTP server", "err", err)
 				}
 
+				httpServerRadiusContext.signatures.Stop()
+
 				log.LogDebugText("Radius HTTP termination completed")
 				ws.ReportQuitCompleted()
 				return
@@ -0,0 +1,164 @@
+package http_server_radius_worker
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	secretHeaderName      = "X-PortalSwan-Secret"
+	adminSecretHeaderName = "X-PortalSwan-Admin-Secret"
+	signatureHeaderName   = "X-PortalSwan-Signature"
+	timestampHeaderName   = "X-PortalSwan-Timestamp"
+	// Signed requests older or newer than this are rejected, signatures of
+	// accepted requests are remembered until their timestamps expire.
+	signatureMaxAge = 5 * time.Minute
+)
+
+// checkSignature verifies hex encoded HMAC-SHA256, optionally prefixed with
+// "sha256=", of method, request URI and timestamp, each followed by a new line,
+// and request body.
+func checkSignature(secret string, signature string, method string, requestUri string, timestamp string, requestData []byte) bool {
+	signatureData, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n", method, requestUri, timestamp)
+	mac.Write(requestData)
+
+	return hmac.Equal(signatureData, mac.Sum(nil))
+}
+
+// checkTimestamp verifies unix time in seconds is within signatureMaxAge of now.
+func checkTimestamp(timestamp string, now time.Time) bool {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+
+	if err != nil {
+		return false
+	}
+
+	age := now.Sub(time.Unix(seconds, 0))
+
+	return (age > -signatureMaxAge) && (age < signatureMaxAge)
+}
+
+func checkSecret(secret string, r *http.Request) bool {
+	headerSecret := r.Header.Get(secretHeaderName)
+
+	if headerSecret == "" {
+		// FreeRADIUS rest module supports basic authentication, username is
+		// ignored
+		_, headerSecret, _ = r.BasicAuth()
+	}
+
+	return (headerSecret != "") && (subtle.ConstantTimeCompare([]byte(headerSecret), []byte(secret)) == 1)
+}
+
+// authMiddleware rejects requests without valid signature or secret, unless
+// secret is not configured. Signature is required if so configured.
+func (sc *httpServerRadiusContext) authMiddleware(innerHandler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s := sc.settings
+
+		if s.Secret == "" {
+			innerHandler(w, r)
+			return
+		}
+
+		log := sc.workerState.AppState.LoggingAdapter
+		requestData, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<16))
+
+		if err != nil {
+			log.LogErrorText("Failed to read request body", "err", err)
+			jsonErrorResponse(w, 401)
+
+			return
+		}
+
+		if signature := r.Header.Get(signatureHeaderName); signature != "" {
+			timestamp := r.Header.Get(timestampHeaderName)
+
+			if !checkTimestamp(timestamp, time.Now()) {
+				log.LogErrorText(
+					"Radius HTTP request rejected, signature timestamp is missing or stale",
+					"remoteAddr", r.RemoteAddr,
+					"action", r.URL.Query().Get("action"),
+					"timestamp", timestamp)
+				jsonErrorResponse(w, 401)
+
+				return
+			}
+
+			if !checkSignature(s.Secret, signature, r.Method, r.RequestURI, timestamp, requestData) {
+				log.LogErrorText(
+					"Radius HTTP request rejected, signature is invalid",
+					"remoteAddr", r.RemoteAddr,
+					"action", r.URL.Query().Get("action"))
+				jsonErrorResponse(w, 401)
+
+				return
+			}
+
+			if _, replayed := sc.signatures.GetOrSet(strings.ToLower(strings.TrimPrefix(signature, "sha256=")), struct{}{}); replayed {
+				log.LogErrorText(
+					"Radius HTTP request rejected, signature is replayed",
+					"remoteAddr", r.RemoteAddr,
+					"action", r.URL.Query().Get("action"))
+				jsonErrorResponse(w, 401)
+
+				return
+			}
+		} else if s.SignatureRequired {
+			log.LogErrorText(
+				"Radius HTTP request rejected, signature is missing",
+				"remoteAddr", r.RemoteAddr,
+				"action", r.URL.Query().Get("action"))
+			jsonErrorResponse(w, 401)
+
+			return
+		} else if !checkSecret(s.Secret, r) {
+			log.LogErrorText(
+				"Radius HTTP request rejected, secret is invalid",
+				"remoteAddr", r.RemoteAddr,
+				"action", r.URL.Query().Get("action"))
+			jsonErrorResponse(w, 401)
+
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(requestData))
+		innerHandler(w, r)
+	}
+}
+
+// adminAuthMiddleware rejects requests without admin secret, which is never
+// the one FreeRADIUS knows.
+func (sc *httpServerRadiusContext) adminAuthMiddleware(innerHandler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		headerSecret := r.Header.Get(adminSecretHeaderName)
+
+		if (headerSecret == "") || (subtle.ConstantTimeCompare([]byte(headerSecret), []byte(sc.settings.AdminSecret)) != 1) {
+			sc.workerState.AppState.LoggingAdapter.LogErrorText(
+				"Radius HTTP admin request rejected, admin secret is invalid",
+				"remoteAddr", r.RemoteAddr,
+				"path", r.URL.Path)
+			jsonErrorResponse(w, 401)
+
+			return
+		}
+
+		innerHandler(w, r)
+	}
+}
@@ -0,0 +1,431 @@
+package udp_server_radius_worker
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/triflesoft/portalswan/internal/radius_handler"
+)
+
+const (
+	dataTypeString     = "string"
+	dataTypeOctets     = "octets"
+	dataTypeInteger    = "integer"
+	dataTypeDate       = "date"
+	dataTypeIpAddr     = "ipaddr"
+	dataTypeIpv6Addr   = "ipv6addr"
+	dataTypeIpv6Prefix = "ipv6prefix"
+)
+
+// Dates are formatted the way FreeRADIUS prints them.
+const dateLayout = "Jan _2 2006 15:04:05 MST"
+
+type attributeDefinition struct {
+	Name       string
+	VendorId   uint32
+	Type       byte
+	DataType   string
+	EnumValues map[uint32]string
+}
+
+type attributeKey struct {
+	VendorId uint32
+	Type     byte
+}
+
+// Only attributes strongSwan eap-radius and PortalSwan handlers deal with are
+// listed, other attributes are passed as "Attr-N" or "Vendor-V-Attr-N" octets.
+var attributeDefinitions = []*attributeDefinition{
+	{Name: "User-Name", Type: 1, DataType: dataTypeString},
+	{Name: "NAS-IP-Address", Type: 4, DataType: dataTypeIpAddr},
+	{Name: "NAS-Port", Type: 5, DataType: dataTypeInteger},
+	{Name: "Service-Type", Type: 6, DataType: dataTypeInteger, EnumValues: map[uint32]string{
+		1:  "Login-User",
+		2:  "Framed-User",
+		3:  "Callback-Login-User",
+		4:  "Callback-Framed-User",
+		5:  "Outbound-User",
+		6:  "Administrative-User",
+		7:  "NAS-Prompt-User",
+		8:  "Authenticate-Only",
+		9:  "Callback-NAS-Prompt",
+		10: "Call-Check",
+		11: "Callback-Administrative",
+	}},
+	{Name: "Framed-Protocol", Type: 7, DataType: dataTypeInteger},
+	{Name: "Framed-IP-Address", Type: 8, DataType: dataTypeIpAddr},
+	{Name: "Framed-IP-Netmask", Type: 9, DataType: dataTypeIpAddr},
+	{Name: "Filter-Id", Type: 11, DataType: dataTypeString},
+	{Name: "Framed-MTU", Type: 12, DataType: dataTypeInteger},
+	{Name: "Reply-Message", Type: 18, DataType: dataTypeString},
+	{Name: "Framed-Route", Type: 22, DataType: dataTypeString},
+	{Name: "State", Type: 24, DataType: dataTypeOctets},
+	{Name: "Class", Type: 25, DataType: dataTypeOctets},
+	{Name: "Session-Timeout", Type: 27, DataType: dataTypeInteger},
+	{Name: "Idle-Timeout", Type: 28, DataType: dataTypeInteger},
+	{Name: "Called-Station-Id", Type: 30, DataType: dataTypeString},
+	{Name: "Calling-Station-Id", Type: 31, DataType: dataTypeString},
+	{Name: "NAS-Identifier", Type: 32, DataType: dataTypeString},
+	{Name: "Proxy-State", Type: 33, DataType: dataTypeOctets},
+	{Name: "Acct-Status-Type", Type: 40, DataType: dataTypeInteger, EnumValues: map[uint32]string{
+		1:  "Start",
+		2:  "Stop",
+		3:  "Interim-Update",
+		7:  "Accounting-On",
+		8:  "Accounting-Off",
+		15: "Failed",
+	}},
+	{Name: "Acct-Delay-Time", Type: 41, DataType: dataTypeInteger},
+	{Name: "Acct-Input-Octets", Type: 42, DataType: dataTypeInteger},
+	{Name: "Acct-Output-Octets", Type: 43, DataType: dataTypeInteger},
+	{Name: "Acct-Session-Id", Type: 44, DataType: dataTypeString},
+	{Name: "Acct-Authentic", Type: 45, DataType: dataTypeInteger},
+	{Name: "Acct-Session-Time", Type: 46, DataType: dataTypeInteger},
+	{Name: "Acct-Input-Packets", Type: 47, DataType: dataTypeInteger},
+	{Name: "Acct-Output-Packets", Type: 48, DataType: dataTypeInteger},
+	{Name: "Acct-Terminate-Cause", Type: 49, DataType: dataTypeInteger, EnumValues: map[uint32]string{
+		1:  "User-Request",
+		2:  "Lost-Carrier",
+		3:  "Lost-Service",
+		4:  "Idle-Timeout",
+		5:  "Session-Timeout",
+		6:  "Admin-Reset",
+		7:  "Admin-Reboot",
+		8:  "Port-Error",
+		9:  "NAS-Error",
+		10: "NAS-Request",
+		11: "NAS-Reboot",
+		12: "Port-Unneeded",
+		13: "Port-Preempted",
+		14: "Port-Suspended",
+		15: "Service-Unavailable",
+		16: "Callback",
+		17: "User-Error",
+		18: "Host-Request",
+	}},
+	{Name: "Acct-Multi-Session-Id", Type: 50, DataType: dataTypeString},
+	{Name: "Acct-Input-Gigawords", Type: 52, DataType: dataTypeInteger},
+	{Name: "Acct-Output-Gigawords", Type: 53, DataType: dataTypeInteger},
+	{Name: "Event-Timestamp", Type: 55, DataType: dataTypeDate},
+	{Name: "NAS-Port-Type", Type: 61, DataType: dataTypeInteger, EnumValues: map[uint32]string{
+		0:  "Async",
+		1:  "Sync",
+		2:  "ISDN",
+		3:  "ISDN-V120",
+		4:  "ISDN-V110",
+		5:  "Virtual",
+		15: "Ethernet",
+		19: "Wireless-802.11",
+	}},
+	{Name: "Tunnel-Type", Type: 64, DataType: dataTypeInteger},
+	{Name: "Tunnel-Medium-Type", Type: 65, DataType: dataTypeInteger},
+	{Name: "Tunnel-Client-Endpoint", Type: 66, DataType: dataTypeString},
+	{Name: "Tunnel-Server-Endpoint", Type: 67, DataType: dataTypeString},
+	{Name: "Acct-Interim-Interval", Type: 85, DataType: dataTypeInteger},
+	{Name: "EAP-Message", Type: 79, DataType: dataTypeOctets},
+	{Name: "Message-Authenticator", Type: 80, DataType: dataTypeOctets},
+	{Name: "NAS-Port-Id", Type: 87, DataType: dataTypeString},
+	{Name: "Framed-Pool", Type: 88, DataType: dataTypeString},
+	{Name: "NAS-IPv6-Address", Type: 95, DataType: dataTypeIpv6Addr},
+	{Name: "Framed-IPv6-Prefix", Type: 97, DataType: dataTypeIpv6Prefix},
+	{Name: "Framed-IPv6-Route", Type: 99, DataType: dataTypeString},
+	{Name: "Framed-IPv6-Pool", Type: 100, DataType: dataTypeString},
+	{Name: "Framed-IPv6-Address", Type: 168, DataType: dataTypeIpv6Addr},
+	{Name: "DNS-Server-IPv6-Address", Type: 169, DataType: dataTypeIpv6Addr},
+	{Name: "MS-MPPE-Send-Key", VendorId: vendorIdMicrosoft, Type: vendorTypeMsMppeSendKey, DataType: dataTypeOctets},
+	{Name: "MS-MPPE-Recv-Key", VendorId: vendorIdMicrosoft, Type: vendorTypeMsMppeRecvKey, DataType: dataTypeOctets},
+	{Name: "MS-Primary-DNS-Server", VendorId: vendorIdMicrosoft, Type: 28, DataType: dataTypeIpAddr},
+	{Name: "MS-Secondary-DNS-Server", VendorId: vendorIdMicrosoft, Type: 29, DataType: dataTypeIpAddr},
+	{Name: "MS-Primary-NBNS-Server", VendorId: vendorIdMicrosoft, Type: 30, DataType: dataTypeIpAddr},
+	{Name: "MS-Secondary-NBNS-Server", VendorId: vendorIdMicrosoft, Type: 31, DataType: dataTypeIpAddr},
+}
+
+var attributeDefinitionsByKey = map[attributeKey]*attributeDefinition{}
+var attributeDefinitionsByName = map[string]*attributeDefinition{}
+
+var unknownAttributeNamePattern = regexp.MustCompile(`^(?:Vendor-([0-9]+)-)?Attr-([0-9]+)$`)
+
+func init() {
+	for _, definition := range attributeDefinitions {
+		attributeDefinitionsByKey[attributeKey{definition.VendorId, definition.Type}] = definition
+		attributeDefinitionsByName[definition.Name] = definition
+	}
+}
+
+// lookupAttributeDefinition also accepts names of attributes missing from the
+// dictionary, e.g. "Attr-92" or "Vendor-9-Attr-1", their values are octets.
+func lookupAttributeDefinition(name string) (*attributeDefinition, bool) {
+	if definition, ok := attributeDefinitionsByName[name]; ok {
+		return definition, true
+	}
+
+	match := unknownAttributeNamePattern.FindStringSubmatch(name)
+
+	if match == nil {
+		return nil, false
+	}
+
+	vendorId := uint64(0)
+	attributeType, err := strconv.ParseUint(match[2], 10, 8)
+
+	if (err == nil) && (match[1] != "") {
+		vendorId, err = strconv.ParseUint(match[1], 10, 32)
+	}
+
+	if (err != nil) || (attributeType == 0) || ((vendorId == 0) && (attributeType == attributeTypeVendorSpecific)) {
+		return nil, false
+	}
+
+	return &attributeDefinition{
+		Name:     name,
+		VendorId: uint32(vendorId),
+		Type:     byte(attributeType),
+		DataType: dataTypeOctets,
+	}, true
+}
+
+func decodeAttributeValue(definition *attributeDefinition, value []byte) (any, bool) {
+	switch definition.DataType {
+	case dataTypeString:
+		return string(value), true
+	case dataTypeInteger:
+		if len(value) != 4 {
+			return nil, false
+		}
+
+		number := binary.BigEndian.Uint32(value)
+
+		if name, ok := definition.EnumValues[number]; ok {
+			return name, true
+		}
+
+		return float64(number), true
+	case dataTypeDate:
+		if len(value) != 4 {
+			return nil, false
+		}
+
+		return time.Unix(int64(binary.BigEndian.Uint32(value)), 0).UTC().Format(dateLayout), true
+	case dataTypeIpAddr:
+		if len(value) != net.IPv4len {
+			return nil, false
+		}
+
+		return net.IP(value).String(), true
+	case dataTypeIpv6Addr:
+		if len(value) != net.IPv6len {
+			return nil, false
+		}
+
+		return net.IP(value).String(), true
+	case dataTypeIpv6Prefix:
+		if (len(value) < 2) || (len(value) > 2+net.IPv6len) || (value[1] > 128) {
+			return nil, false
+		}
+
+		prefix := make(net.IP, net.IPv6len)
+		copy(prefix, value[2:])
+
+		return fmt.Sprintf("%s/%d", prefix.String(), value[1]), true
+	}
+
+	return "0x" + hex.EncodeToString(value), true
+}
+
+func encodeAttributeValue(definition *attributeDefinition, value any) ([]byte, error) {
+	text, isText := value.(string)
+	number, isNumber := value.(float64)
+
+	switch definition.DataType {
+	case dataTypeString:
+		if isText {
+			return []byte(text), nil
+		}
+	case dataTypeOctets:
+		if isText {
+			if strings.HasPrefix(text, "0x") || strings.HasPrefix(text, "0X") {
+				return hex.DecodeString(text[2:])
+			}
+
+			return []byte(text), nil
+		}
+	case dataTypeInteger, dataTypeDate:
+		if isText {
+			for enumNumber, enumName := range definition.EnumValues {
+				if enumName == text {
+					number, isNumber = float64(enumNumber), true
+				}
+			}
+
+			if parsed, err := strconv.ParseUint(text, 10, 32); err == nil {
+				number, isNumber = float64(parsed), true
+			}
+		}
+
+		if isNumber && (number >= 0) && (number <= math.MaxUint32) {
+			return binary.BigEndian.AppendUint32(nil, uint32(number)), nil
+		}
+	case dataTypeIpAddr:
+		if ip := net.ParseIP(text).To4(); isText && (ip != nil) {
+			return ip, nil
+		}
+	case dataTypeIpv6Addr:
+		if ip := net.ParseIP(text); isText && (ip != nil) && (ip.To4() == nil) {
+			return ip.To16(), nil
+		}
+	case dataTypeIpv6Prefix:
+		if _, prefix, err := net.ParseCIDR(text); isText && (err == nil) && (prefix.IP.To4() == nil) {
+			prefixLength, _ := prefix.Mask.Size()
+
+			return append([]byte{0, byte(prefixLength)}, prefix.IP.To16()...), nil
+		}
+	}
+
+	return nil, fmt.Errorf("value %v is not a valid %s", value, definition.DataType)
+}
+
+func appendRequestAttribute(request radius_handler.RadiusRequest, name string, dataType string, value any) {
+	attribute, exists := request[name]
+
+	if !exists {
+		attribute = radius_handler.RadiusAttribute{Type: dataType, Value: []any{}}
+	}
+
+	attribute.Value = append(attribute.Value, value)
+	request[name] = attribute
+}
+
+// newRadiusRequest converts packet attributes into the same structure FreeRADIUS
+// rlm_rest posts, so that the packet can be passed to shared RADIUS handlers.
+func newRadiusRequest(packet *radiusPacket) radius_handler.RadiusRequest {
+	request := radius_handler.RadiusRequest{}
+	unknown := func(name string, value []byte) {
+		appendRequestAttribute(request, name, dataTypeOctets, "0x"+hex.EncodeToString(value))
+	}
+
+	for _, attribute := range packet.Attributes {
+		if attribute.Type != attributeTypeVendorSpecific {
+			definition, ok := attributeDefinitionsByKey[attributeKey{0, attribute.Type}]
+
+			if !ok {
+				unknown(fmt.Sprintf("Attr-%d", attribute.Type), attribute.Value)
+			} else if value, ok := decodeAttributeValue(definition, attribute.Value); ok {
+				appendRequestAttribute(request, definition.Name, definition.DataType, value)
+			} else {
+				unknown(fmt.Sprintf("Attr-%d", attribute.Type), attribute.Value)
+			}
+
+			continue
+		}
+
+		if len(attribute.Value) < 4 {
+			unknown(fmt.Sprintf("Attr-%d", attribute.Type), attribute.Value)
+			continue
+		}
+
+		vendorId := binary.BigEndian.Uint32(attribute.Value[0:4])
+		vendorData := attribute.Value[4:]
+
+		for len(vendorData) >= 2 {
+			vendorType := vendorData[0]
+			vendorLength := int(vendorData[1])
+
+			if (vendorLength < 2) || (vendorLength > len(vendorData)) {
+				unknown(fmt.Sprintf("Vendor-%d-Attr-%d", vendorId, vendorType), vendorData)
+				break
+			}
+
+			vendorValue := vendorData[2:vendorLength]
+			definition, ok := attributeDefinitionsByKey[attributeKey{vendorId, vendorType}]
+
+			if !ok {
+				unknown(fmt.Sprintf("Vendor-%d-Attr-%d", vendorId, vendorType), vendorValue)
+			} else if value, ok := decodeAttributeValue(definition, vendorValue); ok {
+				appendRequestAttribute(request, definition.Name, definition.DataType, value)
+			} else {
+				unknown(fmt.Sprintf("Vendor-%d-Attr-%d", vendorId, vendorType), vendorValue)
+			}
+
+			vendorData = vendorData[vendorLength:]
+		}
+	}
+
+	return request
+}
+
+// addAcctUniqueSessionId computes Acct-Unique-Session-Id the way default
+// FreeRADIUS configuration does, so that both RADIUS servers agree on it.
+func addAcctUniqueSessionId(request radius_handler.RadiusRequest) {
+	text := func(name string) string {
+		if attribute, ok := request[name]; ok && (len(attribute.Value) > 0) {
+			return fmt.Sprint(attribute.Value[0])
+		}
+
+		return ""
+	}
+
+	nasAddress := text("NAS-IPv6-Address")
+
+	if nasAddress == "" {
+		nasAddress = text("NAS-IP-Address")
+	}
+
+	hash := md5.Sum([]byte(strings.Join(
+		[]string{
+			text("User-Name"),
+			text("Acct-Session-Id"),
+			nasAddress,
+			text("NAS-Identifier"),
+			text("NAS-Port-Id"),
+			text("NAS-Port"),
+		},
+		",")))
+
+	request["Acct-Unique-Session-Id"] = radius_handler.RadiusAttribute{
+		Type:  dataTypeString,
+		Value: []any{hex.EncodeToString(hash[:])},
+	}
+}
+
+// addReplyAttributes encodes "reply:" attributes of handler reply, "control:"
+// attributes are internal to the RADIUS server and never sent to NAS.
+func addReplyAttributes(packet *radiusPacket, reply radius_handler.RadiusReply) []error {
+	errs := []error{}
+
+	for name, attribute := range reply {
+		name, isReply := strings.CutPrefix(name, "reply:")
+
+		if !isReply {
+			continue
+		}
+
+		definition, ok := lookupAttributeDefinition(name)
+
+		if !ok {
+			errs = append(errs, fmt.Errorf("attribute %s is unknown", name))
+			continue
+		}
+
+		for _, value := range attribute.Value {
+			data, err := encodeAttributeValue(definition, value)
+
+			if err != nil {
+				errs = append(errs, fmt.Errorf("attribute %s is invalid: %w", name, err))
+			} else if definition.VendorId != 0 {
+				packet.addVendorSpecific(definition.VendorId, definition.Type, data)
+			} else {
+				packet.add(definition.Type, data)
+			}
+		}
+	}
+
+	return errs
+}
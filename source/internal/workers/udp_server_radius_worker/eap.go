@@ -0,0 +1,95 @@
+package udp_server_radius_worker
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/triflesoft/portalswan/internal/radius_handler"
+)
+
+// EAP, see RFC 3748, and EAP-MSCHAPv2, see draft-kamath-pppext-eap-mschapv2.
+const (
+	eapCodeRequest  = 1
+	eapCodeResponse = 2
+	eapCodeSuccess  = 3
+	eapCodeFailure  = 4
+
+	eapTypeIdentity  = 1
+	eapTypeMsChapV2  = 26
+	eapHeaderLength  = 4
+	eapMsChapV2Start = eapHeaderLength + 1
+
+	msChapV2OpCodeChallenge = 1
+	msChapV2OpCodeResponse  = 2
+	msChapV2OpCodeSuccess   = 3
+	msChapV2OpCodeFailure   = 4
+
+	msChapV2ChallengeLength = 16
+	msChapV2ResponseLength  = 49
+	msChapV2HeaderLength    = 4
+)
+
+type eapPacket struct {
+	Code       byte
+	Identifier byte
+	Type       byte
+	Data       []byte
+}
+
+func parseEapPacket(data []byte) (*eapPacket, error) {
+	if len(data) < eapHeaderLength {
+		return nil, errors.New("EAP packet is too short")
+	}
+
+	length := int(binary.BigEndian.Uint16(data[2:4]))
+
+	if (length < eapHeaderLength) || (length > len(data)) {
+		return nil, errors.New("EAP packet length is invalid")
+	}
+
+	packet := &eapPacket{
+		Code:       data[0],
+		Identifier: data[1],
+	}
+
+	if length > eapHeaderLength {
+		packet.Type = data[4]
+		packet.Data = data[eapMsChapV2Start:length]
+	}
+
+	return packet, nil
+}
+
+func (p *eapPacket) encode() []byte {
+	data := []byte{p.Code, p.Identifier, 0, 0}
+
+	if (p.Code == eapCodeRequest) || (p.Code == eapCodeResponse) {
+		data = append(data, p.Type)
+		data = append(data, p.Data...)
+	}
+
+	binary.BigEndian.PutUint16(data[2:4], uint16(len(data)))
+
+	return data
+}
+
+// newMsChapV2Data prepends MS-CHAPv2 header, MS-Length covers OpCode and
+// everything after it.
+func newMsChapV2Data(opCode byte, msChapV2Id byte, value []byte) []byte {
+	data := []byte{opCode, msChapV2Id, 0, 0}
+	data = append(data, value...)
+	binary.BigEndian.PutUint16(data[2:4], uint16(len(data)))
+
+	return data
+}
+
+type eapConversation struct {
+	Username               string
+	CallingStationId       string
+	EapIdentifier          byte
+	AuthenticatorChallenge []byte
+	PasswordHash           []byte
+	Reply                  radius_handler.RadiusReply
+	SendKey                []byte
+	RecvKey                []byte
+}
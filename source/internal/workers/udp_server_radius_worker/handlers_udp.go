@@ -0,0 +1,247 @@
+package udp_server_radius_worker
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/triflesoft/portalswan/internal/radius_handler"
+)
+
+func (sc *udpServerRadiusContext) newEapResponse(request *radiusPacket, code byte, eapIdentifier byte) *radiusPacket {
+	response := request.newResponse(code)
+	eapCode := byte(eapCodeFailure)
+
+	if code == codeAccessAccept {
+		eapCode = eapCodeSuccess
+	}
+
+	response.add(attributeTypeEapMessage, (&eapPacket{Code: eapCode, Identifier: eapIdentifier}).encode())
+
+	return response
+}
+
+func (sc *udpServerRadiusContext) newEapChallenge(request *radiusPacket, conversation *eapConversation, data []byte) *radiusPacket {
+	state := make([]byte, 16)
+	rand.Read(state)
+
+	conversation.EapIdentifier++
+	sc.conversations.Set(string(state), conversation, 0)
+
+	response := request.newResponse(codeAccessChallenge)
+	response.add(attributeTypeEapMessage, (&eapPacket{
+		Code:       eapCodeRequest,
+		Identifier: conversation.EapIdentifier,
+		Type:       eapTypeMsChapV2,
+		Data:       data,
+	}).encode())
+	response.add(attributeTypeState, state)
+
+	return response
+}
+
+// handleAccessRequest runs EAP-MSCHAPv2 conversation, every round trip is
+// tied to the previous one by State attribute. nil response means the request
+// is silently discarded.
+func (sc *udpServerRadiusContext) handleAccessRequest(request *radiusPacket, data []byte) *radiusPacket {
+	log := sc.workerState.AppState.LoggingAdapter
+	eapData := request.concat(attributeTypeEapMessage)
+	username, _ := request.get(attributeTypeUserName)
+
+	if len(eapData) == 0 {
+		log.LogErrorText("Radius Access-Request rejected, only EAP is supported", "username", string(username))
+
+		return request.newResponse(codeAccessReject)
+	}
+
+	if !verifyMessageAuthenticator(data, sc.secret) {
+		log.LogErrorText("Radius Access-Request discarded, Message-Authenticator is missing or invalid", "username", string(username))
+
+		return nil
+	}
+
+	eapMessage, err := parseEapPacket(eapData)
+
+	if (err != nil) || (eapMessage.Code != eapCodeResponse) {
+		log.LogErrorText("Radius Access-Request rejected, EAP-Message is invalid", "err", err, "username", string(username))
+
+		return request.newResponse(codeAccessReject)
+	}
+
+	state, hasState := request.get(attributeTypeState)
+
+	if !hasState {
+		if eapMessage.Type != eapTypeIdentity {
+			log.LogErrorText("Radius Access-Request rejected, EAP-Identity expected", "username", string(username))
+
+			return sc.newEapResponse(request, codeAccessReject, eapMessage.Identifier)
+		}
+
+		return sc.handleEapIdentity(request, eapMessage, string(username))
+	}
+
+	conversationItem, _ := sc.conversations.GetAndDelete(string(state))
+
+	if conversationItem == nil {
+		log.LogErrorText("Radius Access-Request rejected, EAP conversation is unknown or expired", "username", string(username))
+
+		return sc.newEapResponse(request, codeAccessReject, eapMessage.Identifier)
+	}
+
+	conversation := conversationItem.Value()
+
+	if (conversation.Username != string(username)) || (conversation.EapIdentifier != eapMessage.Identifier) {
+		log.LogErrorText(
+			"Radius Access-Request rejected, EAP conversation mismatch",
+			"username", string(username),
+			"conversationUsername", conversation.Username)
+
+		return sc.newEapResponse(request, codeAccessReject, eapMessage.Identifier)
+	}
+
+	if (eapMessage.Type != eapTypeMsChapV2) || (len(eapMessage.Data) < 1) {
+		log.LogErrorText("Radius Access-Request rejected, EAP-MSCHAPv2 expected", "username", conversation.Username)
+
+		return sc.newEapResponse(request, codeAccessReject, eapMessage.Identifier)
+	}
+
+	switch eapMessage.Data[0] {
+	case msChapV2OpCodeResponse:
+		return sc.handleMsChapV2Response(request, eapMessage, conversation)
+	case msChapV2OpCodeSuccess:
+		return sc.handleMsChapV2Success(request, eapMessage, conversation)
+	}
+
+	log.LogErrorText(
+		"Radius Access-Request rejected, EAP-MSCHAPv2 operation is unexpected",
+		"username", conversation.Username,
+		"opCode", eapMessage.Data[0])
+
+	return sc.newEapResponse(request, codeAccessReject, eapMessage.Identifier)
+}
+
+// handleEapIdentity authorizes user the same way FreeRADIUS does through REST,
+// simultaneous sessions included, and sends MS-CHAPv2 challenge.
+func (sc *udpServerRadiusContext) handleEapIdentity(request *radiusPacket, eapMessage *eapPacket, username string) *radiusPacket {
+	log := sc.workerState.AppState.LoggingAdapter
+	radiusRequest := newRadiusRequest(request)
+	status, reply := radius_handler.Authorize(sc.workerState, &radiusRequest)
+
+	if status != 200 {
+		return sc.newEapResponse(request, codeAccessReject, eapMessage.Identifier)
+	}
+
+	passwordHash := []byte{}
+
+	if attribute, ok := (*reply)["control:NT-Password"]; ok && (len(attribute.Value) == 1) {
+		ntPassword, _ := attribute.Value[0].(string)
+		passwordHash, _ = hex.DecodeString(ntPassword)
+	}
+
+	if len(passwordHash) != 16 {
+		log.LogErrorText("Radius Access-Request rejected, NT password is invalid", "username", username)
+
+		return sc.newEapResponse(request, codeAccessReject, eapMessage.Identifier)
+	}
+
+	callingStationId, _ := request.get(attributeTypeCallingStationId)
+	conversation := &eapConversation{
+		Username:               username,
+		CallingStationId:       string(callingStationId),
+		EapIdentifier:          eapMessage.Identifier,
+		AuthenticatorChallenge: make([]byte, msChapV2ChallengeLength),
+		PasswordHash:           passwordHash,
+		Reply:                  *reply,
+	}
+
+	rand.Read(conversation.AuthenticatorChallenge)
+
+	value := []byte{msChapV2ChallengeLength}
+	value = append(value, conversation.AuthenticatorChallenge...)
+	value = append(value, []byte(sc.settings.ServerName)...)
+
+	return sc.newEapChallenge(request, conversation, newMsChapV2Data(msChapV2OpCodeChallenge, eapMessage.Identifier+1, value))
+}
+
+// handleMsChapV2Response verifies NT-Response and sends Success-Request with
+// authenticator response. On mismatch the request is rejected straight away,
+// without Failure-Request and retry, strongSwan fails IKE_AUTH anyway.
+func (sc *udpServerRadiusContext) handleMsChapV2Response(request *radiusPacket, eapMessage *eapPacket, conversation *eapConversation) *radiusPacket {
+	log := sc.workerState.AppState.LoggingAdapter
+	data := eapMessage.Data
+
+	if (len(data) < msChapV2HeaderLength+1+msChapV2ResponseLength) || (data[msChapV2HeaderLength] != msChapV2ResponseLength) {
+		log.LogErrorText("Radius Access-Request rejected, EAP-MSCHAPv2 response is invalid", "username", conversation.Username)
+
+		return sc.newEapResponse(request, codeAccessReject, eapMessage.Identifier)
+	}
+
+	value := data[msChapV2HeaderLength+1 : msChapV2HeaderLength+1+msChapV2ResponseLength]
+	name := string(data[msChapV2HeaderLength+1+msChapV2ResponseLength:])
+	peerChallenge := value[0:16]
+	ntResponse := value[24:48]
+	expectedNtResponse := generateNtResponse(conversation.AuthenticatorChallenge, peerChallenge, name, conversation.PasswordHash)
+
+	if !hmac.Equal(ntResponse, expectedNtResponse) {
+		log.LogErrorText("Radius Access-Request rejected, EAP-MSCHAPv2 NT response mismatch", "username", conversation.Username)
+		sc.workerState.AppState.LockoutTracker.RecordFailure(conversation.Username, conversation.CallingStationId)
+
+		return sc.newEapResponse(request, codeAccessReject, eapMessage.Identifier)
+	}
+
+	conversation.SendKey, conversation.RecvKey = mppeKeys(conversation.PasswordHash, ntResponse)
+	authenticatorResponse := generateAuthenticatorResponse(
+		conversation.PasswordHash,
+		ntResponse,
+		peerChallenge,
+		conversation.AuthenticatorChallenge,
+		name)
+	message := fmt.Sprintf("%s M=OK", authenticatorResponse)
+
+	return sc.newEapChallenge(request, conversation, newMsChapV2Data(msChapV2OpCodeSuccess, data[1], []byte(message)))
+}
+
+// handleMsChapV2Success completes conversation, reply attributes are the same
+// FreeRADIUS would send after REST authorize, plus MPPE keys for strongSwan.
+func (sc *udpServerRadiusContext) handleMsChapV2Success(request *radiusPacket, eapMessage *eapPacket, conversation *eapConversation) *radiusPacket {
+	log := sc.workerState.AppState.LoggingAdapter
+
+	if conversation.SendKey == nil {
+		log.LogErrorText("Radius Access-Request rejected, EAP-MSCHAPv2 success is unexpected", "username", conversation.Username)
+
+		return sc.newEapResponse(request, codeAccessReject, eapMessage.Identifier)
+	}
+
+	response := sc.newEapResponse(request, codeAccessAccept, eapMessage.Identifier)
+
+	for _, err := range addReplyAttributes(response, conversation.Reply) {
+		log.LogErrorText("Failed to encode Radius reply attribute", "err", err, "username", conversation.Username)
+	}
+
+	response.addVendorSpecific(vendorIdMicrosoft, vendorTypeMsMppeSendKey, encryptMppeKey(conversation.SendKey, request.Authenticator, sc.secret))
+	response.addVendorSpecific(vendorIdMicrosoft, vendorTypeMsMppeRecvKey, encryptMppeKey(conversation.RecvKey, request.Authenticator, sc.secret))
+
+	log.LogDebugText("Radius EAP-MSCHAPv2 authentication succeeded", "username", conversation.Username)
+	sc.workerState.AppState.LockoutTracker.RecordSuccess(conversation.Username)
+
+	return response
+}
+
+// handleAccountingRequest passes request to shared accounting handler, the
+// response is sent regardless of handler outcome as FreeRADIUS does.
+func (sc *udpServerRadiusContext) handleAccountingRequest(request *radiusPacket, data []byte) *radiusPacket {
+	log := sc.workerState.AppState.LoggingAdapter
+
+	if !verifyAccountingRequest(data, sc.secret) {
+		log.LogErrorText("Radius Accounting-Request discarded, Request Authenticator is invalid")
+
+		return nil
+	}
+
+	radiusRequest := newRadiusRequest(request)
+	addAcctUniqueSessionId(radiusRequest)
+	radius_handler.Accounting(sc.workerState, &radiusRequest)
+
+	return request.newResponse(codeAccountingResponse)
+}
@@ -0,0 +1,198 @@
+package udp_server_radius_worker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	ttlcache "github.com/jellydator/ttlcache/v3"
+	"github.com/triflesoft/portalswan/internal/settings"
+	"github.com/triflesoft/portalswan/internal/state"
+)
+
+const (
+	conversationTtl     = 30 * time.Second
+	responseTtl         = 10 * time.Second
+	responseWaitTimeout = 5 * time.Second
+)
+
+// cachedResponse is shared by retransmissions of the same request, done is
+// closed when data is ready, data is nil when the request was discarded.
+type cachedResponse struct {
+	done chan struct{}
+	data []byte
+}
+
+type udpServerRadiusContext struct {
+	workerState   *state.WorkerState
+	settings      *settings.AppRadiusUdpSettings
+	secret        []byte
+	conversations *ttlcache.Cache[string, *eapConversation]
+	responses     *ttlcache.Cache[string, *cachedResponse]
+}
+
+func (sc *udpServerRadiusContext) handlePacket(
+	conn net.PacketConn,
+	address net.Addr,
+	data []byte,
+	expectedCode byte,
+	handle func(request *radiusPacket, data []byte) *radiusPacket) {
+	log := sc.workerState.AppState.LoggingAdapter
+	request, err := parseRadiusPacket(data)
+
+	if err != nil {
+		log.LogErrorText("Failed to parse Radius packet", "err", err, "address", address.String())
+
+		return
+	}
+
+	if request.Code != expectedCode {
+		log.LogErrorText("Radius packet discarded, code is unexpected", "code", request.Code, "address", address.String())
+
+		return
+	}
+
+	responseKey := fmt.Sprintf("%s/%d/%x", address.String(), request.Identifier, request.Authenticator)
+	responseItem, isRetransmission := sc.responses.GetOrSet(responseKey, &cachedResponse{done: make(chan struct{})})
+	response := responseItem.Value()
+
+	if isRetransmission {
+		select {
+		case <-response.done:
+		case <-time.After(responseWaitTimeout):
+			return
+		}
+
+		if response.data != nil {
+			conn.WriteTo(response.data, address)
+		}
+
+		return
+	}
+
+	defer close(response.done)
+
+	responsePacket := handle(request, data)
+
+	if responsePacket == nil {
+		return
+	}
+
+	response.data = responsePacket.encodeResponse(request.Authenticator, sc.secret, responsePacket.Code != codeAccountingResponse)
+
+	if _, err := conn.WriteTo(response.data, address); err != nil {
+		log.LogErrorText("Failed to send Radius packet", "err", err, "address", address.String())
+	}
+}
+
+func (sc *udpServerRadiusContext) serve(
+	conn net.PacketConn,
+	expectedCode byte,
+	handle func(request *radiusPacket, data []byte) *radiusPacket) {
+	log := sc.workerState.AppState.LoggingAdapter
+	buffer := make([]byte, packetMaxLength)
+
+	for {
+		length, address, err := conn.ReadFrom(buffer)
+
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			log.LogErrorText("Failed to receive Radius packet", "err", err)
+			continue
+		}
+
+		go sc.handlePacket(conn, address, bytes.Clone(buffer[:length]), expectedCode, handle)
+	}
+}
+
+func UdpServerRadiusWorker(ws *state.WorkerState) bool {
+	log := ws.AppState.LoggingAdapter
+	s := ws.AppState.GetRadiusSettings().Udp
+
+	if (s == nil) || (s.Secret == "") {
+		if s != nil {
+			log.LogErrorText("Failed to start Radius UDP server, secret is missing")
+		}
+
+		go func() {
+			<-ws.QuitChan
+			ws.ReportQuitCompleted()
+		}()
+
+		ws.ReportInitCompleted()
+
+		return false
+	}
+
+	for _, attributeName := range ws.AppState.ReplyTemplates.AttributeNames() {
+		if _, ok := lookupAttributeDefinition(attributeName); !ok {
+			log.LogErrorText(
+				"Radius reply attribute is unknown to Radius UDP server and will not be sent",
+				"attributeName", attributeName)
+		}
+	}
+
+	authConn, authErr := net.ListenPacket("udp", s.AuthAddress)
+
+	if authErr != nil {
+		log.LogErrorText("Failed to start Radius UDP server", "err", authErr, "address", s.AuthAddress)
+	}
+
+	acctConn, acctErr := net.ListenPacket("udp", s.AcctAddress)
+
+	if acctErr != nil {
+		log.LogErrorText("Failed to start Radius UDP server", "err", acctErr, "address", s.AcctAddress)
+	}
+
+	udpServerRadiusContext := udpServerRadiusContext{
+		workerState: ws,
+		settings:    s,
+		secret:      []byte(s.Secret),
+		conversations: ttlcache.New(
+			ttlcache.WithTTL[string, *eapConversation](conversationTtl),
+			ttlcache.WithDisableTouchOnHit[string, *eapConversation]()),
+		responses: ttlcache.New(
+			ttlcache.WithTTL[string, *cachedResponse](responseTtl),
+			ttlcache.WithDisableTouchOnHit[string, *cachedResponse]()),
+	}
+
+	go udpServerRadiusContext.conversations.Start()
+	go udpServerRadiusContext.responses.Start()
+
+	if authConn != nil {
+		go udpServerRadiusContext.serve(authConn, codeAccessRequest, udpServerRadiusContext.handleAccessRequest)
+	}
+
+	if acctConn != nil {
+		go udpServerRadiusContext.serve(acctConn, codeAccountingRequest, udpServerRadiusContext.handleAccountingRequest)
+	}
+
+	go func() {
+		<-ws.QuitChan
+		log.LogDebugText("Terminating Radius UDP...")
+
+		if authConn != nil {
+			authConn.Close()
+		}
+
+		if acctConn != nil {
+			acctConn.Close()
+		}
+
+		udpServerRadiusContext.conversations.Stop()
+		udpServerRadiusContext.responses.Stop()
+
+		log.LogDebugText("Radius UDP termination completed")
+		ws.ReportQuitCompleted()
+	}()
+
+	log.LogDebugText("Radius UDP initalization completed")
+	ws.ReportInitCompleted()
+
+	return (authConn != nil) && (acctConn != nil)
+}
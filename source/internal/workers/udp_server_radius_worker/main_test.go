@@ -0,0 +1,381 @@
+package udp_server_radius_worker
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fernet/fernet-go"
+	"github.com/triflesoft/portalswan/internal/adapters/adapters"
+	_ "github.com/triflesoft/portalswan/internal/adapters/file_credentials_adapter"
+	_ "github.com/triflesoft/portalswan/internal/adapters/stdout_logs_adapter"
+	"github.com/triflesoft/portalswan/internal/settings"
+	"github.com/triflesoft/portalswan/internal/state"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2759"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2866"
+	"layeh.com/radius/rfc2869"
+	"layeh.com/radius/rfc3079"
+	"layeh.com/radius/vendors/microsoft"
+)
+
+const (
+	testClass            = "staff"
+	testEmail            = "user@example.com"
+	testCallingStationId = "192.0.2.10"
+	testFramedIpAddress  = "10.0.0.2"
+)
+
+// testIdentityAdapter knows the test user by username, in any case, and by
+// email, as LDAP adapter does.
+type testIdentityAdapter struct{}
+
+func (a *testIdentityAdapter) SelectVpnUser(username string) *adapters.VpnUser {
+	if !strings.EqualFold(username, testUsername) && (username != testEmail) {
+		return nil
+	}
+
+	return &adapters.VpnUser{Username: testUsername, Email: testEmail, Class: testClass}
+}
+
+type testEmailAdapter struct{}
+
+func (a *testEmailAdapter) SendEmail(recipientAddress string, subject string, bodyText string, bodyHtml string, attachments map[string]adapters.EmailAttachment) {
+}
+
+func init() {
+	adapters.RegisterIdentityAdapter("test", adapters.AdapterFactory[settings.AppIdentitySettings, adapters.IdentityAdapter]{
+		IsConfigured: func(s *settings.AppIdentitySettings) bool {
+			return true
+		},
+		New: func(s *settings.AppIdentitySettings, l adapters.LoggingAdapter) (adapters.IdentityAdapter, error) {
+			return &testIdentityAdapter{}, nil
+		},
+	})
+	adapters.RegisterEmailAdapter("test", adapters.AdapterFactory[settings.AppEmailSettings, adapters.EmailAdapter]{
+		IsConfigured: func(s *settings.AppEmailSettings) bool {
+			return true
+		},
+		New: func(s *settings.AppEmailSettings, l adapters.LoggingAdapter) (adapters.EmailAdapter, error) {
+			return &testEmailAdapter{}, nil
+		},
+	})
+}
+
+// freeUdpAddress returns loopback address with a port nobody listens on.
+func freeUdpAddress(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	defer conn.Close()
+
+	return conn.LocalAddr().String()
+}
+
+// startTestWorker starts Radius UDP server with in-process identity and file
+// credentials, the test user has NT password for the test calling station.
+func startTestWorker(t *testing.T, radiusSettings *settings.AppRadiusSettings) (*state.AppState, *settings.AppRadiusUdpSettings) {
+	fernetKey := &fernet.Key{}
+
+	if err := fernetKey.Generate(); err != nil {
+		t.Fatalf("failed to generate fernet key: %v", err)
+	}
+
+	udpSettings := &settings.AppRadiusUdpSettings{
+		AuthAddress: freeUdpAddress(t),
+		AcctAddress: freeUdpAddress(t),
+		Secret:      string(testSecret),
+		ServerName:  "portalswan",
+	}
+	radiusSettings.Udp = udpSettings
+	appState, err := state.NewAppStateWithSettings(&settings.AppSettings{
+		Identity: &settings.AppIdentitySettings{},
+		Credentials: &settings.AppCredentialsSettings{
+			File: &settings.AppCredentialsFileSettings{DirectoryPath: t.TempDir(), FernetKeys: []*fernet.Key{fernetKey}},
+		},
+		Email:   &settings.AppEmailSettings{},
+		Logging: &settings.AppLoggingSettings{Stdout: &settings.AppLoggingStdoutSettings{}},
+		Server:  &settings.AppServerSettings{StateDirectoryPath: t.TempDir()},
+		Client:  &settings.AppClientSettings{DnsServers: []string{"10.0.0.53"}},
+		Radius:  radiusSettings,
+	})
+
+	if err != nil {
+		t.Fatalf("failed to create app state: %v", err)
+	}
+
+	vpnUser := appState.IdentityAdapter.SelectVpnUser(testUsername)
+	appState.CredentialsAdapter.UpdateNtPassword(vpnUser, testCallingStationId, testPassword)
+
+	if !UdpServerRadiusWorker(appState.NewWorkerState()) {
+		t.Fatalf("failed to start Radius UDP server")
+	}
+
+	appState.WaitInitCompleted()
+	t.Cleanup(func() {
+		appState.Quit()
+		appState.WaitQuitCompleted()
+	})
+
+	return appState, udpSettings
+}
+
+// exchangeEap sends EAP-Message in Access-Request signed with
+// Message-Authenticator and returns request and verified response.
+func exchangeEap(t *testing.T, address string, eapMessage *eapPacket, state []byte) (*radius.Packet, *radius.Packet, *eapPacket) {
+	request := radius.New(radius.CodeAccessRequest, testSecret)
+	rfc2865.UserName_SetString(request, testUsername)
+	rfc2865.CallingStationID_SetString(request, testCallingStationId)
+	rfc2869.EAPMessage_Set(request, eapMessage.encode())
+
+	if state != nil {
+		rfc2865.State_Set(request, state)
+	}
+
+	signAccessRequest(t, request)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	response, err := radius.Exchange(ctx, request, address)
+
+	if err != nil {
+		t.Fatalf("failed to exchange Access-Request: %v", err)
+	}
+
+	responseData, _ := response.MarshalBinary()
+	verifyResponseMessageAuthenticator(t, responseData, request)
+
+	eapResponse, err := parseEapPacket(rfc2869.EAPMessage_Get(response))
+
+	if err != nil {
+		t.Fatalf("failed to parse EAP-Message: %v", err)
+	}
+
+	return request, response, eapResponse
+}
+
+func TestAccessRequestEapMsChapV2(t *testing.T) {
+	_, udpSettings := startTestWorker(t, &settings.AppRadiusSettings{})
+
+	// EAP-Identity
+	_, response, eapChallenge := exchangeEap(t, udpSettings.AuthAddress, &eapPacket{
+		Code:       eapCodeResponse,
+		Identifier: 1,
+		Type:       eapTypeIdentity,
+		Data:       []byte(testUsername),
+	}, nil)
+
+	if (response.Code != radius.CodeAccessChallenge) || (eapChallenge.Type != eapTypeMsChapV2) || (eapChallenge.Data[0] != msChapV2OpCodeChallenge) {
+		t.Fatalf("expected EAP-MSCHAPv2 challenge, got %v %+v", response.Code, eapChallenge)
+	}
+
+	value := eapChallenge.Data[msChapV2HeaderLength:]
+
+	if (value[0] != msChapV2ChallengeLength) || (string(value[1+msChapV2ChallengeLength:]) != udpSettings.ServerName) {
+		t.Fatalf("expected challenge value with server name, got %X", value)
+	}
+
+	authenticatorChallenge := value[1 : 1+msChapV2ChallengeLength]
+
+	// EAP-MSCHAPv2 Response
+	peerChallenge := bytes.Repeat([]byte{0x21}, 16)
+	ntResponse, _ := rfc2759.GenerateNTResponse(authenticatorChallenge, peerChallenge, []byte(testUsername), []byte(testPassword))
+	responseValue := []byte{msChapV2ResponseLength}
+	responseValue = append(responseValue, peerChallenge...)
+	responseValue = append(responseValue, make([]byte, 8)...)
+	responseValue = append(responseValue, ntResponse...)
+	responseValue = append(responseValue, 0)
+	responseValue = append(responseValue, []byte(testUsername)...)
+	_, response, eapSuccessRequest := exchangeEap(t, udpSettings.AuthAddress, &eapPacket{
+		Code:       eapCodeResponse,
+		Identifier: eapChallenge.Identifier,
+		Type:       eapTypeMsChapV2,
+		Data:       newMsChapV2Data(msChapV2OpCodeResponse, eapChallenge.Data[1], responseValue),
+	}, rfc2865.State_Get(response))
+
+	if (response.Code != radius.CodeAccessChallenge) || (eapSuccessRequest.Data[0] != msChapV2OpCodeSuccess) {
+		t.Fatalf("expected EAP-MSCHAPv2 success request, got %v %+v", response.Code, eapSuccessRequest)
+	}
+
+	authenticatorResponse, _ := rfc2759.GenerateAuthenticatorResponse(authenticatorChallenge, peerChallenge, ntResponse, []byte(testUsername), []byte(testPassword))
+
+	if message := string(eapSuccessRequest.Data[msChapV2HeaderLength:]); message != authenticatorResponse+" M=OK" {
+		t.Fatalf("expected '%s M=OK', got '%s'", authenticatorResponse, message)
+	}
+
+	// EAP-MSCHAPv2 Success
+	request, response, eapSuccess := exchangeEap(t, udpSettings.AuthAddress, &eapPacket{
+		Code:       eapCodeResponse,
+		Identifier: eapSuccessRequest.Identifier,
+		Type:       eapTypeMsChapV2,
+		Data:       []byte{msChapV2OpCodeSuccess},
+	}, rfc2865.State_Get(response))
+
+	if (response.Code != radius.CodeAccessAccept) || (eapSuccess.Code != eapCodeSuccess) {
+		t.Fatalf("expected Access-Accept with EAP-Success, got %v %+v", response.Code, eapSuccess)
+	}
+
+	if class := rfc2865.Class_GetString(response); class != testClass {
+		t.Fatalf("expected class '%s', got '%s'", testClass, class)
+	}
+
+	if dnsServer := microsoft.MSPrimaryDNSServer_Get(response); !dnsServer.Equal(net.ParseIP("10.0.0.53")) {
+		t.Fatalf("expected primary DNS server, got %v", dnsServer)
+	}
+
+	expectedSendKey, _ := rfc3079.MakeKey(ntResponse, []byte(testPassword), true)
+	expectedRecvKey, _ := rfc3079.MakeKey(ntResponse, []byte(testPassword), false)
+
+	if sendKey := microsoft.MSMPPESendKey_Get(response, request); !bytes.Equal(sendKey, expectedSendKey) {
+		t.Fatalf("expected MS-MPPE-Send-Key %X, got %X", expectedSendKey, sendKey)
+	}
+
+	if recvKey := microsoft.MSMPPERecvKey_Get(response, request); !bytes.Equal(recvKey, expectedRecvKey) {
+		t.Fatalf("expected MS-MPPE-Recv-Key %X, got %X", expectedRecvKey, recvKey)
+	}
+}
+
+func TestAccessRequestTooManySessions(t *testing.T) {
+	appState, udpSettings := startTestWorker(t, &settings.AppRadiusSettings{MaxSessions: map[string]int{"*": 1}})
+	appState.EnsureVpnConnectionState("session-1", testFramedIpAddress, testUsername, testClass)
+
+	_, response, eapFailure := exchangeEap(t, udpSettings.AuthAddress, &eapPacket{
+		Code:       eapCodeResponse,
+		Identifier: 1,
+		Type:       eapTypeIdentity,
+		Data:       []byte(testUsername),
+	}, nil)
+
+	if (response.Code != radius.CodeAccessReject) || (eapFailure.Code != eapCodeFailure) {
+		t.Fatalf("expected Access-Reject with EAP-Failure, got %v %+v", response.Code, eapFailure)
+	}
+}
+
+func TestAccessRequestWithoutMessageAuthenticator(t *testing.T) {
+	_, udpSettings := startTestWorker(t, &settings.AppRadiusSettings{})
+	request := newTestAccessRequest(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	// Request is silently discarded
+	if response, err := radius.Exchange(ctx, request, udpSettings.AuthAddress); err == nil {
+		t.Fatalf("expected no response, got %v", response.Code)
+	}
+}
+
+func TestAccountingRequest(t *testing.T) {
+	appState, udpSettings := startTestWorker(t, &settings.AppRadiusSettings{})
+	request := radius.New(radius.CodeAccountingRequest, testSecret)
+	rfc2865.UserName_SetString(request, testUsername)
+	rfc2865.FramedIPAddress_Set(request, net.ParseIP(testFramedIpAddress))
+	rfc2865.Class_SetString(request, testClass)
+	rfc2866.AcctStatusType_Set(request, rfc2866.AcctStatusType_Value_Start)
+	rfc2866.AcctSessionID_SetString(request, "session-1")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	response, err := radius.Exchange(ctx, request, udpSettings.AcctAddress)
+
+	if err != nil {
+		t.Fatalf("failed to exchange Accounting-Request: %v", err)
+	}
+
+	if response.Code != radius.CodeAccountingResponse {
+		t.Fatalf("expected Accounting-Response, got %v", response.Code)
+	}
+
+	connectionState, ok := appState.GetVpnConnectionState(testFramedIpAddress)
+
+	if !ok || (connectionState.Username != testUsername) {
+		t.Fatalf("expected connection of '%s', got %+v", testUsername, connectionState)
+	}
+
+	// Request signed with another secret is silently discarded
+	request.Secret = []byte("wrong")
+	request.Identifier++
+	ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if response, err := radius.Exchange(ctx, request, udpSettings.AcctAddress); err == nil {
+		t.Fatalf("expected no response, got %v", response.Code)
+	}
+
+}
+
+// exchangeAccounting sends Accounting-Request of the test session.
+func exchangeAccounting(t *testing.T, address string, username string, framedIpAddress string, statusType rfc2866.AcctStatusType) {
+	request := radius.New(radius.CodeAccountingRequest, testSecret)
+	rfc2865.UserName_SetString(request, username)
+	rfc2865.FramedIPAddress_Set(request, net.ParseIP(framedIpAddress))
+	rfc2865.CallingStationID_SetString(request, testCallingStationId)
+	rfc2866.AcctStatusType_Set(request, statusType)
+	rfc2866.AcctSessionID_SetString(request, "session-1")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	response, err := radius.Exchange(ctx, request, address)
+
+	if err != nil {
+		t.Fatalf("failed to exchange Accounting-Request: %v", err)
+	}
+
+	if response.Code != radius.CodeAccountingResponse {
+		t.Fatalf("expected Accounting-Response, got %v", response.Code)
+	}
+}
+
+func TestAccountingRequestAlias(t *testing.T) {
+	appState, udpSettings := startTestWorker(t, &settings.AppRadiusSettings{
+		Pools: map[string]*settings.AppRadiusPoolSettings{
+			"*": {Ipv4Prefix: "10.0.0.0/29", LeaseDuration: time.Hour},
+		},
+	})
+	lease, err := appState.IpPoolManager.Lease(testClass, testUsername, testCallingStationId)
+
+	if err != nil {
+		t.Fatalf("failed to lease address: %v", err)
+	}
+
+	// Client authenticated with email, authorize leased for username
+	exchangeAccounting(t, udpSettings.AcctAddress, testEmail, lease.Ipv4Address, rfc2866.AcctStatusType_Value_Start)
+
+	connectionState, ok := appState.GetVpnConnectionState(lease.Ipv4Address)
+
+	if !ok || (connectionState.Username != testUsername) {
+		t.Fatalf("expected connection of '%s', got %+v", testUsername, connectionState)
+	}
+
+	if count := appState.CountVpnConnectionStates(testUsername); count != 1 {
+		t.Fatalf("expected 1 connection, got %d", count)
+	}
+
+	// Lease is active, so it is not handed out again even to the same user
+	if secondLease, _ := appState.IpPoolManager.Lease(testClass, testUsername, testCallingStationId); secondLease.Ipv4Address == lease.Ipv4Address {
+		t.Fatalf("expected active lease %s not to be reused", lease.Ipv4Address)
+	}
+}
+
+func TestAccessRequestTooManySessionsAlias(t *testing.T) {
+	_, udpSettings := startTestWorker(t, &settings.AppRadiusSettings{MaxSessions: map[string]int{"*": 1}})
+
+	// Session of the same user, who authenticated with email
+	exchangeAccounting(t, udpSettings.AcctAddress, testEmail, testFramedIpAddress, rfc2866.AcctStatusType_Value_Start)
+
+	_, response, eapFailure := exchangeEap(t, udpSettings.AuthAddress, &eapPacket{
+		Code:       eapCodeResponse,
+		Identifier: 1,
+		Type:       eapTypeIdentity,
+		Data:       []byte(testUsername),
+	}, nil)
+
+	if (response.Code != radius.CodeAccessReject) || (eapFailure.Code != eapCodeFailure) {
+		t.Fatalf("expected Access-Reject with EAP-Failure, got %v %+v", response.Code, eapFailure)
+	}
+}
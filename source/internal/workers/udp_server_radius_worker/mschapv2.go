@@ -0,0 +1,117 @@
+package udp_server_radius_worker
+
+import (
+	"crypto/des"
+	"crypto/sha1"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/md4"
+)
+
+// MS-CHAPv2 computations, see RFC 2759, and MPPE key derivation, see RFC 3079.
+
+var (
+	authenticatorMagic1 = []byte("Magic server to client signing constant")
+	authenticatorMagic2 = []byte("Pad to make it do more than one iteration")
+	masterKeyMagic1     = []byte("This is the MPPE Master Key")
+	masterKeyMagic2     = []byte("On the client side, this is the send key; on the server side, it is the receive key.")
+	masterKeyMagic3     = []byte("On the client side, this is the receive key; on the server side, it is the send key.")
+	shsPad1             = make([]byte, 40)
+	shsPad2             = []byte(strings.Repeat("\xf2", 40))
+)
+
+const mppeKeyLength = 16
+
+// challengeHash uses username without domain, as sent by Windows clients.
+func challengeHash(peerChallenge []byte, authenticatorChallenge []byte, username string) []byte {
+	if i := strings.LastIndex(username, "\\"); i >= 0 {
+		username = username[i+1:]
+	}
+
+	hash := sha1.New()
+	hash.Write(peerChallenge)
+	hash.Write(authenticatorChallenge)
+	hash.Write([]byte(username))
+
+	return hash.Sum(nil)[:8]
+}
+
+// desEncrypt spreads 56 key bits over 8 bytes, parity bits are ignored.
+func desEncrypt(clear []byte, key7 []byte) []byte {
+	key := []byte{
+		key7[0],
+		key7[0]<<7 | key7[1]>>1,
+		key7[1]<<6 | key7[2]>>2,
+		key7[2]<<5 | key7[3]>>3,
+		key7[3]<<4 | key7[4]>>4,
+		key7[4]<<3 | key7[5]>>5,
+		key7[5]<<2 | key7[6]>>6,
+		key7[6] << 1,
+	}
+	block, _ := des.NewCipher(key)
+	encrypted := make([]byte, des.BlockSize)
+	block.Encrypt(encrypted, clear)
+
+	return encrypted
+}
+
+func challengeResponse(challenge []byte, passwordHash []byte) []byte {
+	zPasswordHash := make([]byte, 21)
+	copy(zPasswordHash, passwordHash)
+
+	response := make([]byte, 0, 24)
+	response = append(response, desEncrypt(challenge, zPasswordHash[0:7])...)
+	response = append(response, desEncrypt(challenge, zPasswordHash[7:14])...)
+	response = append(response, desEncrypt(challenge, zPasswordHash[14:21])...)
+
+	return response
+}
+
+func generateNtResponse(authenticatorChallenge []byte, peerChallenge []byte, username string, passwordHash []byte) []byte {
+	return challengeResponse(challengeHash(peerChallenge, authenticatorChallenge, username), passwordHash)
+}
+
+func hashNtPasswordHash(passwordHash []byte) []byte {
+	hash := md4.New()
+	hash.Write(passwordHash)
+
+	return hash.Sum(nil)
+}
+
+func generateAuthenticatorResponse(passwordHash []byte, ntResponse []byte, peerChallenge []byte, authenticatorChallenge []byte, username string) string {
+	hash := sha1.New()
+	hash.Write(hashNtPasswordHash(passwordHash))
+	hash.Write(ntResponse)
+	hash.Write(authenticatorMagic1)
+	digest := hash.Sum(nil)
+
+	hash.Reset()
+	hash.Write(digest)
+	hash.Write(challengeHash(peerChallenge, authenticatorChallenge, username))
+	hash.Write(authenticatorMagic2)
+
+	return fmt.Sprintf("S=%X", hash.Sum(nil))
+}
+
+func getAsymmetricStartKey(masterKey []byte, magic []byte) []byte {
+	hash := sha1.New()
+	hash.Write(masterKey)
+	hash.Write(shsPad1)
+	hash.Write(magic)
+	hash.Write(shsPad2)
+
+	return hash.Sum(nil)[:mppeKeyLength]
+}
+
+// mppeKeys returns MS-MPPE-Send-Key and MS-MPPE-Recv-Key from the server
+// point of view.
+func mppeKeys(passwordHash []byte, ntResponse []byte) ([]byte, []byte) {
+	hash := sha1.New()
+	hash.Write(hashNtPasswordHash(passwordHash))
+	hash.Write(ntResponse)
+	hash.Write(masterKeyMagic1)
+	masterKey := hash.Sum(nil)[:16]
+
+	return getAsymmetricStartKey(masterKey, masterKeyMagic3), getAsymmetricStartKey(masterKey, masterKeyMagic2)
+}
@@ -0,0 +1,105 @@
+package udp_server_radius_worker
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"layeh.com/radius/rfc2759"
+	"layeh.com/radius/rfc3079"
+)
+
+// Sample data of RFC 2759 section 9.2 and RFC 3079 section 3.5.3.
+const (
+	testUsername               = "User"
+	testPassword               = "clientPass"
+	testAuthenticatorChallenge = "5B5D7C7D7B3F2F3E3C2C602132262628"
+	testPeerChallenge          = "21402324255E262A28295F2B3A337C7E"
+	testPasswordHash           = "44EBBA8D5312B8D611474411F56989AE"
+	testNtResponse             = "82309ECD8D708B5EA08FAA3981CD83544233114A3D85D6DF"
+	testAuthenticatorResponse  = "S=407A5589115FD0D6209F510FE9C04566932CDA56"
+	testMasterKey              = "FDECE3717A8C838CB388E527AE3CDD31"
+	testSendStartKey128        = "8B7CDC149B993A1BA118CB153F56DCCB"
+)
+
+func mustDecodeHex(t *testing.T, text string) []byte {
+	data, err := hex.DecodeString(text)
+
+	if err != nil {
+		t.Fatalf("failed to decode '%s': %v", text, err)
+	}
+
+	return data
+}
+
+func TestGenerateNtResponse(t *testing.T) {
+	ntResponse := generateNtResponse(
+		mustDecodeHex(t, testAuthenticatorChallenge),
+		mustDecodeHex(t, testPeerChallenge),
+		testUsername,
+		mustDecodeHex(t, testPasswordHash))
+
+	if !bytes.Equal(ntResponse, mustDecodeHex(t, testNtResponse)) {
+		t.Fatalf("expected %s, got %X", testNtResponse, ntResponse)
+	}
+}
+
+func TestGenerateNtResponseStripsDomain(t *testing.T) {
+	ntResponse := generateNtResponse(
+		mustDecodeHex(t, testAuthenticatorChallenge),
+		mustDecodeHex(t, testPeerChallenge),
+		"EXAMPLE\\"+testUsername,
+		mustDecodeHex(t, testPasswordHash))
+
+	if !bytes.Equal(ntResponse, mustDecodeHex(t, testNtResponse)) {
+		t.Fatalf("expected %s, got %X", testNtResponse, ntResponse)
+	}
+}
+
+func TestGenerateAuthenticatorResponse(t *testing.T) {
+	authenticatorResponse := generateAuthenticatorResponse(
+		mustDecodeHex(t, testPasswordHash),
+		mustDecodeHex(t, testNtResponse),
+		mustDecodeHex(t, testPeerChallenge),
+		mustDecodeHex(t, testAuthenticatorChallenge),
+		testUsername)
+
+	if authenticatorResponse != testAuthenticatorResponse {
+		t.Fatalf("expected %s, got %s", testAuthenticatorResponse, authenticatorResponse)
+	}
+
+	expected, err := rfc2759.GenerateAuthenticatorResponse(
+		mustDecodeHex(t, testAuthenticatorChallenge),
+		mustDecodeHex(t, testPeerChallenge),
+		mustDecodeHex(t, testNtResponse),
+		[]byte(testUsername),
+		[]byte(testPassword))
+
+	if (err != nil) || (authenticatorResponse != expected) {
+		t.Fatalf("expected %s, got %s, err %v", expected, authenticatorResponse, err)
+	}
+}
+
+func TestMppeKeys(t *testing.T) {
+	passwordHash := mustDecodeHex(t, testPasswordHash)
+	ntResponse := mustDecodeHex(t, testNtResponse)
+	masterKey := rfc3079.GetMasterKey(hashNtPasswordHash(passwordHash), ntResponse)
+
+	if !bytes.Equal(masterKey, mustDecodeHex(t, testMasterKey)) {
+		t.Fatalf("expected master key %s, got %X", testMasterKey, masterKey)
+	}
+
+	sendKey, recvKey := mppeKeys(passwordHash, ntResponse)
+
+	// RFC 3079 sample send key is derived with Magic3, as the server does.
+	if !bytes.Equal(sendKey, mustDecodeHex(t, testSendStartKey128)) {
+		t.Fatalf("expected send key %s, got %X", testSendStartKey128, sendKey)
+	}
+
+	expectedSendKey, _ := rfc3079.MakeKey(ntResponse, []byte(testPassword), true)
+	expectedRecvKey, _ := rfc3079.MakeKey(ntResponse, []byte(testPassword), false)
+
+	if !bytes.Equal(sendKey, expectedSendKey) || !bytes.Equal(recvKey, expectedRecvKey) {
+		t.Fatalf("expected keys %X and %X, got %X and %X", expectedSendKey, expectedRecvKey, sendKey, recvKey)
+	}
+}
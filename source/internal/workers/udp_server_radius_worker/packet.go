@@ -0,0 +1,314 @@
+package udp_server_radius_worker
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	codeAccessRequest      = 1
+	codeAccessAccept       = 2
+	codeAccessReject       = 3
+	codeAccountingRequest  = 4
+	codeAccountingResponse = 5
+	codeAccessChallenge    = 11
+)
+
+const (
+	attributeTypeUserName             = 1
+	attributeTypeState                = 24
+	attributeTypeCallingStationId     = 31
+	attributeTypeVendorSpecific       = 26
+	attributeTypeProxyState           = 33
+	attributeTypeEapMessage           = 79
+	attributeTypeMessageAuthenticator = 80
+)
+
+const (
+	vendorIdMicrosoft           = 311
+	vendorTypeMsMppeSendKey     = 16
+	vendorTypeMsMppeRecvKey     = 17
+	packetHeaderLength          = 20
+	packetMaxLength             = 4096
+	attributeMaxValueLength     = 253
+	messageAuthenticatorLength  = 16
+	requestAuthenticatorLength  = 16
+	mppeKeySaltLength           = 2
+	mppeKeyEncryptionBlockBytes = 16
+)
+
+type packetAttribute struct {
+	Type  byte
+	Value []byte
+}
+
+type radiusPacket struct {
+	Code          byte
+	Identifier    byte
+	Authenticator [requestAuthenticatorLength]byte
+	Attributes    []packetAttribute
+}
+
+func parseRadiusPacket(data []byte) (*radiusPacket, error) {
+	if len(data) < packetHeaderLength {
+		return nil, errors.New("packet is too short")
+	}
+
+	length := int(binary.BigEndian.Uint16(data[2:4]))
+
+	if (length < packetHeaderLength) || (length > len(data)) || (length > packetMaxLength) {
+		return nil, errors.New("packet length is invalid")
+	}
+
+	packet := &radiusPacket{
+		Code:       data[0],
+		Identifier: data[1],
+		Attributes: []packetAttribute{},
+	}
+
+	copy(packet.Authenticator[:], data[4:20])
+
+	for offset := packetHeaderLength; offset < length; {
+		if offset+2 > length {
+			return nil, errors.New("attribute header is truncated")
+		}
+
+		attributeLength := int(data[offset+1])
+
+		if (attributeLength < 2) || (offset+attributeLength > length) {
+			return nil, errors.New("attribute length is invalid")
+		}
+
+		packet.Attributes = append(packet.Attributes, packetAttribute{
+			Type:  data[offset],
+			Value: bytes.Clone(data[offset+2 : offset+attributeLength]),
+		})
+
+		offset += attributeLength
+	}
+
+	return packet, nil
+}
+
+func (p *radiusPacket) encode() []byte {
+	data := make([]byte, packetHeaderLength, packetMaxLength)
+	data[0] = p.Code
+	data[1] = p.Identifier
+	copy(data[4:20], p.Authenticator[:])
+
+	for _, attribute := range p.Attributes {
+		data = append(data, attribute.Type, byte(len(attribute.Value)+2))
+		data = append(data, attribute.Value...)
+	}
+
+	binary.BigEndian.PutUint16(data[2:4], uint16(len(data)))
+
+	return data
+}
+
+func (p *radiusPacket) get(attributeType byte) ([]byte, bool) {
+	for _, attribute := range p.Attributes {
+		if attribute.Type == attributeType {
+			return attribute.Value, true
+		}
+	}
+
+	return nil, false
+}
+
+// concat joins values of attributes split over several instances, e.g. EAP-Message.
+func (p *radiusPacket) concat(attributeType byte) []byte {
+	var value []byte
+
+	for _, attribute := range p.Attributes {
+		if attribute.Type == attributeType {
+			value = append(value, attribute.Value...)
+		}
+	}
+
+	return value
+}
+
+// add splits values longer than a single attribute can hold.
+func (p *radiusPacket) add(attributeType byte, value []byte) {
+	for len(value) > attributeMaxValueLength {
+		p.Attributes = append(p.Attributes, packetAttribute{Type: attributeType, Value: value[:attributeMaxValueLength]})
+		value = value[attributeMaxValueLength:]
+	}
+
+	p.Attributes = append(p.Attributes, packetAttribute{Type: attributeType, Value: value})
+}
+
+func (p *radiusPacket) addVendorSpecific(vendorId uint32, vendorType byte, value []byte) {
+	vendorValue := binary.BigEndian.AppendUint32(nil, vendorId)
+	vendorValue = append(vendorValue, vendorType, byte(len(value)+2))
+	vendorValue = append(vendorValue, value...)
+
+	p.Attributes = append(p.Attributes, packetAttribute{Type: attributeTypeVendorSpecific, Value: vendorValue})
+}
+
+// newResponse copies Proxy-State attributes as required by RFC 2865.
+func (p *radiusPacket) newResponse(code byte) *radiusPacket {
+	response := &radiusPacket{
+		Code:       code,
+		Identifier: p.Identifier,
+		Attributes: []packetAttribute{},
+	}
+
+	for _, attribute := range p.Attributes {
+		if attribute.Type == attributeTypeProxyState {
+			response.Attributes = append(response.Attributes, attribute)
+		}
+	}
+
+	return response
+}
+
+// validPacketLength returns Length field of data, zero if the header is
+// truncated or Length does not fit data.
+func validPacketLength(data []byte) int {
+	if len(data) < packetHeaderLength {
+		return 0
+	}
+
+	length := int(binary.BigEndian.Uint16(data[2:4]))
+
+	if (length < packetHeaderLength) || (length > len(data)) || (length > packetMaxLength) {
+		return 0
+	}
+
+	return length
+}
+
+// verifyMessageAuthenticator checks Message-Authenticator of a request, see
+// RFC 3579. It is mandatory for requests carrying EAP-Message. Malformed
+// packets fail verification.
+func verifyMessageAuthenticator(data []byte, secret []byte) bool {
+	length := validPacketLength(data)
+
+	if length == 0 {
+		return false
+	}
+
+	data = bytes.Clone(data[:length])
+	var messageAuthenticator []byte
+
+	for offset := packetHeaderLength; offset < length; {
+		if offset+2 > length {
+			return false
+		}
+
+		attributeLength := int(data[offset+1])
+
+		if (attributeLength < 2) || (offset+attributeLength > length) {
+			return false
+		}
+
+		if data[offset] == attributeTypeMessageAuthenticator {
+			if (attributeLength != messageAuthenticatorLength+2) || (messageAuthenticator != nil) {
+				return false
+			}
+
+			messageAuthenticator = bytes.Clone(data[offset+2 : offset+attributeLength])
+			clear(data[offset+2 : offset+attributeLength])
+		}
+
+		offset += attributeLength
+	}
+
+	if messageAuthenticator == nil {
+		return false
+	}
+
+	mac := hmac.New(md5.New, secret)
+	mac.Write(data)
+
+	return hmac.Equal(mac.Sum(nil), messageAuthenticator)
+}
+
+// verifyAccountingRequest checks Request Authenticator of Accounting-Request,
+// see RFC 2866.
+func verifyAccountingRequest(data []byte, secret []byte) bool {
+	length := validPacketLength(data)
+
+	if length == 0 {
+		return false
+	}
+
+	data = bytes.Clone(data[:length])
+	requestAuthenticator := bytes.Clone(data[4:20])
+	clear(data[4:20])
+
+	hash := md5.New()
+	hash.Write(data)
+	hash.Write(secret)
+
+	return hmac.Equal(hash.Sum(nil), requestAuthenticator)
+}
+
+// encodeResponse signs response to a request with given Request Authenticator,
+// Message-Authenticator is computed first since Response Authenticator covers it.
+func (p *radiusPacket) encodeResponse(requestAuthenticator [requestAuthenticatorLength]byte, secret []byte, withMessageAuthenticator bool) []byte {
+	p.Authenticator = requestAuthenticator
+
+	if withMessageAuthenticator {
+		p.Attributes = append(p.Attributes, packetAttribute{
+			Type:  attributeTypeMessageAuthenticator,
+			Value: make([]byte, messageAuthenticatorLength),
+		})
+	}
+
+	data := p.encode()
+
+	if withMessageAuthenticator {
+		mac := hmac.New(md5.New, secret)
+		mac.Write(data)
+		copy(data[len(data)-messageAuthenticatorLength:], mac.Sum(nil))
+	}
+
+	hash := md5.New()
+	hash.Write(data)
+	hash.Write(secret)
+	copy(data[4:20], hash.Sum(nil))
+
+	return data
+}
+
+// encryptMppeKey encrypts MS-MPPE-Send-Key and MS-MPPE-Recv-Key values, see
+// RFC 2548 section 2.4.2.
+func encryptMppeKey(key []byte, requestAuthenticator [requestAuthenticatorLength]byte, secret []byte) []byte {
+	salt := make([]byte, mppeKeySaltLength)
+	rand.Read(salt)
+	salt[0] |= 0x80
+
+	plaintext := append([]byte{byte(len(key))}, key...)
+
+	for len(plaintext)%mppeKeyEncryptionBlockBytes != 0 {
+		plaintext = append(plaintext, 0)
+	}
+
+	ciphertext := bytes.Clone(salt)
+	hash := md5.New()
+	hash.Write(secret)
+	hash.Write(requestAuthenticator[:])
+	hash.Write(salt)
+	block := hash.Sum(nil)
+
+	for offset := 0; offset < len(plaintext); offset += mppeKeyEncryptionBlockBytes {
+		for i := range mppeKeyEncryptionBlockBytes {
+			block[i] ^= plaintext[offset+i]
+		}
+
+		ciphertext = append(ciphertext, block...)
+		hash.Reset()
+		hash.Write(secret)
+		hash.Write(block)
+		block = hash.Sum(nil)
+	}
+
+	return ciphertext
+}
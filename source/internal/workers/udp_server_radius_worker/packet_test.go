@@ -0,0 +1,231 @@
+package udp_server_radius_worker
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"testing"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2869"
+	"layeh.com/radius/vendors/microsoft"
+)
+
+var testSecret = []byte("testing123")
+
+// signAccessRequest sets Message-Authenticator of request as RFC 3579
+// describes, independently of verifyMessageAuthenticator.
+func signAccessRequest(t *testing.T, request *radius.Packet) []byte {
+	rfc2869.MessageAuthenticator_Set(request, make([]byte, messageAuthenticatorLength))
+	data, err := request.MarshalBinary()
+
+	if err != nil {
+		t.Fatalf("failed to encode Access-Request: %v", err)
+	}
+
+	mac := hmac.New(md5.New, request.Secret)
+	mac.Write(data)
+	rfc2869.MessageAuthenticator_Set(request, mac.Sum(nil))
+
+	data, err = request.Encode()
+
+	if err != nil {
+		t.Fatalf("failed to encode Access-Request: %v", err)
+	}
+
+	return data
+}
+
+// verifyResponseMessageAuthenticator checks Message-Authenticator of response
+// as RFC 3579 describes, independently of encodeResponse.
+func verifyResponseMessageAuthenticator(t *testing.T, responseData []byte, request *radius.Packet) {
+	response, err := radius.Parse(responseData, request.Secret)
+
+	if err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	messageAuthenticator := rfc2869.MessageAuthenticator_Get(response)
+
+	if len(messageAuthenticator) != messageAuthenticatorLength {
+		t.Fatalf("expected Message-Authenticator, got %X", messageAuthenticator)
+	}
+
+	rfc2869.MessageAuthenticator_Set(response, make([]byte, messageAuthenticatorLength))
+	response.Authenticator = request.Authenticator
+	data, _ := response.MarshalBinary()
+	mac := hmac.New(md5.New, request.Secret)
+	mac.Write(data)
+
+	if !hmac.Equal(mac.Sum(nil), messageAuthenticator) {
+		t.Fatalf("response Message-Authenticator is invalid")
+	}
+}
+
+func newTestAccessRequest(t *testing.T) *radius.Packet {
+	request := radius.New(radius.CodeAccessRequest, testSecret)
+	rfc2865.UserName_SetString(request, testUsername)
+	rfc2865.CallingStationID_SetString(request, "192.0.2.10")
+	rfc2869.EAPMessage_Set(request, (&eapPacket{Code: eapCodeResponse, Identifier: 1, Type: eapTypeIdentity, Data: []byte(testUsername)}).encode())
+
+	return request
+}
+
+func TestVerifyMessageAuthenticator(t *testing.T) {
+	data := signAccessRequest(t, newTestAccessRequest(t))
+
+	if !verifyMessageAuthenticator(data, testSecret) {
+		t.Fatalf("expected valid Message-Authenticator")
+	}
+
+	if verifyMessageAuthenticator(data, []byte("wrong")) {
+		t.Fatalf("expected invalid Message-Authenticator for wrong secret")
+	}
+
+	tampered := bytes.Clone(data)
+	tampered[len(tampered)-1] ^= 0x01
+
+	if verifyMessageAuthenticator(tampered, testSecret) {
+		t.Fatalf("expected invalid Message-Authenticator for tampered packet")
+	}
+
+	// Bytes beyond Length are padding and are not authenticated
+	if !verifyMessageAuthenticator(append(bytes.Clone(data), 0xff, 0xff), testSecret) {
+		t.Fatalf("expected valid Message-Authenticator with trailing padding")
+	}
+
+	unsigned, _ := newTestAccessRequest(t).Encode()
+
+	if verifyMessageAuthenticator(unsigned, testSecret) {
+		t.Fatalf("expected missing Message-Authenticator to be invalid")
+	}
+}
+
+func TestVerifyAccountingRequest(t *testing.T) {
+	request := radius.New(radius.CodeAccountingRequest, testSecret)
+	rfc2865.UserName_SetString(request, testUsername)
+	data, _ := request.Encode()
+
+	if !verifyAccountingRequest(data, testSecret) {
+		t.Fatalf("expected valid Request Authenticator")
+	}
+
+	if verifyAccountingRequest(data, []byte("wrong")) {
+		t.Fatalf("expected invalid Request Authenticator for wrong secret")
+	}
+}
+
+func TestEncodeResponse(t *testing.T) {
+	request := newTestAccessRequest(t)
+	requestData := signAccessRequest(t, request)
+	parsedRequest, err := parseRadiusPacket(requestData)
+
+	if err != nil {
+		t.Fatalf("failed to parse Access-Request: %v", err)
+	}
+
+	response := parsedRequest.newResponse(codeAccessChallenge)
+	response.add(attributeTypeState, []byte("state"))
+	responseData := response.encodeResponse(parsedRequest.Authenticator, testSecret, true)
+
+	if !radius.IsAuthenticResponse(responseData, requestData, testSecret) {
+		t.Fatalf("response Response Authenticator is invalid")
+	}
+
+	verifyResponseMessageAuthenticator(t, responseData, request)
+}
+
+func TestEncryptMppeKey(t *testing.T) {
+	request := newTestAccessRequest(t)
+	sendKey := mustDecodeHex(t, testSendStartKey128)
+	recvKey := bytes.Repeat([]byte{0xa5}, 32)
+	response := &radiusPacket{Code: codeAccessAccept, Identifier: request.Identifier}
+	response.addVendorSpecific(vendorIdMicrosoft, vendorTypeMsMppeSendKey, encryptMppeKey(sendKey, request.Authenticator, testSecret))
+	response.addVendorSpecific(vendorIdMicrosoft, vendorTypeMsMppeRecvKey, encryptMppeKey(recvKey, request.Authenticator, testSecret))
+
+	parsedResponse, err := radius.Parse(response.encode(), testSecret)
+
+	if err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	if decrypted, err := microsoft.MSMPPESendKey_Lookup(parsedResponse, request); (err != nil) || !bytes.Equal(decrypted, sendKey) {
+		t.Fatalf("expected MS-MPPE-Send-Key %X, got %X, err %v", sendKey, decrypted, err)
+	}
+
+	if decrypted, err := microsoft.MSMPPERecvKey_Lookup(parsedResponse, request); (err != nil) || !bytes.Equal(decrypted, recvKey) {
+		t.Fatalf("expected MS-MPPE-Recv-Key %X, got %X, err %v", recvKey, decrypted, err)
+	}
+
+	for _, attribute := range response.Attributes {
+		// Vendor-Id, Vendor-Type, Vendor-Length, salt with the high bit set
+		if salt := attribute.Value[6:8]; salt[0]&0x80 == 0 {
+			t.Fatalf("expected salt with the high bit set, got %X", salt)
+		}
+	}
+}
+
+func TestParseRadiusPacketMalformed(t *testing.T) {
+	valid, _ := newTestAccessRequest(t).Encode()
+	testCases := []struct {
+		name      string
+		data      []byte
+		parseable bool
+	}{
+		{"empty", []byte{}, false},
+		{"short header", valid[:packetHeaderLength-1], false},
+		{"length below header", append([]byte{codeAccessRequest, 1, 0, 19}, valid[4:]...), false},
+		{"length beyond data", append([]byte{codeAccessRequest, 1, 0x10, 0x00}, valid[4:]...), false},
+		{"attribute length 0", withAttributeBytes(valid, 0x01, 0x00), false},
+		{"attribute length 1", withAttributeBytes(valid, 0x01, 0x01), false},
+		{"attribute beyond length", withAttributeBytes(valid, 0x01, 0x10), false},
+		{"attribute header truncated", withAttributeBytes(valid, 0x01), false},
+		{"Message-Authenticator too short", withAttributeBytes(valid, attributeTypeMessageAuthenticator, 0x04, 0x00, 0x00), true},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if _, err := parseRadiusPacket(testCase.data); (err == nil) != testCase.parseable {
+				t.Fatalf("expected parseable %t, got err %v", testCase.parseable, err)
+			}
+
+			if verifyMessageAuthenticator(testCase.data, testSecret) {
+				t.Fatalf("expected invalid Message-Authenticator")
+			}
+
+			if verifyAccountingRequest(testCase.data, testSecret) {
+				t.Fatalf("expected invalid Request Authenticator")
+			}
+		})
+	}
+}
+
+// withAttributeBytes appends raw attribute bytes to packet and fixes Length.
+func withAttributeBytes(data []byte, attributeData ...byte) []byte {
+	data = append(bytes.Clone(data), attributeData...)
+	data[2] = byte(len(data) >> 8)
+	data[3] = byte(len(data))
+
+	return data
+}
+
+func TestParseEapPacketMalformed(t *testing.T) {
+	testCases := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", []byte{}},
+		{"short header", []byte{eapCodeResponse, 1, 0}},
+		{"length beyond data", []byte{eapCodeResponse, 1, 0, 10, eapTypeIdentity}},
+		{"length below header", []byte{eapCodeResponse, 1, 0, 2}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if _, err := parseEapPacket(testCase.data); err == nil {
+				t.Fatalf("expected error")
+			}
+		})
+	}
+}
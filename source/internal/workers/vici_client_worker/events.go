@@ -0,0 +1,162 @@
+package vici_client_worker
+
+import (
+	"github.com/strongswan/govici/vici"
+	"github.com/triflesoft/portalswan/internal/session_control"
+	"github.com/triflesoft/portalswan/internal/state"
+)
+
+// Events are logged with fixed field names, missing values are logged as
+// empty or zero, so that queries do not depend on StrongSwan version. Times
+// are in seconds, as StrongSwan reports them.
+type viciChildSaEvent struct {
+	Name                   string   `json:"name"`
+	UniqueId               string   `json:"unique_id"`
+	State                  string   `json:"state"`
+	Mode                   string   `json:"mode"`
+	Protocol               string   `json:"protocol"`
+	BytesIn                int64    `json:"bytes_in"`
+	BytesOut               int64    `json:"bytes_out"`
+	PacketsIn              int64    `json:"packets_in"`
+	PacketsOut             int64    `json:"packets_out"`
+	InstallTime            int64    `json:"install_time"`
+	RekeyTime              int64    `json:"rekey_time"`
+	LifeTime               int64    `json:"life_time"`
+	LocalTrafficSelectors  []string `json:"local_traffic_selectors"`
+	RemoteTrafficSelectors []string `json:"remote_traffic_selectors"`
+}
+
+type viciIkeSaEvent struct {
+	Event       string             `json:"event"`
+	Up          bool               `json:"up"`
+	Name        string             `json:"name"`
+	UniqueId    string             `json:"unique_id"`
+	State       string             `json:"state"`
+	LocalHost   string             `json:"local_host"`
+	LocalPort   int64              `json:"local_port"`
+	LocalId     string             `json:"local_id"`
+	RemoteHost  string             `json:"remote_host"`
+	RemotePort  int64              `json:"remote_port"`
+	RemoteId    string             `json:"remote_id"`
+	EapIdentity string             `json:"eap_identity"`
+	VirtualIps  []string           `json:"virtual_ips"`
+	Established int64              `json:"established"`
+	RekeyTime   int64              `json:"rekey_time"`
+	ReauthTime  int64              `json:"reauth_time"`
+	Username    string             `json:"username"`
+	Class       string             `json:"class"`
+	ChildSas    []viciChildSaEvent `json:"child_sas"`
+
+	// ike-update only, addresses after MOBIKE update
+	NewLocalHost  string `json:"new_local_host,omitempty"`
+	NewLocalPort  int64  `json:"new_local_port,omitempty"`
+	NewRemoteHost string `json:"new_remote_host,omitempty"`
+	NewRemotePort int64  `json:"new_remote_port,omitempty"`
+}
+
+func viciStrings(message *vici.Message, key string) []string {
+	values, ok := message.Get(key).([]string)
+
+	if !ok {
+		return []string{}
+	}
+
+	return values
+}
+
+func newViciChildSaEvent(childSaName string, childSa *vici.Message) viciChildSaEvent {
+	name := viciString(childSa, "name")
+
+	// Older StrongSwan versions do not report name, the key is the name then
+	if name == "" {
+		name = childSaName
+	}
+
+	return viciChildSaEvent{
+		Name:                   name,
+		UniqueId:               viciString(childSa, "uniqueid"),
+		State:                  viciString(childSa, "state"),
+		Mode:                   viciString(childSa, "mode"),
+		Protocol:               viciString(childSa, "protocol"),
+		BytesIn:                viciInt64(childSa, "bytes-in"),
+		BytesOut:               viciInt64(childSa, "bytes-out"),
+		PacketsIn:              viciInt64(childSa, "packets-in"),
+		PacketsOut:             viciInt64(childSa, "packets-out"),
+		InstallTime:            viciInt64(childSa, "install-time"),
+		RekeyTime:              viciInt64(childSa, "rekey-time"),
+		LifeTime:               viciInt64(childSa, "life-time"),
+		LocalTrafficSelectors:  viciStrings(childSa, "local-ts"),
+		RemoteTrafficSelectors: viciStrings(childSa, "remote-ts"),
+	}
+}
+
+// enrichViciIkeSaEvent takes username and class from live connection using
+// one of virtual IPs, EAP identity is used otherwise. Identity adapter is never
+// called, it would block event loop, restored connections know class already.
+func enrichViciIkeSaEvent(ws *state.WorkerState, event *viciIkeSaEvent) {
+	event.Username = event.EapIdentity
+
+	for _, virtualIp := range event.VirtualIps {
+		if connectionState, ok := ws.AppState.GetVpnConnectionState(virtualIp); ok {
+			event.Username = connectionState.Username
+			event.Class = connectionState.Class
+			break
+		}
+	}
+}
+
+func newViciIkeSaEvent(eventName string, message *vici.Message, ikeSaName string, ikeSa *vici.Message) *viciIkeSaEvent {
+	event := &viciIkeSaEvent{
+		Event:       eventName,
+		Up:          viciString(message, "up") == "yes",
+		Name:        ikeSaName,
+		UniqueId:    viciString(ikeSa, "uniqueid"),
+		State:       viciString(ikeSa, "state"),
+		LocalHost:   viciString(ikeSa, "local-host"),
+		LocalPort:   viciInt64(ikeSa, "local-port"),
+		LocalId:     viciString(ikeSa, "local-id"),
+		RemoteHost:  viciString(ikeSa, "remote-host"),
+		RemotePort:  viciInt64(ikeSa, "remote-port"),
+		RemoteId:    viciString(ikeSa, "remote-id"),
+		EapIdentity: session_control.IkeSaUsername(ikeSa),
+		VirtualIps:  viciStrings(ikeSa, "remote-vips"),
+		Established: viciInt64(ikeSa, "established"),
+		RekeyTime:   viciInt64(ikeSa, "rekey-time"),
+		ReauthTime:  viciInt64(ikeSa, "reauth-time"),
+		ChildSas:    []viciChildSaEvent{},
+	}
+
+	if eventName == "ike-update" {
+		event.NewLocalHost = viciString(message, "local-host")
+		event.NewLocalPort = viciInt64(message, "local-port")
+		event.NewRemoteHost = viciString(message, "remote-host")
+		event.NewRemotePort = viciInt64(message, "remote-port")
+	}
+
+	if childSas, ok := ikeSa.Get("child-sas").(*vici.Message); ok {
+		for _, childSaName := range childSas.Keys() {
+			if childSa, ok := childSas.Get(childSaName).(*vici.Message); ok {
+				event.ChildSas = append(event.ChildSas, newViciChildSaEvent(childSaName, childSa))
+			}
+		}
+	}
+
+	return event
+}
+
+// logViciEvent logs IKE and CHILD SA events as typed events, one per IKE SA,
+// other events are logged as they are.
+func logViciEvent(ws *state.WorkerState, event vici.Event) {
+	switch event.Name {
+	case "ike-updown", "ike-update", "child-updown":
+		for _, ikeSaName := range event.Message.Keys() {
+			if ikeSa, ok := event.Message.Get(ikeSaName).(*vici.Message); ok {
+				ikeSaEvent := newViciIkeSaEvent(event.Name, event.Message, ikeSaName, ikeSa)
+				enrichViciIkeSaEvent(ws, ikeSaEvent)
+				ws.AppState.LoggingAdapter.LogInfoJson(LogChannelName, ikeSaEvent)
+			}
+		}
+	default:
+		logViciMessage(ws, event.Message)
+	}
+}
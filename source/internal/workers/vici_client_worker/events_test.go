@@ -0,0 +1,185 @@
+package vici_client_worker
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/strongswan/govici/vici"
+)
+
+// newTestMessage builds message from key and value pairs, keeping order.
+func newTestMessage(t *testing.T, pairs ...any) *vici.Message {
+	message := vici.NewMessage()
+
+	for i := 0; i < len(pairs); i += 2 {
+		if err := message.Set(pairs[i].(string), pairs[i+1]); err != nil {
+			t.Fatalf("failed to set '%s': %v", pairs[i], err)
+		}
+	}
+
+	return message
+}
+
+func newTestChildSa(t *testing.T, pairs ...any) *vici.Message {
+	return newTestMessage(t, append([]any{
+		"uniqueid", "7",
+		"state", "INSTALLED",
+		"mode", "TUNNEL",
+		"protocol", "ESP",
+		"bytes-in", "1024",
+		"bytes-out", "2048",
+		"packets-in", "10",
+		"packets-out", "20",
+		"install-time", "30",
+		"rekey-time", "3000",
+		"life-time", "3300",
+		"local-ts", []string{"0.0.0.0/0"},
+		"remote-ts", []string{"10.0.0.2/32"},
+	}, pairs...)...)
+}
+
+func newTestIkeSa(t *testing.T, childSas *vici.Message, pairs ...any) *vici.Message {
+	return newTestMessage(t, append([]any{
+		"uniqueid", "3",
+		"state", "ESTABLISHED",
+		"local-host", "198.51.100.1",
+		"local-port", "4500",
+		"local-id", "vpn.example.com",
+		"remote-host", "192.0.2.10",
+		"remote-port", "4500",
+		"remote-id", "192.0.2.10",
+		"remote-vips", []string{"10.0.0.2"},
+		"established", "120",
+		"rekey-time", "14000",
+		"reauth-time", "0",
+		"child-sas", childSas,
+	}, pairs...)...)
+}
+
+func TestNewViciChildSaEvent(t *testing.T) {
+	testCases := []struct {
+		name     string
+		key      string
+		childSa  *vici.Message
+		expected viciChildSaEvent
+	}{
+		{
+			"all fields",
+			"rw-7",
+			newTestChildSa(t, "name", "rw"),
+			viciChildSaEvent{
+				Name:                   "rw",
+				UniqueId:               "7",
+				State:                  "INSTALLED",
+				Mode:                   "TUNNEL",
+				Protocol:               "ESP",
+				BytesIn:                1024,
+				BytesOut:               2048,
+				PacketsIn:              10,
+				PacketsOut:             20,
+				InstallTime:            30,
+				RekeyTime:              3000,
+				LifeTime:               3300,
+				LocalTrafficSelectors:  []string{"0.0.0.0/0"},
+				RemoteTrafficSelectors: []string{"10.0.0.2/32"},
+			},
+		},
+		{
+			"name from key",
+			"rw",
+			newTestChildSa(t),
+			viciChildSaEvent{
+				Name:                   "rw",
+				UniqueId:               "7",
+				State:                  "INSTALLED",
+				Mode:                   "TUNNEL",
+				Protocol:               "ESP",
+				BytesIn:                1024,
+				BytesOut:               2048,
+				PacketsIn:              10,
+				PacketsOut:             20,
+				InstallTime:            30,
+				RekeyTime:              3000,
+				LifeTime:               3300,
+				LocalTrafficSelectors:  []string{"0.0.0.0/0"},
+				RemoteTrafficSelectors: []string{"10.0.0.2/32"},
+			},
+		},
+		{
+			"missing and invalid values",
+			"rw",
+			newTestMessage(t, "bytes-in", "many"),
+			viciChildSaEvent{
+				Name:                   "rw",
+				LocalTrafficSelectors:  []string{},
+				RemoteTrafficSelectors: []string{},
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if event := newViciChildSaEvent(testCase.key, testCase.childSa); !reflect.DeepEqual(event, testCase.expected) {
+				t.Fatalf("expected %+v, got %+v", testCase.expected, event)
+			}
+		})
+	}
+}
+
+func TestNewViciIkeSaEvent(t *testing.T) {
+	childSas := newTestMessage(t, "rw-7", newTestChildSa(t, "name", "rw"))
+	testCases := []struct {
+		name                string
+		eventName           string
+		message             *vici.Message
+		ikeSa               *vici.Message
+		expectedUp          bool
+		expectedEapIdentity string
+		expectedNewHost     string
+		expectedNewPort     int64
+		expectedChildSas    int
+	}{
+		{"ike-updown up", "ike-updown", newTestMessage(t, "up", "yes"), newTestIkeSa(t, childSas, "remote-eap-id", "alice@example.com"), true, "alice@example.com", "", 0, 1},
+		{"ike-updown down", "ike-updown", vici.NewMessage(), newTestIkeSa(t, vici.NewMessage(), "remote-eap-id", "alice"), false, "alice", "", 0, 0},
+		{"xauth identity", "child-updown", newTestMessage(t, "up", "yes"), newTestIkeSa(t, childSas, "remote-xauth-id", "bob"), true, "bob", "", 0, 1},
+		{"IKE identity", "child-updown", newTestMessage(t, "up", "yes"), newTestIkeSa(t, childSas), true, "192.0.2.10", "", 0, 1},
+		{"ike-update", "ike-update", newTestMessage(t, "remote-host", "203.0.113.7", "remote-port", "61000"), newTestIkeSa(t, childSas, "remote-eap-id", "alice"), false, "alice", "203.0.113.7", 61000, 1},
+		{"new addresses of other events", "ike-updown", newTestMessage(t, "remote-host", "203.0.113.7"), newTestIkeSa(t, childSas, "remote-eap-id", "alice"), false, "alice", "", 0, 1},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			event := newViciIkeSaEvent(testCase.eventName, testCase.message, "rw-3", testCase.ikeSa)
+
+			if (event.Event != testCase.eventName) || (event.Name != "rw-3") || (event.Up != testCase.expectedUp) {
+				t.Fatalf("expected %s of rw-3 with up %t, got %+v", testCase.eventName, testCase.expectedUp, event)
+			}
+
+			if (event.UniqueId != "3") || (event.State != "ESTABLISHED") || (event.Established != 120) || (event.RekeyTime != 14000) || (event.ReauthTime != 0) {
+				t.Fatalf("expected IKE SA state, got %+v", event)
+			}
+
+			if (event.LocalHost != "198.51.100.1") || (event.LocalPort != 4500) || (event.LocalId != "vpn.example.com") ||
+				(event.RemoteHost != "192.0.2.10") || (event.RemotePort != 4500) || (event.RemoteId != "192.0.2.10") {
+				t.Fatalf("expected IKE SA endpoints, got %+v", event)
+			}
+
+			if (event.EapIdentity != testCase.expectedEapIdentity) || !reflect.DeepEqual(event.VirtualIps, []string{"10.0.0.2"}) {
+				t.Fatalf("expected identity '%s' with virtual IP, got %+v", testCase.expectedEapIdentity, event)
+			}
+
+			if (event.NewRemoteHost != testCase.expectedNewHost) || (event.NewRemotePort != testCase.expectedNewPort) {
+				t.Fatalf("expected new remote address '%s' port %d, got %+v", testCase.expectedNewHost, testCase.expectedNewPort, event)
+			}
+
+			// Username and class are set by enrichment only
+			if (event.Username != "") || (event.Class != "") {
+				t.Fatalf("expected no username and class, got %+v", event)
+			}
+
+			if len(event.ChildSas) != testCase.expectedChildSas {
+				t.Fatalf("expected %d CHILD SAs, got %+v", testCase.expectedChildSas, event.ChildSas)
+			}
+		})
+	}
+}
@@ -1,18 +1,30 @@
 package vici_client_worker
 
 import (
+	"sync"
 	"time"
 
 	"github.com/strongswan/govici/vici"
+	"github.com/triflesoft/portalswan/internal/session_control"
 	"github.com/triflesoft/portalswan/internal/state"
+	"github.com/triflesoft/portalswan/internal/vpn_config"
 )
 
 func ViciWorker(ws *state.WorkerState) bool {
 	go func() {
 		log := ws.AppState.LoggingAdapter
+		// Reconcile runs outside of event loop, since events are dropped while
+		// it is blocked, but never concurrently
+		reconcileMtx := sync.Mutex{}
+		reconcile := func(session *vici.Session) {
+			reconcileMtx.Lock()
+			defer reconcileMtx.Unlock()
+
+			vpn_config.Reconcile(session, ws.AppState.GetAppSettings(), log)
+		}
 
 		for {
-			session, err := vici.NewSession(vici.WithAddr("unix", "/var/run/strongswan/charon.vici"))
+			session, err := vici.NewSession(vici.WithAddr("unix", session_control.ViciSocketPath))
 
 			if err != nil {
 				log.LogErrorText("Failed to connect to StrongSwan", "err", err)
@@ -30,6 +42,8 @@ func ViciWorker(ws *state.WorkerState) bool {
 			}
 
 			logViciMessage(ws, versionMessage)
+			restoreVpnConnectionStates(ws, session)
+			reconcile(session)
 
 			log.LogDebugText("VICI initalization completed")
 			ws.ReportInitCompleted()
@@ -53,7 +67,11 @@ func ViciWorker(ws *state.WorkerState) bool {
 					ws.ReportQuitCompleted()
 					return
 				case event := <-eventChan:
-					logViciMessage(ws, event.Message)
+					logViciEvent(ws, event)
+				case request := <-ws.AppState.VpnTerminationRequests():
+					go terminateVpnConnections(ws, session, request)
+				case <-ws.AppState.VpnReconcileRequests():
+					go reconcile(session)
 				}
 			}
 		}
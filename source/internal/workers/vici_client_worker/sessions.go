@@ -0,0 +1,111 @@
+package vici_client_worker
+
+import (
+	"strconv"
+
+	"github.com/strongswan/govici/vici"
+	"github.com/triflesoft/portalswan/internal/session_control"
+	"github.com/triflesoft/portalswan/internal/state"
+)
+
+func viciString(message *vici.Message, key string) string {
+	value, _ := message.Get(key).(string)
+
+	return value
+}
+
+func viciInt64(message *vici.Message, key string) int64 {
+	value, _ := strconv.ParseInt(viciString(message, key), 10, 64)
+
+	return value
+}
+
+// restoreVpnConnectionStates rebuilds connection states from established IKE
+// SAs, so that connections survive PortalSwan restart. Connections already
+// known from RADIUS accounting are left as they are, restored ones are matched
+// by framed IP address and username to the next accounting request.
+func restoreVpnConnectionStates(ws *state.WorkerState, session *vici.Session) {
+	log := ws.AppState.LoggingAdapter
+	messages, err := session.StreamedCommandRequest("list-sas", "list-sa", nil)
+
+	if err != nil {
+		log.LogErrorText("Failed to list IKE SAs", "err", err)
+		return
+	}
+
+	restoredCount := 0
+
+	for _, message := range messages {
+		for _, ikeSaName := range message.Keys() {
+			ikeSa, ok := message.Get(ikeSaName).(*vici.Message)
+
+			if !ok || (viciString(ikeSa, "state") != "ESTABLISHED") {
+				continue
+			}
+
+			username := session_control.IkeSaUsername(ikeSa)
+			framedIpAddresses, _ := ikeSa.Get("remote-vips").([]string)
+
+			if (username == "") || (len(framedIpAddresses) == 0) {
+				continue
+			}
+
+			// EAP identity may be an alias, accounting keeps canonical username.
+			// Identity adapter may only be called before events are subscribed.
+			class := ""
+
+			if vpnUser := ws.AppState.IdentityAdapter.SelectVpnUser(username); vpnUser != nil {
+				username = vpnUser.Username
+				class = vpnUser.Class
+			}
+
+			// Accounting session ID is not known to VICI, it is assigned by
+			// the next accounting request of this session
+			connectionState := &state.VpnConnectionState{
+				SessionId:         "vici:" + viciString(ikeSa, "uniqueid"),
+				FramedIpAddresses: framedIpAddresses,
+				Username:          username,
+				Class:             class,
+			}
+
+			if childSas, ok := ikeSa.Get("child-sas").(*vici.Message); ok {
+				for _, childSaName := range childSas.Keys() {
+					if childSa, ok := childSas.Get(childSaName).(*vici.Message); ok {
+						connectionState.ClientToServerBytes.Add(viciInt64(childSa, "bytes-in"))
+						connectionState.ServerToClientBytes.Add(viciInt64(childSa, "bytes-out"))
+						connectionState.ClientToServerPackets.Add(viciInt64(childSa, "packets-in"))
+						connectionState.ServerToClientPackets.Add(viciInt64(childSa, "packets-out"))
+					}
+				}
+			}
+
+			actualState, loaded := ws.AppState.RestoreVpnConnectionState(connectionState)
+
+			if loaded {
+				if actualState.Username != username {
+					log.LogErrorText(
+						"VICI restore VPN connection failed, username mismatch",
+						"ikeSa", ikeSaName,
+						"framedIpAddresses", framedIpAddresses,
+						"username", username)
+				}
+
+				continue
+			}
+
+			restoredCount++
+
+			for _, framedIpAddress := range framedIpAddresses {
+				ws.AppState.IpPoolManager.Activate(framedIpAddress, username)
+			}
+
+			log.LogDebugText(
+				"VICI restore VPN connection",
+				"ikeSa", ikeSaName,
+				"framedIpAddresses", framedIpAddresses,
+				"username", username)
+		}
+	}
+
+	log.LogDebugText("VICI restore VPN connections completed", "count", restoredCount)
+}
@@ -0,0 +1,26 @@
+package vici_client_worker
+
+import (
+	"github.com/strongswan/govici/vici"
+	"github.com/triflesoft/portalswan/internal/session_control"
+	"github.com/triflesoft/portalswan/internal/state"
+)
+
+// terminateVpnConnections terminates all IKE SAs of user, there may be several
+// if user is connected from several devices. EAP identity may be an alias of
+// username, so IKE SAs are matched by virtual IPs of user connections too.
+func terminateVpnConnections(ws *state.WorkerState, session *vici.Session, request state.VpnTerminationRequest) {
+	session_control.TerminateIkeSas(
+		session,
+		&session_control.Selector{Username: request.Username},
+		request.Reason,
+		ws.AppState.LoggingAdapter)
+
+	for _, framedIpAddress := range ws.AppState.GetVpnConnectionFramedIpAddresses(request.Username) {
+		session_control.TerminateIkeSas(
+			session,
+			&session_control.Selector{VirtualIp: framedIpAddress},
+			request.Reason,
+			ws.AppState.LoggingAdapter)
+	}
+}
@@ -6,25 +6,48 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/triflesoft/portalswan/internal/commands"
 	"github.com/triflesoft/portalswan/internal/state"
 	"github.com/triflesoft/portalswan/internal/workers/http_server_portal_worker"
 	"github.com/triflesoft/portalswan/internal/workers/http_server_radius_worker"
 	"github.com/triflesoft/portalswan/internal/workers/netfilter_client_worker"
+	"github.com/triflesoft/portalswan/internal/workers/udp_server_radius_worker"
 	"github.com/triflesoft/portalswan/internal/workers/vici_client_worker"
 
+	_ "github.com/triflesoft/portalswan/internal/adapters/aws_accounting_adapter"
+	_ "github.com/triflesoft/portalswan/internal/adapters/aws_credentials_adapter"
+	_ "github.com/triflesoft/portalswan/internal/adapters/aws_email_adapter"
+	_ "github.com/triflesoft/portalswan/internal/adapters/aws_identity_adapter"
+	_ "github.com/triflesoft/portalswan/internal/adapters/aws_logs_adapter"
+	_ "github.com/triflesoft/portalswan/internal/adapters/file_accounting_adapter"
+	_ "github.com/triflesoft/portalswan/internal/adapters/file_credentials_adapter"
+	_ "github.com/triflesoft/portalswan/internal/adapters/file_logs_adapter"
+	_ "github.com/triflesoft/portalswan/internal/adapters/ldap_identity_adapter"
+	_ "github.com/triflesoft/portalswan/internal/adapters/smtp_email_adapter"
+	_ "github.com/triflesoft/portalswan/internal/adapters/stdout_logs_adapter"
 	_ "github.com/triflesoft/portalswan/internal/localization"
 )
 
 func main() {
-	appState := state.NewAppState()
+	if len(os.Args) > 1 {
+		os.Exit(commands.Run(os.Args[1:]))
+	}
+
+	appState, err := state.NewAppState()
+
+	if err != nil {
+		fmt.Printf("error: %v\n", err)
+		os.Exit(1)
+	}
 
 	fmt.Println("Starting up...")
 	viciClientResult := vici_client_worker.ViciWorker(appState.NewWorkerState())
 	netFilterClientResult := netfilter_client_worker.NetFilterWorker(appState.NewWorkerState())
 	httpServerRadiusWorker := http_server_radius_worker.HttpServerRadiusWorker(appState.NewWorkerState())
+	udpServerRadiusWorker := udp_server_radius_worker.UdpServerRadiusWorker(appState.NewWorkerState())
 	httpServerPortalWorker := http_server_portal_worker.HttpServerPortalWorker(appState.NewWorkerState())
 
-	if !(viciClientResult || netFilterClientResult || httpServerRadiusWorker || httpServerPortalWorker) {
+	if !(viciClientResult || netFilterClientResult || httpServerRadiusWorker || udpServerRadiusWorker || httpServerPortalWorker) {
 		fmt.Println("Failed to start up!")
 		return
 	}
@@ -33,11 +56,14 @@ func main() {
 	fmt.Println("Started up successfully.")
 
 	signalChan := make(chan os.Signal, 10)
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM)
+	signal.Notify(signalChan, syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM)
 
 for_loop:
 	for {
 		switch signal := <-signalChan; signal {
+		case syscall.SIGHUP:
+			fmt.Printf("Received signal %s, reloading client and VPN settings\n", signal.String())
+			go appState.ReloadAppSettings()
 		case syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM:
 			fmt.Printf("Received signal %s", signal.String())
 			break for_loop